@@ -1,26 +1,46 @@
 package api
 
 import (
+	"context"
+	"crypto/subtle"
 	"database/sql"
+	"expvar"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/whitallee/animal-family-backend/config"
 	"github.com/whitallee/animal-family-backend/docs"
 	"github.com/whitallee/animal-family-backend/service/animal"
+	"github.com/whitallee/animal-family-backend/service/calendar"
+	"github.com/whitallee/animal-family-backend/service/caretemplate"
 	"github.com/whitallee/animal-family-backend/service/enclosure"
 	"github.com/whitallee/animal-family-backend/service/habitat"
+	"github.com/whitallee/animal-family-backend/service/llm"
 	"github.com/whitallee/animal-family-backend/service/loopmessage"
+	"github.com/whitallee/animal-family-backend/service/mailer"
 	"github.com/whitallee/animal-family-backend/service/notification"
+	"github.com/whitallee/animal-family-backend/service/pause"
 	"github.com/whitallee/animal-family-backend/service/species"
+	"github.com/whitallee/animal-family-backend/service/stats"
 	"github.com/whitallee/animal-family-backend/service/task"
 	"github.com/whitallee/animal-family-backend/service/user"
+	"github.com/whitallee/animal-family-backend/service/webhook"
+	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
 
+// shutdownTimeout bounds how long a shutdown waits for requests in progress
+// and queued pushes.
+const shutdownTimeout = 30 * time.Second
+
 type APIServer struct {
 	addr string
 	db   *sql.DB
@@ -44,8 +64,31 @@ func (s *APIServer) Run() error {
 	// RegisterV2Routes call below are still v1-only.
 	v2 := router.PathPrefix("/api/v2").Subrouter()
 
+	frontendURL, ok := os.LookupEnv("FRONTEND_URL")
+	if !ok {
+		log.Fatal("FRONTEND_URL is not set")
+	}
+
+	// Without a mail relay no email is sent: the user handler skips account
+	// emails and the notification sender has no email channel.
+	var mail *mailer.Mailer
+	notifiers := map[string]notification.Notifier{}
+	if config.Envs.SMTPHost != "" {
+		transport := mailer.NewSMTPTransport(config.Envs.SMTPHost, config.Envs.SMTPPort, config.Envs.SMTPUser, config.Envs.SMTPPassword)
+		mail = mailer.New(transport, config.Envs.EmailFrom, frontendURL, config.Envs.SupportEmail)
+		notifiers[notification.ChannelEmail] = notification.NewEmailNotifier(mail, config.Envs.PublicHost, []byte(config.Envs.JWTSecret))
+	}
+
+	// Likewise, without LoopMessage credentials nothing is texted.
+	loopMessageStore := loopmessage.NewStore(s.db)
+	var texts loopmessage.Client
+	if config.Envs.LoopMessageAuthKey != "" {
+		texts = loopmessage.NewHTTPClient(config.Envs.LoopMessageBaseURL, config.Envs.LoopMessageAuthKey, config.Envs.LoopMessageSecretKey, config.Envs.LoopMessageSenderName, config.Envs.LoopMessageWebhookSecret)
+		notifiers[notification.ChannelSMS] = loopmessage.NewSMSNotifier(texts, loopMessageStore, config.Envs.PublicHost)
+	}
+
 	userStore := user.NewStore(s.db)
-	userHandler := user.NewHandler(userStore)
+	userHandler := user.NewHandler(userStore, mail, config.Envs.PublicHost, []byte(config.Envs.JWTSecret))
 	userHandler.RegisterRoutes(subrouter)
 	userHandler.RegisterV2Routes(v2)
 
@@ -59,42 +102,98 @@ func (s *APIServer) Run() error {
 	habitatHandler.RegisterRoutes(subrouter)
 	habitatHandler.RegisterV2Routes(v2)
 
+	careTemplateStore := caretemplate.NewStore(s.db)
+	careTemplateHandler := caretemplate.NewHandler(careTemplateStore, userStore)
+	careTemplateHandler.RegisterV2Routes(v2)
+
+	// Outside production a webhook may point at a local receiver, and over
+	// plain http, which makes integrations easier to try out.
+	webhookStore := webhook.NewStore(s.db)
+	webhooks := webhook.NewDispatcher(webhookStore, !config.Envs.IsProduction())
+
 	enclosureStore := enclosure.NewStore(s.db)
-	enclosureHandler := enclosure.NewHandler(enclosureStore, userStore)
+	enclosureHandler := enclosure.NewHandler(enclosureStore, userStore, careTemplateStore, webhooks)
 	enclosureHandler.RegisterRoutes(subrouter)
 	enclosureHandler.RegisterV2Routes(v2)
 
 	animalStore := animal.NewStore(s.db)
-	animalHandler := animal.NewHandler(animalStore, userStore, enclosureStore)
+	animalHandler := animal.NewHandler(animalStore, userStore, enclosureStore, careTemplateStore, webhooks)
 	animalHandler.RegisterRoutes(subrouter)
 	animalHandler.RegisterV2Routes(v2)
 
-	// Create notification store and sender
-	notificationStore := notification.NewStore(s.db)
+	calendarStore := calendar.NewStore(s.db)
+	calendarHandler := calendar.NewHandler(calendarStore, userStore)
+	calendarHandler.RegisterV2Routes(v2)
+
+	notificationStore := notification.NewStore(s.db, []byte(config.Envs.JWTSecret))
+
+	// The configured VAPID key seeds the keys kept in the database, and
+	// becomes current whenever it changes. cmd/vapidgen rotates them too.
+	if err := notificationStore.EnsureVAPIDKey(config.Envs.VAPIDPublicKey, config.Envs.VAPIDPrivateKey); err != nil {
+		log.Fatal(err)
+	}
+	vapidKeys := notification.NewVAPIDKeyring(notificationStore)
+
+	// Web push is always on; the native providers only with their credentials.
+	providers := map[string]notification.PushProvider{
+		types.ProviderWebPush: notification.NewWebPushProvider(vapidKeys, config.Envs.VAPIDSubject),
+	}
+	if config.Envs.FCMServiceAccount != "" {
+		fcm, err := notification.NewFCMProvider(config.Envs.FCMBaseURL, config.Envs.FCMTokenURL, []byte(config.Envs.FCMServiceAccount))
+		if err != nil {
+			log.Fatal(err)
+		}
+		providers[types.ProviderFCM] = fcm
+	}
+	if config.Envs.APNsPrivateKey != "" {
+		apns, err := notification.NewAPNsProvider(config.Envs.APNsBaseURL, config.Envs.APNsKeyID, config.Envs.APNsTeamID, config.Envs.APNsTopic, []byte(config.Envs.APNsPrivateKey))
+		if err != nil {
+			log.Fatal(err)
+		}
+		providers[types.ProviderAPNs] = apns
+	}
+
+	// Create notification sender
 	notificationSender := notification.NewNotificationSender(
 		notificationStore,
-		config.Envs.VAPIDPublicKey,
-		config.Envs.VAPIDPrivateKey,
-		config.Envs.VAPIDSubject,
+		userStore,
+		notifiers,
+		providers,
+		vapidKeys,
+		[]byte(config.Envs.JWTSecret),
 	)
-	notificationHandler := notification.NewHandler(notificationStore, userStore, notificationSender)
+	notificationHandler := notification.NewHandler(notificationStore, userStore, notificationSender, []byte(config.Envs.JWTSecret))
 	notificationHandler.RegisterRoutes(subrouter)
 	notificationHandler.RegisterV2Routes(v2)
 
+	// Without an API key the parse route reports itself unavailable rather
+	// than failing every request against OpenAI.
+	var taskChat llm.ChatClient
+	if config.Envs.OpenAIAPIKey != "" {
+		taskChat = llm.NewOpenAIClient(config.Envs.OpenAIAPIKey)
+	}
+
 	taskStore := task.NewStore(s.db)
-	taskHandler := task.NewHandler(taskStore, userStore, animalStore, enclosureStore, notificationSender)
+	taskHandler := task.NewHandler(taskStore, userStore, animalStore, enclosureStore, notificationSender, webhooks, taskChat, []byte(config.Envs.JWTSecret))
 	taskHandler.RegisterRoutes(subrouter)
 	taskHandler.RegisterV2Routes(v2)
 
-	loopMessageStore := loopmessage.NewStore(s.db)
-	loopMessageHandler := loopmessage.NewHandler(loopMessageStore)
+	pauseStore := pause.NewStore(s.db)
+	pauseHandler := pause.NewHandler(pauseStore, userStore, taskStore, animalStore, enclosureStore)
+	pauseHandler.RegisterV2Routes(v2)
+
+	statsStore := stats.NewStore(s.db)
+	statsHandler := stats.NewHandler(statsStore, userStore)
+	statsHandler.RegisterV2Routes(v2)
+
+	webhookHandler := webhook.NewHandler(webhookStore, userStore, webhooks, config.Envs.IsProduction())
+	webhookHandler.RegisterV2Routes(v2)
+
+	loopMessageHandler := loopmessage.NewHandler(loopMessageStore, userStore, taskStore, animalStore, enclosureStore, webhooks, texts, []byte(config.Envs.JWTSecret), config.Envs.LoopMessageWebhookSecret)
 	loopMessageHandler.RegisterRoutes(subrouter)
+	loopMessageHandler.RegisterV2Routes(v2)
 
 	var headersOk = handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
-	frontendURL, ok := os.LookupEnv("FRONTEND_URL")
-	if !ok {
-		log.Fatal("FRONTEND_URL is not set")
-	}
 	// Allow both localhost and production frontend
 	allowedOrigins := []string{frontendURL}
 	productionURL, ok := os.LookupEnv("PRODUCTION_FRONTEND_URL")
@@ -104,9 +203,58 @@ func (s *APIServer) Run() error {
 	var originsOk = handlers.AllowedOrigins(allowedOrigins)
 	var methodsOk = handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"})
 
-	log.Println("Listening on", s.addr)
+	if config.Envs.MetricsToken != "" {
+		router.Handle("/debug/vars", withMetricsToken(config.Envs.MetricsToken, expvar.Handler())).Methods("GET")
+	}
+
+	server := &http.Server{Addr: s.addr, Handler: handlers.CORS(headersOk, originsOk, methodsOk)(router)}
+
+	// On SIGINT or SIGTERM, stop taking requests, let those in progress
+	// finish, answer the texts still being run, then send the pushes still
+	// queued, all within shutdownTimeout.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Println("Listening on", s.addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to finish requests before shutting down: %v", err)
+	}
+	if err := loopMessageHandler.Close(shutdownCtx); err != nil {
+		log.Printf("failed to answer texts before shutting down: %v", err)
+	}
+	if err := notificationSender.Close(shutdownCtx); err != nil {
+		log.Printf("failed to send queued pushes before shutting down: %v", err)
+	}
+
+	return nil
+}
+
+// withMetricsToken only lets through requests bearing token.
+func withMetricsToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid metrics token"))
+			return
+		}
 
-	return http.ListenAndServe(s.addr, handlers.CORS(headersOk, originsOk, methodsOk)(router))
+		next.ServeHTTP(w, r)
+	})
 }
 
 // handleOpenAPISpec serves the v2 API contract. It is public and
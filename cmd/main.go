@@ -4,6 +4,10 @@ import (
 	"database/sql"
 	"log"
 
+	// Embeds the tz database. The runtime image is bare alpine without
+	// tzdata, and user timezones are loaded by name.
+	_ "time/tzdata"
+
 	"github.com/whitallee/animal-family-backend/cmd/api"
 	"github.com/whitallee/animal-family-backend/config"
 	"github.com/whitallee/animal-family-backend/db"
@@ -0,0 +1,3 @@
+ALTER TABLE "tasks"
+DROP COLUMN IF EXISTS "snoozedUntil",
+DROP COLUMN IF EXISTS "dueAt";
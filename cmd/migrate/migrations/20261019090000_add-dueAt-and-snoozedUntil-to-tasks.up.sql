@@ -0,0 +1,9 @@
+ALTER TABLE "tasks"
+ADD COLUMN "dueAt" TIMESTAMP,
+ADD COLUMN "snoozedUntil" TIMESTAMP;
+
+UPDATE "tasks" SET "dueAt" = "lastCompleted" + ("repeatIntervHours" * interval '1 hour');
+
+ALTER TABLE "tasks"
+ALTER COLUMN "dueAt" SET NOT NULL,
+ALTER COLUMN "dueAt" SET DEFAULT CURRENT_TIMESTAMP;
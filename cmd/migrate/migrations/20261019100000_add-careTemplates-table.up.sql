@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS "careTemplates" (
+    "templateId" SERIAL PRIMARY KEY,
+    "speciesId" INTEGER,
+    "habitatId" INTEGER,
+    "taskName" VARCHAR(255) NOT NULL,
+    "taskDesc" VARCHAR(1500) NOT NULL,
+    "repeatIntervHours" INTEGER NOT NULL,
+    "lifeStage" VARCHAR(255) NOT NULL DEFAULT '',
+    "minAgeMonths" INTEGER,
+    "maxAgeMonths" INTEGER,
+
+    FOREIGN KEY ("speciesId") REFERENCES species("speciesId") ON DELETE CASCADE,
+    FOREIGN KEY ("habitatId") REFERENCES habitats("habitatId") ON DELETE CASCADE,
+    CHECK (("speciesId" IS NULL) <> ("habitatId" IS NULL)),
+    CHECK ("minAgeMonths" IS NULL OR "maxAgeMonths" IS NULL OR "minAgeMonths" < "maxAgeMonths")
+);
+
+CREATE INDEX idx_care_templates_species ON "careTemplates"("speciesId");
+CREATE INDEX idx_care_templates_habitat ON "careTemplates"("habitatId");
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "calendarFeeds";
+
+ALTER TABLE "users" DROP COLUMN IF EXISTS "timezone";
@@ -0,0 +1,11 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "timezone" VARCHAR(64) NOT NULL DEFAULT 'UTC';
+
+-- One feed per user. Only a hash of the token is kept, so a leaked database
+-- does not hand out working calendar URLs.
+CREATE TABLE IF NOT EXISTS "calendarFeeds" (
+    "userId" INTEGER PRIMARY KEY,
+    "tokenHash" VARCHAR(64) NOT NULL UNIQUE,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY ("userId") REFERENCES users("userId") ON DELETE CASCADE
+);
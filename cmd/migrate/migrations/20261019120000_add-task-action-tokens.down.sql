@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "usedActionTokens";
+
+ALTER TABLE "tasks" DROP COLUMN IF EXISTS "lastCompletedBy";
@@ -0,0 +1,8 @@
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "lastCompletedBy" INTEGER REFERENCES users("userId") ON DELETE SET NULL;
+
+-- Action tokens are stateless JWTs, so this is what makes them single use. A
+-- row only has to outlive the token it records; older rows are pruned.
+CREATE TABLE IF NOT EXISTS "usedActionTokens" (
+    "tokenId" VARCHAR(64) PRIMARY KEY,
+    "expiresAt" TIMESTAMP NOT NULL
+);
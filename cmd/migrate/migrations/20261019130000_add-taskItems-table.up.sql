@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "taskItems" (
+    "itemId" SERIAL PRIMARY KEY,
+    "taskId" INTEGER NOT NULL,
+    "position" INTEGER NOT NULL,
+    "itemText" VARCHAR(255) NOT NULL,
+    "done" BOOLEAN NOT NULL DEFAULT false,
+    "doneAt" TIMESTAMP,
+
+    FOREIGN KEY ("taskId") REFERENCES tasks("taskId") ON DELETE CASCADE
+);
+
+CREATE INDEX idx_task_items_task ON "taskItems"("taskId", "position");
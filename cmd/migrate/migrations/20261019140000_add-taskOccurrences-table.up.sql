@@ -0,0 +1,22 @@
+-- One row per occurrence of a task that was dealt with, either completed or
+-- deliberately skipped. History starts when this table does; earlier
+-- completions only ever survived as "tasks"."lastCompleted" and cannot be
+-- reconstructed.
+CREATE TABLE IF NOT EXISTS "taskOccurrences" (
+    "occurrenceId" SERIAL PRIMARY KEY,
+    "taskId" INTEGER NOT NULL,
+    "dueAt" TIMESTAMP NOT NULL,
+    "completedAt" TIMESTAMP,
+    "completedBy" INTEGER,
+    -- The interval in force for this occurrence, so later edits to the task
+    -- do not change how late past occurrences count as.
+    "intervalHours" INTEGER NOT NULL,
+    "outcome" VARCHAR(16) NOT NULL,
+
+    FOREIGN KEY ("taskId") REFERENCES tasks("taskId") ON DELETE CASCADE,
+    FOREIGN KEY ("completedBy") REFERENCES users("userId") ON DELETE SET NULL,
+    CHECK ("outcome" IN ('completed', 'skipped')),
+    CHECK (("outcome" = 'completed') = ("completedAt" IS NOT NULL))
+);
+
+CREATE INDEX idx_task_occurrences_task_due ON "taskOccurrences"("taskId", "dueAt");
@@ -0,0 +1,7 @@
+ALTER TABLE "tasks"
+    ADD COLUMN IF NOT EXISTS "priority" VARCHAR(16) NOT NULL DEFAULT 'normal'
+        CHECK ("priority" IN ('low', 'normal', 'high')),
+    ADD COLUMN IF NOT EXISTS "tags" TEXT[] NOT NULL DEFAULT '{}';
+
+-- Tag filters use array containment, which a GIN index can answer.
+CREATE INDEX IF NOT EXISTS idx_tasks_tags ON "tasks" USING GIN ("tags");
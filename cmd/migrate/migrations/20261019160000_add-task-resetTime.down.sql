@@ -0,0 +1,5 @@
+DROP FUNCTION IF EXISTS "taskNextDue"(INTEGER, TIMESTAMPTZ, INTEGER, TIME);
+
+ALTER TABLE "tasks" DROP COLUMN IF EXISTS "resetTime";
+
+ALTER TABLE "tasks" ALTER COLUMN "lastCompleted" TYPE TIMESTAMP USING "lastCompleted" AT TIME ZONE 'UTC';
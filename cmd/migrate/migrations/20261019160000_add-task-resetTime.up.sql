@@ -0,0 +1,39 @@
+-- Existing values were written in UTC, so that is the zone they are read in.
+ALTER TABLE "tasks" ALTER COLUMN "lastCompleted" TYPE TIMESTAMPTZ USING "lastCompleted" AT TIME ZONE 'UTC';
+
+-- A task with a reset time resets at that local time of day in its owner's
+-- timezone, every repeatIntervHours / 24 days, instead of a fixed number of
+-- hours after it was completed.
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "resetTime" TIME;
+
+-- "taskNextDue" is when a task completed at "completed" next falls due, as a
+-- UTC timestamp like "dueAt". Interval and reset time are passed in rather
+-- than read from the row, so an update can use the values it is writing.
+--
+-- For a calendar-day task, subtracting the reset time from the local wall
+-- clock makes the day being covered start at midnight: completing a 06:00
+-- task at 05:00 still covers yesterday's, and it resets at 06:00 today. The
+-- days are added to the wall clock, so a DST change does not move the reset
+-- time, and a reset time a spring-forward gap skips happens after the gap.
+CREATE OR REPLACE FUNCTION "taskNextDue"("taskId" INTEGER, "completed" TIMESTAMPTZ, "intervalHours" INTEGER, "resetTime" TIME)
+RETURNS TIMESTAMP
+LANGUAGE sql STABLE
+AS $$
+    SELECT CASE
+        WHEN "resetTime" IS NULL THEN
+            ("completed" AT TIME ZONE 'UTC') + ("intervalHours" * interval '1 hour')
+        ELSE
+            ((date_trunc('day', ("completed" AT TIME ZONE tz.name) - "resetTime")
+                + GREATEST("intervalHours" / 24, 1) * interval '1 day'
+                + "resetTime") AT TIME ZONE tz.name) AT TIME ZONE 'UTC'
+    END
+    FROM (
+        SELECT COALESCE((
+            SELECT u."timezone" FROM "taskUser" tu
+            INNER JOIN "users" u ON u."userId" = tu."userId"
+            WHERE tu."taskId" = "taskNextDue"."taskId"
+            ORDER BY tu."userId"
+            LIMIT 1
+        ), 'UTC') AS name
+    ) tz
+$$;
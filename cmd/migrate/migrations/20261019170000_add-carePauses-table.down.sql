@@ -0,0 +1,4 @@
+DROP VIEW IF EXISTS "pausedAnimals";
+DROP VIEW IF EXISTS "pausedTasks";
+DROP VIEW IF EXISTS "carePauseTasks";
+DROP TABLE IF EXISTS "carePauses";
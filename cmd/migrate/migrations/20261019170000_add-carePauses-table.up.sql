@@ -0,0 +1,45 @@
+-- A pause suspends the care schedule of one task, one animal or one
+-- enclosure. An enclosure's pause also covers the tasks of the animals in it.
+-- "endsAt" is NULL for a pause that runs until it is ended by hand.
+-- "resumedAt" records when the tasks it covered were put back on schedule.
+CREATE TABLE IF NOT EXISTS "carePauses" (
+    "pauseId" SERIAL PRIMARY KEY,
+    "userId" INTEGER NOT NULL,
+    "taskId" INTEGER,
+    "animalId" INTEGER,
+    "enclosureId" INTEGER,
+    "reason" VARCHAR(255) NOT NULL,
+    "startsAt" TIMESTAMP NOT NULL,
+    "endsAt" TIMESTAMP,
+    "resumedAt" TIMESTAMP,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY ("userId") REFERENCES users("userId") ON DELETE CASCADE,
+    FOREIGN KEY ("taskId") REFERENCES tasks("taskId") ON DELETE CASCADE,
+    FOREIGN KEY ("animalId") REFERENCES animals("animalId") ON DELETE CASCADE,
+    FOREIGN KEY ("enclosureId") REFERENCES enclosures("enclosureId") ON DELETE CASCADE,
+    CHECK (num_nonnulls("taskId", "animalId", "enclosureId") = 1),
+    CHECK ("endsAt" IS NULL OR "endsAt" > "startsAt")
+);
+
+CREATE INDEX idx_care_pauses_user ON "carePauses"("userId");
+
+-- Every task each pause covers, whether or not the pause is in force.
+CREATE VIEW "carePauseTasks" AS
+SELECT ts."taskId", p."pauseId", p."reason", p."startsAt", p."endsAt", p."resumedAt"
+FROM "carePauses" p
+INNER JOIN "taskSubject" ts ON ts."taskId" = p."taskId"
+    OR ts."animalId" = p."animalId"
+    OR ts."enclosureId" = p."enclosureId"
+    OR ts."animalId" IN (SELECT a."animalId" FROM "animals" a WHERE a."enclosureId" = p."enclosureId");
+
+-- The tasks and animals under a pause in force right now.
+CREATE VIEW "pausedTasks" AS
+SELECT * FROM "carePauseTasks"
+WHERE "startsAt" <= NOW() AND ("endsAt" IS NULL OR "endsAt" > NOW());
+
+CREATE VIEW "pausedAnimals" AS
+SELECT a."animalId", p."pauseId", p."reason", p."startsAt", p."endsAt"
+FROM "carePauses" p
+INNER JOIN "animals" a ON a."animalId" = p."animalId" OR a."enclosureId" = p."enclosureId"
+WHERE p."startsAt" <= NOW() AND (p."endsAt" IS NULL OR p."endsAt" > NOW());
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "taskEscalations";
+DROP TABLE IF EXISTS "taskBackupUsers";
@@ -0,0 +1,28 @@
+-- Other accounts the owner of a task has named to hear about it when it is
+-- left undone.
+CREATE TABLE IF NOT EXISTS "taskBackupUsers" (
+    "taskId" INTEGER NOT NULL,
+    "userId" INTEGER NOT NULL,
+
+    PRIMARY KEY ("taskId", "userId"),
+    FOREIGN KEY ("taskId") REFERENCES tasks("taskId") ON DELETE CASCADE,
+    FOREIGN KEY ("userId") REFERENCES users("userId") ON DELETE CASCADE
+);
+
+CREATE INDEX idx_task_backup_users_user ON "taskBackupUsers"("userId");
+
+-- A rule fires once a task has been outstanding for "afterHours" past its
+-- effective due time. "lastSentDueAt" is the "dueAt" of the occurrence it
+-- last fired for, so each rule fires at most once per occurrence and fires
+-- again for the next one.
+CREATE TABLE IF NOT EXISTS "taskEscalations" (
+    "escalationId" SERIAL PRIMARY KEY,
+    "taskId" INTEGER NOT NULL,
+    "afterHours" INTEGER NOT NULL CHECK ("afterHours" > 0),
+    "audience" VARCHAR(16) NOT NULL CHECK ("audience" IN ('backups', 'everyone')),
+    "urgency" VARCHAR(16) NOT NULL CHECK ("urgency" IN ('normal', 'high')),
+    "lastSentDueAt" TIMESTAMP,
+
+    UNIQUE ("taskId", "afterHours"),
+    FOREIGN KEY ("taskId") REFERENCES tasks("taskId") ON DELETE CASCADE
+);
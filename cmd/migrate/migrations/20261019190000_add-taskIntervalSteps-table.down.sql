@@ -0,0 +1,27 @@
+-- Restores "taskNextDue" as it was before steps.
+CREATE OR REPLACE FUNCTION "taskNextDue"("taskId" INTEGER, "completed" TIMESTAMPTZ, "intervalHours" INTEGER, "resetTime" TIME)
+RETURNS TIMESTAMP
+LANGUAGE sql STABLE
+AS $$
+    SELECT CASE
+        WHEN "resetTime" IS NULL THEN
+            ("completed" AT TIME ZONE 'UTC') + ("intervalHours" * interval '1 hour')
+        ELSE
+            ((date_trunc('day', ("completed" AT TIME ZONE tz.name) - "resetTime")
+                + GREATEST("intervalHours" / 24, 1) * interval '1 day'
+                + "resetTime") AT TIME ZONE tz.name) AT TIME ZONE 'UTC'
+    END
+    FROM (
+        SELECT COALESCE((
+            SELECT u."timezone" FROM "taskUser" tu
+            INNER JOIN "users" u ON u."userId" = tu."userId"
+            WHERE tu."taskId" = "taskNextDue"."taskId"
+            ORDER BY tu."userId"
+            LIMIT 1
+        ), 'UTC') AS name
+    ) tz
+$$;
+
+DROP FUNCTION IF EXISTS "taskInterval"(INTEGER, TIMESTAMPTZ);
+DROP FUNCTION IF EXISTS "ageInMonths"(DATE, DATE);
+DROP TABLE IF EXISTS "taskIntervalSteps";
@@ -0,0 +1,70 @@
+-- Steps make a task's interval depend on the age of its animal. The step in
+-- force is the first, by position, whose "untilAgeMonths" the animal has not
+-- yet reached; the last step has none and lasts for the rest of its life.
+CREATE TABLE IF NOT EXISTS "taskIntervalSteps" (
+    "taskId" INTEGER NOT NULL,
+    "position" INTEGER NOT NULL,
+    "untilAgeMonths" INTEGER CHECK ("untilAgeMonths" > 0),
+    "intervalHours" INTEGER NOT NULL CHECK ("intervalHours" > 0),
+
+    PRIMARY KEY ("taskId", "position"),
+    FOREIGN KEY ("taskId") REFERENCES tasks("taskId") ON DELETE CASCADE
+);
+
+-- "ageInMonths" counts whole months the way the API does for care templates:
+-- a month is only complete once the day of the month of the birthday comes
+-- round. An animal created without a dob holds 0001-01-01, which has no age.
+CREATE OR REPLACE FUNCTION "ageInMonths"("dob" DATE, "on" DATE)
+RETURNS INTEGER
+LANGUAGE sql IMMUTABLE
+AS $$
+    SELECT CASE WHEN "dob" = DATE '0001-01-01' THEN NULL ELSE GREATEST(
+        (EXTRACT(YEAR FROM "on")::int - EXTRACT(YEAR FROM "dob")::int) * 12
+        + EXTRACT(MONTH FROM "on")::int - EXTRACT(MONTH FROM "dob")::int
+        - CASE WHEN EXTRACT(DAY FROM "on") < EXTRACT(DAY FROM "dob") THEN 1 ELSE 0 END,
+    0) END
+$$;
+
+-- "taskInterval" is the stepped interval of a task at a moment, or NULL for a
+-- task without steps, or whose animal has no dob.
+CREATE OR REPLACE FUNCTION "taskInterval"("taskId" INTEGER, "at" TIMESTAMPTZ)
+RETURNS INTEGER
+LANGUAGE sql STABLE
+AS $$
+    SELECT s."intervalHours"
+    FROM "taskSubject" ts
+    INNER JOIN "animals" a ON a."animalId" = ts."animalId"
+    INNER JOIN "taskIntervalSteps" s ON s."taskId" = ts."taskId"
+    WHERE ts."taskId" = "taskInterval"."taskId"
+    AND (s."untilAgeMonths" IS NULL
+        OR "ageInMonths"(a."dob", ("at" AT TIME ZONE 'UTC')::date) < s."untilAgeMonths")
+    AND "ageInMonths"(a."dob", ("at" AT TIME ZONE 'UTC')::date) IS NOT NULL
+    ORDER BY s."position"
+    LIMIT 1
+$$;
+
+-- As before, except that a task with steps uses the interval for its
+-- animal's age when it was completed, whatever interval is passed in.
+CREATE OR REPLACE FUNCTION "taskNextDue"("taskId" INTEGER, "completed" TIMESTAMPTZ, "intervalHours" INTEGER, "resetTime" TIME)
+RETURNS TIMESTAMP
+LANGUAGE sql STABLE
+AS $$
+    SELECT CASE
+        WHEN "resetTime" IS NULL THEN
+            ("completed" AT TIME ZONE 'UTC') + (hours.n * interval '1 hour')
+        ELSE
+            ((date_trunc('day', ("completed" AT TIME ZONE tz.name) - "resetTime")
+                + GREATEST(hours.n / 24, 1) * interval '1 day'
+                + "resetTime") AT TIME ZONE tz.name) AT TIME ZONE 'UTC'
+    END
+    FROM (
+        SELECT COALESCE((
+            SELECT u."timezone" FROM "taskUser" tu
+            INNER JOIN "users" u ON u."userId" = tu."userId"
+            WHERE tu."taskId" = "taskNextDue"."taskId"
+            ORDER BY tu."userId"
+            LIMIT 1
+        ), 'UTC') AS name
+    ) tz,
+    (SELECT COALESCE("taskInterval"("taskNextDue"."taskId", "completed"), "intervalHours") AS n) hours
+$$;
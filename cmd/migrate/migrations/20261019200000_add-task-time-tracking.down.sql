@@ -0,0 +1,6 @@
+ALTER TABLE "taskOccurrences" DROP COLUMN IF EXISTS "durationMinutes";
+
+ALTER TABLE "tasks"
+    DROP COLUMN IF EXISTS "startedBy",
+    DROP COLUMN IF EXISTS "startedAt",
+    DROP COLUMN IF EXISTS "expectedMinutes";
@@ -0,0 +1,11 @@
+-- "expectedMinutes" is how long a task usually takes, for the workload
+-- estimate. "startedAt" is set while someone has a timer running on the task,
+-- and is cleared again when it is completed.
+ALTER TABLE "tasks"
+    ADD COLUMN IF NOT EXISTS "expectedMinutes" INTEGER CHECK ("expectedMinutes" > 0),
+    ADD COLUMN IF NOT EXISTS "startedAt" TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS "startedBy" INTEGER REFERENCES users("userId") ON DELETE SET NULL;
+
+-- How long the completion took, when it was timed or reported.
+ALTER TABLE "taskOccurrences"
+    ADD COLUMN IF NOT EXISTS "durationMinutes" INTEGER CHECK ("durationMinutes" >= 0);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "heldNotifications";
+DROP TABLE IF EXISTS "mutedNotificationChannels";
+DROP TABLE IF EXISTS "notificationPreferences";
@@ -0,0 +1,44 @@
+-- A user without a row has the defaults: every channel on, no quiet hours and
+-- no digest. Quiet hours are local times of day in the user's timezone, and
+-- may run across midnight.
+CREATE TABLE IF NOT EXISTS "notificationPreferences" (
+    "userId" INTEGER PRIMARY KEY,
+    "quietHoursStart" TIME,
+    "quietHoursEnd" TIME,
+    "digestEnabled" BOOLEAN NOT NULL DEFAULT false,
+    "digestMinutes" INTEGER NOT NULL DEFAULT 60 CHECK ("digestMinutes" BETWEEN 5 AND 1440),
+
+    FOREIGN KEY ("userId") REFERENCES users("userId") ON DELETE CASCADE,
+    CHECK (("quietHoursStart" IS NULL) = ("quietHoursEnd" IS NULL))
+);
+
+-- Only the channels a user has turned off are stored, so a channel added
+-- later starts out on for everyone.
+CREATE TABLE IF NOT EXISTS "mutedNotificationChannels" (
+    "userId" INTEGER NOT NULL,
+    "event" VARCHAR(32) NOT NULL,
+    "channel" VARCHAR(16) NOT NULL,
+
+    PRIMARY KEY ("userId", "event", "channel"),
+    FOREIGN KEY ("userId") REFERENCES users("userId") ON DELETE CASCADE
+);
+
+-- Notifications held back by quiet hours or a digest, to be delivered once
+-- "deliverAt" has passed. The payload is stored without its data block, which
+-- carries an action token that is minted on delivery so it is still fresh.
+CREATE TABLE IF NOT EXISTS "heldNotifications" (
+    "heldId" SERIAL PRIMARY KEY,
+    "userId" INTEGER NOT NULL,
+    "event" VARCHAR(32) NOT NULL,
+    "taskId" INTEGER NOT NULL,
+    "ownerId" INTEGER NOT NULL,
+    "urgency" VARCHAR(16) NOT NULL,
+    "payload" JSONB NOT NULL,
+    "deliverAt" TIMESTAMP NOT NULL,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY ("userId") REFERENCES users("userId") ON DELETE CASCADE,
+    FOREIGN KEY ("taskId") REFERENCES tasks("taskId") ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_held_notifications_deliver_at ON "heldNotifications" ("deliverAt");
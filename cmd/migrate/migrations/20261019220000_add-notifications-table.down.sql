@@ -0,0 +1,3 @@
+ALTER TABLE "heldNotifications" DROP COLUMN IF EXISTS "notificationId";
+
+DROP TABLE IF EXISTS "notifications";
@@ -0,0 +1,23 @@
+-- The notification center: a record of every notification sent to a user,
+-- whichever channels it was also delivered on. A notification outlives the
+-- task it was about.
+CREATE TABLE IF NOT EXISTS "notifications" (
+    "notificationId" SERIAL PRIMARY KEY,
+    "userId" INTEGER NOT NULL,
+    "event" VARCHAR(32) NOT NULL,
+    "taskId" INTEGER,
+    "title" TEXT NOT NULL,
+    "body" TEXT NOT NULL,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "readAt" TIMESTAMP,
+
+    FOREIGN KEY ("userId") REFERENCES users("userId") ON DELETE CASCADE,
+    FOREIGN KEY ("taskId") REFERENCES tasks("taskId") ON DELETE SET NULL
+);
+
+CREATE INDEX idx_notifications_user ON "notifications"("userId", "notificationId" DESC);
+CREATE INDEX idx_notifications_unread ON "notifications"("userId") WHERE "readAt" IS NULL;
+
+-- A held push delivers a notification center record.
+ALTER TABLE "heldNotifications"
+    ADD COLUMN IF NOT EXISTS "notificationId" INTEGER REFERENCES notifications("notificationId") ON DELETE CASCADE;
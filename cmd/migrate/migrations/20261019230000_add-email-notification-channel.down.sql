@@ -0,0 +1,3 @@
+DELETE FROM "notificationChannelSettings" WHERE "enabled";
+ALTER TABLE "notificationChannelSettings" DROP COLUMN "enabled";
+ALTER TABLE "notificationChannelSettings" RENAME TO "mutedNotificationChannels";
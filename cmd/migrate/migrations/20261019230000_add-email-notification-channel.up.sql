@@ -0,0 +1,6 @@
+-- Email is opt-in, unlike push, so the table can no longer store only the
+-- channels a user has turned off. It now stores every channel a user has set
+-- away from its default, with the setting; every existing row was a mute.
+ALTER TABLE "mutedNotificationChannels" RENAME TO "notificationChannelSettings";
+ALTER TABLE "notificationChannelSettings" ADD COLUMN "enabled" BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE "notificationChannelSettings" ALTER COLUMN "enabled" DROP DEFAULT;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS "loopMessages";
+DROP TABLE IF EXISTS "phoneVerifications";
+ALTER TABLE users DROP COLUMN IF EXISTS "smsOptedInAt";
+UPDATE users SET "phone" = NULL WHERE length("phone") > 15;
+ALTER TABLE users ALTER COLUMN "phone" TYPE VARCHAR(15);
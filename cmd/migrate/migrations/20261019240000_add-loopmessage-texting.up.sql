@@ -0,0 +1,36 @@
+-- "phone" is only ever set to a number the user has proved they hold, by
+-- replying with a code texted to it. E.164 numbers run to 15 digits after
+-- the "+", one more character than the column allowed.
+ALTER TABLE users ALTER COLUMN "phone" TYPE VARCHAR(16);
+ALTER TABLE users ADD COLUMN "smsOptedInAt" TIMESTAMP;
+
+-- A code texted to a number the user wants to add, kept as a hash. One per
+-- user: asking again replaces it.
+CREATE TABLE IF NOT EXISTS "phoneVerifications" (
+    "userId" INTEGER PRIMARY KEY,
+    "phone" VARCHAR(16) NOT NULL,
+    "codeHash" VARCHAR(64) NOT NULL,
+    "attempts" INTEGER NOT NULL DEFAULT 0,
+    "expiresAt" TIMESTAMP NOT NULL,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY ("userId") REFERENCES users("userId") ON DELETE CASCADE
+);
+
+-- Every text sent through LoopMessage, keyed by the ID LoopMessage gave it,
+-- with its delivery status as reported back by its status webhooks.
+CREATE TABLE IF NOT EXISTS "loopMessages" (
+    "messageId" VARCHAR(64) PRIMARY KEY,
+    "userId" INTEGER NOT NULL,
+    "notificationId" INTEGER,
+    "recipient" VARCHAR(16) NOT NULL,
+    "text" TEXT NOT NULL,
+    "status" VARCHAR(16) NOT NULL DEFAULT 'queued' CHECK ("status" IN ('queued', 'sent', 'failed')),
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updatedAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY ("userId") REFERENCES users("userId") ON DELETE CASCADE,
+    FOREIGN KEY ("notificationId") REFERENCES "notifications"("notificationId") ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_loop_messages_user_id ON "loopMessages" ("userId");
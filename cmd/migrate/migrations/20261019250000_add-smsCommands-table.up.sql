@@ -0,0 +1,13 @@
+-- Every text command received, keyed by LoopMessage's ID for the inbound
+-- message. A webhook delivered twice finds its row already here and is not
+-- run again. "command" and "reply" are filled in once it has run.
+CREATE TABLE IF NOT EXISTS "smsCommands" (
+    "messageId" VARCHAR(64) PRIMARY KEY,
+    "userId" INTEGER NOT NULL,
+    "text" TEXT NOT NULL,
+    "command" VARCHAR(16),
+    "reply" TEXT,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY ("userId") REFERENCES users("userId") ON DELETE CASCADE
+);
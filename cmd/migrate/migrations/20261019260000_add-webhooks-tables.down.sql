@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "webhookAttempts";
+DROP TABLE IF EXISTS "webhookDeliveries";
+DROP TABLE IF EXISTS "webhooks";
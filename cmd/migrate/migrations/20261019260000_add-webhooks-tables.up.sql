@@ -0,0 +1,55 @@
+-- Outgoing webhooks: each user can register URLs that are sent a signed JSON
+-- event when their tasks, animals or enclosures change. The secret signs the
+-- payloads, so it is kept as issued rather than hashed.
+CREATE TABLE IF NOT EXISTS "webhooks" (
+    "webhookId" SERIAL PRIMARY KEY,
+    "userId" INTEGER NOT NULL,
+    "url" TEXT NOT NULL,
+    "description" VARCHAR(255) NOT NULL DEFAULT '',
+    "events" TEXT[] NOT NULL,
+    "secret" VARCHAR(64) NOT NULL,
+    "enabled" BOOLEAN NOT NULL DEFAULT true,
+    -- Failed attempts since the last one that succeeded. The webhook is
+    -- disabled, and "disabledAt" set, once too many fail in a row.
+    "consecutiveFailures" INTEGER NOT NULL DEFAULT 0,
+    "disabledAt" TIMESTAMP,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY ("userId") REFERENCES users("userId") ON DELETE CASCADE
+);
+
+CREATE INDEX idx_webhooks_user ON "webhooks"("userId");
+
+-- One event sent to one webhook. "payload" is the exact body that was signed,
+-- so a retry sends the same bytes. A pending delivery is tried again at
+-- "nextAttemptAt".
+CREATE TABLE IF NOT EXISTS "webhookDeliveries" (
+    "deliveryId" SERIAL PRIMARY KEY,
+    "webhookId" INTEGER NOT NULL,
+    "event" VARCHAR(32) NOT NULL,
+    "payload" TEXT NOT NULL,
+    "status" VARCHAR(16) NOT NULL DEFAULT 'pending',
+    "attempts" INTEGER NOT NULL DEFAULT 0,
+    "nextAttemptAt" TIMESTAMP,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY ("webhookId") REFERENCES webhooks("webhookId") ON DELETE CASCADE
+);
+
+CREATE INDEX idx_webhookDeliveries_webhook ON "webhookDeliveries"("webhookId", "deliveryId" DESC);
+CREATE INDEX idx_webhookDeliveries_due ON "webhookDeliveries"("nextAttemptAt") WHERE "status" = 'pending';
+
+-- Each time a delivery was sent, and what came back. "responseStatus" is
+-- null when no response arrived at all, and "error" says why.
+CREATE TABLE IF NOT EXISTS "webhookAttempts" (
+    "attemptId" SERIAL PRIMARY KEY,
+    "deliveryId" INTEGER NOT NULL,
+    "responseStatus" INTEGER,
+    "error" TEXT,
+    "durationMs" INTEGER NOT NULL,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY ("deliveryId") REFERENCES "webhookDeliveries"("deliveryId") ON DELETE CASCADE
+);
+
+CREATE INDEX idx_webhookAttempts_delivery ON "webhookAttempts"("deliveryId");
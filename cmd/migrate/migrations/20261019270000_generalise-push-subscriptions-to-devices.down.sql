@@ -0,0 +1,13 @@
+-- Native registrations have no place in the old table.
+DELETE FROM "deviceRegistrations" WHERE "provider" <> 'webpush';
+
+ALTER TABLE "deviceRegistrations"
+    DROP CONSTRAINT "deviceRegistrations_webpush_keys",
+    DROP COLUMN "provider",
+    ALTER COLUMN "p256dh" SET NOT NULL,
+    ALTER COLUMN "auth" SET NOT NULL;
+
+ALTER INDEX idx_device_registrations_user RENAME TO idx_push_subscriptions_user;
+ALTER TABLE "deviceRegistrations" RENAME COLUMN "token" TO "endpoint";
+ALTER TABLE "deviceRegistrations" RENAME COLUMN "registrationId" TO "subscriptionId";
+ALTER TABLE "deviceRegistrations" RENAME TO "pushSubscriptions";
@@ -0,0 +1,15 @@
+-- A push subscription becomes one kind of device registration. Web push keeps
+-- its endpoint URL in "token" and needs its encryption keys; FCM and APNs
+-- registrations are a bare device token.
+ALTER TABLE "pushSubscriptions" RENAME TO "deviceRegistrations";
+ALTER TABLE "deviceRegistrations" RENAME COLUMN "subscriptionId" TO "registrationId";
+ALTER TABLE "deviceRegistrations" RENAME COLUMN "endpoint" TO "token";
+ALTER INDEX idx_push_subscriptions_user RENAME TO idx_device_registrations_user;
+
+ALTER TABLE "deviceRegistrations"
+    ADD COLUMN "provider" VARCHAR(16) NOT NULL DEFAULT 'webpush'
+        CHECK ("provider" IN ('webpush', 'fcm', 'apns')),
+    ALTER COLUMN "p256dh" DROP NOT NULL,
+    ALTER COLUMN "auth" DROP NOT NULL,
+    ADD CONSTRAINT "deviceRegistrations_webpush_keys"
+        CHECK ("provider" <> 'webpush' OR ("p256dh" IS NOT NULL AND "auth" IS NOT NULL));
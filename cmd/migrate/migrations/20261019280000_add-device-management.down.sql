@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS "deviceAlertSubjects";
+
+ALTER TABLE "deviceRegistrations"
+    DROP COLUMN "name",
+    DROP COLUMN "mutedUntil",
+    DROP COLUMN "alertsFiltered",
+    DROP COLUMN "lastSuccessAt",
+    DROP COLUMN "lastFailureAt",
+    DROP COLUMN "lastFailureStatus",
+    DROP COLUMN "failureCount";
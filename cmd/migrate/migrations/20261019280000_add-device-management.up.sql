@@ -0,0 +1,27 @@
+-- A device can be named, muted until a time, and limited to alerts about
+-- some animals and enclosures. Its delivery health is kept from real sends:
+-- "failureCount" is the failures since the last success.
+ALTER TABLE "deviceRegistrations"
+    ADD COLUMN "name" VARCHAR(64),
+    ADD COLUMN "mutedUntil" TIMESTAMP,
+    ADD COLUMN "alertsFiltered" BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN "lastSuccessAt" TIMESTAMP,
+    ADD COLUMN "lastFailureAt" TIMESTAMP,
+    ADD COLUMN "lastFailureStatus" INTEGER,
+    ADD COLUMN "failureCount" INTEGER NOT NULL DEFAULT 0;
+
+-- The animals and enclosures a filtered device alerts for. An enclosure
+-- covers the animals in it. A filtered device whose subjects have all been
+-- deleted alerts for nothing, rather than going back to everything.
+CREATE TABLE IF NOT EXISTS "deviceAlertSubjects" (
+    "registrationId" INTEGER NOT NULL,
+    "animalId" INTEGER,
+    "enclosureId" INTEGER,
+
+    FOREIGN KEY ("registrationId") REFERENCES "deviceRegistrations"("registrationId") ON DELETE CASCADE,
+    FOREIGN KEY ("animalId") REFERENCES animals("animalId") ON DELETE CASCADE,
+    FOREIGN KEY ("enclosureId") REFERENCES enclosures("enclosureId") ON DELETE CASCADE,
+    CHECK (num_nonnulls("animalId", "enclosureId") = 1),
+    UNIQUE ("registrationId", "animalId"),
+    UNIQUE ("registrationId", "enclosureId")
+);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_device_registrations_vapid_key;
+
+ALTER TABLE "deviceRegistrations"
+    DROP COLUMN "vapidKeyId",
+    DROP COLUMN "resubscribeRequestedAt";
+
+DROP TABLE IF EXISTS "vapidKeys";
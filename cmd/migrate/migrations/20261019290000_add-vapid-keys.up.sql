@@ -0,0 +1,30 @@
+-- The VAPID key pairs web push is signed with. A browser subscription only
+-- takes pushes signed with the key it was created against, so old keys stay
+-- usable after a rotation until no subscription is left on them.
+--
+-- Exactly one key is current: new subscriptions are made against it.
+-- "rotatedAt" is when a key stopped being current, and "retiredAt" when its
+-- last subscription went, at which point its private key is cleared.
+CREATE TABLE IF NOT EXISTS "vapidKeys" (
+    "vapidKeyId" SERIAL PRIMARY KEY,
+    "publicKey" TEXT NOT NULL UNIQUE,
+    "privateKey" TEXT NOT NULL,
+    "current" BOOLEAN NOT NULL DEFAULT FALSE,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    "rotatedAt" TIMESTAMP,
+    "retiredAt" TIMESTAMP,
+
+    CHECK (NOT ("current" AND "retiredAt" IS NOT NULL))
+);
+
+CREATE UNIQUE INDEX idx_vapid_keys_current ON "vapidKeys"("current") WHERE "current";
+
+-- The key each web push subscription was made against. Subscriptions from
+-- before this are assigned the configured key when the server starts.
+-- "resubscribeRequestedAt" is when a subscription on an old key was sent the
+-- push asking it to resubscribe, so it is asked only once.
+ALTER TABLE "deviceRegistrations"
+    ADD COLUMN "vapidKeyId" INTEGER REFERENCES "vapidKeys"("vapidKeyId") ON DELETE SET NULL,
+    ADD COLUMN "resubscribeRequestedAt" TIMESTAMP;
+
+CREATE INDEX idx_device_registrations_vapid_key ON "deviceRegistrations"("vapidKeyId");
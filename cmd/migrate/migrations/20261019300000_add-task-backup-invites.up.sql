@@ -0,0 +1,24 @@
+-- A backup must agree to hear about someone else's task. The owner invites an
+-- email address, and the account with that address accepts or declines. Until
+-- then the invite is kept by address rather than account, so inviting an
+-- address with no account looks exactly like inviting one that has.
+CREATE TABLE IF NOT EXISTS "taskBackupInvites" (
+    "taskId" INTEGER NOT NULL,
+    "email" VARCHAR(255) NOT NULL,
+    "invitedAt" TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+
+    PRIMARY KEY ("taskId", "email"),
+    FOREIGN KEY ("taskId") REFERENCES tasks("taskId") ON DELETE CASCADE
+);
+
+CREATE INDEX idx_task_backup_invites_email ON "taskBackupInvites"("email");
+
+-- Backups named before invites existed never agreed to it. They are invited
+-- instead, and hear nothing more until they accept.
+INSERT INTO "taskBackupInvites" ("taskId", "email")
+SELECT b."taskId", u."email"
+FROM "taskBackupUsers" b
+INNER JOIN "users" u ON u."userId" = b."userId"
+ON CONFLICT DO NOTHING;
+
+DELETE FROM "taskBackupUsers";
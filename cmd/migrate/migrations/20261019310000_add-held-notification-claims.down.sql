@@ -0,0 +1 @@
+ALTER TABLE "heldNotifications" DROP COLUMN IF EXISTS "claimedAt";
@@ -0,0 +1,4 @@
+-- A held notification is claimed when a sender takes it for delivery and only
+-- deleted once it has been queued. "claimedAt" lets another sweep take it
+-- again if the sender that claimed it stopped before getting that far.
+ALTER TABLE "heldNotifications" ADD COLUMN IF NOT EXISTS "claimedAt" TIMESTAMP;
@@ -0,0 +1,3 @@
+ALTER TABLE "users"
+    DROP COLUMN IF EXISTS "emailVerificationSentAt",
+    DROP COLUMN IF EXISTS "emailVerifiedAt";
@@ -0,0 +1,6 @@
+-- An address is verified once its owner follows the link emailed to it, and
+-- until then that link is the only email sent to it. Anyone can register
+-- with any address, so "emailVerificationSentAt" spaces the links out.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "emailVerifiedAt" TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS "emailVerificationSentAt" TIMESTAMP;
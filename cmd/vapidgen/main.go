@@ -3,11 +3,40 @@ package main
 import (
 	"encoding/base64"
 	"fmt"
+	"log"
+	"os"
 
 	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/whitallee/animal-family-backend/config"
+	"github.com/whitallee/animal-family-backend/db"
+	"github.com/whitallee/animal-family-backend/service/notification"
 )
 
+// With no arguments vapidgen prints a new key pair for a first setup's .env.
+//
+//	rotate	generates a key pair and makes it the current one in the database.
+//		Running servers pick it up within a minute; subscriptions on the old
+//		key are asked to resubscribe on the next sweep, and the old key is
+//		retired once none are left on it.
+//	list	shows the keys still in use and how many subscriptions are on each.
 func main() {
+	if len(os.Args) < 2 {
+		printKeys()
+		return
+	}
+
+	switch os.Args[1] {
+	case "rotate":
+		rotate(openStore())
+	case "list":
+		list(openStore())
+	default:
+		fmt.Fprintf(os.Stderr, "usage: vapidgen [rotate | list]\n")
+		os.Exit(2)
+	}
+}
+
+func printKeys() {
 	privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
 	if err != nil {
 		panic(err)
@@ -27,6 +56,75 @@ func main() {
 	fmt.Println("Public Key (for frontend):", publicKey)
 	fmt.Println()
 	fmt.Println("Keys are base64 encoded and ready to use.")
+	fmt.Println()
+	fmt.Println("To replace the keys of a running deployment, use 'vapidgen rotate' instead.")
+}
+
+func openStore() *notification.Store {
+	db, err := db.NewPostgresStorage(db.PostgresConfig{
+		Host:     config.Envs.DBHost,
+		Port:     config.Envs.DBPort,
+		User:     config.Envs.DBUser,
+		Password: config.Envs.DBPassword,
+		DBName:   config.Envs.DBName,
+		SSLMode:  config.Envs.DBSSLMode,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		log.Fatal(err)
+	}
+
+	return notification.NewStore(db, []byte(config.Envs.JWTSecret))
+}
+
+func rotate(store *notification.Store) {
+	privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The configured key is the one the server seeds from. Adding it first
+	// means a database that has never seen it does not take it as a new key
+	// when the server next starts, and make it current again.
+	if err := store.EnsureVAPIDKey(config.Envs.VAPIDPublicKey, config.Envs.VAPIDPrivateKey); err != nil {
+		log.Fatal(err)
+	}
+
+	vapidKeyId, err := store.CreateVAPIDKey(publicKey, privateKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Registered VAPID key %d as the current key.\n", vapidKeyId)
+	fmt.Println()
+	fmt.Println("Public Key (for frontend):", publicKey)
+	fmt.Println()
+	fmt.Println("The servers start using it within a minute. Subscriptions on older keys are")
+	fmt.Println("asked to resubscribe, and each old key is retired once none are left on it.")
+	fmt.Println("VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY can stay as they are; the database")
+	fmt.Println("now holds the keys.")
+}
+
+func list(store *notification.Store) {
+	keys, err := store.GetVAPIDKeys()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(keys) == 0 {
+		fmt.Println("No VAPID keys registered.")
+		return
+	}
+
+	for _, key := range keys {
+		status := "current"
+		if !key.Current && key.RotatedAt != nil {
+			status = "rotated " + key.RotatedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%d\t%s\t%d subscriptions\t%s\n", key.VAPIDKeyId, status, key.Subscriptions, key.PublicKey)
+	}
 }
 
 func init() {
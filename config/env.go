@@ -41,10 +41,43 @@ type Config struct {
 	JWTExpInSec int64
 	JWTSecret   string
 
+	// MetricsToken must be sent as a bearer token to read GET /debug/vars.
+	// The route is not served while it is empty.
+	MetricsToken string
+
 	VAPIDPublicKey  string
 	VAPIDPrivateKey string
 	VAPIDSubject    string
 
+	// Native push. FCM is on when FCMServiceAccount holds a service account
+	// key file's JSON, and APNs when APNsPrivateKey holds a .p8 key's PEM.
+	// The base URLs can point at a local fake for testing.
+	FCMBaseURL        string
+	FCMTokenURL       string
+	FCMServiceAccount string
+	APNsBaseURL       string
+	APNsKeyID         string
+	APNsTeamID        string
+	APNsTopic         string
+	APNsPrivateKey    string
+
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUser     string
+	SMTPPassword string
+	EmailFrom    string
+	// SupportEmail is the address account emails give for getting in touch,
+	// since EmailFrom does not take replies.
+	SupportEmail string
+
+	LoopMessageBaseURL    string
+	LoopMessageAuthKey    string
+	LoopMessageSecretKey  string
+	LoopMessageSenderName string
+	// LoopMessageWebhookSecret is what LoopMessage sends in the Authorization
+	// header of its webhooks. They are refused while it is empty.
+	LoopMessageWebhookSecret string
+
 	OpenAIAPIKey   string
 	S3AssetsBucket string
 	AWSRegion      string
@@ -78,10 +111,34 @@ func initConfig() Config {
 		JWTExpInSec: getEnvAsInt("JWT_EXP_IN_SEC", 3600*24*7),
 		JWTSecret:   getEnv("JWT_SECRET", "secretNotFoundAndNotSecretAnymore"),
 
+		MetricsToken: getEnv("METRICS_TOKEN", ""),
+
 		VAPIDPublicKey:  getEnv("VAPID_PUBLIC_KEY", ""),
 		VAPIDPrivateKey: getEnv("VAPID_PRIVATE_KEY", ""),
 		VAPIDSubject:    getEnv("VAPID_SUBJECT", "mailto:noreply@animalfamily.app"),
 
+		FCMBaseURL:        getEnv("FCM_BASE_URL", "https://fcm.googleapis.com"),
+		FCMTokenURL:       getEnv("FCM_TOKEN_URL", ""),
+		FCMServiceAccount: getEnv("FCM_SERVICE_ACCOUNT", ""),
+		APNsBaseURL:       getEnv("APNS_BASE_URL", "https://api.push.apple.com"),
+		APNsKeyID:         getEnv("APNS_KEY_ID", ""),
+		APNsTeamID:        getEnv("APNS_TEAM_ID", ""),
+		APNsTopic:         getEnv("APNS_TOPIC", ""),
+		APNsPrivateKey:    getEnv("APNS_PRIVATE_KEY", ""),
+
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUser:     getEnv("SMTP_USER", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		EmailFrom:    getEnv("EMAIL_FROM", "Animal Family <noreply@animalfamily.app>"),
+		SupportEmail: getEnv("SUPPORT_EMAIL", "support@animalfamily.app"),
+
+		LoopMessageBaseURL:       getEnv("LOOPMESSAGE_BASE_URL", "https://server.loopmessage.com"),
+		LoopMessageAuthKey:       getEnv("LOOPMESSAGE_AUTH_KEY", ""),
+		LoopMessageSecretKey:     getEnv("LOOPMESSAGE_SECRET_KEY", ""),
+		LoopMessageSenderName:    getEnv("LOOPMESSAGE_SENDER_NAME", ""),
+		LoopMessageWebhookSecret: getEnv("LOOPMESSAGE_WEBHOOK_SECRET", ""),
+
 		OpenAIAPIKey:   getEnv("OPENAI_API_KEY", ""),
 		S3AssetsBucket: getEnv("S3_ASSETS_BUCKET", "brindl-assets"),
 		AWSRegion:      getEnv("AWS_REGION", "us-east-1"),
@@ -0,0 +1,107 @@
+// Package dbtest gives store tests a migrated database of their own.
+package dbtest
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/lib/pq"
+)
+
+// Open connects to the database TEST_DATABASE_URL names, a postgres:// URL,
+// and skips the test if it is not set. Each test gets a schema of its own
+// with every migration applied, dropped again when the test ends, so tests
+// in different packages can share the database while go test runs them at
+// once.
+func Open(t *testing.T) *sql.DB {
+	t.Helper()
+
+	base := os.Getenv("TEST_DATABASE_URL")
+	if base == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := sql.Open("postgres", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = admin.Close() })
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + hex.EncodeToString(suffix)
+
+	if _, err := admin.Exec(`CREATE SCHEMA "` + schema + `"`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA "` + schema + `" CASCADE`); err != nil {
+			t.Errorf("failed to drop test schema %s: %v", schema, err)
+		}
+	})
+
+	dsn, err := url.Parse(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := dsn.Query()
+	query.Set("search_path", schema)
+	dsn.RawQuery = query.Encode()
+
+	applyMigrations(t, dsn.String())
+
+	db, err := sql.Open("postgres", dsn.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+// applyMigrations runs the migrations over a connection of their own, which
+// closing the migrator closes.
+func applyMigrations(t *testing.T, dsn string) {
+	t.Helper()
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		_ = db.Close()
+		t.Fatal(err)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance("file://"+migrationsDir(), "postgres", driver)
+	if err != nil {
+		_ = db.Close()
+		t.Fatal(err)
+	}
+	defer func() { _, _ = m.Close() }()
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		t.Fatal(err)
+	}
+}
+
+// migrationsDir is found from this file, since go test runs each package in
+// its own directory.
+func migrationsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+
+	return filepath.Join(filepath.Dir(file), "..", "..", "cmd", "migrate", "migrations")
+}
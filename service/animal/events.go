@@ -0,0 +1,34 @@
+package animal
+
+import (
+	"log"
+
+	"github.com/whitallee/animal-family-backend/service/webhook"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// publishAnimal tells the user's webhooks about a change to the animal,
+// sending it as GET /animals/{id} would return it now.
+func (h *Handler) publishAnimal(userID int, event string, id int) {
+	if h.webhooks == nil {
+		return
+	}
+
+	animal, err := h.store.GetAnimalById(id)
+	if err != nil {
+		log.Printf("failed to read animal %d for %s webhook: %v", id, event, err)
+		return
+	}
+
+	response, err := types.NewAnimalResponse(animal)
+	if err != nil {
+		log.Printf("failed to encode animal %d for %s webhook: %v", id, event, err)
+		return
+	}
+
+	h.webhooks.Publish(userID, event, response)
+}
+
+func (h *Handler) publishAnimalDeleted(userID int, id int) {
+	h.webhooks.Publish(userID, webhook.EventAnimalDeleted, map[string]int{"animalId": id})
+}
@@ -10,19 +10,22 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/webhook"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
 
 // Handler struct contains all of the stores needed for the service
 type Handler struct {
-	store          types.AnimalStore
-	userStore      types.UserStore
-	enclosureStore types.EnclosureStore
+	store             types.AnimalStore
+	userStore         types.UserStore
+	enclosureStore    types.EnclosureStore
+	careTemplateStore types.CareTemplateStore
+	webhooks          *webhook.Dispatcher
 }
 
-func NewHandler(store types.AnimalStore, userStore types.UserStore, enclosureStore types.EnclosureStore) *Handler {
-	return &Handler{store: store, userStore: userStore, enclosureStore: enclosureStore}
+func NewHandler(store types.AnimalStore, userStore types.UserStore, enclosureStore types.EnclosureStore, careTemplateStore types.CareTemplateStore, webhooks *webhook.Dispatcher) *Handler {
+	return &Handler{store: store, userStore: userStore, enclosureStore: enclosureStore, careTemplateStore: careTemplateStore, webhooks: webhooks}
 }
 
 func convertUpdatePayloadToAnimal(payload types.UpdateAnimalPayload, existingAnimal *types.Animal) (types.Animal, error) {
@@ -135,7 +138,7 @@ func (h *Handler) handleAdminCreateAnimal(w http.ResponseWriter, r *http.Request
 	}
 
 	// if it doesn't exist, create new animal
-	err = h.store.CreateAnimal(types.Animal{
+	_, err = h.store.CreateAnimal(types.Animal{
 		AnimalName:      animal.AnimalName,
 		SpeciesId:       animal.SpeciesId,
 		EnclosureId:     animal.EnclosureId,
@@ -184,7 +187,7 @@ func (h *Handler) handleUserCreateAnimal(w http.ResponseWriter, r *http.Request)
 	}
 
 	// if it doesn't exist, create new animal
-	err = h.store.CreateAnimal(types.Animal{
+	_, err = h.store.CreateAnimal(types.Animal{
 		AnimalName:      animal.AnimalName,
 		SpeciesId:       animal.SpeciesId,
 		EnclosureId:     animal.EnclosureId,
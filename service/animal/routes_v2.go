@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/caretemplate"
+	"github.com/whitallee/animal-family-backend/service/webhook"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
@@ -147,6 +150,7 @@ func (h *Handler) handleGetAnimal(w http.ResponseWriter, r *http.Request) {
 //
 //	@Id				createAnimal
 //	@Summary		Create an animal
+//	@Description	Set applyCareTemplates to also create a task from each of the species' care templates that suits the animal's age, in the same transaction.
 //	@Tags			animals
 //	@Accept			json
 //	@Produce		json
@@ -189,7 +193,7 @@ func (h *Handler) handleCreateAnimal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.store.CreateAnimal(types.Animal{
+	animal := types.Animal{
 		AnimalName:      payload.AnimalName,
 		SpeciesId:       payload.SpeciesId,
 		EnclosureId:     payload.EnclosureId,
@@ -200,12 +204,29 @@ func (h *Handler) handleCreateAnimal(w http.ResponseWriter, r *http.Request) {
 		DietDesc:        payload.DietDesc,
 		RoutineDesc:     payload.RoutineDesc,
 		ExtraNotes:      payload.ExtraNotes,
-	}, userID)
+	}
+
+	var id int
+	var err error
+	if payload.ApplyCareTemplates {
+		var templates []*types.CareTemplate
+		templates, err = h.careTemplateStore.GetCareTemplatesForAnimal(payload.SpeciesId, utils.AgeInMonths(payload.Dob, time.Now()))
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		id, err = h.store.CreateAnimalWithTasks(animal, caretemplate.TasksFromTemplates(templates), userID)
+	} else {
+		id, err = h.store.CreateAnimal(animal, userID)
+	}
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	h.publishAnimal(userID, webhook.EventAnimalCreated, id)
+
 	utils.WriteStatus(w, http.StatusCreated)
 }
 
@@ -261,6 +282,8 @@ func (h *Handler) handleUpdateAnimal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.publishAnimal(userID, webhook.EventAnimalUpdated, id)
+
 	utils.WriteStatus(w, http.StatusNoContent)
 }
 
@@ -335,6 +358,8 @@ func (h *Handler) handleSetAnimalMemorial(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	h.publishAnimal(auth.GetuserIdFromContext(r.Context()), webhook.EventAnimalMemorialized, id)
+
 	utils.WriteStatus(w, http.StatusNoContent)
 }
 
@@ -360,6 +385,8 @@ func (h *Handler) handleClearAnimalMemorial(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	h.publishAnimal(auth.GetuserIdFromContext(r.Context()), webhook.EventAnimalUpdated, id)
+
 	utils.WriteStatus(w, http.StatusNoContent)
 }
 
@@ -399,5 +426,7 @@ func (h *Handler) handleDeleteAnimal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.publishAnimalDeleted(auth.GetuserIdFromContext(r.Context()), id)
+
 	utils.WriteStatus(w, http.StatusNoContent)
 }
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/lib/pq"
+	"github.com/whitallee/animal-family-backend/service/task"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
@@ -17,11 +19,11 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-func (s *Store) CreateAnimal(animal types.Animal, userID int) error {
+func (s *Store) CreateAnimal(animal types.Animal, userID int) (int, error) {
 	// start transaction
 	tx, err := s.db.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// add animal to animals table
@@ -30,22 +32,22 @@ func (s *Store) CreateAnimal(animal types.Animal, userID int) error {
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING "animalId"`,
 		animal.AnimalName, animal.SpeciesId, animal.EnclosureId, animal.Image, animal.Gender, animal.Dob, animal.PersonalityDesc, animal.DietDesc, animal.RoutineDesc, animal.ExtraNotes, animal.IsMemorialized, animal.LastMessage, animal.MemorialPhotos, animal.MemorialDate).Scan(&addedAnimalId)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// add user-animal joiner to animalUser table
 	_, err = tx.Exec(`INSERT INTO "animalUser" ("animalId", "userId") VALUES ($1, $2)`, addedAnimalId, userID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// commit transation
 	err = tx.Commit()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return addedAnimalId, nil
 }
 
 func (s *Store) UpdateAnimal(animal types.Animal) error {
@@ -182,6 +184,10 @@ func (s *Store) GetAnimalById(animalId int) (*types.Animal, error) {
 		return nil, fmt.Errorf("animal not found")
 	}
 
+	if err := s.attachAnimalPauses([]*types.Animal{animal}); err != nil {
+		return nil, err
+	}
+
 	return animal, nil
 }
 
@@ -204,6 +210,10 @@ func (s *Store) GetAnimalsByUserId(userID int) ([]*types.Animal, error) {
 		animals = append(animals, animal)
 	}
 
+	if err := s.attachAnimalPauses(animals); err != nil {
+		return nil, err
+	}
+
 	return animals, nil
 }
 
@@ -225,6 +235,10 @@ func (s *Store) GetAnimalsByEnclosureId(enclosureId int) ([]*types.Animal, error
 		animals = append(animals, animal)
 	}
 
+	if err := s.attachAnimalPauses(animals); err != nil {
+		return nil, err
+	}
+
 	return animals, nil
 }
 
@@ -374,3 +388,75 @@ func (s *Store) ClearAnimalMemorial(animalId int) error {
 
 	return err
 }
+
+// CreateAnimalWithTasks is CreateAnimal plus the animal's initial tasks, in one
+// transaction so that an animal is never left without the tasks it was created
+// with, or the tasks without their animal.
+func (s *Store) CreateAnimalWithTasks(animal types.Animal, tasks []types.Task, userID int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// Rolls back every early return below. A no-op once Commit has succeeded.
+	defer func() { _ = tx.Rollback() }()
+
+	var addedAnimalId int
+	err = tx.QueryRow(`INSERT INTO "animals" ("animalName", "speciesId", "enclosureId", "image", "gender", "dob", "personalityDesc", "dietDesc", "routineDesc", "extraNotes", "isMemorialized", "lastMessage", "memorialPhotos", "memorialDate")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING "animalId"`,
+		animal.AnimalName, animal.SpeciesId, animal.EnclosureId, animal.Image, animal.Gender, animal.Dob, animal.PersonalityDesc, animal.DietDesc, animal.RoutineDesc, animal.ExtraNotes, animal.IsMemorialized, animal.LastMessage, animal.MemorialPhotos, animal.MemorialDate).Scan(&addedAnimalId)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO "animalUser" ("animalId", "userId") VALUES ($1, $2)`, addedAnimalId, userID); err != nil {
+		return 0, err
+	}
+
+	if err := task.InsertTasksTx(tx, tasks, &addedAnimalId, nil, userID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return addedAnimalId, nil
+}
+
+// attachAnimalPauses sets Pause on each animal under a pause in force, its
+// own or its enclosure's. An animal under several is given the one that holds
+// it longest.
+func (s *Store) attachAnimalPauses(animals []*types.Animal) error {
+	if len(animals) == 0 {
+		return nil
+	}
+
+	byId := make(map[int]*types.Animal, len(animals))
+	ids := make([]int, 0, len(animals))
+	for _, animal := range animals {
+		byId[animal.AnimalId] = animal
+		ids = append(ids, animal.AnimalId)
+	}
+
+	rows, err := s.db.Query(`SELECT DISTINCT ON (pa."animalId") pa."animalId", `+task.CarePauseColumns+`
+							FROM "pausedAnimals" pa INNER JOIN "carePauses" p ON p."pauseId" = pa."pauseId"
+							WHERE pa."animalId" = ANY($1)
+							ORDER BY pa."animalId", p."endsAt" DESC NULLS FIRST, p."pauseId"`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	now := time.Now()
+	for rows.Next() {
+		var animalId int
+		pause, err := task.ScanRowsIntoCarePause(rows, now, &animalId)
+		if err != nil {
+			return err
+		}
+
+		byId[animalId].Pause = pause
+	}
+
+	return rows.Err()
+}
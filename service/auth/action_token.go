@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ActionCompleteTask is the only action a token can currently authorise.
+const ActionCompleteTask = "complete-task"
+
+// TaskActionClaims authorise one action on one task for one user. The
+// registered ID is the token's identity for single-use tracking.
+type TaskActionClaims struct {
+	TaskId int    `json:"taskId"`
+	UserID int    `json:"userId"`
+	Action string `json:"action"`
+	jwt.RegisteredClaims
+}
+
+// CreateTaskActionToken issues a token that lets whoever holds it perform
+// action on taskID as userID, without a session, until ttl has passed.
+//
+// These travel in push payloads, which sit on devices and in service worker
+// storage, so they are signed with a key derived from the secret rather than
+// the secret itself. A leaked action token can then never pass for a session
+// token, nor a session token for an action token.
+func CreateTaskActionToken(secret []byte, action string, taskID int, userID int, ttl time.Duration) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, TaskActionClaims{
+		TaskId: taskID,
+		UserID: userID,
+		Action: action,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	})
+
+	return token.SignedString(actionTokenKey(secret))
+}
+
+// ParseTaskActionToken checks the signature, expiry and action of a token.
+// Whether it has been used before is the caller's concern, since that needs
+// the database.
+func ParseTaskActionToken(secret []byte, action string, tokenString string) (*TaskActionClaims, error) {
+	claims := new(TaskActionClaims)
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return actionTokenKey(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Action != action {
+		return nil, fmt.Errorf("token is for %q, not %q", claims.Action, action)
+	}
+
+	if claims.ID == "" {
+		return nil, fmt.Errorf("token has no id")
+	}
+
+	return claims, nil
+}
+
+func actionTokenKey(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("task-action-token"))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/config"
+)
+
+func TestTaskActionTokenRoundTrip(t *testing.T) {
+	secret := []byte("secret")
+
+	token, err := CreateTaskActionToken(secret, ActionCompleteTask, 12, 3, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseTaskActionToken(secret, ActionCompleteTask, token)
+	if err != nil {
+		t.Fatalf("expected a fresh token to parse, got %v", err)
+	}
+
+	if claims.TaskId != 12 || claims.UserID != 3 {
+		t.Errorf("got task %d user %d, want task 12 user 3", claims.TaskId, claims.UserID)
+	}
+	if claims.ID == "" {
+		t.Error("expected the token to carry an id for single-use tracking")
+	}
+}
+
+func TestTaskActionTokenRejects(t *testing.T) {
+	secret := []byte("secret")
+
+	expired, err := CreateTaskActionToken(secret, ActionCompleteTask, 1, 1, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherAction, err := CreateTaskActionToken(secret, "delete-task", 1, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherSecret, err := CreateTaskActionToken([]byte("other"), ActionCompleteTask, 1, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A session token is signed with the raw secret, so it must not be
+	// accepted in place of an action token.
+	session, err := CreateJWT(secret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"expired":          expired,
+		"another action":   otherAction,
+		"another secret":   otherSecret,
+		"a session token":  session,
+		"a malformed blob": "not-a-token",
+	}
+
+	for name, token := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseTaskActionToken(secret, ActionCompleteTask, token); err == nil {
+				t.Error("expected the token to be rejected")
+			}
+		})
+	}
+}
+
+// The reverse direction: an action token must not authenticate a session,
+// even when minted from the very secret sessions are verified with.
+func TestTaskActionTokenIsNotASessionToken(t *testing.T) {
+	secret := []byte(config.Envs.JWTSecret)
+
+	token, err := CreateTaskActionToken(secret, ActionCompleteTask, 1, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := validateToken(token); err == nil {
+		t.Error("expected session validation to reject an action token")
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmailVerificationTokenRoundTrip(t *testing.T) {
+	secret := []byte("secret")
+
+	token, err := CreateEmailVerificationToken(secret, 3, "sam@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseEmailVerificationToken(secret, token)
+	if err != nil {
+		t.Fatalf("expected the token to parse, got %v", err)
+	}
+
+	if claims.UserID != 3 || claims.Email != "sam@example.com" {
+		t.Errorf("got %+v, want user 3 at sam@example.com", claims)
+	}
+}
+
+func TestEmailVerificationTokenRejects(t *testing.T) {
+	secret := []byte("secret")
+
+	otherSecret, err := CreateEmailVerificationToken([]byte("other"), 3, "sam@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := CreateEmailVerificationToken(secret, 3, "sam@example.com", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Signed from the same secret, but with a different derived key.
+	unsubscribe, err := CreateUnsubscribeToken(secret, 3, "taskReset", "email", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"another secret":       otherSecret,
+		"an expired token":     expired,
+		"an unsubscribe token": unsubscribe,
+		"a malformed token":    "not-a-token",
+	}
+
+	for name, token := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseEmailVerificationToken(secret, token); err == nil {
+				t.Error("expected the token to be rejected")
+			}
+		})
+	}
+}
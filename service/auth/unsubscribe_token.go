@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// UnsubscribeClaims name the user and the kind of notification an unsubscribe
+// link turns off on one channel.
+type UnsubscribeClaims struct {
+	UserID  int    `json:"userId"`
+	Event   string `json:"event"`
+	Channel string `json:"channel"`
+	jwt.RegisteredClaims
+}
+
+// CreateUnsubscribeToken issues the token in an unsubscribe link, good until
+// ttl has passed. Using it more than once does no harm, since all it can do
+// is turn one channel off, but a link forwarded or left in an old mailbox
+// should not work for good. Like action tokens it is signed with its own
+// derived key, so it cannot pass for any other kind of token.
+func CreateUnsubscribeToken(secret []byte, userID int, event string, channel string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, UnsubscribeClaims{
+		UserID:  userID,
+		Event:   event,
+		Channel: channel,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	})
+
+	return token.SignedString(unsubscribeTokenKey(secret))
+}
+
+// ParseUnsubscribeToken checks the signature and expiry of a token. Tokens
+// issued before they carried an expiry are refused like expired ones.
+func ParseUnsubscribeToken(secret []byte, tokenString string) (*UnsubscribeClaims, error) {
+	claims := new(UnsubscribeClaims)
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return unsubscribeTokenKey(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.UserID == 0 || claims.Event == "" || claims.Channel == "" {
+		return nil, fmt.Errorf("token does not name a user, event and channel")
+	}
+
+	return claims, nil
+}
+
+func unsubscribeTokenKey(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("unsubscribe-token"))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestUnsubscribeTokenRoundTrip(t *testing.T) {
+	secret := []byte("secret")
+
+	token, err := CreateUnsubscribeToken(secret, 3, "taskReset", "email", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseUnsubscribeToken(secret, token)
+	if err != nil {
+		t.Fatalf("expected the token to parse, got %v", err)
+	}
+
+	if claims.UserID != 3 || claims.Event != "taskReset" || claims.Channel != "email" {
+		t.Errorf("got %+v, want user 3, taskReset on email", claims)
+	}
+}
+
+func TestUnsubscribeTokenRejects(t *testing.T) {
+	secret := []byte("secret")
+
+	otherSecret, err := CreateUnsubscribeToken([]byte("other"), 3, "taskReset", "email", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := CreateUnsubscribeToken(secret, 3, "taskReset", "email", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As issued before unsubscribe tokens expired.
+	unexpiring, err := jwt.NewWithClaims(jwt.SigningMethodHS256, UnsubscribeClaims{
+		UserID:  3,
+		Event:   "taskReset",
+		Channel: "email",
+	}).SignedString(unsubscribeTokenKey(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both are signed from the same secret, but with different derived keys.
+	action, err := CreateTaskActionToken(secret, ActionCompleteTask, 1, 3, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := CreateJWT(secret, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"another secret":         otherSecret,
+		"an expired token":       expired,
+		"a token with no expiry": unexpiring,
+		"an action token":        action,
+		"a session token":        session,
+		"a malformed token":      "not-a-token",
+	}
+
+	for name, token := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseUnsubscribeToken(secret, token); err == nil {
+				t.Error("expected the token to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package calendar
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/whitallee/animal-family-backend/service/task"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+const (
+	icsLocalTime = "20060102T150405"
+	icsUTCTime   = "20060102T150405Z"
+
+	// icsLineLimit is the longest a content line may be, in octets, before it
+	// has to be folded (RFC 5545 section 3.1).
+	icsLineLimit = 75
+
+	// eventDuration gives each occurrence a visible block in calendar views.
+	// Tasks have no length of their own.
+	eventDuration = "PT30M"
+
+	// timezoneYears is how far ahead the VTIMEZONE lists the zone's offset
+	// changes. Subscribers refetch the feed far more often than that, so the
+	// window moves along with them.
+	timezoneYears = 5
+)
+
+// renderCalendar writes the feed as an iCalendar document with one recurring
+// VEVENT per task, starting at its next occurrence.
+//
+// Events rather than VTODOs because Google Calendar, the most common
+// subscriber, ignores VTODO entirely.
+//
+// Start times carry the zone's IANA name as their TZID, defined by a
+// VTIMEZONE block as RFC 5545 requires. A recurrence that is a whole number of
+// days is written as DAILY so it keeps its wall-clock time across daylight
+// saving changes, which is how an owner thinks of "feed every morning".
+func renderCalendar(tasks []*types.CalendarTask, loc *time.Location, now time.Time) string {
+	var b strings.Builder
+
+	writeLine(&b, "BEGIN:VCALENDAR")
+	writeLine(&b, "VERSION:2.0")
+	writeLine(&b, "PRODID:-//Animal Family//Care Tasks//EN")
+	writeLine(&b, "CALSCALE:GREGORIAN")
+	writeLine(&b, "METHOD:PUBLISH")
+	writeLine(&b, "X-WR-CALNAME:Animal Family care")
+	writeLine(&b, "X-WR-TIMEZONE:"+loc.String())
+
+	if loc != time.UTC {
+		from := now
+		for _, t := range tasks {
+			if due := task.EffectiveDue(&t.TaskWithSubject); due.Before(from) {
+				from = due
+			}
+		}
+		writeTimezone(&b, loc, from.Add(-24*time.Hour), now.AddDate(timezoneYears, 0, 0))
+	}
+
+	stamp := now.UTC().Format(icsUTCTime)
+	for _, t := range tasks {
+		writeLine(&b, "BEGIN:VEVENT")
+		writeLine(&b, fmt.Sprintf("UID:task-%d@animal-family", t.TaskId))
+		writeLine(&b, "DTSTAMP:"+stamp)
+		writeLine(&b, dtstart(task.EffectiveDue(&t.TaskWithSubject), loc))
+		writeLine(&b, "DURATION:"+eventDuration)
+		if rule := recurrenceRule(t.RepeatIntervHours); rule != "" {
+			writeLine(&b, "RRULE:"+rule)
+		}
+		writeLine(&b, "SUMMARY:"+escapeText(eventSummary(t)))
+		if t.TaskDesc != "" {
+			writeLine(&b, "DESCRIPTION:"+escapeText(t.TaskDesc))
+		}
+		writeLine(&b, "END:VEVENT")
+	}
+
+	writeLine(&b, "END:VCALENDAR")
+
+	return b.String()
+}
+
+// dtstart formats a start time in the feed's zone. UTC is written in the
+// trailing-Z form, which needs no TZID at all.
+func dtstart(start time.Time, loc *time.Location) string {
+	if loc == time.UTC {
+		return "DTSTART:" + start.UTC().Format(icsUTCTime)
+	}
+
+	return "DTSTART;TZID=" + loc.String() + ":" + start.In(loc).Format(icsLocalTime)
+}
+
+// writeTimezone writes a VTIMEZONE for loc covering from to until: the offset
+// in force at from, then each change of offset after it. Every change is its
+// own observance rather than a yearly rule, since Go's zone data only gives
+// the instants, and a zone's rules do change over the years.
+func writeTimezone(b *strings.Builder, loc *time.Location, from, until time.Time) {
+	writeLine(b, "BEGIN:VTIMEZONE")
+	writeLine(b, "TZID:"+loc.String())
+
+	writeObservance(b, from.In(loc), from.In(loc))
+	for _, at := range zoneTransitions(loc, from, until) {
+		writeObservance(b, at.Add(-time.Second).In(loc), at.In(loc))
+	}
+
+	writeLine(b, "END:VTIMEZONE")
+}
+
+// writeObservance writes the observance that starts at at, coming from the
+// offset in force at before. Its DTSTART is the wall-clock time of the change
+// in the old offset, as RFC 5545 counts it.
+func writeObservance(b *strings.Builder, before, at time.Time) {
+	_, fromOffset := before.Zone()
+	name, toOffset := at.Zone()
+
+	kind := "STANDARD"
+	if at.IsDST() {
+		kind = "DAYLIGHT"
+	}
+
+	writeLine(b, "BEGIN:"+kind)
+	writeLine(b, "DTSTART:"+at.In(time.FixedZone("", fromOffset)).Format(icsLocalTime))
+	writeLine(b, "TZOFFSETFROM:"+utcOffset(fromOffset))
+	writeLine(b, "TZOFFSETTO:"+utcOffset(toOffset))
+	writeLine(b, "TZNAME:"+name)
+	writeLine(b, "END:"+kind)
+}
+
+// zoneTransitions returns the instants between from and until at which loc
+// changes offset. It steps a day at a time, which no zone changes twice
+// within, then narrows each change down to the second.
+func zoneTransitions(loc *time.Location, from, until time.Time) []time.Time {
+	var transitions []time.Time
+
+	offset := func(t time.Time) int {
+		_, o := t.In(loc).Zone()
+		return o
+	}
+
+	for day := from; day.Before(until); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		if offset(day) == offset(next) {
+			continue
+		}
+
+		lo, hi := day, next
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2).Truncate(time.Second)
+			if offset(mid) == offset(lo) {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		transitions = append(transitions, hi)
+	}
+
+	return transitions
+}
+
+// utcOffset formats an offset in seconds as RFC 5545's UTC-OFFSET, with the
+// seconds only when there are any.
+func utcOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+
+	formatted := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		formatted += fmt.Sprintf("%02d", seconds%60)
+	}
+
+	return formatted
+}
+
+func recurrenceRule(intervalHours int) string {
+	switch {
+	case intervalHours <= 0:
+		return ""
+	case intervalHours%24 == 0:
+		return fmt.Sprintf("FREQ=DAILY;INTERVAL=%d", intervalHours/24)
+	default:
+		return fmt.Sprintf("FREQ=HOURLY;INTERVAL=%d", intervalHours)
+	}
+}
+
+func eventSummary(t *types.CalendarTask) string {
+	if t.SubjectName == "" {
+		return t.TaskName
+	}
+
+	return t.TaskName + " (" + t.SubjectName + ")"
+}
+
+// escapeText escapes a TEXT value. Carriage returns are dropped so a
+// Windows-style line break becomes one \n rather than two.
+func escapeText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r", "",
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// writeLine folds a content line at icsLineLimit octets and terminates each
+// physical line with CRLF. A fold never splits a multi-byte character, since
+// clients that decode line by line would otherwise see invalid UTF-8.
+func writeLine(b *strings.Builder, line string) {
+	limit := icsLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+
+		// Continuation lines lose one octet to the leading space.
+		limit = icsLineLimit - 1
+	}
+
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
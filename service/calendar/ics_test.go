@@ -0,0 +1,160 @@
+package calendar
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+var feedNow = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func TestRenderCalendarUsesOwnersTimezone(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 08:00 UTC on a date in British Summer Time is 09:00 local.
+	due := time.Date(2026, 6, 1, 8, 0, 0, 0, time.UTC)
+	tasks := []*types.CalendarTask{{
+		TaskWithSubject: types.TaskWithSubject{TaskId: 7, TaskName: "Feed", TaskDesc: "Two crickets", RepeatIntervHours: 48, DueAt: due},
+		SubjectName:     "Rex",
+	}}
+
+	ics := renderCalendar(tasks, london, feedNow)
+
+	for _, want := range []string{
+		"X-WR-TIMEZONE:Europe/London\r\n",
+		"UID:task-7@animal-family\r\n",
+		"DTSTAMP:20260310T120000Z\r\n",
+		"DTSTART;TZID=Europe/London:20260601T090000\r\n",
+		"RRULE:FREQ=DAILY;INTERVAL=2\r\n",
+		"SUMMARY:Feed (Rex)\r\n",
+		"DESCRIPTION:Two crickets\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("feed is missing %q:\n%s", want, ics)
+		}
+	}
+}
+
+// A TZID must be defined by a VTIMEZONE in the same calendar, or strict
+// clients reject the feed or read the times as floating.
+func TestRenderCalendarDefinesTimezone(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := []*types.CalendarTask{{
+		TaskWithSubject: types.TaskWithSubject{TaskId: 7, TaskName: "Feed", RepeatIntervHours: 24, DueAt: feedNow},
+	}}
+
+	ics := renderCalendar(tasks, london, feedNow)
+
+	for _, want := range []string{
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/London\r\n",
+		// Clocks go forward at 01:00 GMT on the last Sunday of March...
+		"BEGIN:DAYLIGHT\r\nDTSTART:20260329T010000\r\nTZOFFSETFROM:+0000\r\nTZOFFSETTO:+0100\r\nTZNAME:BST\r\nEND:DAYLIGHT\r\n",
+		// ...and back at 02:00 BST on the last Sunday of October.
+		"BEGIN:STANDARD\r\nDTSTART:20261025T020000\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0000\r\nTZNAME:GMT\r\nEND:STANDARD\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("feed is missing %q:\n%s", want, ics)
+		}
+	}
+
+	if strings.Index(ics, "END:VTIMEZONE") > strings.Index(ics, "BEGIN:VEVENT") {
+		t.Error("the VTIMEZONE should come before the events that use it")
+	}
+
+	if utcFeed := renderCalendar(tasks, time.UTC, feedNow); strings.Contains(utcFeed, "VTIMEZONE") {
+		t.Error("a UTC feed writes its times with Z and needs no VTIMEZONE")
+	}
+}
+
+func TestUTCOffset(t *testing.T) {
+	cases := map[int]string{0: "+0000", 3600: "+0100", -18000: "-0500", 19800: "+0530", -2670: "-004430"}
+	for seconds, want := range cases {
+		if got := utcOffset(seconds); got != want {
+			t.Errorf("utcOffset(%d) = %q, want %q", seconds, got, want)
+		}
+	}
+}
+
+func TestRenderCalendarStartsAtSnoozeEnd(t *testing.T) {
+	due := feedNow.Add(-time.Hour)
+	snoozed := feedNow.Add(2 * time.Hour)
+	tasks := []*types.CalendarTask{{
+		TaskWithSubject: types.TaskWithSubject{TaskId: 1, TaskName: "Mist", RepeatIntervHours: 12, DueAt: due, SnoozedUntil: &snoozed},
+	}}
+
+	ics := renderCalendar(tasks, time.UTC, feedNow)
+
+	if !strings.Contains(ics, "DTSTART:20260310T140000Z\r\n") {
+		t.Errorf("expected the event to start when the snooze ends:\n%s", ics)
+	}
+	if !strings.Contains(ics, "RRULE:FREQ=HOURLY;INTERVAL=12\r\n") {
+		t.Errorf("expected an hourly rule for a 12 hour interval:\n%s", ics)
+	}
+	if strings.Contains(ics, "DESCRIPTION") {
+		t.Errorf("an empty description should be left out:\n%s", ics)
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	got := escapeText("Clean; rinse, dry\r\nRepeat \\ done")
+	want := `Clean\; rinse\, dry\nRepeat \\ done`
+
+	if got != want {
+		t.Errorf("escapeText = %q, want %q", got, want)
+	}
+}
+
+// Folded lines must stay within the limit and must not split a multi-byte
+// character across the fold.
+func TestWriteLineFolds(t *testing.T) {
+	var b strings.Builder
+	line := "DESCRIPTION:" + strings.Repeat("é", 100)
+	writeLine(&b, line)
+
+	physical := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n")
+	if len(physical) < 2 {
+		t.Fatalf("expected the line to be folded, got %q", b.String())
+	}
+
+	var unfolded strings.Builder
+	for i, l := range physical {
+		if len(l) > icsLineLimit {
+			t.Errorf("line %d is %d octets, over the limit", i, len(l))
+		}
+		if !utf8.ValidString(l) {
+			t.Errorf("line %d is not valid UTF-8: %q", i, l)
+		}
+		if i > 0 {
+			if !strings.HasPrefix(l, " ") {
+				t.Errorf("continuation line %d does not start with a space", i)
+			}
+			l = l[1:]
+		}
+		unfolded.WriteString(l)
+	}
+
+	if unfolded.String() != line {
+		t.Errorf("unfolding did not give back the original line")
+	}
+}
+
+func TestFeedResponse(t *testing.T) {
+	got := feedResponse("https://api.example.com/", "abc")
+
+	if got.Url != "https://api.example.com/api/v2/calendar/abc.ics" {
+		t.Errorf("Url = %q", got.Url)
+	}
+	if got.WebcalUrl != "webcal://api.example.com/api/v2/calendar/abc.ics" {
+		t.Errorf("WebcalUrl = %q", got.WebcalUrl)
+	}
+}
@@ -0,0 +1,196 @@
+package calendar
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/whitallee/animal-family-backend/config"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// feedTokenBytes is the amount of randomness in a feed token. The token is the
+// only credential on the feed URL, so it has to be unguessable on its own.
+const feedTokenBytes = 32
+
+type Handler struct {
+	store     types.CalendarFeedStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.CalendarFeedStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+// RegisterV2Routes mounts the calendar feed routes. There is no v1 equivalent.
+//
+// The feed itself cannot use JWT auth: calendar apps poll a bare URL and have
+// no way to send an Authorization header. The token in the path stands in for
+// it, and is managed through the authenticated /calendar/feed routes.
+func (h *Handler) RegisterV2Routes(router *mux.Router) {
+	router.HandleFunc("/calendar/feed", auth.WithJWTAuth(h.handleRegenerateFeed, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/calendar/feed", auth.WithJWTAuth(h.handleRevokeFeed, h.userStore)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/calendar/{token:[A-Za-z0-9_-]+}.ics", h.handleGetFeed).Methods(http.MethodGet)
+}
+
+// handleRegenerateFeed godoc
+//
+//	@Id				regenerateCalendarFeed
+//	@Summary		Issue a new calendar feed URL
+//	@Description	Replaces any existing feed URL, which stops working immediately. The URL is only returned by this call, so store it or subscribe straight away.
+//	@Tags			calendar
+//	@Produce		json
+//	@Success		201	{object}	types.CalendarFeedResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/calendar/feed [post]
+func (h *Handler) handleRegenerateFeed(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	token, err := newFeedToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.SetCalendarFeedToken(userID, hashFeedToken(token)); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, feedResponse(config.Envs.PublicHost, token))
+}
+
+// handleRevokeFeed godoc
+//
+//	@Id				revokeCalendarFeed
+//	@Summary		Turn off the calendar feed
+//	@Description	The current feed URL stops working. Subscribed calendars keep the events they last fetched until they are unsubscribed.
+//	@Tags			calendar
+//	@Produce		json
+//	@Success		204
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		404	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/calendar/feed [delete]
+func (h *Handler) handleRevokeFeed(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	if err := h.store.DeleteCalendarFeed(userID); err != nil {
+		if errors.Is(err, ErrFeedNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteStatus(w, http.StatusNoContent)
+}
+
+// handleGetFeed godoc
+//
+//	@Id				getCalendarFeed
+//	@Summary		Fetch the iCalendar feed
+//	@Description	Authenticated by the token in the path rather than a header. Each task is one recurring event starting at its next occurrence, in the owner's timezone. Pass animalId for one animal's tasks, or enclosureId for an enclosure's tasks together with those of the animals in it.
+//	@Tags			calendar
+//	@Produce		text/calendar
+//	@Param			token		path		string	true	"Feed token from POST /calendar/feed"
+//	@Param			animalId	query		int		false	"Only this animal's tasks"
+//	@Param			enclosureId	query		int		false	"Only this enclosure's tasks and its animals' tasks"
+//	@Success		200			{string}	string	"iCalendar document"
+//	@Failure		400			{object}	types.ErrorResponse
+//	@Failure		404			{object}	types.ErrorResponse
+//	@Failure		500			{object}	types.ErrorResponse
+//	@Router			/calendar/{token}.ics [get]
+func (h *Handler) handleGetFeed(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	animalId, err := utils.ParseOptionalIDQuery(r, "animalId")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	enclosureId, err := utils.ParseOptionalIDQuery(r, "enclosureId")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if animalId != nil && enclosureId != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("filter by animalId or enclosureId, not both"))
+		return
+	}
+
+	// An unknown token and a revoked one look the same, and neither says
+	// whether the other half of a guessed URL was right.
+	userID, err := h.store.GetUserIdByCalendarFeedToken(hashFeedToken(token))
+	if errors.Is(err, ErrFeedNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	u, err := h.userStore.GetUserById(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	tasks, err := h.store.GetCalendarTasks(userID, animalId, enclosureId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="animal-family.ics"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(renderCalendar(tasks, u.Location(), time.Now())))
+}
+
+func newFeedToken() (string, error) {
+	raw := make([]byte, feedTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// feedResponse builds both forms of the feed address. The webcal:// form is
+// what makes a tap on a phone open the subscribe dialog rather than download
+// the file once.
+func feedResponse(publicHost string, token string) types.CalendarFeedResponse {
+	url := strings.TrimSuffix(publicHost, "/") + "/api/v2/calendar/" + token + ".ics"
+
+	webcal := url
+	if _, rest, ok := strings.Cut(url, "://"); ok {
+		webcal = "webcal://" + rest
+	}
+
+	return types.CalendarFeedResponse{Url: url, WebcalUrl: webcal}
+}
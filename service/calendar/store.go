@@ -0,0 +1,107 @@
+package calendar
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// ErrFeedNotFound is returned when the user has no calendar feed, or no feed
+// has the token. Handlers map it to 404.
+var ErrFeedNotFound = errors.New("calendar feed not found")
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) SetCalendarFeedToken(userID int, tokenHash string) error {
+	_, err := s.db.Exec(`INSERT INTO "calendarFeeds" ("userId", "tokenHash") VALUES ($1, $2)
+						ON CONFLICT ("userId") DO UPDATE SET "tokenHash" = EXCLUDED."tokenHash", "createdAt" = CURRENT_TIMESTAMP`,
+		userID, tokenHash)
+
+	return err
+}
+
+func (s *Store) DeleteCalendarFeed(userID int) error {
+	result, err := s.db.Exec(`DELETE FROM "calendarFeeds" WHERE "userId" = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrFeedNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) GetUserIdByCalendarFeedToken(tokenHash string) (int, error) {
+	var userID int
+	err := s.db.QueryRow(`SELECT "userId" FROM "calendarFeeds" WHERE "tokenHash" = $1`, tokenHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrFeedNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (s *Store) GetCalendarTasks(userID int, animalId *int, enclosureId *int) ([]*types.CalendarTask, error) {
+	// The enclosure filter also matches tasks on the animals living in it, so
+	// a feed for a tank shows feeding its fish as well as cleaning the glass.
+	// Paused tasks are left out until their pause ends.
+	rows, err := s.db.Query(`SELECT t."taskId", t."taskName", t."taskDesc", t."complete", t."lastCompleted", t."repeatIntervHours",
+							ts."animalId", ts."enclosureId", t."dueAt", t."snoozedUntil", t."lastCompletedBy", COALESCE(a."animalName", e."enclosureName", '')
+							FROM "tasks" t
+							INNER JOIN "taskUser" tu ON tu."taskId" = t."taskId"
+							INNER JOIN "taskSubject" ts ON ts."taskId" = t."taskId"
+							LEFT JOIN "animals" a ON a."animalId" = ts."animalId"
+							LEFT JOIN "enclosures" e ON e."enclosureId" = ts."enclosureId"
+							WHERE tu."userId" = $1
+							AND ($2::int IS NULL OR ts."animalId" = $2)
+							AND ($3::int IS NULL OR ts."enclosureId" = $3 OR a."enclosureId" = $3)
+							AND t."taskId" NOT IN (SELECT "taskId" FROM "pausedTasks")
+							ORDER BY t."dueAt", t."taskId"`, userID, animalId, enclosureId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	tasks := make([]*types.CalendarTask, 0)
+	for rows.Next() {
+		task := new(types.CalendarTask)
+		err := rows.Scan(
+			&task.TaskId,
+			&task.TaskName,
+			&task.TaskDesc,
+			&task.Complete,
+			&task.LastCompleted,
+			&task.RepeatIntervHours,
+			&task.AnimalId,
+			&task.EnclosureId,
+			&task.DueAt,
+			&task.SnoozedUntil,
+			&task.LastCompletedBy,
+			&task.SubjectName,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, rows.Err()
+}
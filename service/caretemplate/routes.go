@@ -0,0 +1,253 @@
+package caretemplate
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+type Handler struct {
+	store     types.CareTemplateStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.CareTemplateStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+// RegisterV2Routes mounts the care template routes. There is no v1
+// equivalent.
+//
+// Templates are global reference data like the species and habitats they hang
+// off, so reading them is public and changing them is admin only.
+func (h *Handler) RegisterV2Routes(router *mux.Router) {
+	router.HandleFunc("/care-templates", h.handleListCareTemplates).Methods(http.MethodGet)
+
+	router.HandleFunc("/care-templates", auth.WithJWTAuth(auth.RequireAdmin(h.handleCreateCareTemplate), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/care-templates/{id}", auth.WithJWTAuth(auth.RequireAdmin(h.handleUpdateCareTemplate), h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/care-templates/{id}", auth.WithJWTAuth(auth.RequireAdmin(h.handleDeleteCareTemplate), h.userStore)).Methods(http.MethodDelete)
+}
+
+// handleListCareTemplates godoc
+//
+//	@Id				listCareTemplates
+//	@Summary		List care templates
+//	@Description	Pass speciesId or habitatId to return only the templates attached to it.
+//	@Tags			care-templates
+//	@Produce		json
+//	@Param			speciesId	query	int	false	"Only templates for this species"
+//	@Param			habitatId	query	int	false	"Only templates for this habitat"
+//	@Success		200	{array}		types.CareTemplate
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Router			/care-templates [get]
+func (h *Handler) handleListCareTemplates(w http.ResponseWriter, r *http.Request) {
+	speciesId, err := utils.ParseOptionalIDQuery(r, "speciesId")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	habitatId, err := utils.ParseOptionalIDQuery(r, "habitatId")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	templates, err := h.store.GetCareTemplates(speciesId, habitatId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, templates)
+}
+
+// handleCreateCareTemplate godoc
+//
+//	@Id				createCareTemplate
+//	@Summary		Create a care template
+//	@Description	Admin only. A template belongs to exactly one species or habitat. Age bounds only make sense on species templates. An unknown speciesId or habitatId is refused with 400.
+//	@Tags			care-templates
+//	@Accept			json
+//	@Produce		json
+//	@Param			template	body	types.CareTemplatePayload	true	"Template to create"
+//	@Success		201
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/care-templates [post]
+func (h *Handler) handleCreateCareTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := parseCareTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.CreateCareTemplate(template); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteStatus(w, http.StatusCreated)
+}
+
+// handleUpdateCareTemplate godoc
+//
+//	@Id				updateCareTemplate
+//	@Summary		Update a care template
+//	@Description	Admin only. A full replace. Tasks already created from the template are not changed. An unknown speciesId or habitatId is refused with 400.
+//	@Tags			care-templates
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path	int							true	"Template ID"
+//	@Param			template	body	types.CareTemplatePayload	true	"Updated template"
+//	@Success		204
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		404	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/care-templates/{id} [put]
+func (h *Handler) handleUpdateCareTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ParseIDParam(r, "id")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	template, ok := parseCareTemplate(w, r)
+	if !ok {
+		return
+	}
+	template.TemplateId = id
+
+	if err := h.store.UpdateCareTemplate(template); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteStatus(w, http.StatusNoContent)
+}
+
+// handleDeleteCareTemplate godoc
+//
+//	@Id				deleteCareTemplate
+//	@Summary		Delete a care template
+//	@Description	Admin only. Tasks already created from the template are kept.
+//	@Tags			care-templates
+//	@Produce		json
+//	@Param			id	path	int	true	"Template ID"
+//	@Success		204
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		404	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/care-templates/{id} [delete]
+func (h *Handler) handleDeleteCareTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ParseIDParam(r, "id")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.DeleteCareTemplateById(id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteStatus(w, http.StatusNoContent)
+}
+
+// writeStoreError answers 404 for a template that does not exist, 400 for
+// one naming a species or habitat that does not, and 500 for anything else.
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrCareTemplateNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if errors.Is(err, ErrOwnerNotFound) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteError(w, http.StatusInternalServerError, err)
+}
+
+// parseCareTemplate decodes and validates a template body, writing the error
+// response itself when it fails.
+func parseCareTemplate(w http.ResponseWriter, r *http.Request) (types.CareTemplate, bool) {
+	var payload types.CareTemplatePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return types.CareTemplate{}, false
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return types.CareTemplate{}, false
+	}
+
+	if err := checkCareTemplate(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return types.CareTemplate{}, false
+	}
+
+	return types.CareTemplate{
+		SpeciesId:         payload.SpeciesId,
+		HabitatId:         payload.HabitatId,
+		TaskName:          payload.TaskName,
+		TaskDesc:          payload.TaskDesc,
+		RepeatIntervHours: payload.RepeatIntervHours,
+		LifeStage:         payload.LifeStage,
+		MinAgeMonths:      payload.MinAgeMonths,
+		MaxAgeMonths:      payload.MaxAgeMonths,
+	}, true
+}
+
+// checkCareTemplate enforces the rules the struct tags cannot express. They
+// are also CHECK constraints on the table, but catching them here reports a 400
+// instead of a database error.
+func checkCareTemplate(payload types.CareTemplatePayload) error {
+	switch {
+	case payload.SpeciesId == nil && payload.HabitatId == nil:
+		return fmt.Errorf("a care template needs an owner: supply speciesId or habitatId")
+	case payload.SpeciesId != nil && payload.HabitatId != nil:
+		return fmt.Errorf("a care template has one owner: supply speciesId or habitatId, not both")
+	}
+
+	hasAgeRange := payload.MinAgeMonths != nil || payload.MaxAgeMonths != nil
+	if payload.HabitatId != nil && hasAgeRange {
+		return fmt.Errorf("age bounds only apply to species templates")
+	}
+
+	if payload.MinAgeMonths != nil && payload.MaxAgeMonths != nil && *payload.MinAgeMonths >= *payload.MaxAgeMonths {
+		return fmt.Errorf("minAgeMonths must be less than maxAgeMonths")
+	}
+
+	return nil
+}
+
+// TasksFromTemplates turns templates into tasks ready to be created alongside
+// a new animal or enclosure. They start incomplete, so the new subject's care
+// is due from the moment it is added.
+func TasksFromTemplates(templates []*types.CareTemplate) []types.Task {
+	tasks := make([]types.Task, 0, len(templates))
+	for _, template := range templates {
+		tasks = append(tasks, types.Task{
+			TaskName:          template.TaskName,
+			TaskDesc:          template.TaskDesc,
+			RepeatIntervHours: template.RepeatIntervHours,
+		})
+	}
+
+	return tasks
+}
@@ -0,0 +1,48 @@
+package caretemplate
+
+import (
+	"testing"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func intPtr(v int) *int { return &v }
+
+func TestCheckCareTemplate(t *testing.T) {
+	base := func(mutate func(*types.CareTemplatePayload)) types.CareTemplatePayload {
+		payload := types.CareTemplatePayload{TaskName: "Feed", TaskDesc: "Two crickets", RepeatIntervHours: 24}
+		mutate(&payload)
+		return payload
+	}
+
+	cases := []struct {
+		name    string
+		payload types.CareTemplatePayload
+		wantErr bool
+	}{
+		{"species template", base(func(p *types.CareTemplatePayload) { p.SpeciesId = intPtr(1) }), false},
+		{"habitat template", base(func(p *types.CareTemplatePayload) { p.HabitatId = intPtr(2) }), false},
+		{"no owner", base(func(p *types.CareTemplatePayload) {}), true},
+		{"both owners", base(func(p *types.CareTemplatePayload) { p.SpeciesId, p.HabitatId = intPtr(1), intPtr(2) }), true},
+		{"species age range", base(func(p *types.CareTemplatePayload) {
+			p.SpeciesId, p.MinAgeMonths, p.MaxAgeMonths = intPtr(1), intPtr(0), intPtr(6)
+		}), false},
+		{"open-ended age range", base(func(p *types.CareTemplatePayload) { p.SpeciesId, p.MinAgeMonths = intPtr(1), intPtr(18) }), false},
+		{"inverted age range", base(func(p *types.CareTemplatePayload) {
+			p.SpeciesId, p.MinAgeMonths, p.MaxAgeMonths = intPtr(1), intPtr(6), intPtr(6)
+		}), true},
+		{"habitats have no age", base(func(p *types.CareTemplatePayload) { p.HabitatId, p.MaxAgeMonths = intPtr(2), intPtr(6) }), true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkCareTemplate(tc.payload)
+			if tc.wantErr && err == nil {
+				t.Error("expected an error, got none")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
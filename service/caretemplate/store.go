@@ -0,0 +1,152 @@
+package caretemplate
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// ErrCareTemplateNotFound is returned for a template ID that does not exist.
+// Handlers map it to 404.
+var ErrCareTemplateNotFound = errors.New("care template not found")
+
+// ErrOwnerNotFound is returned when the species or habitat a template names
+// does not exist. Handlers map it to 400.
+var ErrOwnerNotFound = errors.New("the speciesId or habitatId does not exist")
+
+const templateColumns = `"templateId", "speciesId", "habitatId", "taskName", "taskDesc", "repeatIntervHours", "lifeStage", "minAgeMonths", "maxAgeMonths"`
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) CreateCareTemplate(template types.CareTemplate) error {
+	_, err := s.db.Exec(`INSERT INTO "careTemplates" ("speciesId", "habitatId", "taskName", "taskDesc", "repeatIntervHours", "lifeStage", "minAgeMonths", "maxAgeMonths")
+						VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		template.SpeciesId, template.HabitatId, template.TaskName, template.TaskDesc, template.RepeatIntervHours,
+		template.LifeStage, template.MinAgeMonths, template.MaxAgeMonths)
+
+	return ownerError(err)
+}
+
+func (s *Store) UpdateCareTemplate(template types.CareTemplate) error {
+	result, err := s.db.Exec(`UPDATE "careTemplates"
+						SET "speciesId" = $1, "habitatId" = $2, "taskName" = $3, "taskDesc" = $4, "repeatIntervHours" = $5,
+						"lifeStage" = $6, "minAgeMonths" = $7, "maxAgeMonths" = $8
+						WHERE "templateId" = $9`,
+		template.SpeciesId, template.HabitatId, template.TaskName, template.TaskDesc, template.RepeatIntervHours,
+		template.LifeStage, template.MinAgeMonths, template.MaxAgeMonths, template.TemplateId)
+	if err != nil {
+		return ownerError(err)
+	}
+
+	return notFoundIfNone(result)
+}
+
+func (s *Store) GetCareTemplates(speciesId *int, habitatId *int) ([]*types.CareTemplate, error) {
+	// A nil filter compares as NULL, which the IS NULL arm turns into "match
+	// everything".
+	return s.queryTemplates(`SELECT `+templateColumns+` FROM "careTemplates"
+							WHERE ($1::int IS NULL OR "speciesId" = $1)
+							AND ($2::int IS NULL OR "habitatId" = $2)
+							ORDER BY "templateId"`, speciesId, habitatId)
+}
+
+func (s *Store) GetCareTemplateById(templateId int) (*types.CareTemplate, error) {
+	templates, err := s.queryTemplates(`SELECT `+templateColumns+` FROM "careTemplates" WHERE "templateId" = $1`, templateId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(templates) == 0 {
+		return nil, ErrCareTemplateNotFound
+	}
+
+	return templates[0], nil
+}
+
+// GetCareTemplatesForAnimal treats the age range as half-open, so a template
+// ending at 6 months and one starting at 6 months never both apply.
+func (s *Store) GetCareTemplatesForAnimal(speciesId int, ageMonths *int) ([]*types.CareTemplate, error) {
+	return s.queryTemplates(`SELECT `+templateColumns+` FROM "careTemplates"
+							WHERE "speciesId" = $1
+							AND (("minAgeMonths" IS NULL AND "maxAgeMonths" IS NULL)
+								OR ($2::int IS NOT NULL
+									AND ("minAgeMonths" IS NULL OR "minAgeMonths" <= $2)
+									AND ("maxAgeMonths" IS NULL OR $2 < "maxAgeMonths")))
+							ORDER BY "templateId"`, speciesId, ageMonths)
+}
+
+func (s *Store) GetCareTemplatesForHabitat(habitatId int) ([]*types.CareTemplate, error) {
+	return s.queryTemplates(`SELECT `+templateColumns+` FROM "careTemplates" WHERE "habitatId" = $1 ORDER BY "templateId"`, habitatId)
+}
+
+func (s *Store) DeleteCareTemplateById(templateId int) error {
+	result, err := s.db.Exec(`DELETE FROM "careTemplates" WHERE "templateId" = $1`, templateId)
+	if err != nil {
+		return err
+	}
+
+	return notFoundIfNone(result)
+}
+
+// ownerError reads a foreign key violation as the template naming a species
+// or habitat that does not exist, the only references it has.
+func ownerError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+		return ErrOwnerNotFound
+	}
+
+	return err
+}
+
+func notFoundIfNone(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrCareTemplateNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) queryTemplates(query string, args ...any) ([]*types.CareTemplate, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	templates := make([]*types.CareTemplate, 0)
+	for rows.Next() {
+		template := new(types.CareTemplate)
+		err := rows.Scan(
+			&template.TemplateId,
+			&template.SpeciesId,
+			&template.HabitatId,
+			&template.TaskName,
+			&template.TaskDesc,
+			&template.RepeatIntervHours,
+			&template.LifeStage,
+			&template.MinAgeMonths,
+			&template.MaxAgeMonths,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		templates = append(templates, template)
+	}
+
+	return templates, rows.Err()
+}
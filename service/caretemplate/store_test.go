@@ -0,0 +1,26 @@
+package caretemplate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/whitallee/animal-family-backend/db/dbtest"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// A template naming a species or habitat that does not exist is the caller's
+// mistake, not the server's.
+func TestCareTemplateUnknownOwner(t *testing.T) {
+	store := NewStore(dbtest.Open(t))
+
+	missing := 999999
+	template := types.CareTemplate{SpeciesId: &missing, TaskName: "Feed", RepeatIntervHours: 24, LifeStage: "adult"}
+	if err := store.CreateCareTemplate(template); !errors.Is(err, ErrOwnerNotFound) {
+		t.Errorf("CreateCareTemplate = %v, want ErrOwnerNotFound", err)
+	}
+
+	template = types.CareTemplate{HabitatId: &missing, TaskName: "Clean", RepeatIntervHours: 24}
+	if err := store.CreateCareTemplate(template); !errors.Is(err, ErrOwnerNotFound) {
+		t.Errorf("CreateCareTemplate = %v, want ErrOwnerNotFound", err)
+	}
+}
@@ -0,0 +1,27 @@
+package enclosure
+
+import (
+	"log"
+
+	"github.com/whitallee/animal-family-backend/service/webhook"
+)
+
+// publishEnclosure tells the user's webhooks about a change to the
+// enclosure, sending it as GET /enclosures/{id} would return it now.
+func (h *Handler) publishEnclosure(userID int, event string, id int) {
+	if h.webhooks == nil {
+		return
+	}
+
+	enclosure, err := h.store.GetEnclosureById(id)
+	if err != nil {
+		log.Printf("failed to read enclosure %d for %s webhook: %v", id, event, err)
+		return
+	}
+
+	h.webhooks.Publish(userID, event, enclosure)
+}
+
+func (h *Handler) publishEnclosureDeleted(userID int, id int) {
+	h.webhooks.Publish(userID, webhook.EventEnclosureDeleted, map[string]int{"enclosureId": id})
+}
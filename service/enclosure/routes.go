@@ -7,17 +7,20 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/webhook"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
 
 type Handler struct {
-	store     types.EnclosureStore
-	userStore types.UserStore
+	store             types.EnclosureStore
+	userStore         types.UserStore
+	careTemplateStore types.CareTemplateStore
+	webhooks          *webhook.Dispatcher
 }
 
-func NewHandler(store types.EnclosureStore, userStore types.UserStore) *Handler {
-	return &Handler{store: store, userStore: userStore}
+func NewHandler(store types.EnclosureStore, userStore types.UserStore, careTemplateStore types.CareTemplateStore, webhooks *webhook.Dispatcher) *Handler {
+	return &Handler{store: store, userStore: userStore, careTemplateStore: careTemplateStore, webhooks: webhooks}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
@@ -205,7 +208,7 @@ func (h *Handler) handleAdminCreateEnclosure(w http.ResponseWriter, r *http.Requ
 	}
 
 	// if it doesn't exist, create new enclosure
-	err = h.store.CreateEnclosure(types.Enclosure{
+	_, err = h.store.CreateEnclosure(types.Enclosure{
 		EnclosureName: enclosure.EnclosureName,
 		Image:         enclosure.Image,
 		Notes:         enclosure.Notes,
@@ -242,7 +245,7 @@ func (h *Handler) handleUserCreateEnclosure(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	// if it doesn't exist, create new enclosure with userID
-	err = h.store.CreateEnclosure(types.Enclosure{
+	_, err = h.store.CreateEnclosure(types.Enclosure{
 		EnclosureName: enclosure.EnclosureName,
 		Image:         enclosure.Image,
 		Notes:         enclosure.Notes,
@@ -285,7 +288,7 @@ func (h *Handler) handleAdminCreateEnclosureWithAnimals(w http.ResponseWriter, r
 	}
 
 	// if it doesn't exist, create new enclosure with animals
-	err = h.store.CreateEnclosureWithAnimals(types.Enclosure{
+	_, err = h.store.CreateEnclosureWithAnimals(types.Enclosure{
 		EnclosureName: enclosureWithAnimals.EnclosureName,
 		Image:         enclosureWithAnimals.Image,
 		Notes:         enclosureWithAnimals.Notes,
@@ -325,7 +328,7 @@ func (h *Handler) handleUserCreateEnclosureWithAnimals(w http.ResponseWriter, r
 	}
 
 	// if it doesn't exist, create new enclosure with userID
-	err = h.store.CreateEnclosureWithAnimals(types.Enclosure{
+	_, err = h.store.CreateEnclosureWithAnimals(types.Enclosure{
 		EnclosureName: enclosureWithAnimals.EnclosureName,
 		Image:         enclosureWithAnimals.Image,
 		Notes:         enclosureWithAnimals.Notes,
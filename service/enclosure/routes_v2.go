@@ -9,6 +9,8 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/caretemplate"
+	"github.com/whitallee/animal-family-backend/service/webhook"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
@@ -85,7 +87,7 @@ func (h *Handler) handleGetEnclosure(w http.ResponseWriter, r *http.Request) {
 //
 //	@Id				createEnclosure
 //	@Summary		Create an enclosure
-//	@Description	Supply animalIds to move existing animals into the new enclosure as it is created. Every animal listed must belong to the caller.
+//	@Description	Supply animalIds to move existing animals into the new enclosure as it is created. Every animal listed must belong to the caller. Set applyCareTemplates to also create a task from each of the habitat's care templates, in the same transaction.
 //	@Tags			enclosures
 //	@Accept			json
 //	@Produce		json
@@ -123,17 +125,25 @@ func (h *Handler) handleCreateEnclosure(w http.ResponseWriter, r *http.Request)
 		Notes:         payload.Notes,
 	}
 
-	if len(payload.AnimalIds) == 0 {
-		if err := h.store.CreateEnclosure(enclosure, userID); err != nil {
+	var id int
+	var err error
+	switch {
+	case payload.ApplyCareTemplates:
+		var templates []*types.CareTemplate
+		templates, err = h.careTemplateStore.GetCareTemplatesForHabitat(payload.HabitatId)
+		if err != nil {
 			utils.WriteError(w, http.StatusInternalServerError, err)
 			return
 		}
 
-		utils.WriteStatus(w, http.StatusCreated)
-		return
+		id, err = h.store.CreateEnclosureWithTasks(enclosure, payload.AnimalIds, caretemplate.TasksFromTemplates(templates), userID)
+	case len(payload.AnimalIds) == 0:
+		id, err = h.store.CreateEnclosure(enclosure, userID)
+	default:
+		id, err = h.store.CreateEnclosureWithAnimals(enclosure, payload.AnimalIds, userID)
 	}
 
-	if err := h.store.CreateEnclosureWithAnimals(enclosure, payload.AnimalIds, userID); err != nil {
+	if err != nil {
 		// Naming an animal you do not own is a permission problem, not a fault.
 		if errors.Is(err, ErrAnimalNotOwned) {
 			utils.WriteError(w, http.StatusForbidden, err)
@@ -144,6 +154,8 @@ func (h *Handler) handleCreateEnclosure(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	h.publishEnclosure(userID, webhook.EventEnclosureCreated, id)
+
 	utils.WriteStatus(w, http.StatusCreated)
 }
 
@@ -189,6 +201,8 @@ func (h *Handler) handleUpdateEnclosure(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	h.publishEnclosure(auth.GetuserIdFromContext(r.Context()), webhook.EventEnclosureUpdated, id)
+
 	utils.WriteStatus(w, http.StatusNoContent)
 }
 
@@ -230,6 +244,8 @@ func (h *Handler) handleDeleteEnclosure(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	h.publishEnclosureDeleted(auth.GetuserIdFromContext(r.Context()), id)
+
 	utils.WriteStatus(w, http.StatusNoContent)
 }
 
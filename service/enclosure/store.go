@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/lib/pq"
+	"github.com/whitallee/animal-family-backend/service/task"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
@@ -23,35 +24,35 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-func (s *Store) CreateEnclosure(enclosure types.Enclosure, userID int) error {
+func (s *Store) CreateEnclosure(enclosure types.Enclosure, userID int) (int, error) {
 	tx, err := s.db.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	var addedEnclosureId int
 	err = tx.QueryRow(`INSERT INTO "enclosures" ("enclosureName", "image", "notes", "habitatId") VALUES ($1,$2,$3,$4) RETURNING "enclosureId"`, enclosure.EnclosureName, enclosure.Image, enclosure.Notes, enclosure.HabitatId).Scan(&addedEnclosureId)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	_, err = tx.Exec(`INSERT INTO "enclosureUser" ("enclosureId", "userId") VALUES ($1,$2)`, addedEnclosureId, userID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return addedEnclosureId, nil
 }
 
-func (s *Store) CreateEnclosureWithAnimals(enclosure types.Enclosure, animalIds []int, userID int) error {
+func (s *Store) CreateEnclosureWithAnimals(enclosure types.Enclosure, animalIds []int, userID int) (int, error) {
 	tx, err := s.db.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	// Rolls back every early return below. A no-op once Commit has succeeded.
 	defer func() { _ = tx.Rollback() }()
@@ -59,18 +60,22 @@ func (s *Store) CreateEnclosureWithAnimals(enclosure types.Enclosure, animalIds
 	var addedEnclosureId int
 	err = tx.QueryRow(`INSERT INTO "enclosures" ("enclosureName", "image", "notes", "habitatId") VALUES ($1,$2,$3,$4) RETURNING "enclosureId"`, enclosure.EnclosureName, enclosure.Image, enclosure.Notes, enclosure.HabitatId).Scan(&addedEnclosureId)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if _, err := tx.Exec(`INSERT INTO "enclosureUser" ("enclosureId", "userId") VALUES ($1,$2)`, addedEnclosureId, userID); err != nil {
-		return err
+		return 0, err
 	}
 
 	if err := assignAnimalsToEnclosure(tx, addedEnclosureId, animalIds, userID); err != nil {
-		return err
+		return 0, err
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return addedEnclosureId, nil
 }
 
 // assignAnimalsToEnclosure moves the caller's animals into the enclosure.
@@ -695,3 +700,39 @@ func (s *Store) UserOwnsEnclosure(enclosureId int, userID int) (bool, error) {
 
 	return owned, nil
 }
+
+// CreateEnclosureWithTasks is CreateEnclosureWithAnimals plus the enclosure's
+// initial tasks. animalIds may be empty. Everything happens in one transaction,
+// so an animal the caller does not own rolls back the tasks too.
+func (s *Store) CreateEnclosureWithTasks(enclosure types.Enclosure, animalIds []int, tasks []types.Task, userID int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// Rolls back every early return below. A no-op once Commit has succeeded.
+	defer func() { _ = tx.Rollback() }()
+
+	var addedEnclosureId int
+	err = tx.QueryRow(`INSERT INTO "enclosures" ("enclosureName", "image", "notes", "habitatId") VALUES ($1,$2,$3,$4) RETURNING "enclosureId"`, enclosure.EnclosureName, enclosure.Image, enclosure.Notes, enclosure.HabitatId).Scan(&addedEnclosureId)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO "enclosureUser" ("enclosureId", "userId") VALUES ($1,$2)`, addedEnclosureId, userID); err != nil {
+		return 0, err
+	}
+
+	if err := assignAnimalsToEnclosure(tx, addedEnclosureId, animalIds, userID); err != nil {
+		return 0, err
+	}
+
+	if err := task.InsertTasksTx(tx, tasks, nil, &addedEnclosureId, userID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return addedEnclosureId, nil
+}
@@ -0,0 +1,135 @@
+// Package llm is the chat model the API talks to. Callers depend on
+// ChatClient, so tests and local development can swap OpenAI for a Fake.
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ChatClient sends one system and one user message and returns the reply. In
+// JSON mode the reply is a single JSON object with no surrounding text.
+type ChatClient interface {
+	Chat(ctx context.Context, systemPrompt string, userPrompt string, jsonMode bool) (string, error)
+}
+
+const openAIChatURL = "https://api.openai.com/v1/chat/completions"
+
+// openAITimeout bounds a whole chat request, reading the reply included. A
+// request still holds its caller's connection open while it waits, so one
+// that hangs must not hang forever.
+const openAITimeout = 60 * time.Second
+
+// OpenAIClient is a ChatClient backed by OpenAI's chat completions API.
+type OpenAIClient struct {
+	apiKey string
+	model  string
+	http   *http.Client
+}
+
+func NewOpenAIClient(apiKey string) *OpenAIClient {
+	return &OpenAIClient{apiKey: apiKey, model: "gpt-4o", http: &http.Client{Timeout: openAITimeout}}
+}
+
+type openAIMsg struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIRespFmt struct {
+	Type string `json:"type"`
+}
+
+type openAIChatReq struct {
+	Model          string         `json:"model"`
+	Messages       []openAIMsg    `json:"messages"`
+	ResponseFormat *openAIRespFmt `json:"response_format,omitempty"`
+}
+
+type openAIChatResp struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+func (c *OpenAIClient) Chat(ctx context.Context, systemPrompt string, userPrompt string, jsonMode bool) (string, error) {
+	reqBody := openAIChatReq{
+		Model: c.model,
+		Messages: []openAIMsg{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: userPrompt},
+		},
+	}
+	if jsonMode {
+		reqBody.ResponseFormat = &openAIRespFmt{Type: "json_object"}
+	}
+
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, openAIChatURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("openai chat error %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result openAIChatResp
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("openai returned no choices")
+	}
+
+	return result.Choices[0].Message.Content, nil
+}
+
+// Fake is a ChatClient that returns a canned Reply, or Err, and keeps the
+// user prompts it was sent so a test can check what the model would have
+// seen.
+type Fake struct {
+	Reply string
+	Err   error
+
+	mu      sync.Mutex
+	prompts []string
+}
+
+func (f *Fake) Chat(ctx context.Context, systemPrompt string, userPrompt string, jsonMode bool) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.prompts = append(f.prompts, userPrompt)
+
+	return f.Reply, f.Err
+}
+
+// Prompts returns the user prompts sent so far, oldest first.
+func (f *Fake) Prompts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.prompts...)
+}
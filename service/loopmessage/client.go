@@ -0,0 +1,147 @@
+package loopmessage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client sends one text and returns the ID LoopMessage gave it, which its
+// status webhooks refer back to. statusCallback is where those webhooks go,
+// or empty for none.
+type Client interface {
+	Send(ctx context.Context, recipient string, text string, statusCallback string) (string, error)
+}
+
+// HTTPClient is a Client backed by LoopMessage's send API.
+type HTTPClient struct {
+	baseURL    string
+	authKey    string
+	secretKey  string
+	senderName string
+	// webhookSecret goes with each status callback, for withWebhookSecret.
+	webhookSecret string
+	http          *http.Client
+}
+
+// NewHTTPClient sends through the API at baseURL, which is LoopMessage's own
+// outside of development and tests, where a local fake can stand in for it.
+// LoopMessage sends webhookSecret back as the Authorization header of the
+// status callbacks for the texts it sends.
+func NewHTTPClient(baseURL, authKey, secretKey, senderName, webhookSecret string) *HTTPClient {
+	return &HTTPClient{
+		baseURL:       strings.TrimSuffix(baseURL, "/"),
+		authKey:       authKey,
+		secretKey:     secretKey,
+		senderName:    senderName,
+		webhookSecret: webhookSecret,
+		http:          &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+type sendReq struct {
+	Recipient      string `json:"recipient"`
+	Text           string `json:"text"`
+	SenderName     string `json:"sender_name"`
+	StatusCallback string `json:"status_callback,omitempty"`
+	// StatusCallbackHeader is sent as the callback's Authorization header.
+	StatusCallbackHeader string `json:"status_callback_header,omitempty"`
+}
+
+type sendResp struct {
+	MessageId string `json:"message_id"`
+	Success   bool   `json:"success"`
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+}
+
+func (c *HTTPClient) Send(ctx context.Context, recipient string, text string, statusCallback string) (string, error) {
+	payload := sendReq{
+		Recipient:      recipient,
+		Text:           text,
+		SenderName:     c.senderName,
+		StatusCallback: statusCallback,
+	}
+	if statusCallback != "" {
+		payload.StatusCallbackHeader = c.webhookSecret
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/v1/message/send/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", c.authKey)
+	req.Header.Set("Loop-Secret-Key", c.secretKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var result sendResp
+	if err := json.Unmarshal(bodyBytes, &result); err != nil {
+		return "", fmt.Errorf("loopmessage send error %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	if resp.StatusCode != http.StatusOK || !result.Success {
+		return "", fmt.Errorf("loopmessage send error %d (code %d): %s", resp.StatusCode, result.Code, result.Message)
+	}
+	if result.MessageId == "" {
+		return "", fmt.Errorf("loopmessage returned no message id")
+	}
+
+	return result.MessageId, nil
+}
+
+// Fake is a Client that keeps the texts it is sent instead of sending them,
+// and returns Err if it is set.
+type Fake struct {
+	Err error
+
+	mu   sync.Mutex
+	sent []FakeText
+}
+
+type FakeText struct {
+	Recipient      string
+	Text           string
+	StatusCallback string
+}
+
+func (f *Fake) Send(ctx context.Context, recipient string, text string, statusCallback string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return "", f.Err
+	}
+
+	f.sent = append(f.sent, FakeText{Recipient: recipient, Text: text, StatusCallback: statusCallback})
+
+	return fmt.Sprintf("fake-%d", len(f.sent)), nil
+}
+
+// Sent returns a copy of the texts sent so far, oldest first.
+func (f *Fake) Sent() []FakeText {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeText(nil), f.sent...)
+}
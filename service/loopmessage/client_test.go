@@ -0,0 +1,70 @@
+package loopmessage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPClientSend(t *testing.T) {
+	var got sendReq
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/message/send/" {
+			t.Errorf("got path %q", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "auth" || r.Header.Get("Loop-Secret-Key") != "secret" {
+			t.Errorf("got auth headers %q and %q", r.Header.Get("Authorization"), r.Header.Get("Loop-Secret-Key"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		_, _ = w.Write([]byte(`{"message_id": "m-1", "success": true}`))
+	}))
+	defer server.Close()
+
+	// A trailing slash on the configured base URL must not double up.
+	client := NewHTTPClient(server.URL+"/", "auth", "secret", "pets@imsg.co", "hook")
+
+	id, err := client.Send(context.Background(), "+15555550100", "Feed (Rex)", "https://api.example.com/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "m-1" {
+		t.Errorf("got message id %q, want m-1", id)
+	}
+
+	want := sendReq{Recipient: "+15555550100", Text: "Feed (Rex)", SenderName: "pets@imsg.co", StatusCallback: "https://api.example.com/status", StatusCallbackHeader: "hook"}
+	if got != want {
+		t.Errorf("got request %+v, want %+v", got, want)
+	}
+}
+
+func TestHTTPClientSendErrors(t *testing.T) {
+	cases := map[string]struct {
+		status int
+		body   string
+	}{
+		"rejected":      {http.StatusBadRequest, `{"success": false, "code": 120, "message": "invalid recipient"}`},
+		"not a success": {http.StatusOK, `{"success": false, "message": "no credits"}`},
+		"no message id": {http.StatusOK, `{"success": true}`},
+		"not json":      {http.StatusBadGateway, `<html>bad gateway</html>`},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			client := NewHTTPClient(server.URL, "auth", "secret", "", "")
+			if _, err := client.Send(context.Background(), "+15555550100", "hi", ""); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package loopmessage
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// The commands a text can carry.
+const (
+	CommandDone    = "done"
+	CommandList    = "list"
+	CommandSnooze  = "snooze"
+	CommandStop    = "stop"
+	CommandStart   = "start"
+	CommandHelp    = "help"
+	CommandUnknown = "unknown"
+)
+
+// maxSnooze matches the longest snooze POST /tasks/{id}/snooze accepts.
+const maxSnooze = 30 * 24 * time.Hour
+
+const helpText = `Text me:
+done feeding Rex - mark a task done
+list today - what is due today
+list overdue - what is overdue
+snooze tank 2h - put off a task (m, h or d)
+stop - no more texts`
+
+// command is one text, read. Query is the words naming the task, and Scope
+// is "today" or "overdue" for a list.
+type command struct {
+	Kind     string
+	Query    []string
+	Scope    string
+	Duration time.Duration
+	// Problem is why a recognised command could not be read, to send back.
+	Problem string
+}
+
+var commandAliases = map[string]string{
+	"done":        CommandDone,
+	"did":         CommandDone,
+	"complete":    CommandDone,
+	"fed":         CommandDone,
+	"list":        CommandList,
+	"ls":          CommandList,
+	"snooze":      CommandSnooze,
+	"later":       CommandSnooze,
+	"stop":        CommandStop,
+	"unsubscribe": CommandStop,
+	"start":       CommandStart,
+	"subscribe":   CommandStart,
+	"help":        CommandHelp,
+	"?":           CommandHelp,
+}
+
+// parseCommand reads a text. Case, punctuation and extra spaces are ignored,
+// so "Done: feeding Rex!" reads the same as "done feeding rex".
+func parseCommand(text string) command {
+	words := commandWords(text)
+	if len(words) == 0 {
+		return command{Kind: CommandHelp}
+	}
+
+	kind, ok := commandAliases[words[0]]
+	if !ok {
+		return command{Kind: CommandUnknown}
+	}
+	words = words[1:]
+
+	switch kind {
+	case CommandDone:
+		if len(words) == 0 {
+			return command{Kind: kind, Problem: `Which task? e.g. "done feeding Rex"`}
+		}
+		return command{Kind: kind, Query: words}
+
+	case CommandList:
+		scope := "today"
+		if len(words) > 0 {
+			scope = words[0]
+		}
+		if scope != "today" && scope != "overdue" {
+			return command{Kind: kind, Problem: `Try "list today" or "list overdue"`}
+		}
+		return command{Kind: kind, Scope: scope}
+
+	case CommandSnooze:
+		duration, query, ok := splitDuration(words)
+		if !ok || len(query) == 0 {
+			return command{Kind: kind, Problem: `Which task, and for how long? e.g. "snooze tank 2h"`}
+		}
+		if duration > maxSnooze {
+			return command{Kind: kind, Problem: "A snooze can be at most 30 days"}
+		}
+		return command{Kind: kind, Query: query, Duration: duration}
+
+	default:
+		return command{Kind: kind}
+	}
+}
+
+// commandWords lower-cases the text and splits it into words, dropping
+// punctuation other than "?" on its own.
+func commandWords(text string) []string {
+	text = strings.TrimSpace(strings.ToLower(text))
+	if text == "?" {
+		return []string{"?"}
+	}
+
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+var durationPattern = regexp.MustCompile(`^(\d+)(m|min|mins|minute|minutes|h|hr|hrs|hour|hours|d|day|days)$`)
+
+// splitDuration takes the snooze length off the end of the words, written
+// either as one word ("2h") or as two ("2 hours").
+func splitDuration(words []string) (time.Duration, []string, bool) {
+	for _, n := range []int{1, 2} {
+		if len(words) < n {
+			break
+		}
+
+		match := durationPattern.FindStringSubmatch(strings.Join(words[len(words)-n:], ""))
+		if match == nil {
+			continue
+		}
+
+		amount, err := strconv.Atoi(match[1])
+		if err != nil || amount < 1 {
+			return 0, nil, false
+		}
+
+		unit := time.Minute
+		switch match[2][0] {
+		case 'h':
+			unit = time.Hour
+		case 'd':
+			unit = 24 * time.Hour
+		}
+
+		return time.Duration(amount) * unit, words[:len(words)-n], true
+	}
+
+	return 0, nil, false
+}
+
+// taskMatches reports whether every query word names part of the task: a
+// word of its name or of its animal's or enclosure's name. A query word
+// matches a name word that starts with it or that it starts with, so
+// "feeding" finds "Feed" and "rex" finds "Rexy". Words shorter than three
+// letters must match exactly, or "2" would match anything starting with it.
+func taskMatches(query []string, taskName string, subjectName string) bool {
+	names := commandWords(taskName + " " + subjectName)
+
+	for _, q := range query {
+		found := false
+		for _, name := range names {
+			if q == name || (len(q) >= 3 && len(name) >= 3 && (strings.HasPrefix(name, q) || strings.HasPrefix(q, name))) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// taskLabel is how a task is named in a reply, as in push notifications.
+func taskLabel(task *types.TaskWithSubject, subjectName string) string {
+	if subjectName == "" {
+		return task.TaskName
+	}
+
+	return fmt.Sprintf("%s (%s)", task.TaskName, subjectName)
+}
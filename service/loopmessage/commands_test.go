@@ -0,0 +1,83 @@
+package loopmessage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCommand(t *testing.T) {
+	cases := map[string]command{
+		"done feeding Rex":    {Kind: CommandDone, Query: []string{"feeding", "rex"}},
+		"Done: feeding Rex!":  {Kind: CommandDone, Query: []string{"feeding", "rex"}},
+		"fed rex":             {Kind: CommandDone, Query: []string{"rex"}},
+		"list":                {Kind: CommandList, Scope: "today"},
+		"LIST overdue":        {Kind: CommandList, Scope: "overdue"},
+		"snooze tank 2h":      {Kind: CommandSnooze, Query: []string{"tank"}, Duration: 2 * time.Hour},
+		"snooze tank 3 days":  {Kind: CommandSnooze, Query: []string{"tank"}, Duration: 72 * time.Hour},
+		"snooze mist 45 mins": {Kind: CommandSnooze, Query: []string{"mist"}, Duration: 45 * time.Minute},
+		"STOP":                {Kind: CommandStop},
+		"?":                   {Kind: CommandHelp},
+		"":                    {Kind: CommandHelp},
+		"feed rex":            {Kind: CommandUnknown},
+	}
+
+	for text, want := range cases {
+		if got := parseCommand(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("parseCommand(%q) = %+v, want %+v", text, got, want)
+		}
+	}
+}
+
+// A command that is recognised but cannot be run is answered with what is
+// wrong, rather than falling back to the help text.
+func TestParseCommandProblems(t *testing.T) {
+	for _, text := range []string{
+		"done",
+		"list tomorrow",
+		"snooze tank",
+		"snooze 2h",
+		"snooze tank 0h",
+		"snooze tank 31d",
+	} {
+		if cmd := parseCommand(text); cmd.Problem == "" {
+			t.Errorf("parseCommand(%q) = %+v, want a problem", text, cmd)
+		}
+	}
+}
+
+func TestTaskMatches(t *testing.T) {
+	cases := []struct {
+		query   []string
+		task    string
+		subject string
+		want    bool
+	}{
+		{[]string{"feeding", "rex"}, "Feed", "Rex", true},
+		{[]string{"rex"}, "Feed", "Rexy", true},
+		{[]string{"tank"}, "Clean tank", "", true},
+		{[]string{"feeding", "rex"}, "Feed", "Bruno", false},
+		// Short words must match exactly, or "2" would match "200g".
+		{[]string{"2"}, "Feed 200g", "", false},
+		{[]string{"2"}, "Clean tank 2", "", true},
+	}
+
+	for _, c := range cases {
+		if got := taskMatches(c.query, c.task, c.subject); got != c.want {
+			t.Errorf("taskMatches(%q, %q, %q) = %v, want %v", c.query, c.task, c.subject, got, c.want)
+		}
+	}
+}
+
+func TestWebhookAuthorized(t *testing.T) {
+	if !webhookAuthorized("s3cret", "s3cret") {
+		t.Error("the secret was refused")
+	}
+	if webhookAuthorized("wrong", "s3cret") {
+		t.Error("a wrong secret was let through")
+	}
+	// An unset secret must not be matched by a missing header.
+	if webhookAuthorized("", "") {
+		t.Error("an empty secret was let through")
+	}
+}
@@ -0,0 +1,321 @@
+package loopmessage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/whitallee/animal-family-backend/service/task"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// maxListed bounds a list reply, which has to stay readable as one text.
+const maxListed = 10
+
+// handleInboundLoopMessage godoc
+//
+//	@Id				loopMessageInbound
+//	@Summary		Receive a text from LoopMessage
+//	@Description	Called by LoopMessage when someone texts the service. The Authorization header must be the shared webhook secret. A text from a verified phone is read as a command, run as that phone's user, and answered by text; see helpText in service/loopmessage for the commands. The request is acknowledged before the command runs. Each message ID is run at most once, so a webhook delivered again is acknowledged and otherwise ignored. Texts from unknown numbers, and alerts other than inbound messages, are ignored.
+//	@Tags			loopmessage
+//	@Accept			json
+//	@Param			message	body	types.InboundLoopMessagePayload	true	"LoopMessage inbound webhook"
+//	@Success		200
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		401	{object}	types.ErrorResponse
+//	@Failure		503	{object}	types.ErrorResponse
+//	@Router			/loopmessage/inbound [post]
+func (h *Handler) handleInboundLoopMessage(w http.ResponseWriter, r *http.Request) {
+	var payload types.InboundLoopMessagePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	if payload.AlertType != "message_inbound" {
+		utils.WriteStatus(w, http.StatusOK)
+		return
+	}
+
+	// LoopMessage retries a webhook that is slow to answer, and a response is
+	// not sent until the handler returns, so the command runs in the
+	// background. Close waits for it on shutdown.
+	h.inbound.Add(1)
+	go func() {
+		defer h.inbound.Done()
+
+		if err := h.receiveText(payload); err != nil {
+			log.Printf("failed to handle inbound loopmessage %s: %v", payload.MessageId, err)
+		}
+	}()
+
+	utils.WriteStatus(w, http.StatusOK)
+}
+
+// receiveText runs the command in a text and replies to it. The message is
+// claimed before the command runs, so a replayed webhook finds it claimed and
+// stops there.
+func (h *Handler) receiveText(payload types.InboundLoopMessagePayload) error {
+	userID, err := h.store.GetUserIdByPhone(payload.Recipient)
+	if errors.Is(err, ErrUnknownPhone) {
+		log.Printf("ignoring loopmessage %s from an unknown number", payload.MessageId)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	claimed, err := h.store.ClaimSMSCommand(payload.MessageId, userID, payload.Text)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		log.Printf("ignoring replayed loopmessage %s", payload.MessageId)
+		return nil
+	}
+
+	cmd := parseCommand(payload.Text)
+	reply := h.runCommand(userID, cmd, time.Now())
+
+	if err := h.store.FinishSMSCommand(payload.MessageId, cmd.Kind, reply); err != nil {
+		log.Printf("failed to log loopmessage command %s: %v", payload.MessageId, err)
+	}
+
+	if h.client == nil {
+		return fmt.Errorf("cannot reply to %s: texting is not configured", payload.MessageId)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	messageId, err := h.client.Send(ctx, payload.Recipient, reply, "")
+	if err != nil {
+		return err
+	}
+
+	return h.store.RecordLoopMessage(types.LoopMessage{
+		MessageId: messageId,
+		UserID:    userID,
+		Recipient: payload.Recipient,
+		Text:      reply,
+	})
+}
+
+// runCommand carries out cmd for the user and returns the reply. Failures
+// are logged and answered with an apology, since the texter cannot see logs.
+func (h *Handler) runCommand(userID int, cmd command, now time.Time) string {
+	if cmd.Problem != "" {
+		return cmd.Problem
+	}
+
+	var reply string
+	var err error
+	switch cmd.Kind {
+	case CommandDone:
+		reply, err = h.runDone(userID, cmd.Query)
+	case CommandList:
+		reply, err = h.runList(userID, cmd.Scope, now)
+	case CommandSnooze:
+		reply, err = h.runSnooze(userID, cmd.Query, cmd.Duration, now)
+	case CommandStop:
+		err = h.store.SetSMSOptIn(userID, false)
+		reply = "You will get no more texts from Animal Family. Text START to turn them back on."
+	case CommandStart:
+		err = h.store.SetSMSOptIn(userID, true)
+		reply = "Texts are back on. Text STOP to turn them off."
+	case CommandHelp:
+		reply = helpText
+	default:
+		reply = "Sorry, I didn't get that.\n" + helpText
+	}
+
+	if err != nil {
+		log.Printf("failed to run %s command for user %d: %v", cmd.Kind, userID, err)
+		return "Sorry, something went wrong. Try again in the app."
+	}
+
+	return reply
+}
+
+func (h *Handler) runDone(userID int, query []string) (string, error) {
+	matches, names, err := h.matchingTasks(userID, query)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(matches) {
+	case 0:
+		return fmt.Sprintf("No open task matches %q.", strings.Join(query, " ")), nil
+	case 1:
+	default:
+		return "Which one? " + joinLabels(matches, names) + ".", nil
+	}
+
+	t := matches[0]
+	if err := h.taskStore.CompleteTask(t.TaskId, userID, nil); err != nil {
+		if errors.Is(err, task.ErrTaskAlreadyComplete) {
+			return fmt.Sprintf("%s was already done.", taskLabel(t, names[t.TaskId])), nil
+		}
+		return "", err
+	}
+
+	task.PublishCompleted(h.webhooks, h.taskStore, userID, t.TaskId)
+
+	return fmt.Sprintf("Done: %s.", taskLabel(t, names[t.TaskId])), nil
+}
+
+// runSnooze snoozes every open task that matches, so "snooze tank 2h" puts
+// off everything due on the tank. Unlike completing, that is easily undone.
+func (h *Handler) runSnooze(userID int, query []string, duration time.Duration, now time.Time) (string, error) {
+	matches, names, err := h.matchingTasks(userID, query)
+	if err != nil {
+		return "", err
+	}
+
+	if len(matches) == 0 {
+		return fmt.Sprintf("No open task matches %q.", strings.Join(query, " ")), nil
+	}
+
+	for _, t := range matches {
+		if err := h.taskStore.SnoozeTask(t.TaskId, task.SnoozeUntil(t, now, duration)); err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("Snoozed for %s: %s.", formatDuration(duration), joinLabels(matches, names)), nil
+}
+
+func (h *Handler) runList(userID int, scope string, now time.Time) (string, error) {
+	tasks, names, err := h.openTasks(userID)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := h.userStore.GetUserById(userID)
+	if err != nil {
+		return "", err
+	}
+	local := now.In(user.Location())
+	endOfToday := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, local.Location())
+
+	labels := make([]string, 0, len(tasks))
+	for _, t := range tasks {
+		due := task.EffectiveDue(t)
+		if (scope == "overdue" && !due.After(now)) || (scope == "today" && due.Before(endOfToday)) {
+			labels = append(labels, "- "+taskLabel(t, names[t.TaskId]))
+		}
+	}
+
+	heading, empty := "Due today", "Nothing due today."
+	if scope == "overdue" {
+		heading, empty = "Overdue", "Nothing overdue."
+	}
+
+	if len(labels) == 0 {
+		return empty, nil
+	}
+
+	more := ""
+	if len(labels) > maxListed {
+		more = fmt.Sprintf("\n...and %d more", len(labels)-maxListed)
+		labels = labels[:maxListed]
+	}
+
+	return fmt.Sprintf("%s:\n%s%s", heading, strings.Join(labels, "\n"), more), nil
+}
+
+// matchingTasks is the user's open tasks that the query names.
+func (h *Handler) matchingTasks(userID int, query []string) ([]*types.TaskWithSubject, map[int]string, error) {
+	tasks, names, err := h.openTasks(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	matches := make([]*types.TaskWithSubject, 0)
+	for _, t := range tasks {
+		if taskMatches(query, t.TaskName, names[t.TaskId]) {
+			matches = append(matches, t)
+		}
+	}
+
+	return matches, names, nil
+}
+
+// openTasks returns the user's incomplete tasks that are not paused, soonest
+// due first, with the name of each one's animal or enclosure by task ID.
+func (h *Handler) openTasks(userID int) ([]*types.TaskWithSubject, map[int]string, error) {
+	complete := false
+	tasks, err := h.taskStore.GetTasksWithSubjectByUserId(userID, types.TaskFilter{Complete: &complete, Sort: "due"})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	animals, err := h.animalStore.GetAnimalsByUserId(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	enclosures, err := h.enclosureStore.GetEnclosuresByUserId(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	animalNames := make(map[int]string, len(animals))
+	for _, a := range animals {
+		animalNames[a.AnimalId] = a.AnimalName
+	}
+	enclosureNames := make(map[int]string, len(enclosures))
+	for _, e := range enclosures {
+		enclosureNames[e.EnclosureId] = e.EnclosureName
+	}
+
+	open := make([]*types.TaskWithSubject, 0, len(tasks))
+	names := make(map[int]string, len(tasks))
+	for _, t := range tasks {
+		if t.Pause != nil {
+			continue
+		}
+
+		open = append(open, t)
+		switch {
+		case t.AnimalId != nil:
+			names[t.TaskId] = animalNames[*t.AnimalId]
+		case t.EnclosureId != nil:
+			names[t.TaskId] = enclosureNames[*t.EnclosureId]
+		}
+	}
+
+	return open, names, nil
+}
+
+func joinLabels(tasks []*types.TaskWithSubject, names map[int]string) string {
+	labels := make([]string, 0, len(tasks))
+	for _, t := range tasks {
+		labels = append(labels, taskLabel(t, names[t.TaskId]))
+	}
+
+	return strings.Join(labels, ", ")
+}
+
+// formatDuration writes a snooze length the way it was most likely typed.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+}
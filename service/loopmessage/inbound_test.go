@@ -0,0 +1,37 @@
+package loopmessage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A webhook missing what its alert type needs is refused before anything
+// runs, while alerts that are not inbound messages are acknowledged.
+func TestInboundPayloadValidation(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"no alert type", `{"recipient": "+15555550100", "messageId": "m1"}`, http.StatusBadRequest},
+		{"inbound without a message ID", `{"alertType": "message_inbound", "recipient": "+15555550100"}`, http.StatusBadRequest},
+		{"inbound without a recipient", `{"alertType": "message_inbound", "messageId": "m1"}`, http.StatusBadRequest},
+		{"another alert", `{"alertType": "message_sent"}`, http.StatusOK},
+	}
+
+	h := &Handler{}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/loopmessage/inbound", strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+
+			h.handleInboundLoopMessage(rr, req)
+
+			if rr.Code != tc.want {
+				t.Errorf("got %d, want %d: %s", rr.Code, tc.want, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package loopmessage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// sendTimeout bounds one text, since the notification sender works through
+// every recipient in turn.
+const sendTimeout = 20 * time.Second
+
+// SMSNotifier is the sms notification channel. It only texts a user whose
+// number is verified and opted in, and records every text so its status
+// webhooks can be matched back to it.
+type SMSNotifier struct {
+	client         Client
+	store          types.LoopMessageStore
+	statusCallback string
+}
+
+// NewSMSNotifier texts through client. publicHost is this service's public
+// URL, which LoopMessage calls back with each text's delivery status.
+func NewSMSNotifier(client Client, store types.LoopMessageStore, publicHost string) *SMSNotifier {
+	return &SMSNotifier{
+		client:         client,
+		store:          store,
+		statusCallback: strings.TrimSuffix(publicHost, "/") + "/api/v2/loopmessage/status",
+	}
+}
+
+func (n *SMSNotifier) Notify(user *types.User, notification *types.Notification) error {
+	status, err := n.store.GetPhoneStatus(user.ID)
+	if err != nil {
+		return err
+	}
+	if status.Phone == nil || !status.OptedIn {
+		return nil
+	}
+
+	text := smsText(notification)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	messageId, err := n.client.Send(ctx, *status.Phone, text, n.statusCallback)
+	if err != nil {
+		return fmt.Errorf("failed to text user %d: %v", user.ID, err)
+	}
+
+	return n.store.RecordLoopMessage(types.LoopMessage{
+		MessageId:      messageId,
+		UserID:         user.ID,
+		NotificationId: notification.NotificationId,
+		Recipient:      *status.Phone,
+		Text:           text,
+	})
+}
+
+// smsText is the title and body as the notification center shows them, on
+// separate lines.
+func smsText(n *types.Notification) string {
+	if n.Body == "" {
+		return n.Title
+	}
+
+	return n.Title + "\n" + n.Body
+}
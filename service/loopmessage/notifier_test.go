@@ -0,0 +1,74 @@
+package loopmessage
+
+import (
+	"testing"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// phoneStore answers the two calls the notifier makes; any other call panics
+// on the nil embedded interface.
+type phoneStore struct {
+	types.LoopMessageStore
+	status   types.PhoneStatus
+	recorded []types.LoopMessage
+}
+
+func (s *phoneStore) GetPhoneStatus(userID int) (*types.PhoneStatus, error) {
+	status := s.status
+	return &status, nil
+}
+
+func (s *phoneStore) RecordLoopMessage(m types.LoopMessage) error {
+	s.recorded = append(s.recorded, m)
+	return nil
+}
+
+func TestSMSNotifier(t *testing.T) {
+	phone := "+15555550100"
+	user := &types.User{ID: 3}
+	n := &types.Notification{NotificationId: 8, UserID: 3, Title: "Feed (Rex)", Body: "Two crickets"}
+
+	cases := []struct {
+		name     string
+		status   types.PhoneStatus
+		wantSent bool
+	}{
+		{"opted in", types.PhoneStatus{Phone: &phone, OptedIn: true}, true},
+		{"opted out", types.PhoneStatus{Phone: &phone}, false},
+		// Only the verified number counts; one waiting for its code does not.
+		{"only pending", types.PhoneStatus{PendingPhone: &phone, OptedIn: true}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &Fake{}
+			store := &phoneStore{status: tc.status}
+
+			if err := NewSMSNotifier(client, store, "https://api.example.com/").Notify(user, n); err != nil {
+				t.Fatal(err)
+			}
+
+			sent := client.Sent()
+			if !tc.wantSent {
+				if len(sent) != 0 {
+					t.Errorf("texted %d times, want none", len(sent))
+				}
+				return
+			}
+
+			if len(sent) != 1 || len(store.recorded) != 1 {
+				t.Fatalf("got %d texts and %d records, want 1 of each", len(sent), len(store.recorded))
+			}
+			if sent[0].Text != "Feed (Rex)\nTwo crickets" {
+				t.Errorf("got text %q", sent[0].Text)
+			}
+			if sent[0].StatusCallback != "https://api.example.com/api/v2/loopmessage/status" {
+				t.Errorf("got status callback %q", sent[0].StatusCallback)
+			}
+			if rec := store.recorded[0]; rec.MessageId != "fake-1" || rec.NotificationId != 8 || rec.Recipient != phone {
+				t.Errorf("got record %+v", rec)
+			}
+		})
+	}
+}
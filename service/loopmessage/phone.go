@@ -0,0 +1,309 @@
+package loopmessage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// A code is good for verificationTTL or maxVerificationAttempts guesses,
+// whichever runs out first, and another cannot be asked for within
+// verificationCooldown of the last. Each request texts a number the caller
+// has not yet proved is theirs, so the cooldown also keeps the route from
+// being used to pester a stranger.
+const (
+	verificationTTL         = 10 * time.Minute
+	maxVerificationAttempts = 5
+	verificationCooldown    = time.Minute
+)
+
+// handleGetPhone godoc
+//
+//	@Id				getPhone
+//	@Summary		Get the caller's phone number for text reminders
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	types.PhoneStatus
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/users/me/phone [get]
+func (h *Handler) handleGetPhone(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	status, err := h.store.GetPhoneStatus(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, status)
+}
+
+// handleSetPhone godoc
+//
+//	@Id				setPhone
+//	@Summary		Start adding a phone number for text reminders
+//	@Description	Texts a six-digit code to the number, which POST /users/me/phone/verify then takes. The caller's current number, if any, stays in use until then. The code expires after 10 minutes or 5 wrong guesses, and another cannot be asked for within a minute of the last one texted. Returns 503 when texting is not configured.
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			phone	body		types.SetPhonePayload	true	"E.164 phone number"
+//	@Success		202		{object}	types.PhoneStatus
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		429		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Failure		502		{object}	types.ErrorResponse
+//	@Failure		503		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/users/me/phone [put]
+func (h *Handler) handleSetPhone(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	if h.client == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("texting is not configured"))
+		return
+	}
+
+	var payload types.SetPhonePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	code, err := newVerificationCode()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	codeHash := hashVerificationCode(h.secret, code)
+	err = h.store.StartPhoneVerification(userID, payload.Phone, codeHash, time.Now().Add(verificationTTL), verificationCooldown)
+	if err != nil {
+		if errors.Is(err, ErrVerificationTooSoon) {
+			utils.WriteError(w, http.StatusTooManyRequests, err)
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	text := fmt.Sprintf("Your Animal Family code is %s. It expires in %d minutes.", code, int(verificationTTL.Minutes()))
+	if _, err := h.client.Send(r.Context(), payload.Phone, text, ""); err != nil {
+		// The cooldown is there to space out texts that were sent; a code
+		// that never arrived should not make the caller wait to try again.
+		if err := h.store.CancelPhoneVerification(userID, codeHash); err != nil {
+			log.Printf("failed to cancel the phone verification for user %d: %v", userID, err)
+		}
+
+		utils.WriteError(w, http.StatusBadGateway, fmt.Errorf("failed to text the code: %v", err))
+		return
+	}
+
+	status, err := h.store.GetPhoneStatus(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, status)
+}
+
+// handleVerifyPhone godoc
+//
+//	@Id				verifyPhone
+//	@Summary		Confirm a phone number with the code texted to it
+//	@Description	The number replaces the caller's current one and is opted in to text reminders, which is the consent the request to add it asked for. Which reminders are texted is then up to the sms channel in the notification preferences.
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			code	body		types.VerifyPhonePayload	true	"The texted code"
+//	@Success		200		{object}	types.PhoneStatus
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		409		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/users/me/phone/verify [post]
+func (h *Handler) handleVerifyPhone(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	var payload types.VerifyPhonePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	v, err := h.store.SpendPhoneVerificationAttempt(userID, maxVerificationAttempts)
+	if err != nil {
+		if errors.Is(err, ErrNoPendingVerification) {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !verificationCodeMatches(h.secret, v.CodeHash, payload.Code) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("wrong code, %d guesses left", maxVerificationAttempts-v.Attempts))
+		return
+	}
+
+	if err := h.store.ConfirmPhone(userID, v.Phone); err != nil {
+		if errors.Is(err, ErrPhoneTaken) {
+			utils.WriteError(w, http.StatusConflict, err)
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writePhoneStatus(w, userID)
+}
+
+// handleOptInToTexts godoc
+//
+//	@Id				optInToTexts
+//	@Summary		Start texting reminders to the caller's phone again
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	types.PhoneStatus
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/users/me/phone/opt-in [post]
+func (h *Handler) handleOptInToTexts(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	if err := h.store.SetSMSOptIn(userID, true); err != nil {
+		if errors.Is(err, ErrPhoneNotVerified) {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writePhoneStatus(w, userID)
+}
+
+// handleOptOutOfTexts godoc
+//
+//	@Id				optOutOfTexts
+//	@Summary		Stop texting the caller's phone
+//	@Description	Keeps the number, so opting back in needs no new code. Nothing is texted while opted out, whatever the notification preferences say.
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	types.PhoneStatus
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/users/me/phone/opt-out [post]
+func (h *Handler) handleOptOutOfTexts(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	if err := h.store.SetSMSOptIn(userID, false); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writePhoneStatus(w, userID)
+}
+
+// handleRemovePhone godoc
+//
+//	@Id				removePhone
+//	@Summary		Remove the caller's phone number
+//	@Description	Also drops any code still waiting to be confirmed.
+//	@Tags			users
+//	@Success		204
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/users/me/phone [delete]
+func (h *Handler) handleRemovePhone(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	if err := h.store.RemovePhone(userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteStatus(w, http.StatusNoContent)
+}
+
+func (h *Handler) writePhoneStatus(w http.ResponseWriter, userID int) {
+	status, err := h.store.GetPhoneStatus(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, status)
+}
+
+// newVerificationCode returns six random digits, keeping leading zeros.
+func newVerificationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashVerificationCode returns what is stored in place of the code. Calendar
+// feed tokens can be stored as a plain hash because they are long and random;
+// a six-digit code has only a million values, so its plain hash would give the
+// code away to anyone who read the table. This is an HMAC keyed from the
+// server secret instead, which cannot be reversed without the secret too.
+func hashVerificationCode(secret []byte, code string) string {
+	mac := hmac.New(sha256.New, verificationCodeKey(secret))
+	mac.Write([]byte(code))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verificationCodeMatches(secret []byte, codeHash string, code string) bool {
+	return subtle.ConstantTimeCompare([]byte(codeHash), []byte(hashVerificationCode(secret, code))) == 1
+}
+
+// verificationCodeKey is derived from the secret, as the token keys in
+// service/auth are, so that it is used for nothing else.
+func verificationCodeKey(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("phone-verification-code"))
+
+	return mac.Sum(nil)
+}
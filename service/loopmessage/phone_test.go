@@ -0,0 +1,52 @@
+package loopmessage
+
+import (
+	"testing"
+
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+func TestVerificationCode(t *testing.T) {
+	secret := []byte("secret")
+
+	for i := 0; i < 20; i++ {
+		code, err := newVerificationCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Whatever is texted has to pass the payload's own checks.
+		if err := utils.Validate.Struct(types.VerifyPhonePayload{Code: code}); err != nil {
+			t.Fatalf("code %q fails validation: %v", code, err)
+		}
+		if !verificationCodeMatches(secret, hashVerificationCode(secret, code), code) {
+			t.Fatalf("code %q does not match its own hash", code)
+		}
+	}
+
+	if verificationCodeMatches(secret, hashVerificationCode(secret, "123456"), "123457") {
+		t.Error("a different code matched")
+	}
+	// A stored hash is of no use without the secret it was made with.
+	if verificationCodeMatches(secret, hashVerificationCode([]byte("other"), "123456"), "123456") {
+		t.Error("a code hashed with another secret matched")
+	}
+}
+
+func TestSetPhonePayloadNeedsE164(t *testing.T) {
+	cases := map[string]bool{
+		"+15555550100":      true,
+		"+447700900123":     true,
+		"5555550100":        false,
+		"+1 555 555 0100":   false,
+		"+1234567890123456": false,
+	}
+
+	for phone, valid := range cases {
+		err := utils.Validate.Struct(types.SetPhonePayload{Phone: phone})
+		if (err == nil) != valid {
+			t.Errorf("%q: got error %v, want valid %v", phone, err, valid)
+		}
+	}
+}
@@ -1,51 +1,111 @@
 package loopmessage
 
 import (
+	"context"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
+	"sync"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/webhook"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
 
 type Handler struct {
-	store types.LoopMessageStore
+	store          types.LoopMessageStore
+	userStore      types.UserStore
+	taskStore      types.TaskStore
+	animalStore    types.AnimalStore
+	enclosureStore types.EnclosureStore
+	webhooks       *webhook.Dispatcher
+	client         Client
+	secret         []byte
+	webhookSecret  string
+
+	// inbound tracks the texts still being answered, for Close.
+	inbound sync.WaitGroup
+}
+
+// NewHandler takes the dispatcher that tells users' integrations about the
+// tasks completed by text, the client texts are sent with, or nil when
+// texting is not configured, the server secret verification codes are hashed
+// with, and the secret LoopMessage's webhooks must carry, or "" to refuse
+// them all.
+func NewHandler(store types.LoopMessageStore, userStore types.UserStore, taskStore types.TaskStore, animalStore types.AnimalStore, enclosureStore types.EnclosureStore, webhooks *webhook.Dispatcher, client Client, secret []byte, webhookSecret string) *Handler {
+	return &Handler{
+		store:          store,
+		userStore:      userStore,
+		taskStore:      taskStore,
+		animalStore:    animalStore,
+		enclosureStore: enclosureStore,
+		webhooks:       webhooks,
+		client:         client,
+		secret:         secret,
+		webhookSecret:  webhookSecret,
+	}
 }
 
-func NewHandler(store types.LoopMessageStore) *Handler {
-	return &Handler{store: store}
+// Close waits for the texts still being answered. If ctx ends first it
+// returns without them; each reply is bounded by its own send timeout.
+func (h *Handler) Close(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.inbound.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/loopmessage/inbound", h.handleInboundLoopMessage).Methods(http.MethodPost)
+	router.HandleFunc("/loopmessage/inbound", h.withWebhookSecret(h.handleInboundLoopMessage)).Methods(http.MethodPost)
 }
 
-func (h *Handler) handleInboundLoopMessage(w http.ResponseWriter, r *http.Request) {
-	// get JSON payload
-	var inboundLoopMessagePayload types.InboundLoopMessagePayload
-	if err := utils.ParseJSON(r, &inboundLoopMessagePayload); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
-	}
+// RegisterV2Routes mounts the phone number routes, which live under
+// /users/me but are served here beside the client that texts the codes, and
+// the webhooks LoopMessage calls.
+func (h *Handler) RegisterV2Routes(router *mux.Router) {
+	router.HandleFunc("/users/me/phone", auth.WithJWTAuth(h.handleGetPhone, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/users/me/phone", auth.WithJWTAuth(h.handleSetPhone, h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/users/me/phone", auth.WithJWTAuth(h.handleRemovePhone, h.userStore)).Methods(http.MethodDelete)
+	router.HandleFunc("/users/me/phone/verify", auth.WithJWTAuth(h.handleVerifyPhone, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/users/me/phone/opt-in", auth.WithJWTAuth(h.handleOptInToTexts, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/users/me/phone/opt-out", auth.WithJWTAuth(h.handleOptOutOfTexts, h.userStore)).Methods(http.MethodPost)
 
-	// validate payload done by other package
-	if err := utils.Validate.Struct(inboundLoopMessagePayload); err != nil {
-		errors := err.(validator.ValidationErrors)
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
-		return
-	}
+	// Authenticated by the shared webhook secret, not a session.
+	router.HandleFunc("/loopmessage/inbound", h.withWebhookSecret(h.handleInboundLoopMessage)).Methods(http.MethodPost)
+	router.HandleFunc("/loopmessage/status", h.withWebhookSecret(h.handleMessageStatus)).Methods(http.MethodPost)
+}
 
-	// send response before processing
-	utils.WriteJSON(w, http.StatusOK, nil)
+// withWebhookSecret only lets through requests whose Authorization header is
+// the webhook secret, which is set as the webhook header in LoopMessage's
+// dashboard and sent with every text for its status callbacks. With no
+// secret configured every webhook is refused, rather than anyone being able
+// to run commands as any user by naming their phone.
+func (h *Handler) withWebhookSecret(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.webhookSecret == "" {
+			utils.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("loopmessage webhooks are not configured"))
+			return
+		}
 
-	// process inbound loop message
-	err := h.store.ReceiveLoopMessage(inboundLoopMessagePayload)
-	if err != nil {
-		// utils.WriteError(w, http.StatusInternalServerError, err)
-		fmt.Println(err)
-		return
+		if !webhookAuthorized(r.Header.Get("Authorization"), h.webhookSecret) {
+			utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("invalid webhook secret"))
+			return
+		}
+
+		next(w, r)
 	}
+}
 
+func webhookAuthorized(header string, secret string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(header), []byte(secret)) == 1
 }
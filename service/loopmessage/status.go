@@ -0,0 +1,80 @@
+package loopmessage
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// The delivery statuses a text can have. It is queued once LoopMessage has
+// accepted it, and moves on when a status webhook reports the outcome.
+const (
+	StatusQueued = "queued"
+	StatusSent   = "sent"
+	StatusFailed = "failed"
+)
+
+// deliveryStatus reads a status webhook, returning false for alert types
+// that say nothing about whether the text arrived.
+func deliveryStatus(payload types.SentLoopMessagePayload) (string, bool) {
+	switch payload.AlertType {
+	case "message_sent":
+		if payload.Success {
+			return StatusSent, true
+		}
+		return StatusFailed, true
+	case "message_failed", "message_timeout":
+		return StatusFailed, true
+	default:
+		return "", false
+	}
+}
+
+// handleMessageStatus godoc
+//
+//	@Id				loopMessageStatus
+//	@Summary		Receive a delivery status from LoopMessage
+//	@Description	Called by LoopMessage, at the status callback given with each text, as the text is sent or fails. Alert types that say nothing about delivery are acknowledged and ignored, as is a status for a text this service did not send. The Authorization header must be the shared webhook secret, which is sent with each text for its callbacks.
+//	@Tags			loopmessage
+//	@Accept			json
+//	@Param			status	body	types.SentLoopMessagePayload	true	"LoopMessage status webhook"
+//	@Success		200
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		401	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Failure		503	{object}	types.ErrorResponse
+//	@Router			/loopmessage/status [post]
+func (h *Handler) handleMessageStatus(w http.ResponseWriter, r *http.Request) {
+	var payload types.SentLoopMessagePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	status, ok := deliveryStatus(payload)
+	if !ok {
+		utils.WriteStatus(w, http.StatusOK)
+		return
+	}
+
+	found, err := h.store.UpdateLoopMessageStatus(payload.MessageId, status)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		log.Printf("status %s for unknown loopmessage %s", status, payload.MessageId)
+	}
+
+	utils.WriteStatus(w, http.StatusOK)
+}
@@ -0,0 +1,32 @@
+package loopmessage
+
+import (
+	"testing"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestDeliveryStatus(t *testing.T) {
+	cases := []struct {
+		alertType string
+		success   bool
+		want      string
+		wantOk    bool
+	}{
+		{"message_sent", true, StatusSent, true},
+		{"message_sent", false, StatusFailed, true},
+		{"message_failed", false, StatusFailed, true},
+		{"message_timeout", false, StatusFailed, true},
+		// Inbound texts and reactions come to the same webhook but are not
+		// about delivery.
+		{"message_inbound", true, "", false},
+		{"message_reaction", true, "", false},
+	}
+
+	for _, tc := range cases {
+		got, ok := deliveryStatus(types.SentLoopMessagePayload{AlertType: tc.alertType, Success: tc.success})
+		if got != tc.want || ok != tc.wantOk {
+			t.Errorf("%s (success %v): got %q %v, want %q %v", tc.alertType, tc.success, got, ok, tc.want, tc.wantOk)
+		}
+	}
+}
@@ -2,11 +2,32 @@ package loopmessage
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/whitallee/animal-family-backend/types"
 )
 
+// ErrVerificationTooSoon is returned when a user asks for another code before
+// the cooldown on the last one has passed.
+var ErrVerificationTooSoon = errors.New("a code was sent moments ago; wait a minute before asking for another")
+
+// ErrNoPendingVerification is returned when the user has no code waiting, or
+// it has expired or run out of guesses.
+var ErrNoPendingVerification = errors.New("no verification code is waiting; ask for a new one")
+
+// ErrPhoneTaken is returned when another account has already verified the
+// number.
+var ErrPhoneTaken = errors.New("this number belongs to another account")
+
+// ErrPhoneNotVerified is returned when opting in without a verified number.
+var ErrPhoneNotVerified = errors.New("add and verify a phone number first")
+
+// ErrUnknownPhone is returned when no user has verified the number.
+var ErrUnknownPhone = errors.New("no user has this phone number")
+
 type Store struct {
 	db *sql.DB
 }
@@ -15,12 +36,199 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-func (s *Store) ReceiveLoopMessage(loopMessage types.InboundLoopMessagePayload) error {
-	fmt.Println(loopMessage.Text)
+func (s *Store) GetUserIdByPhone(phone string) (int, error) {
+	var userID int
+	err := s.db.QueryRow(`SELECT "userId" FROM users WHERE "phone" = $1`, phone).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrUnknownPhone
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// ClaimSMSCommand returns false if the message was claimed already, which is
+// how a replayed webhook is told apart from a new text.
+func (s *Store) ClaimSMSCommand(messageId string, userID int, text string) (bool, error) {
+	result, err := s.db.Exec(`INSERT INTO "smsCommands" ("messageId", "userId", "text") VALUES ($1, $2, $3)
+							ON CONFLICT ("messageId") DO NOTHING`, messageId, userID, text)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (s *Store) FinishSMSCommand(messageId string, command string, reply string) error {
+	_, err := s.db.Exec(`UPDATE "smsCommands" SET "command" = $2, "reply" = $3 WHERE "messageId" = $1`,
+		messageId, command, reply)
+
+	return err
+}
+
+func (s *Store) GetPhoneStatus(userID int) (*types.PhoneStatus, error) {
+	status := &types.PhoneStatus{}
+	err := s.db.QueryRow(`SELECT u."phone", u."smsOptedInAt" IS NOT NULL, v."phone"
+						FROM users u
+						LEFT JOIN "phoneVerifications" v ON v."userId" = u."userId" AND v."expiresAt" > NOW() AT TIME ZONE 'UTC'
+						WHERE u."userId" = $1`, userID).Scan(&status.Phone, &status.OptedIn, &status.PendingPhone)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user with id %d not found", userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+func (s *Store) StartPhoneVerification(userID int, phone string, codeHash string, expiresAt time.Time, cooldown time.Duration) error {
+	result, err := s.db.Exec(`INSERT INTO "phoneVerifications" ("userId", "phone", "codeHash", "expiresAt", "createdAt")
+							VALUES ($1, $2, $3, $4, NOW() AT TIME ZONE 'UTC')
+							ON CONFLICT ("userId") DO UPDATE SET
+								"phone" = EXCLUDED."phone", "codeHash" = EXCLUDED."codeHash", "attempts" = 0,
+								"expiresAt" = EXCLUDED."expiresAt", "createdAt" = EXCLUDED."createdAt"
+							WHERE "phoneVerifications"."createdAt" <= NOW() AT TIME ZONE 'UTC' - make_interval(secs => $5)`,
+		userID, phone, codeHash, expiresAt.UTC(), cooldown.Seconds())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrVerificationTooSoon
+	}
+
 	return nil
 }
 
-func (s *Store) SendLoopMessage(loopMessage types.SentLoopMessagePayload) error {
-	fmt.Println(loopMessage.Text)
+func (s *Store) CancelPhoneVerification(userID int, codeHash string) error {
+	_, err := s.db.Exec(`DELETE FROM "phoneVerifications" WHERE "userId" = $1 AND "codeHash" = $2`, userID, codeHash)
+
+	return err
+}
+
+// SpendPhoneVerificationAttempt counts the guess in the same statement that
+// checks there are guesses left, so guesses made at once cannot share one.
+func (s *Store) SpendPhoneVerificationAttempt(userID int, maxAttempts int) (*types.PhoneVerification, error) {
+	v := &types.PhoneVerification{}
+	err := s.db.QueryRow(`UPDATE "phoneVerifications" SET "attempts" = "attempts" + 1
+						WHERE "userId" = $1 AND "attempts" < $2 AND "expiresAt" > NOW() AT TIME ZONE 'UTC'
+						RETURNING "userId", "phone", "codeHash", "attempts", "expiresAt"`, userID, maxAttempts).Scan(
+		&v.UserID,
+		&v.Phone,
+		&v.CodeHash,
+		&v.Attempts,
+		&v.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoPendingVerification
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// ConfirmPhone also removes the code, so it cannot be used again.
+func (s *Store) ConfirmPhone(userID int, phone string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`UPDATE users SET "phone" = $2, "smsOptedInAt" = NOW() AT TIME ZONE 'UTC' WHERE "userId" = $1`, userID, phone)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+		return ErrPhoneTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM "phoneVerifications" WHERE "userId" = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetSMSOptIn keeps the time of an opt-in already in force, so it records
+// when the user first agreed.
+func (s *Store) SetSMSOptIn(userID int, optedIn bool) error {
+	if !optedIn {
+		_, err := s.db.Exec(`UPDATE users SET "smsOptedInAt" = NULL WHERE "userId" = $1`, userID)
+		return err
+	}
+
+	result, err := s.db.Exec(`UPDATE users SET "smsOptedInAt" = COALESCE("smsOptedInAt", NOW() AT TIME ZONE 'UTC')
+							WHERE "userId" = $1 AND "phone" IS NOT NULL`, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrPhoneNotVerified
+	}
+
 	return nil
 }
+
+func (s *Store) RemovePhone(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`UPDATE users SET "phone" = NULL, "smsOptedInAt" = NULL WHERE "userId" = $1`, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM "phoneVerifications" WHERE "userId" = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) RecordLoopMessage(m types.LoopMessage) error {
+	_, err := s.db.Exec(`INSERT INTO "loopMessages" ("messageId", "userId", "notificationId", "recipient", "text")
+						VALUES ($1, $2, NULLIF($3, 0), $4, $5)`,
+		m.MessageId, m.UserID, m.NotificationId, m.Recipient, m.Text)
+
+	return err
+}
+
+func (s *Store) UpdateLoopMessageStatus(messageId string, status string) (bool, error) {
+	result, err := s.db.Exec(`UPDATE "loopMessages" SET "status" = $2, "updatedAt" = CURRENT_TIMESTAMP WHERE "messageId" = $1`,
+		messageId, status)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
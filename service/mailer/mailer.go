@@ -0,0 +1,160 @@
+// Package mailer renders and sends the emails the app sends: task reminders,
+// which the notification sender delivers on its email channel, and account
+// events, which go out whatever the user's notification preferences say.
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templates embed.FS
+
+// The templates a message can be rendered from. Each has an .html and a .txt
+// file under templates/, which fill in the "content" block of the matching
+// layout.
+const (
+	templateTaskReminder   = "task_reminder"
+	templateVerifyEmail    = "verify_email"
+	templateWelcome        = "welcome"
+	templateAccountDeleted = "account_deleted"
+)
+
+type Mailer struct {
+	transport      Transport
+	from           string
+	appURL         string
+	supportAddress string
+}
+
+// New returns a mailer that sends from from through transport. appURL is
+// where the emails link to open the app. Nobody reads mail sent to from, so
+// emails that ask to hear back name supportAddress instead.
+func New(transport Transport, from string, appURL string, supportAddress string) *Mailer {
+	return &Mailer{
+		transport:      transport,
+		from:           from,
+		appURL:         appURL,
+		supportAddress: supportAddress,
+	}
+}
+
+// TaskReminder is a notification about a task, worded as it is in the
+// notification center. UnsubscribeURL turns this kind of reminder off, and
+// UnsubscribeReason says why the recipient is getting it.
+type TaskReminder struct {
+	Title             string
+	Body              string
+	UnsubscribeURL    string
+	UnsubscribeReason string
+}
+
+// templateData is what every template is executed with. Fields a template
+// does not use are left empty.
+type templateData struct {
+	FirstName         string
+	Title             string
+	Body              string
+	AppURL            string
+	UnsubscribeURL    string
+	UnsubscribeReason string
+	VerifyURL         string
+	SupportAddress    string
+}
+
+// SendTaskReminder emails a task reminder. It carries List-Unsubscribe
+// headers, so mail clients can offer their own one-click unsubscribe, which
+// POSTs to the same URL.
+func (m *Mailer) SendTaskReminder(to string, firstName string, reminder TaskReminder) error {
+	msg, err := m.render(templateTaskReminder, reminder.Title, to, templateData{
+		FirstName:         firstName,
+		Title:             reminder.Title,
+		Body:              reminder.Body,
+		AppURL:            m.appURL,
+		UnsubscribeURL:    reminder.UnsubscribeURL,
+		UnsubscribeReason: reminder.UnsubscribeReason,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg.Headers = map[string]string{
+		"List-Unsubscribe":      "<" + reminder.UnsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+
+	return m.transport.Send(msg)
+}
+
+// SendEmailVerification sends the link that verifies a new account's
+// address. It is the only email sent to an address before that, and it has
+// no link to the app, so that it asks the reader to do one thing.
+func (m *Mailer) SendEmailVerification(to string, firstName string, verifyURL string) error {
+	msg, err := m.render(templateVerifyEmail, "Verify your email address", to, templateData{
+		FirstName: firstName,
+		VerifyURL: verifyURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.transport.Send(msg)
+}
+
+// SendWelcome greets a user once their address has been verified.
+func (m *Mailer) SendWelcome(to string, firstName string) error {
+	msg, err := m.render(templateWelcome, "Welcome to Animal Family", to, templateData{
+		FirstName: firstName,
+		AppURL:    m.appURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.transport.Send(msg)
+}
+
+// SendAccountDeleted confirms that a user's account is gone. It has no link
+// to the app, since there is nothing left to sign in to.
+func (m *Mailer) SendAccountDeleted(to string, firstName string) error {
+	msg, err := m.render(templateAccountDeleted, "Your Animal Family account has been deleted", to, templateData{
+		FirstName:      firstName,
+		SupportAddress: m.supportAddress,
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.transport.Send(msg)
+}
+
+func (m *Mailer) render(name string, subject string, to string, data templateData) (Message, error) {
+	html, err := htmltemplate.ParseFS(templates, "templates/layout.html", "templates/"+name+".html")
+	if err != nil {
+		return Message{}, err
+	}
+	var htmlBody bytes.Buffer
+	if err := html.ExecuteTemplate(&htmlBody, "layout", data); err != nil {
+		return Message{}, err
+	}
+
+	text, err := texttemplate.ParseFS(templates, "templates/layout.txt", "templates/"+name+".txt")
+	if err != nil {
+		return Message{}, err
+	}
+	var textBody bytes.Buffer
+	if err := text.ExecuteTemplate(&textBody, "layout", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		From:    m.from,
+		To:      to,
+		Subject: subject,
+		Text:    textBody.String(),
+		HTML:    htmlBody.String(),
+	}, nil
+}
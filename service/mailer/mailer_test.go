@@ -0,0 +1,119 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSendTaskReminder(t *testing.T) {
+	capture := NewCaptureTransport()
+	m := New(capture, "Animal Family <noreply@example.com>", "https://app.example.com", "support@example.com")
+
+	err := m.SendTaskReminder("sam@example.com", "Sam", TaskReminder{
+		Title:             "Feed (Rex)",
+		Body:              "Two <crickets>",
+		UnsubscribeURL:    "https://api.example.com/unsubscribe?token=abc",
+		UnsubscribeReason: "You turned these on.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := capture.Messages()
+	if len(sent) != 1 {
+		t.Fatalf("got %d messages, want 1", len(sent))
+	}
+	msg := sent[0]
+
+	if msg.Subject != "Feed (Rex)" || msg.To != "sam@example.com" {
+		t.Errorf("got subject %q to %q", msg.Subject, msg.To)
+	}
+	// Task names and descriptions are user input, so the HTML part must
+	// escape them; the plain-text part shows them as written.
+	if !strings.Contains(msg.HTML, "Two &lt;crickets&gt;") {
+		t.Errorf("HTML body does not escape the task body:\n%s", msg.HTML)
+	}
+	if !strings.Contains(msg.Text, "Two <crickets>") {
+		t.Errorf("text body does not show the task body as written:\n%s", msg.Text)
+	}
+	for _, body := range []string{msg.HTML, msg.Text} {
+		if !strings.Contains(body, "https://api.example.com/unsubscribe?token=abc") {
+			t.Errorf("body has no unsubscribe link:\n%s", body)
+		}
+	}
+	if msg.Headers["List-Unsubscribe"] != "<https://api.example.com/unsubscribe?token=abc>" {
+		t.Errorf("got List-Unsubscribe %q", msg.Headers["List-Unsubscribe"])
+	}
+}
+
+func TestAccountEmailsHaveNoUnsubscribeLink(t *testing.T) {
+	capture := NewCaptureTransport()
+	m := New(capture, "noreply@example.com", "https://app.example.com", "support@example.com")
+
+	if err := m.SendEmailVerification("sam@example.com", "Sam", "https://api.example.com/verify?token=abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SendWelcome("sam@example.com", "Sam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SendAccountDeleted("sam@example.com", "Sam"); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := capture.Messages()
+	if len(sent) != 3 {
+		t.Fatalf("got %d messages, want 3", len(sent))
+	}
+	for _, msg := range sent {
+		if strings.Contains(msg.Text, "Unsubscribe") || msg.Headers["List-Unsubscribe"] != "" {
+			t.Errorf("%q offers an unsubscribe", msg.Subject)
+		}
+	}
+	// There is no account left to open the app with.
+	if strings.Contains(sent[2].Text, "https://app.example.com") {
+		t.Errorf("account deleted email links to the app:\n%s", sent[2].Text)
+	}
+	// The sender is a noreply address, so it must not be what they reply to.
+	for _, body := range []string{sent[2].HTML, sent[2].Text} {
+		if !strings.Contains(body, "support@example.com") {
+			t.Errorf("account deleted email does not name the support address:\n%s", body)
+		}
+	}
+	for _, body := range []string{sent[0].HTML, sent[0].Text} {
+		if !strings.Contains(body, "https://api.example.com/verify?token=abc") {
+			t.Errorf("verification email has no verification link:\n%s", body)
+		}
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := Message{
+		From:    "noreply@example.com",
+		To:      "sam@example.com",
+		Subject: "Füttern",
+		Text:    "plain",
+		HTML:    "<p>html</p>",
+	}
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Subject: =?utf-8?q?F=C3=BCttern?=", "multipart/alternative", "text/plain", "text/html"} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("message is missing %q", want)
+		}
+	}
+
+	// A line break would let a header value smuggle in headers of its own.
+	msg.Headers = map[string]string{"List-Unsubscribe": "<x>\r\nBcc: someone@example.com"}
+	if _, err := msg.Bytes(); err == nil {
+		t.Error("expected a header with a line break to be rejected")
+	}
+
+	msg.Headers = nil
+	msg.To = "not an address"
+	if err := NewCaptureTransport().Send(msg); err == nil {
+		t.Error("expected the capture transport to reject what SMTP would")
+	}
+}
@@ -0,0 +1,82 @@
+package mailer
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"sync"
+)
+
+// Transport hands a finished message to whatever delivers it.
+type Transport interface {
+	Send(msg Message) error
+}
+
+// SMTPTransport sends through an SMTP relay. Auth is skipped when User is
+// empty, for a local relay that does not ask for it; net/smtp upgrades to TLS
+// whenever the server offers STARTTLS, and refuses to send credentials
+// without it except to localhost.
+type SMTPTransport struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+}
+
+func NewSMTPTransport(host, port, user, password string) *SMTPTransport {
+	return &SMTPTransport{
+		Host:     host,
+		Port:     port,
+		User:     user,
+		Password: password,
+	}
+}
+
+func (t *SMTPTransport) Send(msg Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if t.User != "" {
+		auth = smtp.PlainAuth("", t.User, t.Password, t.Host)
+	}
+
+	if err := smtp.SendMail(net.JoinHostPort(t.Host, t.Port), auth, msg.From, []string{msg.To}, body); err != nil {
+		return fmt.Errorf("failed to send email to %s: %v", msg.To, err)
+	}
+
+	return nil
+}
+
+// CaptureTransport keeps every message instead of sending it, for tests and
+// for running locally without a relay.
+type CaptureTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewCaptureTransport() *CaptureTransport {
+	return &CaptureTransport{}
+}
+
+func (t *CaptureTransport) Send(msg Message) error {
+	if _, err := msg.Bytes(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = append(t.messages, msg)
+
+	return nil
+}
+
+// Messages returns a copy of what has been sent so far, oldest first.
+func (t *CaptureTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Message(nil), t.messages...)
+}
@@ -0,0 +1,198 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// apnsTokenLifetime is how long a provider token is reused. APNs refuses one
+// older than an hour, and one refreshed more often than every twenty minutes.
+const apnsTokenLifetime = 50 * time.Minute
+
+// APNsProvider sends to iOS devices through the APNs HTTP/2 API, or any
+// service that speaks it, authenticating with a token signing key.
+type APNsProvider struct {
+	baseURL string
+	keyID   string
+	teamID  string
+	topic   string
+	key     *ecdsa.PrivateKey
+	client  *http.Client
+	now     func() time.Time
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// NewAPNsProvider takes the .p8 signing key's PEM, in which a literal \n is
+// read as a newline so the key fits on one line of an env file. baseURL is
+// normally https://api.push.apple.com, or https://api.sandbox.push.apple.com
+// for development builds. topic is the app's bundle ID.
+func NewAPNsProvider(baseURL, keyID, teamID, topic string, privateKeyPEM []byte) (*APNsProvider, error) {
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, fmt.Errorf("invalid APNs config: key ID, team ID and topic are required")
+	}
+
+	pemText := strings.ReplaceAll(string(privateKeyPEM), `\n`, "\n")
+	key, err := jwt.ParseECPrivateKeyFromPEM([]byte(pemText))
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs private key: %v", err)
+	}
+
+	return &APNsProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		keyID:   keyID,
+		teamID:  teamID,
+		topic:   topic,
+		key:     key,
+		client:  &http.Client{},
+		now:     time.Now,
+	}, nil
+}
+
+type apnsAlert struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type apnsAps struct {
+	Alert    apnsAlert `json:"alert"`
+	Sound    string    `json:"sound"`
+	ThreadID string    `json:"thread-id,omitempty"`
+}
+
+type apnsPayload struct {
+	Aps  apnsAps                `json:"aps"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+func (p *APNsProvider) Send(ctx context.Context, reg *types.DeviceRegistration, msg PushMessage) (int, error) {
+	body, err := json.Marshal(apnsPayload{
+		Aps:  apnsAps{Alert: apnsAlert{Title: msg.Title, Body: msg.Body}, Sound: "default", ThreadID: msg.Tag},
+		Data: msg.Data,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	token, err := p.providerToken()
+	if err != nil {
+		return 0, err
+	}
+
+	endpoint := p.baseURL + "/3/device/" + url.PathEscape(reg.Token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	// Priority 10 wakes the device at once; 5 lets it wait for a convenient
+	// moment, as normal urgency does for web push.
+	priority := "5"
+	if msg.Urgency == webpush.UrgencyHigh {
+		priority = "10"
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("apns-topic", p.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", priority)
+	req.Header.Set("apns-expiration", strconv.FormatInt(p.now().Add(pushTTL*time.Second).Unix(), 10))
+	if msg.Tag != "" && len(msg.Tag) <= 64 {
+		req.Header.Set("apns-collapse-id", msg.Tag)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, nil
+	}
+
+	var failure struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 4<<10)).Decode(&failure)
+
+	// APNs can stop taking a provider token before its lifetime is up, for
+	// instance after the clock it was issued by was wrong. Signing a new one
+	// on the next send is all that fixes it.
+	if resp.StatusCode == http.StatusForbidden && (failure.Reason == "ExpiredProviderToken" || failure.Reason == "InvalidProviderToken") {
+		p.forgetProviderToken(token)
+	}
+
+	if apnsTokenInvalid(resp.StatusCode, failure.Reason) {
+		return resp.StatusCode, fmt.Errorf("%w: APNs answered %d %s", ErrRegistrationGone, resp.StatusCode, failure.Reason)
+	}
+
+	return resp.StatusCode, fmt.Errorf("APNs answered %d: %s", resp.StatusCode, failure.Reason)
+}
+
+// apnsTokenInvalid says whether APNs refused the device token itself: 410 is
+// a token no longer active for the topic, and the 400 reasons a token that
+// was never valid for it.
+func apnsTokenInvalid(status int, reason string) bool {
+	if status == http.StatusGone {
+		return true
+	}
+
+	return status == http.StatusBadRequest && (reason == "BadDeviceToken" || reason == "DeviceTokenNotForTopic")
+}
+
+// providerToken returns the signed JWT APNs authenticates by, reusing it for
+// apnsTokenLifetime.
+func (p *APNsProvider) providerToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if p.token != "" && now.Sub(p.issuedAt) < apnsTokenLifetime {
+		return p.token, nil
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": p.teamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = p.keyID
+
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		return "", err
+	}
+
+	p.token = signed
+	p.issuedAt = now
+
+	return signed, nil
+}
+
+// forgetProviderToken drops token so the next send signs a new one, unless
+// another send has already replaced it.
+func (p *APNsProvider) forgetProviderToken(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.token == token {
+		p.token = ""
+	}
+}
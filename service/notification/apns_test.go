@@ -0,0 +1,139 @@
+package notification
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func fakeAPNs(t *testing.T, status int, body string) (*APNsProvider, *http.Request, *apnsPayload) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := new(http.Request)
+	var payload apnsPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*got = *r.Clone(context.Background())
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+
+		token, err := jwt.Parse(strings.TrimPrefix(r.Header.Get("Authorization"), "bearer "), func(*jwt.Token) (any, error) {
+			return &key.PublicKey, nil
+		}, jwt.WithValidMethods([]string{"ES256"}))
+		if err != nil || token.Header["kid"] != "KEY123" {
+			t.Errorf("bad provider token: %v", err)
+		}
+
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Written with literal \n, as it would be in an env file.
+	keyPEM := strings.ReplaceAll(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), "\n", `\n`)
+
+	provider, err := NewAPNsProvider(server.URL, "KEY123", "TEAM123", "app.animalfamily", []byte(keyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider, got, &payload
+}
+
+func TestAPNsProviderSend(t *testing.T) {
+	provider, got, payload := fakeAPNs(t, http.StatusOK, "")
+
+	status, err := provider.Send(context.Background(), &types.DeviceRegistration{Provider: types.ProviderAPNs, Token: "abc123"}, PushMessage{
+		Title:   "Feed (Rex)",
+		Body:    "Two crickets",
+		Tag:     "task-7",
+		Data:    map[string]interface{}{"taskId": 7},
+		Urgency: webpush.UrgencyNormal,
+	})
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("Send = %d, %v", status, err)
+	}
+
+	if got.URL.Path != "/3/device/abc123" {
+		t.Errorf("path = %s", got.URL.Path)
+	}
+	if got.Header.Get("apns-topic") != "app.animalfamily" || got.Header.Get("apns-priority") != "5" || got.Header.Get("apns-collapse-id") != "task-7" {
+		t.Errorf("headers = %v", got.Header)
+	}
+	if payload.Aps.Alert.Title != "Feed (Rex)" || payload.Data["taskId"] != float64(7) {
+		t.Errorf("payload = %+v", payload)
+	}
+}
+
+func TestAPNsProviderInvalidToken(t *testing.T) {
+	cases := []struct {
+		status int
+		reason string
+		gone   bool
+	}{
+		{http.StatusGone, "Unregistered", true},
+		{http.StatusBadRequest, "BadDeviceToken", true},
+		{http.StatusBadRequest, "DeviceTokenNotForTopic", true},
+		{http.StatusBadRequest, "PayloadTooLarge", false},
+		{http.StatusForbidden, "ExpiredProviderToken", false},
+	}
+
+	for _, c := range cases {
+		provider, _, _ := fakeAPNs(t, c.status, `{"reason":"`+c.reason+`"}`)
+
+		_, err := provider.Send(context.Background(), &types.DeviceRegistration{Provider: types.ProviderAPNs, Token: "abc123"}, PushMessage{})
+		if err == nil {
+			t.Errorf("%d %s: no error", c.status, c.reason)
+			continue
+		}
+		if errors.Is(err, ErrRegistrationGone) != c.gone {
+			t.Errorf("%d %s: gone = %v, want %v", c.status, c.reason, !c.gone, c.gone)
+		}
+	}
+}
+
+func TestAPNsProviderRenewsRefusedProviderToken(t *testing.T) {
+	for _, reason := range []string{"ExpiredProviderToken", "InvalidProviderToken"} {
+		provider, _, _ := fakeAPNs(t, http.StatusForbidden, `{"reason":"`+reason+`"}`)
+
+		refused, err := provider.providerToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := provider.Send(context.Background(), &types.DeviceRegistration{Provider: types.ProviderAPNs, Token: "abc123"}, PushMessage{}); err == nil {
+			t.Fatalf("%s: no error", reason)
+		}
+
+		// Tokens are signed with the time in seconds, so the next one would
+		// match the refused one were it not for the clock moving on.
+		provider.now = func() time.Time { return time.Now().Add(time.Minute) }
+		renewed, err := provider.providerToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if renewed == refused {
+			t.Errorf("%s: the refused provider token is still in use", reason)
+		}
+	}
+}
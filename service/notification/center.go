@@ -0,0 +1,167 @@
+package notification
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+const (
+	defaultNotificationPageSize = 20
+	maxNotificationPageSize     = 100
+)
+
+// notificationPage is which notifications a list request asks for. Pages are
+// keyed on the last ID seen rather than an offset, so a notification arriving
+// between two requests does not shift the next page.
+type notificationPage struct {
+	Limit      int
+	Before     *int
+	UnreadOnly bool
+}
+
+func parseNotificationPage(r *http.Request) (notificationPage, error) {
+	query := r.URL.Query()
+	page := notificationPage{Limit: defaultNotificationPageSize}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxNotificationPageSize {
+			return notificationPage{}, fmt.Errorf("invalid limit: must be an integer from 1 to %d", maxNotificationPageSize)
+		}
+		page.Limit = limit
+	}
+
+	if raw := query.Get("before"); raw != "" {
+		before, err := strconv.Atoi(raw)
+		if err != nil || before < 1 {
+			return notificationPage{}, fmt.Errorf("invalid before: must be a notification ID")
+		}
+		page.Before = &before
+	}
+
+	if raw := query.Get("unread"); raw != "" {
+		unread, err := strconv.ParseBool(raw)
+		if err != nil {
+			return notificationPage{}, fmt.Errorf("invalid unread: must be true or false")
+		}
+		page.UnreadOnly = unread
+	}
+
+	return page, nil
+}
+
+// notificationList trims the extra notification fetched past the end of the
+// page, whose presence is what says there is another page.
+func notificationList(notifications []types.Notification, limit int, unread int) types.NotificationListResponse {
+	response := types.NotificationListResponse{
+		Notifications: notifications,
+		UnreadCount:   unread,
+	}
+
+	if len(notifications) > limit {
+		response.Notifications = notifications[:limit]
+		next := notifications[limit-1].NotificationId
+		response.NextBefore = &next
+	}
+
+	return response
+}
+
+// handleListNotifications godoc
+//
+//	@Id				listNotifications
+//	@Summary		List the caller's notifications
+//	@Description	Newest first. Every notification the caller has been sent is kept here, whether or not it was also pushed to a device, until it is pruned: 30 days after it was created once read, or 90 days if it never is. Pass nextBefore from one page as before to fetch the next.
+//	@Tags			notifications
+//	@Produce		json
+//	@Param			limit	query		int		false	"Page size, 1 to 100"	default(20)
+//	@Param			before	query		int		false	"Only notifications older than this notification ID"
+//	@Param			unread	query		bool	false	"Only unread notifications"
+//	@Success		200		{object}	types.NotificationListResponse
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/notifications [get]
+func (h *Handler) handleListNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	page, err := parseNotificationPage(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	notifications, err := h.store.ListNotifications(userID, page.Before, page.Limit+1, page.UnreadOnly)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	unread, err := h.store.CountUnreadNotifications(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, notificationList(notifications, page.Limit, unread))
+}
+
+// handleMarkNotificationRead godoc
+//
+//	@Id				markNotificationRead
+//	@Summary		Mark one of the caller's notifications read
+//	@Description	Marking a notification that is already read leaves its readAt as it was.
+//	@Tags			notifications
+//	@Produce		json
+//	@Param			id	path		int	true	"Notification ID"
+//	@Success		200	{object}	types.Notification
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/notifications/{id}/read [post]
+func (h *Handler) handleMarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	if err := h.store.MarkNotificationRead(id); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	n, err := h.store.GetNotificationById(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, n)
+}
+
+// handleMarkAllNotificationsRead godoc
+//
+//	@Id				markAllNotificationsRead
+//	@Summary		Mark all of the caller's notifications read
+//	@Tags			notifications
+//	@Produce		json
+//	@Success		200	{object}	types.NotificationsReadResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/notifications/read-all [post]
+func (h *Handler) handleMarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	marked, err := h.store.MarkAllNotificationsRead(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.NotificationsReadResponse{Marked: marked})
+}
@@ -0,0 +1,68 @@
+package notification
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestParseNotificationPage(t *testing.T) {
+	cases := []struct {
+		name       string
+		query      string
+		wantLimit  int
+		wantBefore int
+		wantUnread bool
+		wantErr    bool
+	}{
+		{"defaults", "", defaultNotificationPageSize, 0, false, false},
+		{"explicit", "?limit=5&before=40&unread=true", 5, 40, true, false},
+		{"limit too large", "?limit=101", 0, 0, false, true},
+		{"limit zero", "?limit=0", 0, 0, false, true},
+		{"before not an id", "?before=-3", 0, 0, false, true},
+		{"unread not a bool", "?unread=maybe", 0, 0, false, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := parseNotificationPage(httptest.NewRequest("GET", "/notifications"+tc.query, nil))
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			before := 0
+			if page.Before != nil {
+				before = *page.Before
+			}
+			if page.Limit != tc.wantLimit || before != tc.wantBefore || page.UnreadOnly != tc.wantUnread {
+				t.Errorf("got %+v (before %d)", page, before)
+			}
+		})
+	}
+}
+
+func TestNotificationList(t *testing.T) {
+	notifications := []types.Notification{{NotificationId: 9}, {NotificationId: 7}, {NotificationId: 4}}
+
+	// The store is asked for one more than the page size; getting it back
+	// means there is another page, starting below the last one shown.
+	full := notificationList(notifications, 2, 5)
+	if len(full.Notifications) != 2 || full.NextBefore == nil || *full.NextBefore != 7 {
+		t.Errorf("got %d notifications and nextBefore %v, want 2 and 7", len(full.Notifications), full.NextBefore)
+	}
+
+	last := notificationList(notifications, 3, 5)
+	if len(last.Notifications) != 3 || last.NextBefore != nil {
+		t.Errorf("got %d notifications and nextBefore %v, want 3 and none", len(last.Notifications), last.NextBefore)
+	}
+	if last.UnreadCount != 5 {
+		t.Errorf("got unread %d, want 5", last.UnreadCount)
+	}
+}
@@ -0,0 +1,257 @@
+package notification
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// maxDeviceMute is the longest a device can be muted for. A mute is meant to
+// be temporary; a device that should never alert can be removed instead.
+const maxDeviceMute = 30 * 24 * time.Hour
+
+// handleListDevices godoc
+//
+//	@Id				listDevices
+//	@Summary		List the caller's push devices
+//	@Description	Every device registered for push, whichever service it is reached through. Browsers are listed here too, as webpush.
+//	@Tags			notifications
+//	@Produce		json
+//	@Success		200	{array}		types.DeviceRegistrationResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/notifications/devices [get]
+func (h *Handler) handleListDevices(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	registrations, err := h.store.GetDeviceRegistrationsByUserId(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.NewDeviceRegistrationResponses(registrations))
+}
+
+// handleRegisterDevice godoc
+//
+//	@Id				registerDevice
+//	@Summary		Register a native app for push
+//	@Description	Takes an FCM registration token or an APNs device token. Registering a token again refreshes the existing registration. Returns 503 if the server has no credentials for the provider.
+//	@Tags			notifications
+//	@Accept			json
+//	@Produce		json
+//	@Param			device	body		types.RegisterDevicePayload	true	"Device token"
+//	@Success		201		{object}	types.DeviceRegistrationResponse
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Failure		503		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/notifications/devices [post]
+func (h *Handler) handleRegisterDevice(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	var payload types.RegisterDevicePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	// A registration nothing could be sent to would look like it worked.
+	if !h.sender.Supports(payload.Provider) {
+		utils.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("%s push is not configured", payload.Provider))
+		return
+	}
+
+	id, err := h.store.CreateDeviceRegistration(types.DeviceRegistration{
+		UserID:    userID,
+		Provider:  payload.Provider,
+		Token:     payload.Token,
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	reg, err := h.store.GetDeviceRegistrationById(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, types.NewDeviceRegistrationResponse(reg))
+}
+
+// handleGetDevice godoc
+//
+//	@Id				getDevice
+//	@Summary		Get a push device
+//	@Description	Includes the device's delivery health, kept from the pushes actually sent to it.
+//	@Tags			notifications
+//	@Produce		json
+//	@Param			id	path		int	true	"Registration ID"
+//	@Success		200	{object}	types.DeviceRegistrationResponse
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/notifications/devices/{id} [get]
+func (h *Handler) handleGetDevice(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	reg, err := h.store.GetDeviceRegistrationById(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.NewDeviceRegistrationResponse(reg))
+}
+
+// handleUpdateDevice godoc
+//
+//	@Id				updateDevice
+//	@Summary		Name, mute or filter a push device
+//	@Description	Replaces the device's name, mute and alert filter. A device can be muted for up to 30 days. With subjects set it only alerts for those animals and enclosures, an enclosure covering the animals in it; a null subjects lets it alert for everything. The filter does not apply to escalations of tasks the caller is a backup for. Naming an animal or enclosure the caller does not own is refused with 403.
+//	@Tags			notifications
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"Registration ID"
+//	@Param			device	body		types.UpdateDevicePayload	true	"Device settings"
+//	@Success		200		{object}	types.DeviceRegistrationResponse
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/notifications/devices/{id} [put]
+func (h *Handler) handleUpdateDevice(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	var payload types.UpdateDevicePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	if err := checkMute(payload.MutedUntil, time.Now()); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	reg := types.DeviceRegistration{
+		RegistrationId: id,
+		UserID:         userID,
+		Name:           payload.Name,
+		MutedUntil:     payload.MutedUntil,
+	}
+	if payload.Subjects != nil {
+		reg.AlertsFiltered = true
+		reg.AnimalIds = payload.Subjects.AnimalIds
+		reg.EnclosureIds = payload.Subjects.EnclosureIds
+	}
+
+	if err := h.store.UpdateDeviceRegistration(reg); err != nil {
+		if errors.Is(err, ErrSubjectNotOwned) {
+			utils.WriteError(w, http.StatusForbidden, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	updated, err := h.store.GetDeviceRegistrationById(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.NewDeviceRegistrationResponse(updated))
+}
+
+// checkMute refuses a mute that has already ended or runs past
+// maxDeviceMute. nil, which unmutes, is always fine.
+func checkMute(mutedUntil *time.Time, now time.Time) error {
+	if mutedUntil == nil {
+		return nil
+	}
+
+	if !mutedUntil.After(now) {
+		return fmt.Errorf("mutedUntil must be in the future; send null to unmute")
+	}
+	if mutedUntil.Sub(now) > maxDeviceMute {
+		return fmt.Errorf("a device can be muted for at most %d days", int(maxDeviceMute/(24*time.Hour)))
+	}
+
+	return nil
+}
+
+// handleTestDevice godoc
+//
+//	@Id				testDevice
+//	@Summary		Send a test push to one device
+//	@Description	Sends even to a muted or filtered device, and reports the push service's response. The outcome counts towards the device's delivery health like any other push.
+//	@Tags			notifications
+//	@Produce		json
+//	@Param			id	path		int	true	"Registration ID"
+//	@Success		200	{object}	types.TestNotificationResult
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/notifications/devices/{id}/test [post]
+func (h *Handler) handleTestDevice(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	reg, err := h.store.GetDeviceRegistrationById(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, h.sendTest(r.Context(), reg))
+}
+
+// handleDeleteDevice godoc
+//
+//	@Id				deleteDevice
+//	@Summary		Remove a push device
+//	@Tags			notifications
+//	@Param			id	path	int	true	"Registration ID"
+//	@Success		204
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/notifications/devices/{id} [delete]
+func (h *Handler) handleDeleteDevice(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	if err := h.store.DeleteDeviceRegistration(id); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteStatus(w, http.StatusNoContent)
+}
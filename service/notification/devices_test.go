@@ -0,0 +1,37 @@
+package notification
+
+import (
+	"testing"
+	"time"
+)
+
+// A mute is temporary by design, and one already over would read as a mute
+// that silently did nothing.
+func TestCheckMute(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	cases := map[string]struct {
+		mutedUntil *time.Time
+		wantErr    bool
+	}{
+		"null unmutes":            {nil, false},
+		"an hour":                 {at(time.Hour), false},
+		"exactly the longest":     {at(maxDeviceMute), false},
+		"past the longest":        {at(maxDeviceMute + time.Minute), true},
+		"already over":            {at(-time.Minute), true},
+		"ending this very moment": {at(0), true},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := checkMute(tc.mutedUntil, now)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("checkMute() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
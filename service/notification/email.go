@@ -0,0 +1,148 @@
+package notification
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/mailer"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// Notifier sends a notification center record to a user on a channel other
+// than push. NotificationSender pushes to devices itself, since pushes have
+// subscriptions, urgency and held deliveries that other channels do not.
+type Notifier interface {
+	Notify(user *types.User, n *types.Notification) error
+}
+
+// EmailNotifier is the email channel. Each email links to an unsubscribe
+// route that turns that kind of notification off by email, leaving the other
+// kinds and push as they are.
+type EmailNotifier struct {
+	mailer     *mailer.Mailer
+	publicHost string
+	secret     []byte
+}
+
+// unsubscribeTokenTTL is how long an unsubscribe link keeps working. It is
+// long enough for an email read weeks late; after that, email settings are
+// changed in the app.
+const unsubscribeTokenTTL = 90 * 24 * time.Hour
+
+func NewEmailNotifier(m *mailer.Mailer, publicHost string, secret []byte) *EmailNotifier {
+	return &EmailNotifier{mailer: m, publicHost: publicHost, secret: secret}
+}
+
+// emailReasons tells the recipient of each kind of email why they are
+// getting it, beside the unsubscribe link.
+var emailReasons = map[string]string{
+	EventTaskReset:      "You are getting this because you turned on emails for tasks coming due.",
+	EventTaskEscalation: "You are getting this because you turned on emails for overdue tasks.",
+}
+
+// Notify sends nothing to an address that has not been verified, since
+// anyone can register with anyone's address. Its owner gets the email channel
+// once they follow the verification link.
+func (e *EmailNotifier) Notify(user *types.User, n *types.Notification) error {
+	if !user.EmailVerifiedAt.Valid {
+		return nil
+	}
+
+	token, err := auth.CreateUnsubscribeToken(e.secret, user.ID, n.Event, ChannelEmail, unsubscribeTokenTTL)
+	if err != nil {
+		return fmt.Errorf("failed to create unsubscribe token for user %d: %v", user.ID, err)
+	}
+
+	return e.mailer.SendTaskReminder(user.Email, user.FirstName, mailer.TaskReminder{
+		Title:             n.Title,
+		Body:              n.Body,
+		UnsubscribeURL:    unsubscribeURL(e.publicHost, token),
+		UnsubscribeReason: emailReasons[n.Event],
+	})
+}
+
+func unsubscribeURL(publicHost string, token string) string {
+	return strings.TrimSuffix(publicHost, "/") + "/api/v2/notifications/email/unsubscribe?token=" + url.QueryEscape(token)
+}
+
+// handleEmailUnsubscribeForm godoc
+//
+//	@Id				confirmUnsubscribeFromEmail
+//	@Summary		Show the page an unsubscribe link opens
+//	@Description	Changes nothing: the page asks the recipient to confirm, which POSTs the token back to the same URL. Mail scanners follow links in emails, and must not unsubscribe anyone by doing so.
+//	@Tags			notifications
+//	@Produce		html
+//	@Param			token	query	string	true	"Token from the unsubscribe link"
+//	@Success		200
+//	@Failure		403
+//	@Router			/notifications/email/unsubscribe [get]
+func (h *Handler) handleEmailUnsubscribeForm(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.parseUnsubscribeToken(r); err != nil {
+		utils.WritePage(w, http.StatusForbidden, unsubscribeLinkInvalidPage)
+		return
+	}
+
+	utils.WritePage(w, http.StatusOK, utils.Page{
+		Title:   "Unsubscribe",
+		Message: "Stop getting these emails from Animal Family? Other kinds of notification stay as they are.",
+		Button:  "Unsubscribe",
+	})
+}
+
+// handleEmailUnsubscribe godoc
+//
+//	@Id				unsubscribeFromEmail
+//	@Summary		Turn off one kind of email from an unsubscribe link
+//	@Description	Authenticated by the token in the link rather than a session, so it works from any mail client. This is both the confirmation form on the page GET shows and the one-click unsubscribe (RFC 8058) that mail clients send from the List-Unsubscribe header. The token expires 90 days after the email was sent and can be used any number of times until then. It only turns email off for the kind of notification it came with; account emails cannot be unsubscribed from.
+//	@Tags			notifications
+//	@Accept			x-www-form-urlencoded
+//	@Produce		html
+//	@Param			token	query	string	true	"Token from the unsubscribe link"
+//	@Success		200
+//	@Failure		403
+//	@Failure		500
+//	@Router			/notifications/email/unsubscribe [post]
+func (h *Handler) handleEmailUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.parseUnsubscribeToken(r)
+	if err != nil {
+		utils.WritePage(w, http.StatusForbidden, unsubscribeLinkInvalidPage)
+		return
+	}
+
+	if err := h.store.SetNotificationChannel(claims.UserID, claims.Event, claims.Channel, false); err != nil {
+		log.Printf("failed to unsubscribe user %d from %s by %s: %v", claims.UserID, claims.Event, claims.Channel, err)
+		utils.WritePage(w, http.StatusInternalServerError, utils.Page{
+			Title:   "Something went wrong",
+			Message: "You have not been unsubscribed. Try the link again later, or turn these emails off in the app's notification settings.",
+		})
+		return
+	}
+
+	utils.WritePage(w, http.StatusOK, utils.Page{
+		Title:   "Unsubscribed",
+		Message: "You will no longer get these emails.",
+	})
+}
+
+// unsubscribeLinkInvalidPage covers expired links as well as bad ones; the
+// reader can do the same about either.
+var unsubscribeLinkInvalidPage = utils.Page{
+	Title:   "This link no longer works",
+	Message: "Unsubscribe links expire after 90 days. Turn these emails off in the app's notification settings instead.",
+}
+
+func (h *Handler) parseUnsubscribeToken(r *http.Request) (*auth.UnsubscribeClaims, error) {
+	claims, err := auth.ParseUnsubscribeToken(h.secret, r.URL.Query().Get("token"))
+	if err != nil {
+		log.Printf("rejected unsubscribe token: %v", err)
+		return nil, err
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,87 @@
+package notification
+
+import (
+	"database/sql"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/mailer"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestEmailNotifierUnsubscribeLink(t *testing.T) {
+	secret := []byte("secret")
+	capture := mailer.NewCaptureTransport()
+	notifier := NewEmailNotifier(mailer.New(capture, "noreply@example.com", "https://app.example.com", "support@example.com"), "https://api.example.com/", secret)
+
+	user := &types.User{ID: 4, FirstName: "Sam", Email: "sam@example.com"}
+	n := &types.Notification{UserID: 4, Event: EventTaskEscalation, Title: "Still not done: Feed (Rex)"}
+
+	// Nothing goes to an address until it is verified.
+	if err := notifier.Notify(user, n); err != nil {
+		t.Fatal(err)
+	}
+	if sent := capture.Messages(); len(sent) != 0 {
+		t.Fatalf("got %d messages to an unverified address, want 0", len(sent))
+	}
+
+	user.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	if err := notifier.Notify(user, n); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := capture.Messages()
+	if len(sent) != 1 {
+		t.Fatalf("got %d messages, want 1", len(sent))
+	}
+
+	link := strings.Trim(sent[0].Headers["List-Unsubscribe"], "<>")
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/api/v2/notifications/email/unsubscribe" {
+		t.Errorf("got path %q", u.Path)
+	}
+
+	// The link turns off only the kind of email it came with.
+	claims, err := auth.ParseUnsubscribeToken(secret, u.Query().Get("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != 4 || claims.Event != EventTaskEscalation || claims.Channel != ChannelEmail {
+		t.Errorf("got %+v, want user 4, escalations by email", claims)
+	}
+}
+
+func TestChannelSettings(t *testing.T) {
+	prefs := &types.NotificationPreferences{Channels: channelSettings(map[[2]string]bool{
+		{EventTaskReset, ChannelPush}:  false,
+		{EventTaskReset, ChannelEmail}: true,
+	})}
+
+	if len(prefs.Channels) != len(notificationEvents)*len(notificationChannels) {
+		t.Errorf("got %d channels, want every event on every channel", len(prefs.Channels))
+	}
+
+	cases := []struct {
+		event   string
+		channel string
+		want    bool
+	}{
+		{EventTaskReset, ChannelPush, false},
+		{EventTaskReset, ChannelEmail, true},
+		// Untouched pairs take the channel's default: push on, email off.
+		{EventTaskEscalation, ChannelPush, true},
+		{EventTaskEscalation, ChannelEmail, false},
+	}
+
+	for _, tc := range cases {
+		if got := prefs.Enabled(tc.event, tc.channel); got != tc.want {
+			t.Errorf("%s on %s: got %v, want %v", tc.event, tc.channel, got, tc.want)
+		}
+	}
+}
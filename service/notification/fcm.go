@@ -0,0 +1,258 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+const (
+	fcmScope           = "https://www.googleapis.com/auth/firebase.messaging"
+	defaultFCMTokenURL = "https://oauth2.googleapis.com/token"
+	// fcmTokenRefresh is how long before an access token expires that a new
+	// one is fetched, so one is never sent just as it lapses.
+	fcmTokenRefresh = time.Minute
+)
+
+// fcmServiceAccount is the part of a Google service account key file that
+// FCM needs.
+type fcmServiceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// FCMProvider sends to Android and other FCM clients through the FCM HTTP v1
+// API, authenticating as a service account.
+type FCMProvider struct {
+	baseURL  string
+	tokenURL string
+	account  fcmServiceAccount
+	client   *http.Client
+	now      func() time.Time
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewFCMProvider takes the contents of a service account key file. baseURL is
+// the FCM API's, normally https://fcm.googleapis.com. tokenURL overrides the
+// key file's token_uri when set; both exist so tests can point at a fake.
+func NewFCMProvider(baseURL, tokenURL string, serviceAccountJSON []byte) (*FCMProvider, error) {
+	var account fcmServiceAccount
+	if err := json.Unmarshal(serviceAccountJSON, &account); err != nil {
+		return nil, fmt.Errorf("invalid FCM service account: %v", err)
+	}
+	if account.ProjectID == "" || account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, fmt.Errorf("invalid FCM service account: project_id, client_email and private_key are required")
+	}
+	if _, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey)); err != nil {
+		return nil, fmt.Errorf("invalid FCM service account private key: %v", err)
+	}
+
+	if tokenURL == "" {
+		tokenURL = account.TokenURI
+	}
+	if tokenURL == "" {
+		tokenURL = defaultFCMTokenURL
+	}
+
+	return &FCMProvider{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		tokenURL: tokenURL,
+		account:  account,
+		client:   &http.Client{},
+		now:      time.Now,
+	}, nil
+}
+
+type fcmRequest struct {
+	Message fcmMessage `json:"message"`
+}
+
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Notification fcmNotification   `json:"notification"`
+	Data         map[string]string `json:"data,omitempty"`
+	Android      fcmAndroid        `json:"android"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type fcmAndroid struct {
+	Priority     string                 `json:"priority"`
+	TTL          string                 `json:"ttl"`
+	Notification fcmAndroidNotification `json:"notification"`
+}
+
+type fcmAndroidNotification struct {
+	Tag string `json:"tag,omitempty"`
+}
+
+type fcmErrorResponse struct {
+	Error struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+func (p *FCMProvider) Send(ctx context.Context, reg *types.DeviceRegistration, msg PushMessage) (int, error) {
+	data, err := stringData(msg.Data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode FCM data: %v", err)
+	}
+
+	priority := "NORMAL"
+	if msg.Urgency == webpush.UrgencyHigh {
+		priority = "HIGH"
+	}
+
+	body, err := json.Marshal(fcmRequest{Message: fcmMessage{
+		Token:        reg.Token,
+		Notification: fcmNotification{Title: msg.Title, Body: msg.Body},
+		Data:         data,
+		Android: fcmAndroid{
+			Priority:     priority,
+			TTL:          fmt.Sprintf("%ds", pushTTL),
+			Notification: fcmAndroidNotification{Tag: msg.Tag},
+		},
+	}})
+	if err != nil {
+		return 0, err
+	}
+
+	accessToken, err := p.token(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/projects/%s/messages:send", p.baseURL, url.PathEscape(p.account.ProjectID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, nil
+	}
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	var failure fcmErrorResponse
+	_ = json.Unmarshal(raw, &failure)
+
+	if fcmTokenInvalid(failure) {
+		return resp.StatusCode, fmt.Errorf("%w: FCM answered %d %s", ErrRegistrationGone, resp.StatusCode, failure.Error.Message)
+	}
+
+	return resp.StatusCode, fmt.Errorf("FCM answered %d: %s", resp.StatusCode, raw)
+}
+
+// fcmTokenInvalid says whether FCM refused the token itself, as opposed to
+// the request. UNREGISTERED is an app that was uninstalled or a token that
+// expired, and SENDER_ID_MISMATCH a token from another Firebase project.
+// INVALID_ARGUMENT also covers a malformed message, so only the variant about
+// the token counts; otherwise a bug here would wipe every registration.
+func fcmTokenInvalid(failure fcmErrorResponse) bool {
+	for _, detail := range failure.Error.Details {
+		switch detail.ErrorCode {
+		case "UNREGISTERED", "SENDER_ID_MISMATCH":
+			return true
+		case "INVALID_ARGUMENT":
+			if strings.Contains(strings.ToLower(failure.Error.Message), "registration token") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// token returns an OAuth access token for the service account, fetching a
+// new one when the last is about to expire.
+func (p *FCMProvider) token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if p.accessToken != "" && now.Before(p.expiresAt.Add(-fcmTokenRefresh)) {
+		return p.accessToken, nil
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(p.account.PrivateKey))
+	if err != nil {
+		return "", err
+	}
+
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   p.account.ClientEmail,
+		"scope": fcmScope,
+		"aud":   p.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(key)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get FCM access token: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return "", fmt.Errorf("failed to get FCM access token: %d %s", resp.StatusCode, raw)
+	}
+
+	var granted struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&granted); err != nil {
+		return "", fmt.Errorf("failed to read FCM access token: %v", err)
+	}
+
+	p.accessToken = granted.AccessToken
+	p.expiresAt = now.Add(time.Duration(granted.ExpiresIn) * time.Second)
+
+	return p.accessToken, nil
+}
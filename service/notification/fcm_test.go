@@ -0,0 +1,123 @@
+package notification
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// fakeFCM serves the token and send endpoints, answering each send with the
+// status and body the test sets.
+func fakeFCM(t *testing.T, status int, body string) (*FCMProvider, *fcmRequest, *int) {
+	t.Helper()
+
+	var sent fcmRequest
+	tokenFetches := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenFetches++
+		if r.FormValue("assertion") == "" {
+			t.Error("token request has no assertion")
+		}
+		_, _ = w.Write([]byte(`{"access_token":"test-access-token","expires_in":3600}`))
+	})
+	mux.HandleFunc("/v1/projects/test-project/messages:send", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-access-token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&sent)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, _ := json.Marshal(map[string]string{
+		"project_id":   "test-project",
+		"client_email": "push@test-project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+	})
+
+	provider, err := NewFCMProvider(server.URL, server.URL+"/token", account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider, &sent, &tokenFetches
+}
+
+func TestFCMProviderSend(t *testing.T) {
+	provider, sent, tokenFetches := fakeFCM(t, http.StatusOK, `{"name":"projects/test-project/messages/1"}`)
+
+	msg := PushMessage{
+		Title:   "Feed (Rex)",
+		Body:    "Two crickets",
+		Tag:     "task-7",
+		Data:    map[string]interface{}{"taskId": 7, "url": "/"},
+		Urgency: webpush.UrgencyHigh,
+	}
+	reg := &types.DeviceRegistration{Provider: types.ProviderFCM, Token: "device-token"}
+
+	for range 2 {
+		if status, err := provider.Send(context.Background(), reg, msg); err != nil || status != http.StatusOK {
+			t.Fatalf("Send = %d, %v", status, err)
+		}
+	}
+
+	if *tokenFetches != 1 {
+		t.Errorf("fetched %d access tokens, want the first reused", *tokenFetches)
+	}
+	if sent.Message.Token != "device-token" || sent.Message.Notification.Title != "Feed (Rex)" {
+		t.Errorf("sent %+v", sent.Message)
+	}
+	// FCM data values must be strings.
+	if sent.Message.Data["taskId"] != "7" || sent.Message.Data["url"] != "/" {
+		t.Errorf("data = %v", sent.Message.Data)
+	}
+	if sent.Message.Android.Priority != "HIGH" || sent.Message.Android.Notification.Tag != "task-7" {
+		t.Errorf("android = %+v", sent.Message.Android)
+	}
+}
+
+func TestFCMProviderInvalidToken(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		gone   bool
+	}{
+		{"unregistered", http.StatusNotFound, `{"error":{"status":"NOT_FOUND","message":"Requested entity was not found.","details":[{"errorCode":"UNREGISTERED"}]}}`, true},
+		{"malformed token", http.StatusBadRequest, `{"error":{"status":"INVALID_ARGUMENT","message":"The registration token is not a valid FCM registration token","details":[{"errorCode":"INVALID_ARGUMENT"}]}}`, true},
+		// A bad message is our fault, not the device's.
+		{"malformed message", http.StatusBadRequest, `{"error":{"status":"INVALID_ARGUMENT","message":"Invalid value at 'message.android.ttl'","details":[{"errorCode":"INVALID_ARGUMENT"}]}}`, false},
+		{"quota", http.StatusTooManyRequests, `{"error":{"status":"RESOURCE_EXHAUSTED","details":[{"errorCode":"QUOTA_EXCEEDED"}]}}`, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			provider, _, _ := fakeFCM(t, c.status, c.body)
+
+			status, err := provider.Send(context.Background(), &types.DeviceRegistration{Provider: types.ProviderFCM, Token: "t"}, PushMessage{})
+			if err == nil || status != c.status {
+				t.Fatalf("Send = %d, %v, want %d and an error", status, err, c.status)
+			}
+			if errors.Is(err, ErrRegistrationGone) != c.gone {
+				t.Errorf("gone = %v, want %v (%v)", !c.gone, c.gone, err)
+			}
+		})
+	}
+}
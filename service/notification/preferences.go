@@ -0,0 +1,270 @@
+package notification
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// The kinds of notification a user can turn on or off, and the channels they
+// go out on.
+const (
+	EventTaskReset      = "taskReset"
+	EventTaskEscalation = "taskEscalation"
+
+	ChannelPush  = "push"
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+var (
+	notificationEvents   = []string{EventTaskReset, EventTaskEscalation}
+	notificationChannels = []string{ChannelPush, ChannelEmail, ChannelSMS}
+)
+
+// channelDefaults is whether each channel is on for a user who has not said.
+// Push is on, since a user only gets pushes after subscribing a device, and
+// so are texts, which only go to a phone the user has verified and opted in.
+// Email goes to an address given for signing in, so it waits to be asked for.
+var channelDefaults = map[string]bool{
+	ChannelPush:  true,
+	ChannelEmail: false,
+	ChannelSMS:   true,
+}
+
+// channelSettings lists every event on every channel, set as overrides says
+// or else to the channel's default.
+func channelSettings(overrides map[[2]string]bool) []types.NotificationChannel {
+	channels := make([]types.NotificationChannel, 0, len(notificationEvents)*len(notificationChannels))
+	for _, event := range notificationEvents {
+		for _, channel := range notificationChannels {
+			enabled, ok := overrides[[2]string{event, channel}]
+			if !ok {
+				enabled = channelDefaults[channel]
+			}
+
+			channels = append(channels, types.NotificationChannel{
+				Event:   event,
+				Channel: channel,
+				Enabled: enabled,
+			})
+		}
+	}
+
+	return channels
+}
+
+const defaultDigestMinutes = 60
+
+// quietHoursLayout is how the ends of quiet hours are written.
+const quietHoursLayout = "15:04"
+
+// handleGetNotificationPreferences godoc
+//
+//	@Id				getNotificationPreferences
+//	@Summary		Get the caller's notification preferences
+//	@Description	Lists every kind of notification on every channel with whether it is on, along with the caller's quiet hours and digest settings. A caller who has never saved any gets the defaults: push and sms on, email off, no quiet hours and no digest. Texts only go to a phone that is verified and opted in, whatever the sms channel says; see /users/me/phone.
+//	@Tags			notifications
+//	@Produce		json
+//	@Success		200	{object}	types.NotificationPreferences
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/notifications/preferences [get]
+func (h *Handler) handleGetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	prefs, err := h.store.GetNotificationPreferences(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, prefs)
+}
+
+// handleSetNotificationPreferences godoc
+//
+//	@Id				setNotificationPreferences
+//	@Summary		Replace the caller's notification preferences
+//	@Description	Channels not listed go back to their default: push and sms on, email off. Notifications that fall in quiet hours, which are read in the caller's timezone, are held until the quiet hours end; a high urgency escalation still goes out. With a digest, reset notifications are held for digestMinutes from the first one and then sent together as one summary. Changes apply to notifications sent from now on, not to ones already held. Email and texts are sent as soon as a notification is raised; quiet hours and digests only hold back pushes.
+//	@Tags			notifications
+//	@Accept			json
+//	@Produce		json
+//	@Param			preferences	body		types.SetNotificationPreferencesPayload	true	"The new preferences"
+//	@Success		200			{object}	types.NotificationPreferences
+//	@Failure		400			{object}	types.ErrorResponse
+//	@Failure		403			{object}	types.ErrorResponse
+//	@Failure		500			{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/notifications/preferences [put]
+func (h *Handler) handleSetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	var payload types.SetNotificationPreferencesPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	prefs, err := notificationPreferences(payload)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.SetNotificationPreferences(userID, prefs); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	saved, err := h.store.GetNotificationPreferences(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, saved)
+}
+
+// notificationPreferences checks the rules the payload's tags cannot express
+// and returns the preferences to store.
+func notificationPreferences(payload types.SetNotificationPreferencesPayload) (types.NotificationPreferences, error) {
+	if (payload.QuietHoursStart == nil) != (payload.QuietHoursEnd == nil) {
+		return types.NotificationPreferences{}, fmt.Errorf("quiet hours need both quietHoursStart and quietHoursEnd")
+	}
+	if payload.QuietHoursStart != nil && *payload.QuietHoursStart == *payload.QuietHoursEnd {
+		return types.NotificationPreferences{}, fmt.Errorf("quiet hours cannot start and end at the same time")
+	}
+
+	seen := make(map[[2]string]bool, len(payload.Channels))
+	channels := make([]types.NotificationChannel, 0, len(payload.Channels))
+	for _, c := range payload.Channels {
+		key := [2]string{c.Event, c.Channel}
+		if seen[key] {
+			return types.NotificationPreferences{}, fmt.Errorf("%s on %s is listed more than once", c.Event, c.Channel)
+		}
+		seen[key] = true
+
+		channels = append(channels, types.NotificationChannel{Event: c.Event, Channel: c.Channel, Enabled: c.Enabled})
+	}
+
+	digestMinutes := payload.DigestMinutes
+	if digestMinutes == 0 {
+		digestMinutes = defaultDigestMinutes
+	}
+
+	return types.NotificationPreferences{
+		Channels:        channels,
+		QuietHoursStart: payload.QuietHoursStart,
+		QuietHoursEnd:   payload.QuietHoursEnd,
+		DigestEnabled:   payload.DigestEnabled,
+		DigestMinutes:   digestMinutes,
+	}, nil
+}
+
+// quietUntil is when the quiet hours that at falls in end, or nil if at is
+// outside them. Quiet hours that start later in the day than they end run
+// across midnight. Times are read on the wall clock of loc, so the window
+// keeps its local times across a DST change.
+func quietUntil(prefs *types.NotificationPreferences, loc *time.Location, at time.Time) *time.Time {
+	if prefs.QuietHoursStart == nil || prefs.QuietHoursEnd == nil {
+		return nil
+	}
+
+	start, err := time.Parse(quietHoursLayout, *prefs.QuietHoursStart)
+	if err != nil {
+		return nil
+	}
+	end, err := time.Parse(quietHoursLayout, *prefs.QuietHoursEnd)
+	if err != nil {
+		return nil
+	}
+
+	local := at.In(loc)
+	now := local.Hour()*60 + local.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+
+	quiet := now >= from && now < to
+	if from > to {
+		quiet = now >= from || now < to
+	}
+	if !quiet {
+		return nil
+	}
+
+	day := local.Day()
+	if now >= to {
+		day++
+	}
+
+	until := time.Date(local.Year(), local.Month(), day, end.Hour(), end.Minute(), 0, 0, loc)
+
+	return &until
+}
+
+// deliverAt is when a notification for event raised at now should go out. A
+// reset notification under a digest joins the batch already waiting, or
+// starts one. Anything landing in quiet hours waits for them to end, except
+// a high urgency escalation, which is urgent precisely because it cannot wait.
+func deliverAt(prefs *types.NotificationPreferences, loc *time.Location, event string, urgency string, now time.Time, pendingDigest *time.Time) time.Time {
+	at := now
+
+	if event == EventTaskReset && prefs.DigestEnabled {
+		at = now.Add(time.Duration(prefs.DigestMinutes) * time.Minute)
+		if pendingDigest != nil {
+			at = *pendingDigest
+		}
+	}
+
+	if event == EventTaskEscalation && urgency == "high" {
+		return at
+	}
+
+	if until := quietUntil(prefs, loc, at); until != nil {
+		return *until
+	}
+
+	return at
+}
+
+// digestPayload sums up several held reset notifications in one. It lists
+// the tasks by the titles of their own notifications and opens the app
+// rather than any one task, so it has no complete action. Its data names
+// every notification center record it stands for.
+func digestPayload(titles []string, notificationIDs []int) map[string]interface{} {
+	body := strings.Join(titles, ", ")
+	if len(titles) > 1 {
+		body = strings.Join(titles[:len(titles)-1], ", ") + " and " + titles[len(titles)-1]
+	}
+
+	return map[string]interface{}{
+		"title": fmt.Sprintf("%d tasks are due", len(titles)),
+		"body":  body,
+		"data": map[string]interface{}{
+			"url":             "/",
+			"notificationIds": notificationIDs,
+		},
+		"actions": []map[string]interface{}{
+			{
+				"action": "view",
+				"title":  "View",
+			},
+		},
+		"tag":                "task-digest",
+		"requireInteraction": false,
+	}
+}
@@ -0,0 +1,154 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func quietPrefs(start string, end string) *types.NotificationPreferences {
+	return &types.NotificationPreferences{QuietHoursStart: &start, QuietHoursEnd: &end, DigestMinutes: defaultDigestMinutes}
+}
+
+func TestQuietUntil(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("tz database not available")
+	}
+
+	cases := []struct {
+		name  string
+		prefs *types.NotificationPreferences
+		at    time.Time
+		want  *time.Time
+	}{
+		{"no quiet hours", &types.NotificationPreferences{}, time.Date(2026, 10, 19, 3, 0, 0, 0, newYork), nil},
+		{"outside", quietPrefs("22:00", "07:00"), time.Date(2026, 10, 19, 12, 0, 0, 0, newYork), nil},
+		{"before midnight ends tomorrow", quietPrefs("22:00", "07:00"), time.Date(2026, 10, 19, 23, 30, 0, 0, newYork), ptr(time.Date(2026, 10, 20, 7, 0, 0, 0, newYork))},
+		{"after midnight ends today", quietPrefs("22:00", "07:00"), time.Date(2026, 10, 20, 3, 0, 0, 0, newYork), ptr(time.Date(2026, 10, 20, 7, 0, 0, 0, newYork))},
+		{"the end itself is not quiet", quietPrefs("22:00", "07:00"), time.Date(2026, 10, 20, 7, 0, 0, 0, newYork), nil},
+		{"within one day", quietPrefs("13:00", "15:00"), time.Date(2026, 10, 19, 14, 0, 0, 0, newYork), ptr(time.Date(2026, 10, 19, 15, 0, 0, 0, newYork))},
+		// Read on the local clock: 03:00 UTC is 23:00 the evening before in
+		// New York, which is quiet.
+		{"local clock", quietPrefs("22:00", "07:00"), time.Date(2026, 10, 20, 3, 0, 0, 0, time.UTC), ptr(time.Date(2026, 10, 20, 7, 0, 0, 0, newYork))},
+		// The clocks go back on 1 November; the window still ends at 07:00.
+		{"across a DST change", quietPrefs("22:00", "07:00"), time.Date(2026, 10, 31, 23, 0, 0, 0, newYork), ptr(time.Date(2026, 11, 1, 7, 0, 0, 0, newYork))},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := quietUntil(tc.prefs, newYork, tc.at)
+
+			switch {
+			case tc.want == nil && got != nil:
+				t.Errorf("got %v, want not quiet", *got)
+			case tc.want != nil && got == nil:
+				t.Errorf("got not quiet, want %v", *tc.want)
+			case tc.want != nil && !got.Equal(*tc.want):
+				t.Errorf("got %v, want %v", *got, *tc.want)
+			}
+		})
+	}
+}
+
+func TestDeliverAt(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	digest := &types.NotificationPreferences{DigestEnabled: true, DigestMinutes: 30}
+	pending := now.Add(10 * time.Minute)
+
+	cases := []struct {
+		name    string
+		prefs   *types.NotificationPreferences
+		event   string
+		urgency string
+		pending *time.Time
+		want    time.Time
+	}{
+		{"defaults send now", &types.NotificationPreferences{}, EventTaskReset, "high", nil, now},
+		{"digest starts a batch", digest, EventTaskReset, "high", nil, now.Add(30 * time.Minute)},
+		{"digest joins the waiting batch", digest, EventTaskReset, "high", &pending, pending},
+		{"digest leaves escalations alone", digest, EventTaskEscalation, "normal", nil, now},
+		{"quiet hours hold a reset", quietPrefs("11:00", "13:00"), EventTaskReset, "high", nil, now.Add(time.Hour)},
+		{"quiet hours hold a normal escalation", quietPrefs("11:00", "13:00"), EventTaskEscalation, "normal", nil, now.Add(time.Hour)},
+		{"a high escalation breaks through", quietPrefs("11:00", "13:00"), EventTaskEscalation, "high", nil, now},
+		// A batch that would go out during quiet hours waits for them too.
+		{"digest then quiet hours", &types.NotificationPreferences{DigestEnabled: true, DigestMinutes: 90, QuietHoursStart: ptr("13:00"), QuietHoursEnd: ptr("14:00")}, EventTaskReset, "high", nil, now.Add(2 * time.Hour)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := deliverAt(tc.prefs, time.UTC, tc.event, tc.urgency, now, tc.pending)
+			if !got.Equal(tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	cases := []struct {
+		name    string
+		payload types.SetNotificationPreferencesPayload
+		wantErr bool
+	}{
+		{"empty is the defaults", types.SetNotificationPreferencesPayload{}, false},
+		{"quiet hours", types.SetNotificationPreferencesPayload{QuietHoursStart: ptr("22:00"), QuietHoursEnd: ptr("07:00")}, false},
+		{"only a start", types.SetNotificationPreferencesPayload{QuietHoursStart: ptr("22:00")}, true},
+		{"start equals end", types.SetNotificationPreferencesPayload{QuietHoursStart: ptr("22:00"), QuietHoursEnd: ptr("22:00")}, true},
+		{"a channel twice", types.SetNotificationPreferencesPayload{Channels: []types.NotificationChannelPayload{
+			{Event: EventTaskReset, Channel: ChannelPush},
+			{Event: EventTaskReset, Channel: ChannelPush, Enabled: true},
+		}}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			prefs, err := notificationPreferences(tc.payload)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil && prefs.DigestMinutes != defaultDigestMinutes {
+				t.Errorf("got digestMinutes %d, want the default %d", prefs.DigestMinutes, defaultDigestMinutes)
+			}
+		})
+	}
+}
+
+func TestSplitDigest(t *testing.T) {
+	reset := func(id int) *types.HeldNotification {
+		return &types.HeldNotification{HeldId: id, Event: EventTaskReset}
+	}
+	escalation := &types.HeldNotification{HeldId: 9, Event: EventTaskEscalation}
+
+	single, digest := splitDigest([]*types.HeldNotification{reset(1), escalation, reset(2)}, true)
+	if len(digest) != 2 || len(single) != 1 || single[0] != escalation {
+		t.Errorf("got %d single and %d in the digest, want the escalation alone and 2 resets together", len(single), len(digest))
+	}
+
+	// One reset is sent as itself, keeping its complete action.
+	single, digest = splitDigest([]*types.HeldNotification{reset(1)}, true)
+	if len(digest) != 0 || len(single) != 1 {
+		t.Errorf("got %d single and %d in the digest, want a lone reset sent on its own", len(single), len(digest))
+	}
+
+	single, digest = splitDigest([]*types.HeldNotification{reset(1), reset(2)}, false)
+	if len(digest) != 0 || len(single) != 2 {
+		t.Errorf("got %d single and %d in the digest, want no digest without the preference", len(single), len(digest))
+	}
+}
+
+func TestDigestPayload(t *testing.T) {
+	payload := digestPayload([]string{"Feed (Rex)", "Mist (Tank)", "Clean (Tank)"}, []int{1, 2, 3})
+
+	if payload["title"] != "3 tasks are due" {
+		t.Errorf("got title %q", payload["title"])
+	}
+	if payload["body"] != "Feed (Rex), Mist (Tank) and Clean (Tank)" {
+		t.Errorf("got body %q", payload["body"])
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
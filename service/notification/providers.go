@@ -0,0 +1,137 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// ErrRegistrationGone is wrapped by a PushProvider's error when the push
+// service says the registration will never take another push, such as a
+// browser that unsubscribed or an app that was uninstalled. The sender then
+// deletes the registration.
+var ErrRegistrationGone = errors.New("device registration is no longer valid")
+
+// PushProvider sends pushes through one push service. Send returns the HTTP
+// status the service answered with, or 0 if there was none.
+type PushProvider interface {
+	Send(ctx context.Context, reg *types.DeviceRegistration, msg PushMessage) (int, error)
+}
+
+// PushMessage is a notification as a PushProvider is handed it. Web push
+// sends WebPush, the payload the service worker reads, as it is; the native
+// providers build their own from the other fields.
+type PushMessage struct {
+	Title   string
+	Body    string
+	Tag     string
+	Data    map[string]interface{}
+	Urgency webpush.Urgency
+	WebPush []byte
+}
+
+// newPushMessage encodes a payload as built for the service worker. It is
+// encoded when the push is queued, so the caller is free to reuse the map.
+func newPushMessage(payload map[string]interface{}, urgency webpush.Urgency) (PushMessage, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return PushMessage{}, fmt.Errorf("failed to marshal notification payload: %v", err)
+	}
+
+	msg := PushMessage{Urgency: urgency, WebPush: encoded}
+	msg.Title, _ = payload["title"].(string)
+	msg.Body, _ = payload["body"].(string)
+	msg.Tag, _ = payload["tag"].(string)
+	if data, ok := payload["data"].(map[string]interface{}); ok {
+		msg.Data = make(map[string]interface{}, len(data))
+		for k, v := range data {
+			msg.Data[k] = v
+		}
+	}
+
+	return msg, nil
+}
+
+// stringData flattens the data block to strings, which is all FCM carries.
+// Strings are kept as they are and anything else is sent as JSON.
+func stringData(data map[string]interface{}) (map[string]string, error) {
+	flat := make(map[string]string, len(data))
+	for k, v := range data {
+		if s, ok := v.(string); ok {
+			flat[k] = s
+			continue
+		}
+
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		flat[k] = string(encoded)
+	}
+
+	return flat, nil
+}
+
+// WebPushProvider sends to browsers, signing each push with the VAPID key the
+// subscription was made against.
+type WebPushProvider struct {
+	keys    *VAPIDKeyring
+	subject string
+}
+
+func NewWebPushProvider(keys *VAPIDKeyring, vapidSubject string) *WebPushProvider {
+	return &WebPushProvider{keys: keys, subject: vapidSubject}
+}
+
+func (p *WebPushProvider) Send(ctx context.Context, reg *types.DeviceRegistration, msg PushMessage) (int, error) {
+	// A subscription with no key recorded was made while none was
+	// configured; the current key is the best guess.
+	var key *types.VAPIDKey
+	var err error
+	if reg.VAPIDKeyId != nil {
+		key, err = p.keys.ByID(*reg.VAPIDKeyId)
+	} else {
+		key, err = p.keys.Current()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	subscription := &webpush.Subscription{
+		Endpoint: reg.Token,
+		Keys: webpush.Keys{
+			P256dh: reg.P256dh,
+			Auth:   reg.Auth,
+		},
+	}
+
+	resp, err := webpush.SendNotificationWithContext(ctx, msg.WebPush, subscription, &webpush.Options{
+		VAPIDPublicKey:  key.PublicKey,
+		VAPIDPrivateKey: key.PrivateKey,
+		Subscriber:      p.subject,
+		TTL:             pushTTL,
+		Urgency:         msg.Urgency,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// Handle 410 Gone (expired subscription) or 404 Not Found
+	if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, fmt.Errorf("%w: push service answered %d", ErrRegistrationGone, resp.StatusCode)
+	}
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return resp.StatusCode, fmt.Errorf("push service answered %d: %s", resp.StatusCode, body)
+	}
+
+	return resp.StatusCode, nil
+}
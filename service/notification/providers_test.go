@@ -0,0 +1,125 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestNewPushMessage(t *testing.T) {
+	payload := resetPayload(&types.TaskResetNotification{TaskId: 7, TaskName: "Feed", SubjectName: "Rex", TaskDesc: "Two crickets"})
+	payload["data"] = map[string]interface{}{"taskId": 7}
+
+	msg, err := newPushMessage(payload, webpush.UrgencyHigh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Title != "Feed (Rex)" || msg.Body != "Two crickets" || msg.Tag != "task-7" || msg.Data["taskId"] != 7 {
+		t.Errorf("msg = %+v", msg)
+	}
+
+	// The message is a snapshot: the caller may reuse the payload.
+	payload["data"].(map[string]interface{})["taskId"] = 8
+	if msg.Data["taskId"] != 7 {
+		t.Error("the message changed with the payload")
+	}
+}
+
+func TestStringData(t *testing.T) {
+	flat, err := stringData(map[string]interface{}{"url": "/", "taskId": 7, "notificationIds": []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"url": "/", "taskId": "7", "notificationIds": "[1,2]"}
+	for k, v := range want {
+		if flat[k] != v {
+			t.Errorf("%s = %q, want %q", k, flat[k], v)
+		}
+	}
+}
+
+type fakeProvider struct {
+	status int
+	err    error
+}
+
+func (p fakeProvider) Send(ctx context.Context, reg *types.DeviceRegistration, msg PushMessage) (int, error) {
+	return p.status, p.err
+}
+
+type fakeRegistrationStore struct {
+	types.NotificationServiceStore
+	deleted  []int
+	recorded []recordedDelivery
+}
+
+type recordedDelivery struct {
+	registrationId int
+	status         int
+	succeeded      bool
+}
+
+func (s *fakeRegistrationStore) RecordDeviceDelivery(registrationId int, status int, succeeded bool) error {
+	s.recorded = append(s.recorded, recordedDelivery{registrationId, status, succeeded})
+	return nil
+}
+
+func (s *fakeRegistrationStore) DeleteDeviceRegistration(registrationId int) error {
+	s.deleted = append(s.deleted, registrationId)
+	return nil
+}
+
+// Whichever provider reports a registration gone, it is cleaned up the way
+// web push's 404 and 410 always were; any other failure leaves it be.
+func TestSendPushDeletesGoneRegistrations(t *testing.T) {
+	store := &fakeRegistrationStore{}
+	ns := &NotificationSender{store: store, providers: map[string]PushProvider{
+		types.ProviderFCM:  fakeProvider{status: 404, err: ErrRegistrationGone},
+		types.ProviderAPNs: fakeProvider{status: 500, err: errors.New("internal error")},
+	}}
+
+	_, _ = ns.sendPush(context.Background(), pushJob{reg: &types.DeviceRegistration{RegistrationId: 1, Provider: types.ProviderFCM}})
+	_, _ = ns.sendPush(context.Background(), pushJob{reg: &types.DeviceRegistration{RegistrationId: 2, Provider: types.ProviderAPNs}})
+
+	if len(store.deleted) != 1 || store.deleted[0] != 1 {
+		t.Errorf("deleted %v, want only the gone registration 1", store.deleted)
+	}
+
+	// A provider that is not configured is not the device's fault either.
+	if _, err := ns.sendPush(context.Background(), pushJob{reg: &types.DeviceRegistration{RegistrationId: 3, Provider: types.ProviderWebPush}}); err == nil {
+		t.Error("sent through a provider that is not configured")
+	}
+	if len(store.deleted) != 1 {
+		t.Errorf("deleted %v after an unconfigured provider", store.deleted)
+	}
+}
+
+// Delivery health is kept from what the push service answered. A gone
+// registration is deleted rather than recorded, and an unconfigured provider
+// is not held against the device.
+func TestSendPushRecordsDeliveryHealth(t *testing.T) {
+	store := &fakeRegistrationStore{}
+	ns := &NotificationSender{store: store, providers: map[string]PushProvider{
+		types.ProviderWebPush: fakeProvider{status: 201},
+		types.ProviderFCM:     fakeProvider{status: 404, err: ErrRegistrationGone},
+		types.ProviderAPNs:    fakeProvider{status: 429, err: errors.New("too many requests")},
+	}}
+
+	for id, provider := range []string{types.ProviderWebPush, types.ProviderFCM, types.ProviderAPNs, "unknown"} {
+		_, _ = ns.sendPush(context.Background(), pushJob{reg: &types.DeviceRegistration{RegistrationId: id, Provider: provider}})
+	}
+
+	want := []recordedDelivery{{0, 201, true}, {2, 429, false}}
+	if len(store.recorded) != len(want) {
+		t.Fatalf("recorded %+v, want %+v", store.recorded, want)
+	}
+	for i := range want {
+		if store.recorded[i] != want[i] {
+			t.Errorf("recorded %+v, want %+v", store.recorded[i], want[i])
+		}
+	}
+}
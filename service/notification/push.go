@@ -0,0 +1,376 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// Pushes go out through a pool of pushWorkers, each allowed pushTimeout per
+// request. Each push service (FCM, Mozilla, Apple and so on) is sent at most
+// pushServiceRate requests a second, after an initial burst, so that a large
+// sweep does not get the server throttled by one of them.
+const (
+	pushWorkers      = 16
+	pushQueueSize    = 1024
+	pushTimeout      = 15 * time.Second
+	pushServiceRate  = 20
+	pushServiceBurst = 40
+	// pushTTL is how long a push service holds a push for a device that is
+	// offline. A reminder still undelivered after a day is stale: by then the
+	// task has been dealt with or reminded about again.
+	pushTTL = 24 * 60 * 60
+)
+
+var errPushPoolClosed = errors.New("push pool is shut down")
+
+// pushLatencyBuckets are the upper bounds, in milliseconds, of the latency
+// histogram kept in the metrics.
+var pushLatencyBuckets = []int64{50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// pushMetrics is published through expvar as "push": counts of pushes sent,
+// failed and dropped, of each response status, and a latency histogram.
+var pushMetrics = expvar.NewMap("push")
+
+// pushJob is one push to one device. done, if set, is told how sending it
+// went, or why it was dropped. reserved says the job already holds its push
+// service's permission to send.
+type pushJob struct {
+	reg      *types.DeviceRegistration
+	msg      PushMessage
+	done     func(err error)
+	reserved bool
+}
+
+// pushPool sends pushes from a bounded queue. The workers never wait on a
+// push service's rate limit: a push its service cannot take yet is set aside
+// until its turn and then queued again, so a slow or throttling service only
+// holds up its own pushes, not the workers other services need.
+type pushPool struct {
+	jobs    chan pushJob
+	send    func(ctx context.Context, job pushJob) (int, error)
+	limiter *hostLimiter
+
+	// slots bounds the pushes taken and not yet finished, whether queued or
+	// set aside, so the queue always has room for one coming back.
+	slots chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu makes closed and pending.Add safe against Close: a push is either
+	// counted before Close starts waiting or refused.
+	mu      sync.RWMutex
+	closed  bool
+	pending sync.WaitGroup
+	drained chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+func newPushPool(workers int, send func(ctx context.Context, job pushJob) (int, error), limiter *hostLimiter) *pushPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &pushPool{
+		jobs:    make(chan pushJob, pushQueueSize),
+		send:    send,
+		limiter: limiter,
+		slots:   make(chan struct{}, pushQueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		drained: make(chan struct{}),
+	}
+
+	for range workers {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	return p
+}
+
+// enqueue queues a push, waiting for room if the pool is full. Once Close has
+// been called the push is refused, and counted as dropped.
+func (p *pushPool) enqueue(job pushJob) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		p.drop(job, errPushPoolClosed)
+		return
+	}
+
+	p.pending.Add(1)
+	p.slots <- struct{}{}
+	p.jobs <- job
+}
+
+// sendNow sends a push straight away, for a caller waiting on the outcome.
+// It still waits its turn with the push service.
+func (p *pushPool) sendNow(ctx context.Context, job pushJob) (int, error) {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		select {
+		case <-p.ctx.Done():
+			stop()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := p.limiter.wait(ctx, pushService(job.reg)); err != nil {
+		pushMetrics.Add("dropped", 1)
+		return 0, err
+	}
+
+	return p.attempt(ctx, job)
+}
+
+// work runs queued pushes until Close has been called and every push taken
+// before then has finished.
+func (p *pushPool) work() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case job := <-p.jobs:
+			p.run(job)
+		case <-p.drained:
+			return
+		}
+	}
+}
+
+func (p *pushPool) run(job pushJob) {
+	if err := p.ctx.Err(); err != nil {
+		p.finish(job, err)
+		return
+	}
+
+	if !job.reserved {
+		if delay := p.limiter.reserve(pushService(job.reg)); delay > 0 {
+			go p.requeueAfter(job, delay)
+			return
+		}
+	}
+
+	_, err := p.attempt(p.ctx, job)
+	if err != nil {
+		log.Printf("failed to send push to device registration %d: %v", job.reg.RegistrationId, err)
+	}
+	p.finish(job, nil)
+}
+
+// requeueAfter queues a push again once the turn reserved for it with its
+// push service has come. Its slot is still held, so there is room for it.
+func (p *pushPool) requeueAfter(job pushJob, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		job.reserved = true
+		p.jobs <- job
+	case <-p.ctx.Done():
+		p.finish(job, p.ctx.Err())
+	}
+}
+
+// finish frees a push's slot. A push dropped with err is counted, and its
+// done told why; one that was sent has already told done how it went.
+func (p *pushPool) finish(job pushJob, err error) {
+	if err != nil {
+		p.drop(job, err)
+	}
+
+	<-p.slots
+	p.pending.Done()
+}
+
+func (p *pushPool) drop(job pushJob, err error) {
+	pushMetrics.Add("dropped", 1)
+	log.Printf("dropping push to device registration %d: %v", job.reg.RegistrationId, err)
+	if job.done != nil {
+		job.done(err)
+	}
+}
+
+// attempt sends the push within pushTimeout and records how it went.
+func (p *pushPool) attempt(ctx context.Context, job pushJob) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+
+	started := time.Now()
+	status, err := p.send(ctx, job)
+	observePush(status, err, time.Since(started))
+
+	return status, err
+}
+
+// Close refuses new pushes and waits for those already taken to be sent,
+// including the ones waiting their turn with a push service. If ctx ends
+// first, the pushes still in flight are cancelled and the rest dropped.
+func (p *pushPool) Close(ctx context.Context) error {
+	p.once.Do(func() {
+		p.mu.Lock()
+		p.closed = true
+		p.mu.Unlock()
+
+		go func() {
+			p.pending.Wait()
+			close(p.drained)
+		}()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func observePush(status int, err error, latency time.Duration) {
+	if err != nil || status < 200 || status >= 300 {
+		pushMetrics.Add("failed", 1)
+	} else {
+		pushMetrics.Add("sent", 1)
+	}
+	if status != 0 {
+		pushMetrics.Add("status."+strconv.Itoa(status), 1)
+	}
+
+	ms := latency.Milliseconds()
+	pushMetrics.Add("latencyMsTotal", ms)
+	pushMetrics.Add(latencyBucket(ms), 1)
+}
+
+// latencyBucket names the histogram bucket a latency falls in.
+func latencyBucket(ms int64) string {
+	for _, bound := range pushLatencyBuckets {
+		if ms <= bound {
+			return "latencyMs.le" + strconv.FormatInt(bound, 10)
+		}
+	}
+
+	return "latencyMs.inf"
+}
+
+// invalidPushService is the one limit web push endpoints that are not URLs
+// share, so that they cannot each add a bucket of their own.
+const invalidPushService = "invalid web push endpoint"
+
+// pushService names the push service a device is reached through, which is
+// what rate limits are kept per. Web push endpoints are spread across the
+// browser vendors' services, so they are told apart by host.
+func pushService(reg *types.DeviceRegistration) string {
+	if reg.Provider != types.ProviderWebPush {
+		return reg.Provider
+	}
+
+	u, err := url.Parse(reg.Token)
+	if err != nil || u.Host == "" {
+		return invalidPushService
+	}
+
+	return u.Host
+}
+
+// hostLimiter is a token bucket per host: each allows burst requests at once
+// and then rate a second. A bucket left long enough to fill again is no
+// different from a new one, so such buckets are pruned every
+// limiterPruneInterval, keeping one per host only while it is in use.
+type hostLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu         sync.Mutex
+	buckets    map[string]*tokenBucket
+	lastPruned time.Time
+}
+
+const limiterPruneInterval = time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newHostLimiter(rate float64, burst int) *hostLimiter {
+	return &hostLimiter{rate: rate, burst: float64(burst), now: time.Now, buckets: make(map[string]*tokenBucket)}
+}
+
+// wait blocks until the request reserved for host may be sent, or ctx ends.
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	delay := l.reserve(host)
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve takes a token for host and says how long until the request it
+// stands for may be sent. A bucket runs into debt rather than refusing, so
+// that requests waiting on the same host are each given a turn of their own
+// rather than all waking for the next token.
+func (l *hostLimiter) reserve(host string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	b, ok := l.buckets[host]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[host] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	b.tokens--
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / l.rate * float64(time.Second))
+}
+
+func (l *hostLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPruned) < limiterPruneInterval {
+		return
+	}
+	l.lastPruned = now
+
+	for host, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, host)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestHostLimiter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newHostLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := range 3 {
+		if delay := l.reserve("fcm.googleapis.com"); delay != 0 {
+			t.Fatalf("request %d of the burst waited %v", i+1, delay)
+		}
+	}
+	if delay := l.reserve("fcm.googleapis.com"); delay != 500*time.Millisecond {
+		t.Errorf("request after the burst waits %v, want 500ms at 2 a second", delay)
+	}
+
+	// Each push service has its own allowance.
+	if delay := l.reserve("web.push.apple.com"); delay != 0 {
+		t.Errorf("another service waited %v", delay)
+	}
+
+	now = now.Add(time.Second)
+	if delay := l.reserve("fcm.googleapis.com"); delay != 0 {
+		t.Errorf("request a second later waited %v", delay)
+	}
+}
+
+func TestHostLimiterWaitCancelled(t *testing.T) {
+	l := newHostLimiter(0.001, 1)
+	_ = l.reserve("fcm.googleapis.com")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := l.wait(ctx, "fcm.googleapis.com"); !errors.Is(err, context.Canceled) {
+		t.Errorf("wait = %v, want context.Canceled", err)
+	}
+}
+
+func TestPushPoolBoundsConcurrency(t *testing.T) {
+	const workers = 3
+
+	var running, peak, sent atomic.Int32
+	release := make(chan struct{})
+	send := func(ctx context.Context, job pushJob) (int, error) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		running.Add(-1)
+		sent.Add(1)
+		return 201, nil
+	}
+
+	p := newPushPool(workers, send, newHostLimiter(1000, 1000))
+	for i := range 10 {
+		p.enqueue(pushJob{reg: &types.DeviceRegistration{RegistrationId: i, Provider: types.ProviderFCM, Token: "token"}})
+	}
+
+	close(release)
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+
+	if peak.Load() > workers {
+		t.Errorf("%d pushes ran at once, want at most %d", peak.Load(), workers)
+	}
+	// Close sends what was already queued.
+	if sent.Load() != 10 {
+		t.Errorf("sent %d pushes, want 10", sent.Load())
+	}
+}
+
+// A shutdown that runs out of time cancels the pushes still being sent
+// rather than waiting on a slow push service.
+func TestPushPoolCloseCancelsInFlight(t *testing.T) {
+	var once sync.Once
+	started := make(chan struct{})
+	send := func(ctx context.Context, job pushJob) (int, error) {
+		once.Do(func() { close(started) })
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+
+	p := newPushPool(1, send, newHostLimiter(1000, 1000))
+	p.enqueue(pushJob{reg: &types.DeviceRegistration{Provider: types.ProviderFCM, Token: "token"}})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := p.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close = %v, want context.DeadlineExceeded", err)
+	}
+
+	// Once closed, a push is refused rather than queued for no worker.
+	var refused error
+	p.enqueue(pushJob{reg: &types.DeviceRegistration{Provider: types.ProviderFCM, Token: "token"}, done: func(err error) { refused = err }})
+	if !errors.Is(refused, errPushPoolClosed) {
+		t.Errorf("push after Close finished with %v, want errPushPoolClosed", refused)
+	}
+}
+
+// A throttled push service must not hold the workers that other services'
+// pushes need.
+func TestPushPoolThrottledServiceDoesNotBlockOthers(t *testing.T) {
+	sent := make(chan string, 3)
+	send := func(ctx context.Context, job pushJob) (int, error) {
+		sent <- job.reg.Provider
+		return 201, nil
+	}
+
+	// One worker, and the first push to each service uses up its allowance
+	// for the next hour.
+	p := newPushPool(1, send, newHostLimiter(1.0/3600, 1))
+	var dropped error
+	p.enqueue(pushJob{reg: &types.DeviceRegistration{Provider: types.ProviderFCM}})
+	p.enqueue(pushJob{reg: &types.DeviceRegistration{Provider: types.ProviderFCM}, done: func(err error) { dropped = err }})
+	p.enqueue(pushJob{reg: &types.DeviceRegistration{Provider: types.ProviderAPNs}})
+
+	for _, want := range []string{types.ProviderFCM, types.ProviderAPNs} {
+		select {
+		case got := <-sent:
+			if got != want {
+				t.Errorf("sent to %s, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("push to %s waited behind the throttled service", want)
+		}
+	}
+
+	// The push still waiting its turn is dropped when shutdown runs out of
+	// time, rather than holding it up.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close = %v, want context.DeadlineExceeded", err)
+	}
+	if !errors.Is(dropped, context.Canceled) {
+		t.Errorf("waiting push finished with %v, want context.Canceled", dropped)
+	}
+}
+
+// Buckets are only kept for hosts in use, however many endpoints come and go.
+func TestHostLimiterPrunesFullBuckets(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newHostLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	for _, host := range []string{"a.example", "b.example", "c.example"} {
+		_ = l.reserve(host)
+	}
+
+	now = now.Add(limiterPruneInterval)
+	_ = l.reserve("d.example")
+
+	if len(l.buckets) != 1 {
+		t.Errorf("kept %d buckets, want only the one in use", len(l.buckets))
+	}
+}
+
+func TestLatencyBucket(t *testing.T) {
+	cases := map[int64]string{
+		0:     "latencyMs.le50",
+		50:    "latencyMs.le50",
+		51:    "latencyMs.le100",
+		9999:  "latencyMs.le10000",
+		10001: "latencyMs.inf",
+	}
+
+	for ms, want := range cases {
+		if got := latencyBucket(ms); got != want {
+			t.Errorf("latencyBucket(%d) = %q, want %q", ms, got, want)
+		}
+	}
+}
+
+func TestPushService(t *testing.T) {
+	cases := []struct {
+		reg  types.DeviceRegistration
+		want string
+	}{
+		{types.DeviceRegistration{Provider: types.ProviderWebPush, Token: "https://fcm.googleapis.com/fcm/send/abc"}, "fcm.googleapis.com"},
+		{types.DeviceRegistration{Provider: types.ProviderWebPush, Token: "https://updates.push.services.mozilla.com/wpush/v2"}, "updates.push.services.mozilla.com"},
+		{types.DeviceRegistration{Provider: types.ProviderWebPush, Token: "not a url"}, invalidPushService},
+		// Native tokens are not URLs; they share their provider's limit.
+		{types.DeviceRegistration{Provider: types.ProviderAPNs, Token: "abc123"}, types.ProviderAPNs},
+	}
+
+	for _, c := range cases {
+		if got := pushService(&c.reg); got != c.want {
+			t.Errorf("pushService(%+v) = %q, want %q", c.reg, got, c.want)
+		}
+	}
+}
@@ -1,25 +1,29 @@
 package notification
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-	"github.com/whitallee/animal-family-backend/config"
 	"github.com/whitallee/animal-family-backend/service/auth"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
 
 type Handler struct {
-	store     types.PushSubscriptionStore
+	store     types.NotificationServiceStore
 	userStore types.UserStore
 	sender    *NotificationSender
+	secret    []byte
 }
 
-func NewHandler(store types.PushSubscriptionStore, userStore types.UserStore, sender *NotificationSender) *Handler {
-	return &Handler{store: store, userStore: userStore, sender: sender}
+// NewHandler takes the secret unsubscribe links are signed with, which is the
+// one the email notifier was given.
+func NewHandler(store types.NotificationServiceStore, userStore types.UserStore, sender *NotificationSender, secret []byte) *Handler {
+	return &Handler{store: store, userStore: userStore, sender: sender, secret: secret}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
@@ -34,8 +38,14 @@ func (h *Handler) RegisterRoutes(router *mux.Router) {
 }
 
 func (h *Handler) handleGetVAPIDPublicKey(w http.ResponseWriter, r *http.Request) {
+	publicKey, err := h.currentVAPIDPublicKey()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	utils.WriteJSON(w, http.StatusOK, map[string]string{
-		"publicKey": config.Envs.VAPIDPublicKey,
+		"publicKey": publicKey,
 	})
 }
 
@@ -57,19 +67,32 @@ func (h *Handler) handleSubscribe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// get the VAPID key the browser subscribed with
+	vapidKeyId, err := h.subscriptionVAPIDKey(payload.VAPIDPublicKey)
+	if err != nil {
+		if errors.Is(err, ErrUnknownVAPIDKey) {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	// get user agent from request headers
 	userAgent := r.Header.Get("User-Agent")
 
 	// create subscription
-	subscription := types.PushSubscription{
-		UserID:    userID,
-		Endpoint:  payload.Endpoint,
-		P256dh:    payload.Keys.P256dh,
-		Auth:      payload.Keys.Auth,
-		UserAgent: userAgent,
+	subscription := types.DeviceRegistration{
+		UserID:     userID,
+		Provider:   types.ProviderWebPush,
+		Token:      payload.Endpoint,
+		P256dh:     payload.Keys.P256dh,
+		Auth:       payload.Keys.Auth,
+		UserAgent:  userAgent,
+		VAPIDKeyId: vapidKeyId,
 	}
 
-	err := h.store.CreateSubscription(subscription)
+	_, err = h.store.CreateDeviceRegistration(subscription)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -99,7 +122,7 @@ func (h *Handler) handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// delete subscription by endpoint and userId
-	err := h.store.DeleteSubscriptionByEndpoint(userID, payload.Endpoint)
+	err := h.store.DeleteDeviceRegistrationByToken(userID, payload.Endpoint)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -115,13 +138,48 @@ func (h *Handler) handleGetSubscriptions(w http.ResponseWriter, r *http.Request)
 	userID := auth.GetuserIdFromContext(r.Context())
 
 	// get subscriptions for user
-	subscriptions, err := h.store.GetSubscriptionsByUserId(userID)
+	registrations, err := h.store.GetDeviceRegistrationsByUserId(userID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, subscriptions)
+	utils.WriteJSON(w, http.StatusOK, v1Subscriptions(registrations))
+}
+
+// v1Subscription is the shape v1 has always listed push subscriptions in.
+type v1Subscription struct {
+	SubscriptionId int       `json:"subscriptionId"`
+	UserID         int       `json:"userId"`
+	Endpoint       string    `json:"endpoint"`
+	P256dh         string    `json:"p256dh"`
+	Auth           string    `json:"auth"`
+	UserAgent      string    `json:"userAgent"`
+	CreatedAt      time.Time `json:"createdAt"`
+	LastUsed       time.Time `json:"lastUsed"`
+}
+
+// v1Subscriptions lists the web push registrations as v1 subscriptions. v1
+// predates native devices and has no way to describe them.
+func v1Subscriptions(registrations []*types.DeviceRegistration) []v1Subscription {
+	subscriptions := make([]v1Subscription, 0, len(registrations))
+	for _, r := range registrations {
+		if r.Provider != types.ProviderWebPush {
+			continue
+		}
+		subscriptions = append(subscriptions, v1Subscription{
+			SubscriptionId: r.RegistrationId,
+			UserID:         r.UserID,
+			Endpoint:       r.Token,
+			P256dh:         r.P256dh,
+			Auth:           r.Auth,
+			UserAgent:      r.UserAgent,
+			CreatedAt:      r.CreatedAt,
+			LastUsed:       r.LastUsed,
+		})
+	}
+
+	return subscriptions
 }
 
 func (h *Handler) handleTestNotification(w http.ResponseWriter, r *http.Request) {
@@ -129,7 +187,7 @@ func (h *Handler) handleTestNotification(w http.ResponseWriter, r *http.Request)
 	userID := auth.GetuserIdFromContext(r.Context())
 
 	// get subscriptions for user
-	subscriptions, err := h.store.GetSubscriptionsByUserId(userID)
+	subscriptions, err := h.store.GetDeviceRegistrationsByUserId(userID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to get subscriptions: %v", err))
 		return
@@ -157,10 +215,10 @@ func (h *Handler) handleTestNotification(w http.ResponseWriter, r *http.Request)
 	results := []map[string]interface{}{}
 	for _, sub := range subscriptions {
 		result := map[string]interface{}{
-			"subscriptionId": sub.SubscriptionId,
-			"endpoint":       sub.Endpoint[:50] + "...", // truncate for readability
+			"subscriptionId": sub.RegistrationId,
+			"endpoint":       truncateEndpoint(sub.Token),
 		}
-		statusCode, err := h.sender.SendSingleNotificationWithStatus(sub, testNotification)
+		statusCode, err := h.sender.SendSingleNotificationWithStatus(r.Context(), sub, testNotification)
 		result["httpStatus"] = statusCode
 		if err != nil {
 			result["success"] = false
@@ -1,12 +1,13 @@
 package notification
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-	"github.com/whitallee/animal-family-backend/config"
 	"github.com/whitallee/animal-family-backend/service/auth"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
@@ -25,20 +26,46 @@ func (h *Handler) RegisterV2Routes(router *mux.Router) {
 	router.HandleFunc("/notifications/unsubscribe", auth.WithJWTAuth(h.handleUnsubscribeV2, h.userStore)).Methods(http.MethodPost)
 	router.HandleFunc("/notifications/subscriptions", auth.WithJWTAuth(h.handleListSubscriptions, h.userStore)).Methods(http.MethodGet)
 	router.HandleFunc("/notifications/test", auth.WithJWTAuth(h.handleSendTestNotification, h.userStore)).Methods(http.MethodPost)
+
+	// Devices of every provider; the subscription routes above are web push only.
+	router.HandleFunc("/notifications/devices", auth.WithJWTAuth(h.handleListDevices, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/notifications/devices", auth.WithJWTAuth(h.handleRegisterDevice, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/notifications/devices/{id}", auth.WithJWTAuth(auth.RequireOwnership("id", h.store.UserOwnsDeviceRegistration, h.handleGetDevice), h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/notifications/devices/{id}", auth.WithJWTAuth(auth.RequireOwnership("id", h.store.UserOwnsDeviceRegistration, h.handleUpdateDevice), h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/notifications/devices/{id}", auth.WithJWTAuth(auth.RequireOwnership("id", h.store.UserOwnsDeviceRegistration, h.handleDeleteDevice), h.userStore)).Methods(http.MethodDelete)
+	router.HandleFunc("/notifications/devices/{id}/test", auth.WithJWTAuth(auth.RequireOwnership("id", h.store.UserOwnsDeviceRegistration, h.handleTestDevice), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/notifications/preferences", auth.WithJWTAuth(h.handleGetNotificationPreferences, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/notifications/preferences", auth.WithJWTAuth(h.handleSetNotificationPreferences, h.userStore)).Methods(http.MethodPut)
+
+	// Authenticated by the token in the link, not a session.
+	router.HandleFunc("/notifications/email/unsubscribe", h.handleEmailUnsubscribeForm).Methods(http.MethodGet)
+	router.HandleFunc("/notifications/email/unsubscribe", h.handleEmailUnsubscribe).Methods(http.MethodPost)
+
+	// The notification center.
+	router.HandleFunc("/notifications", auth.WithJWTAuth(h.handleListNotifications, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/notifications/read-all", auth.WithJWTAuth(h.handleMarkAllNotificationsRead, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/notifications/{id}/read", auth.WithJWTAuth(auth.RequireOwnership("id", h.store.UserOwnsNotification, h.handleMarkNotificationRead), h.userStore)).Methods(http.MethodPost)
 }
 
 // handleGetVAPIDPublicKeyV2 godoc
 //
 //	@Id				getVapidPublicKey
 //	@Summary		Get the VAPID public key
-//	@Description	Browsers need this key to create a push subscription. It is public by design.
+//	@Description	Browsers need this key to create a push subscription. It is public by design. It changes when the key is rotated, so fetch it before each subscribe rather than building it into the app.
 //	@Tags			notifications
 //	@Produce		json
 //	@Success		200	{object}	types.VAPIDKeyResponse
+//	@Failure		500	{object}	types.ErrorResponse
 //	@Router			/notifications/vapid-public-key [get]
 func (h *Handler) handleGetVAPIDPublicKeyV2(w http.ResponseWriter, r *http.Request) {
+	publicKey, err := h.currentVAPIDPublicKey()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	utils.WriteJSON(w, http.StatusOK, types.VAPIDKeyResponse{
-		PublicKey: config.Envs.VAPIDPublicKey,
+		PublicKey: publicKey,
 	})
 }
 
@@ -46,6 +73,7 @@ func (h *Handler) handleGetVAPIDPublicKeyV2(w http.ResponseWriter, r *http.Reque
 //
 //	@Id				subscribeToPush
 //	@Summary		Register a push subscription
+//	@Description	Send vapidPublicKey, the applicationServerKey the browser subscribed with, so pushes to it are signed with the right key; without it the current key is assumed. A key the server does not have, or has retired, is refused with 400.
 //	@Tags			notifications
 //	@Accept			json
 //	@Produce		json
@@ -71,12 +99,24 @@ func (h *Handler) handleSubscribeV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.store.CreateSubscription(types.PushSubscription{
-		UserID:    userID,
-		Endpoint:  payload.Endpoint,
-		P256dh:    payload.Keys.P256dh,
-		Auth:      payload.Keys.Auth,
-		UserAgent: r.UserAgent(),
+	vapidKeyId, err := h.subscriptionVAPIDKey(payload.VAPIDPublicKey)
+	if err != nil {
+		if errors.Is(err, ErrUnknownVAPIDKey) {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = h.store.CreateDeviceRegistration(types.DeviceRegistration{
+		UserID:     userID,
+		Provider:   types.ProviderWebPush,
+		Token:      payload.Endpoint,
+		P256dh:     payload.Keys.P256dh,
+		Auth:       payload.Keys.Auth,
+		UserAgent:  r.UserAgent(),
+		VAPIDKeyId: vapidKeyId,
 	})
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
@@ -119,7 +159,7 @@ func (h *Handler) handleUnsubscribeV2(w http.ResponseWriter, r *http.Request) {
 
 	// Scoped to the caller, so one user cannot remove another's subscription
 	// by supplying their endpoint.
-	if err := h.store.DeleteSubscriptionByEndpoint(userID, payload.Endpoint); err != nil {
+	if err := h.store.DeleteDeviceRegistrationByToken(userID, payload.Endpoint); err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -133,6 +173,7 @@ func (h *Handler) handleUnsubscribeV2(w http.ResponseWriter, r *http.Request) {
 //
 //	@Id				listPushSubscriptions
 //	@Summary		List the caller's push subscriptions
+//	@Description	A subscription with resubscribe set was made against a VAPID key that has since been rotated. The browser holding it should subscribe again with the current key and unsubscribe the old endpoint. The service worker is also sent a push of type resubscribe asking the same.
 //	@Tags			notifications
 //	@Produce		json
 //	@Success		200	{array}		types.PushSubscriptionResponse
@@ -143,20 +184,26 @@ func (h *Handler) handleUnsubscribeV2(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleListSubscriptions(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetuserIdFromContext(r.Context())
 
-	subscriptions, err := h.store.GetSubscriptionsByUserId(userID)
+	subscriptions, err := h.store.GetDeviceRegistrationsByUserId(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	currentVAPIDKeyId, err := h.currentVAPIDKeyId()
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, types.NewPushSubscriptionResponses(subscriptions))
+	utils.WriteJSON(w, http.StatusOK, types.NewPushSubscriptionResponses(subscriptions, currentVAPIDKeyId))
 }
 
 // handleSendTestNotification godoc
 //
 //	@Id				sendTestNotification
 //	@Summary		Send a test push notification
-//	@Description	Delivers a test message to every subscription the caller has registered, reporting the outcome for each.
+//	@Description	Delivers a test message to every device the caller has registered, browser or native, reporting the outcome for each. Notification preferences do not apply to it.
 //	@Tags			notifications
 //	@Produce		json
 //	@Success		200	{object}	types.TestNotificationResponse
@@ -167,7 +214,7 @@ func (h *Handler) handleListSubscriptions(w http.ResponseWriter, r *http.Request
 func (h *Handler) handleSendTestNotification(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetuserIdFromContext(r.Context())
 
-	subscriptions, err := h.store.GetSubscriptionsByUserId(userID)
+	subscriptions, err := h.store.GetDeviceRegistrationsByUserId(userID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -182,29 +229,9 @@ func (h *Handler) handleSendTestNotification(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	testNotification := &types.TaskResetNotification{
-		TaskName:    "Test Notification",
-		TaskDesc:    "This is a test notification from your Animal Family app",
-		UserID:      userID,
-		SubjectName: "Test",
-		SubjectType: "test",
-	}
-
 	results := make([]types.TestNotificationResult, 0, len(subscriptions))
 	for _, sub := range subscriptions {
-		statusCode, sendErr := h.sender.SendSingleNotificationWithStatus(sub, testNotification)
-
-		result := types.TestNotificationResult{
-			SubscriptionId: sub.SubscriptionId,
-			Endpoint:       truncateEndpoint(sub.Endpoint),
-			HttpStatus:     statusCode,
-			Success:        sendErr == nil,
-		}
-		if sendErr != nil {
-			result.Error = sendErr.Error()
-		}
-
-		results = append(results, result)
+		results = append(results, h.sendTest(r.Context(), sub))
 	}
 
 	utils.WriteJSON(w, http.StatusOK, types.TestNotificationResponse{
@@ -214,6 +241,31 @@ func (h *Handler) handleSendTestNotification(w http.ResponseWriter, r *http.Requ
 	})
 }
 
+// sendTest sends a test notification to one device and reports how it went.
+// It ignores the device's mute and alert filter: a test is asked for.
+func (h *Handler) sendTest(ctx context.Context, reg *types.DeviceRegistration) types.TestNotificationResult {
+	statusCode, err := h.sender.SendSingleNotificationWithStatus(ctx, reg, &types.TaskResetNotification{
+		TaskName:    "Test Notification",
+		TaskDesc:    "This is a test notification from your Animal Family app",
+		UserID:      reg.UserID,
+		SubjectName: "Test",
+		SubjectType: "test",
+	})
+
+	result := types.TestNotificationResult{
+		SubscriptionId: reg.RegistrationId,
+		Provider:       reg.Provider,
+		Endpoint:       truncateEndpoint(reg.Token),
+		HttpStatus:     statusCode,
+		Success:        err == nil,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
 // truncateEndpoint shortens a push endpoint for display. v1 sliced the string
 // directly, which panics on an endpoint shorter than the cut length.
 func truncateEndpoint(endpoint string) string {
@@ -1,69 +1,210 @@
 package notification
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"sync"
+	"time"
 
 	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/whitallee/animal-family-backend/service/auth"
 	"github.com/whitallee/animal-family-backend/types"
 )
 
 type NotificationSender struct {
-	store           types.PushSubscriptionStore
-	vapidPublicKey  string
-	vapidPrivateKey string
-	vapidSubject    string
+	store        types.NotificationServiceStore
+	users        types.UserStore
+	notifiers    map[string]Notifier
+	providers    map[string]PushProvider
+	vapidKeys    *VAPIDKeyring
+	actionSecret []byte
+	pool         *pushPool
+
+	pruneMu    sync.Mutex
+	lastPruned time.Time
+
+	// resubscribing is the subscriptions whose resubscribe request is still
+	// being sent, which a later sweep must not send again.
+	resubscribeMu sync.Mutex
+	resubscribing map[int]bool
 }
 
-func NewNotificationSender(store types.PushSubscriptionStore, vapidPublicKey, vapidPrivateKey, vapidSubject string) *NotificationSender {
-	return &NotificationSender{
-		store:           store,
-		vapidPublicKey:  vapidPublicKey,
-		vapidPrivateKey: vapidPrivateKey,
-		vapidSubject:    vapidSubject,
+// actionTokenTTL bounds how long a leaked notification can complete a task
+// without a login. Actions are tapped soon after the notification arrives;
+// after that the client opens the task in the app, where the user is signed in.
+const actionTokenTTL = 2 * time.Hour
+
+// Notification center records are kept for readRetention once read, and for
+// unreadRetention if they never are.
+const (
+	readRetention   = 30 * 24 * time.Hour
+	unreadRetention = 90 * 24 * time.Hour
+)
+
+// pruneInterval spaces out pruning. Retention is counted in days, so pruning
+// on every sweep would only repeat the same search for nothing.
+const pruneInterval = time.Hour
+
+// NewNotificationSender pushes to devices itself and hands notifications to
+// notifiers, keyed by channel, for the other channels. A channel with no
+// notifier, such as email with no mail relay configured, is skipped.
+//
+// Pushes go through the provider each device is registered with, keyed by
+// provider name. A device whose provider is not configured is skipped.
+// They are sent in the background by a pool of workers, which Close stops.
+// vapidKeys are the keys web push is signed with, which the sender moves
+// subscriptions between when they are rotated. It is required, since web push
+// is always on.
+func NewNotificationSender(store types.NotificationServiceStore, users types.UserStore, notifiers map[string]Notifier, providers map[string]PushProvider, vapidKeys *VAPIDKeyring, actionSecret []byte) *NotificationSender {
+	ns := &NotificationSender{
+		store:        store,
+		users:        users,
+		notifiers:    notifiers,
+		providers:    providers,
+		vapidKeys:    vapidKeys,
+		actionSecret: actionSecret,
+
+		resubscribing: make(map[int]bool),
 	}
+	ns.pool = newPushPool(pushWorkers, ns.sendPush, newHostLimiter(pushServiceRate, pushServiceBurst))
+
+	return ns
+}
+
+// Supports says whether pushes can be sent through provider.
+func (ns *NotificationSender) Supports(provider string) bool {
+	_, ok := ns.providers[provider]
+	return ok
 }
 
+// Close stops taking pushes and waits for those already queued to go out. If
+// ctx ends first, the ones still being sent are cancelled.
+func (ns *NotificationSender) Close(ctx context.Context) error {
+	return ns.pool.Close(ctx)
+}
+
+// SendTaskResetNotifications records a notification in each user's
+// notification center for every task of theirs that reset, then sends it as
+// their preferences allow: a muted channel is skipped, and quiet hours or a
+// digest hold the push back.
+//
+// It first does the housekeeping every sweep needs, delivering the pushes
+// held earlier whose time has come, pruning old records hourly and moving
+// subscriptions off rotated VAPID keys, so it is meant to run on every sweep,
+// even one that reset nothing.
 func (ns *NotificationSender) SendTaskResetNotifications(tasks []*types.TaskResetNotification) {
+	ns.releaseHeldNotifications()
+	ns.pruneNotifications()
+	ns.rotateVAPIDKeys()
+
 	// Group tasks by userId for efficient subscription lookup
 	tasksByUser := groupTasksByUser(tasks)
 
 	for userId, userTasks := range tasksByUser {
-		// Get all subscriptions for this user
-		subscriptions, err := ns.store.GetSubscriptionsByUserId(userId)
-		if err != nil {
-			log.Printf("failed to get subscriptions for user %d: %v", userId, err)
-			continue
+		prefs := ns.preferencesFor(userId)
+		push := prefs.Enabled(EventTaskReset, ChannelPush)
+
+		var pendingDigest *time.Time
+		if push {
+			var err error
+			pendingDigest, err = ns.store.PendingDigestAt(userId)
+			if err != nil {
+				log.Printf("failed to get pending digest for user %d: %v", userId, err)
+			}
 		}
 
-		// Send notification for each task to each subscription
+		now := time.Now()
+		pushes := make([]userPush, 0, len(userTasks))
 		for _, task := range userTasks {
-			for _, sub := range subscriptions {
-				if err := ns.sendNotification(sub, task); err != nil {
-					log.Printf("failed to send notification: %v", err)
+			payload := resetPayload(task)
+			recorded := ns.record(userId, EventTaskReset, task.TaskId, payload)
+			ns.notify(prefs, recorded)
+			notificationID := recorded.NotificationId
+			if !push {
+				continue
+			}
+
+			at := deliverAt(prefs, prefs.Location(), EventTaskReset, string(webpush.UrgencyHigh), now, pendingDigest)
+			if at.After(now) {
+				ns.hold(userId, EventTaskReset, task.TaskId, notificationID, task.UserID, payload, webpush.UrgencyHigh, at)
+				if prefs.DigestEnabled {
+					pendingDigest = &at
 				}
+				continue
 			}
+
+			payload["data"] = ns.notificationData(task.TaskId, task.UserID, notificationID)
+			pushes = append(pushes, userPush{payload: payload, urgency: webpush.UrgencyHigh, taskIds: []int{task.TaskId}, ownTasks: true})
 		}
+		ns.pushToDevices(userId, pushes)
 	}
 }
 
-// SendSingleNotification sends a notification to a single subscription (public for testing)
-func (ns *NotificationSender) SendSingleNotification(sub *types.PushSubscription, task *types.TaskResetNotification) error {
-	return ns.sendNotification(sub, task)
+// userPush is a push going out to one user's devices. ownTasks says whether
+// taskIds are the user's own rather than tasks they are a backup for.
+type userPush struct {
+	payload  map[string]interface{}
+	urgency  webpush.Urgency
+	taskIds  []int
+	ownTasks bool
 }
 
-// SendSingleNotificationWithStatus sends a notification and returns both error and HTTP status code
-func (ns *NotificationSender) SendSingleNotificationWithStatus(sub *types.PushSubscription, task *types.TaskResetNotification) (int, error) {
-	// Build notification payload
-	payload := map[string]interface{}{
+// pushToDevices sends each push to those of the user's devices that alert
+// for it, looking the devices up once for all of them. It returns false if
+// they could not be looked up, in which case nothing was sent.
+func (ns *NotificationSender) pushToDevices(userID int, pushes []userPush) bool {
+	if len(pushes) == 0 {
+		return true
+	}
+
+	var taskIds []int
+	for _, p := range pushes {
+		taskIds = append(taskIds, p.taskIds...)
+	}
+	devices, err := ns.store.GetAlertDevices(userID, taskIds)
+	if err != nil {
+		log.Printf("failed to get devices for user %d: %v", userID, err)
+		return false
+	}
+
+	for _, p := range pushes {
+		for _, device := range devices {
+			if !device.AlertsFor(p.taskIds, p.ownTasks) {
+				continue
+			}
+			if err := ns.deliver(device.Registration, p.payload, p.urgency); err != nil {
+				log.Printf("failed to send notification to device %d: %v", device.Registration.RegistrationId, err)
+			}
+		}
+	}
+
+	return true
+}
+
+// SendSingleNotificationWithStatus sends a reset notification for task to
+// one device and waits for the push service's response, returning its HTTP
+// status, or 0 if there was none.
+func (ns *NotificationSender) SendSingleNotificationWithStatus(ctx context.Context, reg *types.DeviceRegistration, task *types.TaskResetNotification) (int, error) {
+	payload := resetPayload(task)
+	payload["data"] = ns.notificationData(task.TaskId, task.UserID, 0)
+
+	msg, err := newPushMessage(payload, webpush.UrgencyHigh)
+	if err != nil {
+		return 0, err
+	}
+
+	return ns.pool.sendNow(ctx, pushJob{reg: reg, msg: msg})
+}
+
+// resetPayload is a reset notification's payload without its data block,
+// which is added when it is sent rather than when it is held.
+func resetPayload(task *types.TaskResetNotification) map[string]interface{} {
+	return map[string]interface{}{
 		"title": fmt.Sprintf("%s (%s)", task.TaskName, task.SubjectName),
 		"body":  task.TaskDesc,
-		"data": map[string]interface{}{
-			"taskId": task.TaskId,
-			"url":    "/",
-		},
 		"actions": []map[string]interface{}{
 			{
 				"action": "complete",
@@ -77,64 +218,71 @@ func (ns *NotificationSender) SendSingleNotificationWithStatus(sub *types.PushSu
 		"tag":                fmt.Sprintf("task-%d", task.TaskId),
 		"requireInteraction": false,
 	}
+}
 
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return 0, fmt.Errorf("failed to marshal notification payload: %v", err)
-	}
+// SendEscalationNotifications records a notification for each recipient that
+// a task is still not done, and sends it on each channel they have not muted.
+// Quiet hours hold back all but high urgency pushes, and a device's filter
+// does not apply to escalations sent to a backup user. Like
+// SendTaskResetNotifications it only logs failures, since it runs in the
+// background after the sweep has responded.
+func (ns *NotificationSender) SendEscalationNotifications(notifications []*types.TaskEscalationNotification) {
+	now := time.Now()
 
-	// Create subscription for webpush
-	subscription := &webpush.Subscription{
-		Endpoint: sub.Endpoint,
-		Keys: webpush.Keys{
-			P256dh: sub.P256dh,
-			Auth:   sub.Auth,
-		},
-	}
+	pushes := make(map[int][]userPush)
+	for _, n := range notifications {
+		prefs := ns.preferencesFor(n.UserID)
 
-	// Send notification
-	resp, err := webpush.SendNotification(payloadBytes, subscription, &webpush.Options{
-		VAPIDPublicKey:  ns.vapidPublicKey,
-		VAPIDPrivateKey: ns.vapidPrivateKey,
-		Subscriber:      ns.vapidSubject,
-		TTL:             60 * 60 * 24, // 24 hours
-		Urgency:         webpush.UrgencyHigh,
-	})
+		recorded := ns.record(n.UserID, EventTaskEscalation, n.TaskId, escalationPayload(n, nil))
+		ns.notify(prefs, recorded)
+		notificationID := recorded.NotificationId
 
-	statusCode := 0
-	if resp != nil {
-		defer func() { _ = resp.Body.Close() }()
-		statusCode = resp.StatusCode
-
-		// Read response body for debugging
-		if statusCode >= 400 {
-			bodyBytes, readErr := io.ReadAll(resp.Body)
-			if readErr == nil {
-				log.Printf("Push service error - Status: %d, Body: %s, Endpoint: %s", statusCode, string(bodyBytes), sub.Endpoint[:50])
-			} else {
-				log.Printf("Push service error - Status: %d, Endpoint: %s (couldn't read body: %v)", statusCode, sub.Endpoint[:50], readErr)
-			}
+		if !prefs.Enabled(EventTaskEscalation, ChannelPush) {
+			continue
 		}
 
-		// Handle 410 Gone (expired subscription) or 404 Not Found
-		if resp.StatusCode == 410 || resp.StatusCode == 404 {
-			log.Printf("subscription expired, deleting: %d", sub.SubscriptionId)
-			_ = ns.store.DeleteSubscription(sub.SubscriptionId)
+		urgency := webpush.UrgencyNormal
+		if n.Urgency == "high" {
+			urgency = webpush.UrgencyHigh
 		}
+
+		if at := deliverAt(prefs, prefs.Location(), EventTaskEscalation, n.Urgency, now, nil); at.After(now) {
+			ns.hold(n.UserID, EventTaskEscalation, n.TaskId, notificationID, n.OwnerID, escalationPayload(n, nil), urgency, at)
+			continue
+		}
+
+		pushes[n.UserID] = append(pushes[n.UserID], userPush{
+			payload:  escalationPayload(n, ns.notificationData(n.TaskId, n.OwnerID, notificationID)),
+			urgency:  urgency,
+			taskIds:  []int{n.TaskId},
+			ownTasks: n.UserID == n.OwnerID,
+		})
 	}
 
-	return statusCode, err
+	for userID, userPushes := range pushes {
+		ns.pushToDevices(userID, userPushes)
+	}
 }
 
-func (ns *NotificationSender) sendNotification(sub *types.PushSubscription, task *types.TaskResetNotification) error {
-	// Build notification payload
-	payload := map[string]interface{}{
-		"title": fmt.Sprintf("%s (%s)", task.TaskName, task.SubjectName),
-		"body":  task.TaskDesc,
-		"data": map[string]interface{}{
-			"taskId": task.TaskId,
-			"url":    "/",
-		},
+// escalationPayload words the notification for who is receiving it: the
+// owner is reminded of their own task, and a backup user is told whose task
+// it is. It shares the reset notification's tag, so it replaces that one on
+// the device rather than stacking beside it, and renotify makes it alert
+// again when it does. A high urgency escalation stays on screen until it is
+// dealt with.
+func escalationPayload(n *types.TaskEscalationNotification, data map[string]interface{}) map[string]interface{} {
+	body := fmt.Sprintf("Overdue by %d hours.", n.AfterHours)
+	if n.UserID != n.OwnerID {
+		body = fmt.Sprintf("%s's task has been overdue for %d hours.", n.OwnerName, n.AfterHours)
+	}
+	if n.TaskDesc != "" {
+		body += " " + n.TaskDesc
+	}
+
+	return map[string]interface{}{
+		"title": fmt.Sprintf("Still not done: %s (%s)", n.TaskName, n.SubjectName),
+		"body":  body,
+		"data":  data,
 		"actions": []map[string]interface{}{
 			{
 				"action": "complete",
@@ -145,56 +293,322 @@ func (ns *NotificationSender) sendNotification(sub *types.PushSubscription, task
 				"title":  "View",
 			},
 		},
-		"tag":                fmt.Sprintf("task-%d", task.TaskId),
-		"requireInteraction": false,
+		"tag":                fmt.Sprintf("task-%d", n.TaskId),
+		"renotify":           true,
+		"requireInteraction": n.Urgency == "high",
 	}
+}
 
-	payloadBytes, err := json.Marshal(payload)
+// preferencesFor returns the user's notification preferences. If they cannot
+// be read the defaults are used, since sending a notification the user did
+// not want is better than losing one they did.
+func (ns *NotificationSender) preferencesFor(userID int) *types.NotificationPreferences {
+	prefs, err := ns.store.GetNotificationPreferences(userID)
 	if err != nil {
-		return fmt.Errorf("failed to marshal notification payload: %v", err)
+		log.Printf("failed to get notification preferences for user %d, using defaults: %v", userID, err)
+		return &types.NotificationPreferences{Channels: channelSettings(nil), DigestMinutes: defaultDigestMinutes}
 	}
 
-	// Create subscription for webpush
-	subscription := &webpush.Subscription{
-		Endpoint: sub.Endpoint,
-		Keys: webpush.Keys{
-			P256dh: sub.P256dh,
-			Auth:   sub.Auth,
-		},
+	return prefs
+}
+
+// record adds a notification to the user's notification center, taking its
+// title and body from the push payload, and returns the record. A failure is
+// logged and leaves its ID 0, and the notification still goes out.
+func (ns *NotificationSender) record(userID int, event string, taskId int, payload map[string]interface{}) *types.Notification {
+	title, _ := payload["title"].(string)
+	body, _ := payload["body"].(string)
+
+	n := &types.Notification{
+		UserID: userID,
+		Event:  event,
+		TaskId: &taskId,
+		Title:  title,
+		Body:   body,
+	}
+	if err := ns.store.CreateNotification(n); err != nil {
+		log.Printf("failed to record notification for user %d: %v", userID, err)
+	}
+
+	return n
+}
+
+// notify sends n on every channel besides push that the user has on for its
+// event. These go out straight away: quiet hours and digests are there to
+// keep a phone quiet, and an email waits in the inbox until it is read anyway.
+// A text is the exception to that reasoning, but one the user asked for by
+// opting in, knowing it arrives straight away.
+func (ns *NotificationSender) notify(prefs *types.NotificationPreferences, n *types.Notification) {
+	var user *types.User
+	for _, channel := range notificationChannels {
+		notifier, ok := ns.notifiers[channel]
+		if !ok || !prefs.Enabled(n.Event, channel) {
+			continue
+		}
+
+		if user == nil {
+			var err error
+			user, err = ns.users.GetUserById(n.UserID)
+			if err != nil {
+				log.Printf("failed to get user %d to notify by %s: %v", n.UserID, channel, err)
+				return
+			}
+		}
+
+		if err := notifier.Notify(user, n); err != nil {
+			log.Printf("failed to notify user %d by %s: %v", n.UserID, channel, err)
+		}
+	}
+}
+
+func (ns *NotificationSender) pruneNotifications() {
+	now := time.Now()
+
+	ns.pruneMu.Lock()
+	due := now.Sub(ns.lastPruned) >= pruneInterval
+	if due {
+		ns.lastPruned = now
+	}
+	ns.pruneMu.Unlock()
+	if !due {
+		return
+	}
+
+	pruned, err := ns.store.PruneNotifications(now.Add(-readRetention), now.Add(-unreadRetention))
+	if err != nil {
+		log.Printf("failed to prune notifications: %v", err)
+		return
 	}
 
-	// Send notification
-	resp, err := webpush.SendNotification(payloadBytes, subscription, &webpush.Options{
-		VAPIDPublicKey:  ns.vapidPublicKey,
-		VAPIDPrivateKey: ns.vapidPrivateKey,
-		Subscriber:      ns.vapidSubject,
-		TTL:             60 * 60 * 24, // 24 hours
-		Urgency:         webpush.UrgencyHigh,
+	if pruned > 0 {
+		log.Printf("pruned %d old notifications", pruned)
+	}
+}
+
+// hold queues a payload, still without its data block, to be delivered at.
+func (ns *NotificationSender) hold(userID int, event string, taskId int, notificationID int, ownerID int, payload map[string]interface{}, urgency webpush.Urgency, at time.Time) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("failed to marshal held notification for task %d: %v", taskId, err)
+		return
+	}
+
+	err = ns.store.HoldNotification(types.HeldNotification{
+		UserID:         userID,
+		Event:          event,
+		TaskId:         taskId,
+		NotificationId: notificationID,
+		OwnerID:        ownerID,
+		Urgency:        string(urgency),
+		Payload:        payloadBytes,
+		DeliverAt:      at,
 	})
+	if err != nil {
+		log.Printf("failed to hold notification for task %d: %v", taskId, err)
+	}
+}
 
-	if resp != nil {
-		defer func() { _ = resp.Body.Close() }()
+// releaseHeldNotifications delivers the held notifications that have come
+// due. A user with a digest gets the reset notifications released together as
+// one summary when there is more than one of them. Each is deleted once it is
+// queued; those of a user whose devices could not be looked up stay claimed,
+// to be taken again by a later sweep.
+func (ns *NotificationSender) releaseHeldNotifications() {
+	held, err := ns.store.ClaimDueNotifications(time.Now())
+	if err != nil {
+		log.Printf("failed to claim held notifications: %v", err)
+		return
+	}
+
+	heldByUser := make(map[int][]*types.HeldNotification)
+	for _, n := range held {
+		heldByUser[n.UserID] = append(heldByUser[n.UserID], n)
+	}
+
+	for userID, userHeld := range heldByUser {
+		single, digest := splitDigest(userHeld, ns.preferencesFor(userID).DigestEnabled)
 
-		// Log response details for debugging
-		log.Printf("Push service response - Status: %d, Endpoint: %s", resp.StatusCode, sub.Endpoint[:50])
+		pushes := make([]userPush, 0, len(single)+1)
+		heldIds := make([]int, 0, len(userHeld))
+		released := make([]int, 0, len(userHeld))
+		if len(digest) > 0 {
+			pushes = append(pushes, userPush{
+				payload:  digestPayload(heldTitles(digest), heldNotificationIDs(digest)),
+				urgency:  webpush.UrgencyHigh,
+				taskIds:  heldTaskIds(digest),
+				ownTasks: true,
+			})
+			heldIds = append(heldIds, heldRowIds(digest)...)
+		}
+		for _, n := range single {
+			var payload map[string]interface{}
+			if err := json.Unmarshal(n.Payload, &payload); err != nil {
+				// It will read no better next time.
+				log.Printf("failed to read held notification %d: %v", n.HeldId, err)
+				released = append(released, n.HeldId)
+				continue
+			}
+			payload["data"] = ns.notificationData(n.TaskId, n.OwnerID, n.NotificationId)
 
-		// Handle 410 Gone (expired subscription) or 404 Not Found
-		if resp.StatusCode == 410 || resp.StatusCode == 404 {
-			log.Printf("subscription expired, deleting: %d", sub.SubscriptionId)
-			_ = ns.store.DeleteSubscription(sub.SubscriptionId)
+			pushes = append(pushes, userPush{
+				payload:  payload,
+				urgency:  webpush.Urgency(n.Urgency),
+				taskIds:  []int{n.TaskId},
+				ownTasks: n.OwnerID == userID,
+			})
+			heldIds = append(heldIds, n.HeldId)
 		}
 
-		// Log non-2xx responses
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			log.Printf("Push service returned non-success status %d for subscription %d", resp.StatusCode, sub.SubscriptionId)
+		// The devices are looked up now rather than when the notifications
+		// were held, since one may have been muted or filtered meanwhile.
+		if ns.pushToDevices(userID, pushes) {
+			released = append(released, heldIds...)
+		}
+
+		if err := ns.store.DeleteHeldNotifications(released); err != nil {
+			log.Printf("failed to delete released notifications for user %d: %v", userID, err)
 		}
 	}
+}
 
+// splitDigest separates the reset notifications that go out as one digest
+// from those sent on their own. A lone reset notification is sent as it is,
+// with its complete action, rather than as a digest of one.
+func splitDigest(held []*types.HeldNotification, digestEnabled bool) (single []*types.HeldNotification, digest []*types.HeldNotification) {
+	for _, n := range held {
+		if digestEnabled && n.Event == EventTaskReset {
+			digest = append(digest, n)
+		} else {
+			single = append(single, n)
+		}
+	}
+
+	if len(digest) == 1 {
+		single = append(single, digest[0])
+		digest = nil
+	}
+
+	return single, digest
+}
+
+// heldTitles reads the title of each held notification for a digest.
+func heldTitles(held []*types.HeldNotification) []string {
+	titles := make([]string, 0, len(held))
+	for _, n := range held {
+		var payload struct {
+			Title string `json:"title"`
+		}
+		if err := json.Unmarshal(n.Payload, &payload); err != nil || payload.Title == "" {
+			continue
+		}
+		titles = append(titles, payload.Title)
+	}
+
+	return titles
+}
+
+// heldTaskIds is the tasks a digest is about. A device gets the digest if it
+// alerts for any of them.
+func heldTaskIds(held []*types.HeldNotification) []int {
+	ids := make([]int, 0, len(held))
+	for _, n := range held {
+		ids = append(ids, n.TaskId)
+	}
+
+	return ids
+}
+
+func heldRowIds(held []*types.HeldNotification) []int {
+	ids := make([]int, 0, len(held))
+	for _, n := range held {
+		ids = append(ids, n.HeldId)
+	}
+
+	return ids
+}
+
+func heldNotificationIDs(held []*types.HeldNotification) []int {
+	ids := make([]int, 0, len(held))
+	for _, n := range held {
+		if n.NotificationId != 0 {
+			ids = append(ids, n.NotificationId)
+		}
+	}
+
+	return ids
+}
+
+// deliver queues one payload to be pushed to one device. Failures to send
+// are logged by the pool; only a payload that cannot be encoded is returned.
+func (ns *NotificationSender) deliver(reg *types.DeviceRegistration, payload map[string]interface{}, urgency webpush.Urgency) error {
+	msg, err := newPushMessage(payload, urgency)
+	if err != nil {
+		return err
+	}
+
+	ns.pool.enqueue(pushJob{reg: reg, msg: msg})
+
+	return nil
+}
+
+// sendPush sends one push through the device's provider, deleting the
+// registration if the push service reports it gone and otherwise recording
+// the outcome in the device's delivery health. A provider that is not
+// configured is no fault of the device, so it is not held against it.
+func (ns *NotificationSender) sendPush(ctx context.Context, job pushJob) (status int, err error) {
+	if job.done != nil {
+		defer func() { job.done(err) }()
+	}
+
+	provider, ok := ns.providers[job.reg.Provider]
+	if !ok {
+		return 0, fmt.Errorf("%s push is not configured", job.reg.Provider)
+	}
+
+	status, err = provider.Send(ctx, job.reg, job.msg)
+	if errors.Is(err, ErrRegistrationGone) {
+		log.Printf("device registration %d is gone, deleting: %v", job.reg.RegistrationId, err)
+		_ = ns.store.DeleteDeviceRegistration(job.reg.RegistrationId)
+		return status, err
+	}
+
+	if recordErr := ns.store.RecordDeviceDelivery(job.reg.RegistrationId, status, err == nil); recordErr != nil {
+		log.Printf("failed to record delivery to device registration %d: %v", job.reg.RegistrationId, recordErr)
+	}
+
+	return status, err
+}
+
+// notificationData is the payload's data block. It carries a signed token for
+// the "complete" action so the service worker can complete the task without
+// the user's session. If a token cannot be minted the notification still goes
+// out, and the action falls back to opening the app.
+//
+// The token is always issued in the owner's name, since the action route
+// checks ownership. A backup user's notification therefore lets them mark the
+// task done on the owner's behalf, which is the point of naming them.
+//
+// notificationID names the notification center record the push delivers, so
+// the app can mark it read when the push is opened; 0 leaves it out.
+func (ns *NotificationSender) notificationData(taskId int, ownerID int, notificationID int) map[string]interface{} {
+	data := map[string]interface{}{
+		"taskId": taskId,
+		"url":    "/",
+	}
+	if notificationID != 0 {
+		data["notificationId"] = notificationID
+	}
+
+	token, err := auth.CreateTaskActionToken(ns.actionSecret, auth.ActionCompleteTask, taskId, ownerID, actionTokenTTL)
 	if err != nil {
-		log.Printf("Error sending push notification: %v", err)
+		log.Printf("failed to create action token for task %d: %v", taskId, err)
+		return data
 	}
 
-	return err
+	data["actionToken"] = token
+	data["completeUrl"] = fmt.Sprintf("/api/v2/tasks/%d/actions/complete", taskId)
+
+	return data
 }
 
 func groupTasksByUser(tasks []*types.TaskResetNotification) map[int][]*types.TaskResetNotification {
@@ -0,0 +1,72 @@
+package notification
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestEscalationPayload(t *testing.T) {
+	n := &types.TaskEscalationNotification{
+		TaskResetNotification: types.TaskResetNotification{
+			TaskId:      7,
+			TaskName:    "Feed",
+			SubjectName: "Rex",
+			UserID:      1,
+		},
+		OwnerID:    1,
+		OwnerName:  "Sam",
+		AfterHours: 4,
+		Urgency:    "normal",
+	}
+
+	owner := escalationPayload(n, nil)
+	if strings.Contains(owner["body"].(string), "Sam") {
+		t.Errorf("owner's body %q should not name the owner", owner["body"])
+	}
+	if owner["requireInteraction"] != false {
+		t.Error("a normal escalation should not require interaction")
+	}
+	// Same tag as the reset notification, so it replaces it on the device.
+	if owner["tag"] != "task-7" {
+		t.Errorf("got tag %v, want task-7", owner["tag"])
+	}
+
+	n.UserID = 2
+	n.Urgency = "high"
+
+	backup := escalationPayload(n, nil)
+	if !strings.HasPrefix(backup["body"].(string), "Sam's task") {
+		t.Errorf("backup's body %q should say whose task it is", backup["body"])
+	}
+	if backup["requireInteraction"] != true {
+		t.Error("a high urgency escalation should require interaction")
+	}
+}
+
+func TestAlertDeviceAlertsFor(t *testing.T) {
+	filtered := &types.AlertDevice{
+		Registration:   &types.DeviceRegistration{AlertsFiltered: true},
+		CoveredTaskIds: []int{2},
+	}
+	unfiltered := &types.AlertDevice{Registration: &types.DeviceRegistration{}}
+
+	cases := []struct {
+		name     string
+		device   *types.AlertDevice
+		taskIds  []int
+		ownTasks bool
+		want     bool
+	}{
+		{"unfiltered", unfiltered, []int{1}, true, true},
+		{"covered", filtered, []int{1, 2}, true, true},
+		{"not covered", filtered, []int{1}, true, false},
+		{"backup escalation", filtered, []int{1}, false, true},
+	}
+	for _, c := range cases {
+		if got := c.device.AlertsFor(c.taskIds, c.ownTasks); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -2,69 +2,206 @@ package notification
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/whitallee/animal-family-backend/types"
 )
 
+// ErrSubjectNotOwned is returned by UpdateDeviceRegistration when the filter
+// names an animal or enclosure the user does not own. Handlers map it to 403.
+var ErrSubjectNotOwned = errors.New("animal or enclosure does not exist or does not belong to you")
+
 type Store struct {
-	db *sql.DB
+	db     *sql.DB
+	secret []byte
 }
 
-func NewStore(db *sql.DB) *Store {
-	return &Store{db: db}
+// NewStore seals the VAPID private keys it stores with a key derived from
+// secret.
+func NewStore(db *sql.DB, secret []byte) *Store {
+	return &Store{db: db, secret: secret}
 }
 
-func (s *Store) CreateSubscription(sub types.PushSubscription) error {
-	_, err := s.db.Exec(`
-		INSERT INTO "pushSubscriptions" ("userId", "endpoint", "p256dh", "auth", "userAgent", "createdAt", "lastUsed")
-		VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
-		ON CONFLICT ("userId", "endpoint")
-		DO UPDATE SET "lastUsed" = NOW(), "userAgent" = EXCLUDED."userAgent"
-	`, sub.UserID, sub.Endpoint, sub.P256dh, sub.Auth, sub.UserAgent)
+// CreateDeviceRegistration also removes the token from any other user. A
+// token names one app install or browser, and only the account signed in on
+// it last should get its pushes: otherwise someone who signs out and hands
+// the device over keeps alerting the next person with their tasks.
+func (s *Store) CreateDeviceRegistration(reg types.DeviceRegistration) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
 
+	_, err = tx.Exec(`DELETE FROM "deviceRegistrations" WHERE "token" = $1 AND "provider" = $2 AND "userId" <> $3`,
+		reg.Token, reg.Provider, reg.UserID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	var registrationId int
+	err = tx.QueryRow(`
+		INSERT INTO "deviceRegistrations" ("userId", "provider", "token", "p256dh", "auth", "userAgent", "vapidKeyId", "createdAt", "lastUsed")
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, NOW(), NOW())
+		ON CONFLICT ("userId", "token")
+		DO UPDATE SET "lastUsed" = NOW(), "userAgent" = EXCLUDED."userAgent",
+			"provider" = EXCLUDED."provider", "p256dh" = EXCLUDED."p256dh", "auth" = EXCLUDED."auth",
+			"vapidKeyId" = EXCLUDED."vapidKeyId", "resubscribeRequestedAt" = NULL
+		RETURNING "registrationId"
+	`, reg.UserID, reg.Provider, reg.Token, reg.P256dh, reg.Auth, reg.UserAgent, reg.VAPIDKeyId).Scan(&registrationId)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return registrationId, nil
+}
+
+const deviceRegistrationColumns = `"registrationId", "userId", "provider", "token", COALESCE("p256dh", ''), COALESCE("auth", ''),
+	COALESCE("userAgent", ''), COALESCE("name", ''), "mutedUntil", "alertsFiltered", "lastSuccessAt", "lastFailureAt",
+	"lastFailureStatus", "failureCount", "vapidKeyId", "createdAt", "lastUsed"`
+
+func scanDeviceRegistration(row interface{ Scan(...any) error }) (*types.DeviceRegistration, error) {
+	reg := new(types.DeviceRegistration)
+	err := row.Scan(
+		&reg.RegistrationId,
+		&reg.UserID,
+		&reg.Provider,
+		&reg.Token,
+		&reg.P256dh,
+		&reg.Auth,
+		&reg.UserAgent,
+		&reg.Name,
+		&reg.MutedUntil,
+		&reg.AlertsFiltered,
+		&reg.LastSuccessAt,
+		&reg.LastFailureAt,
+		&reg.LastFailureStatus,
+		&reg.FailureCount,
+		&reg.VAPIDKeyId,
+		&reg.CreatedAt,
+		&reg.LastUsed,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return reg, nil
+}
+
+func scanDeviceRegistrations(rows *sql.Rows) ([]*types.DeviceRegistration, error) {
+	registrations := make([]*types.DeviceRegistration, 0)
+	for rows.Next() {
+		reg, err := scanDeviceRegistration(rows)
+		if err != nil {
+			return nil, err
+		}
+		registrations = append(registrations, reg)
+	}
+
+	return registrations, rows.Err()
 }
 
-func (s *Store) GetSubscriptionsByUserId(userId int) ([]*types.PushSubscription, error) {
+func (s *Store) GetDeviceRegistrationsByUserId(userId int) ([]*types.DeviceRegistration, error) {
 	rows, err := s.db.Query(`
-		SELECT "subscriptionId", "userId", "endpoint", "p256dh", "auth", "userAgent", "createdAt", "lastUsed"
-		FROM "pushSubscriptions"
+		SELECT `+deviceRegistrationColumns+`
+		FROM "deviceRegistrations"
 		WHERE "userId" = $1
+		ORDER BY "registrationId"
 	`, userId)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = rows.Close() }()
 
-	subscriptions := make([]*types.PushSubscription, 0)
+	registrations, err := scanDeviceRegistrations(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.loadDeviceSubjects(registrations); err != nil {
+		return nil, err
+	}
+
+	return registrations, nil
+}
+
+// loadDeviceSubjects fills in the animals and enclosures of the filtered
+// devices among registrations.
+func (s *Store) loadDeviceSubjects(registrations []*types.DeviceRegistration) error {
+	byId := make(map[int]*types.DeviceRegistration, len(registrations))
+	ids := make([]int, 0, len(registrations))
+	for _, reg := range registrations {
+		if reg.AlertsFiltered {
+			byId[reg.RegistrationId] = reg
+			ids = append(ids, reg.RegistrationId)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := s.db.Query(`SELECT "registrationId", "animalId", "enclosureId" FROM "deviceAlertSubjects"
+							WHERE "registrationId" = ANY($1)
+							ORDER BY "animalId", "enclosureId"`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
 	for rows.Next() {
-		sub := new(types.PushSubscription)
-		err := rows.Scan(
-			&sub.SubscriptionId,
-			&sub.UserID,
-			&sub.Endpoint,
-			&sub.P256dh,
-			&sub.Auth,
-			&sub.UserAgent,
-			&sub.CreatedAt,
-			&sub.LastUsed,
-		)
-		if err != nil {
-			return nil, err
+		var registrationId int
+		var animalId, enclosureId sql.NullInt64
+		if err := rows.Scan(&registrationId, &animalId, &enclosureId); err != nil {
+			return err
+		}
+
+		reg := byId[registrationId]
+		if animalId.Valid {
+			reg.AnimalIds = append(reg.AnimalIds, int(animalId.Int64))
+		}
+		if enclosureId.Valid {
+			reg.EnclosureIds = append(reg.EnclosureIds, int(enclosureId.Int64))
 		}
-		subscriptions = append(subscriptions, sub)
 	}
 
-	return subscriptions, nil
+	return rows.Err()
+}
+
+func (s *Store) GetDeviceRegistrationById(registrationId int) (*types.DeviceRegistration, error) {
+	row := s.db.QueryRow(`SELECT `+deviceRegistrationColumns+` FROM "deviceRegistrations" WHERE "registrationId" = $1`, registrationId)
+
+	reg, err := scanDeviceRegistration(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("device registration not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.loadDeviceSubjects([]*types.DeviceRegistration{reg}); err != nil {
+		return nil, err
+	}
+
+	return reg, nil
+}
+
+func (s *Store) UserOwnsDeviceRegistration(registrationId int, userId int) (bool, error) {
+	var owns bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM "deviceRegistrations" WHERE "registrationId" = $1 AND "userId" = $2)`, registrationId, userId).Scan(&owns)
+
+	return owns, err
 }
 
-func (s *Store) DeleteSubscription(subscriptionId int) error {
-	result, err := s.db.Exec(`DELETE FROM "pushSubscriptions" WHERE "subscriptionId" = $1`, subscriptionId)
+func (s *Store) DeleteDeviceRegistration(registrationId int) error {
+	result, err := s.db.Exec(`DELETE FROM "deviceRegistrations" WHERE "registrationId" = $1`, registrationId)
 	if err != nil {
 		return err
 	}
@@ -75,14 +212,14 @@ func (s *Store) DeleteSubscription(subscriptionId int) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("subscription not found")
+		return fmt.Errorf("device registration not found")
 	}
 
 	return nil
 }
 
-func (s *Store) DeleteSubscriptionByEndpoint(userId int, endpoint string) error {
-	result, err := s.db.Exec(`DELETE FROM "pushSubscriptions" WHERE "userId" = $1 AND "endpoint" = $2`, userId, endpoint)
+func (s *Store) DeleteDeviceRegistrationByToken(userId int, token string) error {
+	result, err := s.db.Exec(`DELETE FROM "deviceRegistrations" WHERE "userId" = $1 AND "token" = $2`, userId, token)
 	if err != nil {
 		return err
 	}
@@ -93,17 +230,590 @@ func (s *Store) DeleteSubscriptionByEndpoint(userId int, endpoint string) error
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("subscription not found")
+		return fmt.Errorf("device registration not found")
 	}
 
 	return nil
 }
 
-func (s *Store) UpdateLastUsed(subscriptionId int) error {
-	_, err := s.db.Exec(`UPDATE "pushSubscriptions" SET "lastUsed" = NOW() WHERE "subscriptionId" = $1`, subscriptionId)
+// UpdateDeviceRegistration replaces the device's name, mute and alert
+// filter. A filter naming an animal or enclosure the user does not own is
+// refused with ErrSubjectNotOwned, and nothing is changed.
+func (s *Store) UpdateDeviceRegistration(reg types.DeviceRegistration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var mutedUntil *time.Time
+	if reg.MutedUntil != nil {
+		utc := reg.MutedUntil.UTC()
+		mutedUntil = &utc
+	}
+
+	result, err := tx.Exec(`UPDATE "deviceRegistrations" SET "name" = NULLIF($2, ''), "mutedUntil" = $3, "alertsFiltered" = $4
+							WHERE "registrationId" = $1`,
+		reg.RegistrationId, reg.Name, mutedUntil, reg.AlertsFiltered)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("device registration not found")
+	}
+
+	if _, err := tx.Exec(`DELETE FROM "deviceAlertSubjects" WHERE "registrationId" = $1`, reg.RegistrationId); err != nil {
+		return err
+	}
+
+	if reg.AlertsFiltered {
+		var owned bool
+		err := tx.QueryRow(`SELECT NOT EXISTS (SELECT 1 FROM unnest($2::int[]) AS wanted("animalId")
+									WHERE wanted."animalId" NOT IN (SELECT "animalId" FROM "animalUser" WHERE "userId" = $1))
+								AND NOT EXISTS (SELECT 1 FROM unnest($3::int[]) AS wanted("enclosureId")
+									WHERE wanted."enclosureId" NOT IN (SELECT "enclosureId" FROM "enclosureUser" WHERE "userId" = $1))`,
+			reg.UserID, pq.Array(reg.AnimalIds), pq.Array(reg.EnclosureIds)).Scan(&owned)
+		if err != nil {
+			return err
+		}
+		if !owned {
+			return ErrSubjectNotOwned
+		}
+
+		_, err = tx.Exec(`INSERT INTO "deviceAlertSubjects" ("registrationId", "animalId")
+							SELECT DISTINCT $1::int, unnest($2::int[])`, reg.RegistrationId, pq.Array(reg.AnimalIds))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO "deviceAlertSubjects" ("registrationId", "enclosureId")
+							SELECT DISTINCT $1::int, unnest($2::int[])`, reg.RegistrationId, pq.Array(reg.EnclosureIds))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// scanWith scans a row into the destinations it is given and then extra,
+// for queries selecting more than deviceRegistrationColumns.
+type scanWith struct {
+	row   interface{ Scan(...any) error }
+	extra []any
+}
+
+func (s scanWith) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// GetAlertDevices lists, for each device, the tasks among taskIds whose
+// animal or enclosure its filter names, an enclosure covering the animals
+// in it.
+func (s *Store) GetAlertDevices(userId int, taskIds []int) ([]*types.AlertDevice, error) {
+	rows, err := s.db.Query(`
+		SELECT `+deviceRegistrationColumns+`, ARRAY(
+			SELECT DISTINCT ts."taskId" FROM "deviceAlertSubjects" das
+			INNER JOIN "taskSubject" ts ON ts."animalId" = das."animalId"
+				OR ts."enclosureId" = das."enclosureId"
+				OR ts."animalId" IN (SELECT a."animalId" FROM "animals" a WHERE a."enclosureId" = das."enclosureId")
+			WHERE das."registrationId" = d."registrationId" AND ts."taskId" = ANY($2)
+		)
+		FROM "deviceRegistrations" d
+		WHERE d."userId" = $1
+			AND (d."mutedUntil" IS NULL OR d."mutedUntil" <= NOW() AT TIME ZONE 'UTC')
+		ORDER BY d."registrationId"
+	`, userId, pq.Array(taskIds))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	devices := make([]*types.AlertDevice, 0)
+	for rows.Next() {
+		var covered pq.Int64Array
+		reg, err := scanDeviceRegistration(scanWith{row: rows, extra: []any{&covered}})
+		if err != nil {
+			return nil, err
+		}
+
+		device := &types.AlertDevice{Registration: reg, CoveredTaskIds: make([]int, 0, len(covered))}
+		for _, taskId := range covered {
+			device.CoveredTaskIds = append(device.CoveredTaskIds, int(taskId))
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, rows.Err()
+}
+
+// RecordDeviceDelivery resets the failure count on a success, and on a
+// failure counts it and notes the status, storing 0 as NULL.
+func (s *Store) RecordDeviceDelivery(registrationId int, status int, succeeded bool) error {
+	if succeeded {
+		_, err := s.db.Exec(`UPDATE "deviceRegistrations"
+							SET "lastSuccessAt" = NOW() AT TIME ZONE 'UTC', "failureCount" = 0, "lastUsed" = NOW()
+							WHERE "registrationId" = $1`, registrationId)
+		return err
+	}
+
+	_, err := s.db.Exec(`UPDATE "deviceRegistrations"
+						SET "lastFailureAt" = NOW() AT TIME ZONE 'UTC', "lastFailureStatus" = NULLIF($2, 0), "failureCount" = "failureCount" + 1
+						WHERE "registrationId" = $1`, registrationId, status)
+	return err
+}
+
+// EnsureVAPIDKey takes the table lock so that servers starting together
+// add the configured key once.
+func (s *Store) EnsureVAPIDKey(publicKey, privateKey string) error {
+	if (publicKey == "") != (privateKey == "") {
+		return fmt.Errorf("VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY must be set together")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`LOCK TABLE "vapidKeys" IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	if err := s.sealPlainVAPIDKeys(tx); err != nil {
+		return err
+	}
+	if publicKey == "" {
+		return tx.Commit()
+	}
+
+	var known, first bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM "vapidKeys" WHERE "publicKey" = $1), NOT EXISTS (SELECT 1 FROM "vapidKeys")`, publicKey).Scan(&known, &first)
+	if err != nil {
+		return err
+	}
+	if known {
+		return tx.Commit()
+	}
+
+	vapidKeyId, err := s.createVAPIDKey(tx, publicKey, privateKey)
+	if err != nil {
+		return err
+	}
+
+	if first {
+		_, err := tx.Exec(`UPDATE "deviceRegistrations" SET "vapidKeyId" = $1 WHERE "provider" = 'webpush' AND "vapidKeyId" IS NULL`, vapidKeyId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) CreateVAPIDKey(publicKey, privateKey string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	vapidKeyId, err := s.createVAPIDKey(tx, publicKey, privateKey)
+	if err != nil {
+		return 0, err
+	}
+
+	return vapidKeyId, tx.Commit()
+}
+
+// createVAPIDKey adds a key as current, rotating out the one that was.
+func (s *Store) createVAPIDKey(tx *sql.Tx, publicKey, privateKey string) (int, error) {
+	sealed, err := sealVAPIDPrivateKey(s.secret, privateKey)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE "vapidKeys" SET "current" = FALSE, "rotatedAt" = NOW() AT TIME ZONE 'UTC' WHERE "current"`)
+	if err != nil {
+		return 0, err
+	}
+
+	var vapidKeyId int
+	err = tx.QueryRow(`INSERT INTO "vapidKeys" ("publicKey", "privateKey", "current") VALUES ($1, $2, TRUE) RETURNING "vapidKeyId"`,
+		publicKey, sealed).Scan(&vapidKeyId)
+
+	return vapidKeyId, err
+}
+
+// sealPlainVAPIDKeys seals the private keys stored before keys were sealed.
+func (s *Store) sealPlainVAPIDKeys(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT "vapidKeyId", "privateKey" FROM "vapidKeys"
+							WHERE "privateKey" <> '' AND "privateKey" NOT LIKE $1 || '%'`, sealedVAPIDKeyPrefix)
 	if err != nil {
 		return err
 	}
 
+	plain := make(map[int]string)
+	for rows.Next() {
+		var vapidKeyId int
+		var privateKey string
+		if err := rows.Scan(&vapidKeyId, &privateKey); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		plain[vapidKeyId] = privateKey
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for vapidKeyId, privateKey := range plain {
+		sealed, err := sealVAPIDPrivateKey(s.secret, privateKey)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE "vapidKeys" SET "privateKey" = $2 WHERE "vapidKeyId" = $1`, vapidKeyId, sealed); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+func (s *Store) GetVAPIDKeys() ([]*types.VAPIDKey, error) {
+	rows, err := s.db.Query(`SELECT k."vapidKeyId", k."publicKey", k."privateKey", k."current", k."createdAt", k."rotatedAt",
+								(SELECT COUNT(*) FROM "deviceRegistrations" d WHERE d."vapidKeyId" = k."vapidKeyId")
+							FROM "vapidKeys" k
+							WHERE k."retiredAt" IS NULL
+							ORDER BY k."vapidKeyId" DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	keys := make([]*types.VAPIDKey, 0)
+	for rows.Next() {
+		key := new(types.VAPIDKey)
+		if err := rows.Scan(&key.VAPIDKeyId, &key.PublicKey, &key.PrivateKey, &key.Current, &key.CreatedAt, &key.RotatedAt, &key.Subscriptions); err != nil {
+			return nil, err
+		}
+		privateKey, err := openVAPIDPrivateKey(s.secret, key.PrivateKey)
+		if err != nil {
+			// Left out rather than failing the rest, which can still sign.
+			log.Printf("skipping VAPID key %d: %v", key.VAPIDKeyId, err)
+			continue
+		}
+		key.PrivateKey = privateKey
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *Store) GetStaleWebPushRegistrations() ([]*types.DeviceRegistration, error) {
+	rows, err := s.db.Query(`
+		SELECT ` + deviceRegistrationColumns + `
+		FROM "deviceRegistrations"
+		WHERE "provider" = 'webpush' AND "resubscribeRequestedAt" IS NULL
+			AND ("mutedUntil" IS NULL OR "mutedUntil" <= NOW() AT TIME ZONE 'UTC')
+			AND "vapidKeyId" IN (SELECT "vapidKeyId" FROM "vapidKeys" WHERE NOT "current")
+		ORDER BY "registrationId"
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	return scanDeviceRegistrations(rows)
+}
+
+func (s *Store) MarkResubscribeRequested(registrationIds []int) error {
+	_, err := s.db.Exec(`UPDATE "deviceRegistrations" SET "resubscribeRequestedAt" = NOW() AT TIME ZONE 'UTC' WHERE "registrationId" = ANY($1)`,
+		pq.Array(registrationIds))
+
+	return err
+}
+
+// RetireUnusedVAPIDKeys clears a retired key's private key, since nothing
+// will be signed with it again.
+func (s *Store) RetireUnusedVAPIDKeys() (int64, error) {
+	result, err := s.db.Exec(`UPDATE "vapidKeys" k SET "retiredAt" = NOW() AT TIME ZONE 'UTC', "privateKey" = ''
+							WHERE NOT k."current" AND k."retiredAt" IS NULL
+								AND NOT EXISTS (SELECT 1 FROM "deviceRegistrations" d WHERE d."vapidKeyId" = k."vapidKeyId")`)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *Store) GetNotificationPreferences(userID int) (*types.NotificationPreferences, error) {
+	prefs := &types.NotificationPreferences{}
+	err := s.db.QueryRow(`SELECT to_char(p."quietHoursStart", 'HH24:MI'), to_char(p."quietHoursEnd", 'HH24:MI'),
+							COALESCE(p."digestEnabled", false), COALESCE(p."digestMinutes", $2), u."timezone"
+						FROM "users" u
+						LEFT JOIN "notificationPreferences" p ON p."userId" = u."userId"
+						WHERE u."userId" = $1`, userID, defaultDigestMinutes).Scan(
+		&prefs.QuietHoursStart,
+		&prefs.QuietHoursEnd,
+		&prefs.DigestEnabled,
+		&prefs.DigestMinutes,
+		&prefs.Timezone,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT "event", "channel", "enabled" FROM "notificationChannelSettings" WHERE "userId" = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	overrides := make(map[[2]string]bool)
+	for rows.Next() {
+		var event, channel string
+		var enabled bool
+		if err := rows.Scan(&event, &channel, &enabled); err != nil {
+			return nil, err
+		}
+		overrides[[2]string{event, channel}] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	prefs.Channels = channelSettings(overrides)
+
+	return prefs, nil
+}
+
+func (s *Store) SetNotificationPreferences(userID int, prefs types.NotificationPreferences) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`INSERT INTO "notificationPreferences" ("userId", "quietHoursStart", "quietHoursEnd", "digestEnabled", "digestMinutes")
+					VALUES ($1, $2, $3, $4, $5)
+					ON CONFLICT ("userId") DO UPDATE SET
+						"quietHoursStart" = EXCLUDED."quietHoursStart", "quietHoursEnd" = EXCLUDED."quietHoursEnd",
+						"digestEnabled" = EXCLUDED."digestEnabled", "digestMinutes" = EXCLUDED."digestMinutes"`,
+		userID, prefs.QuietHoursStart, prefs.QuietHoursEnd, prefs.DigestEnabled, prefs.DigestMinutes)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM "notificationChannelSettings" WHERE "userId" = $1`, userID); err != nil {
+		return err
+	}
+
+	// Only settings away from the default are stored, so a channel whose
+	// default changes carries everyone who never chose along with it.
+	for _, c := range prefs.Channels {
+		if c.Enabled == channelDefaults[c.Channel] {
+			continue
+		}
+
+		_, err := tx.Exec(`INSERT INTO "notificationChannelSettings" ("userId", "event", "channel", "enabled") VALUES ($1, $2, $3, $4)`,
+			userID, c.Event, c.Channel, c.Enabled)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) SetNotificationChannel(userID int, event string, channel string, enabled bool) error {
+	if enabled == channelDefaults[channel] {
+		_, err := s.db.Exec(`DELETE FROM "notificationChannelSettings" WHERE "userId" = $1 AND "event" = $2 AND "channel" = $3`,
+			userID, event, channel)
+		return err
+	}
+
+	_, err := s.db.Exec(`INSERT INTO "notificationChannelSettings" ("userId", "event", "channel", "enabled") VALUES ($1, $2, $3, $4)
+						ON CONFLICT ("userId", "event", "channel") DO UPDATE SET "enabled" = EXCLUDED."enabled"`,
+		userID, event, channel, enabled)
+	return err
+}
+
+func (s *Store) HoldNotification(n types.HeldNotification) error {
+	_, err := s.db.Exec(`INSERT INTO "heldNotifications" ("userId", "event", "taskId", "ownerId", "urgency", "payload", "deliverAt", "notificationId")
+						VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0))`,
+		n.UserID, n.Event, n.TaskId, n.OwnerID, n.Urgency, n.Payload, n.DeliverAt.UTC(), n.NotificationId)
+
+	return err
+}
+
+func (s *Store) PendingDigestAt(userID int) (*time.Time, error) {
+	var at sql.NullTime
+	err := s.db.QueryRow(`SELECT MIN("deliverAt") FROM "heldNotifications" WHERE "userId" = $1 AND "event" = $2`,
+		userID, EventTaskReset).Scan(&at)
+	if err != nil || !at.Valid {
+		return nil, err
+	}
+
+	return &at.Time, nil
+}
+
+// heldClaimTimeout is how long a claimed notification waits for its sender to
+// queue and delete it before another sweep takes it. It is well over what one
+// release takes, so a notification is only sent twice if a sender stopped
+// partway through.
+const heldClaimTimeout = 10 * time.Minute
+
+func (s *Store) ClaimDueNotifications(now time.Time) ([]*types.HeldNotification, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// The task may have been dealt with while the notification was held.
+	// A snoozed task is notified again when the snooze runs out, and a paused
+	// one when it resumes, so neither needs this one.
+	_, err = tx.Exec(`DELETE FROM "heldNotifications" h
+					USING "tasks" t
+					WHERE t."taskId" = h."taskId" AND h."deliverAt" <= $1
+					AND (t."complete" OR t."snoozedUntil" > $1 OR t."taskId" IN (SELECT "taskId" FROM "pausedTasks"))`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`WITH claimed AS (
+								UPDATE "heldNotifications" SET "claimedAt" = $1
+								WHERE "deliverAt" <= $1 AND ("claimedAt" IS NULL OR "claimedAt" <= $2)
+								RETURNING "heldId", "userId", "event", "taskId", COALESCE("notificationId", 0) AS "notificationId",
+									"ownerId", "urgency", "payload", "deliverAt"
+							)
+							SELECT * FROM claimed ORDER BY "heldId"`, now.UTC(), now.Add(-heldClaimTimeout).UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	held := make([]*types.HeldNotification, 0)
+	for rows.Next() {
+		n := new(types.HeldNotification)
+		err := rows.Scan(&n.HeldId, &n.UserID, &n.Event, &n.TaskId, &n.NotificationId, &n.OwnerID, &n.Urgency, &n.Payload, &n.DeliverAt)
+		if err != nil {
+			return nil, err
+		}
+		held = append(held, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return held, tx.Commit()
+}
+
+func (s *Store) DeleteHeldNotifications(heldIds []int) error {
+	_, err := s.db.Exec(`DELETE FROM "heldNotifications" WHERE "heldId" = ANY($1)`, pq.Array(heldIds))
+
+	return err
+}
+
+const notificationColumns = `"notificationId", "userId", "event", "taskId", "title", "body", "createdAt", "readAt"`
+
+func (s *Store) queryNotifications(query string, args ...any) ([]types.Notification, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	notifications := make([]types.Notification, 0)
+	for rows.Next() {
+		var n types.Notification
+		err := rows.Scan(&n.NotificationId, &n.UserID, &n.Event, &n.TaskId, &n.Title, &n.Body, &n.CreatedAt, &n.ReadAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, rows.Err()
+}
+
+func (s *Store) CreateNotification(n *types.Notification) error {
+	return s.db.QueryRow(`INSERT INTO "notifications" ("userId", "event", "taskId", "title", "body")
+						VALUES ($1, $2, $3, $4, $5) RETURNING "notificationId", "createdAt"`,
+		n.UserID, n.Event, n.TaskId, n.Title, n.Body).Scan(&n.NotificationId, &n.CreatedAt)
+}
+
+func (s *Store) ListNotifications(userID int, before *int, limit int, unreadOnly bool) ([]types.Notification, error) {
+	return s.queryNotifications(`SELECT `+notificationColumns+` FROM "notifications"
+								WHERE "userId" = $1 AND ($2::int IS NULL OR "notificationId" < $2)
+								AND (NOT $3 OR "readAt" IS NULL)
+								ORDER BY "notificationId" DESC
+								LIMIT $4`, userID, before, unreadOnly, limit)
+}
+
+func (s *Store) CountUnreadNotifications(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM "notifications" WHERE "userId" = $1 AND "readAt" IS NULL`, userID).Scan(&count)
+
+	return count, err
+}
+
+func (s *Store) UserOwnsNotification(notificationId int, userID int) (bool, error) {
+	var owned bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM "notifications" WHERE "notificationId" = $1 AND "userId" = $2)`,
+		notificationId, userID).Scan(&owned)
+
+	return owned, err
+}
+
+func (s *Store) GetNotificationById(notificationId int) (*types.Notification, error) {
+	notifications, err := s.queryNotifications(`SELECT `+notificationColumns+` FROM "notifications" WHERE "notificationId" = $1`, notificationId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(notifications) == 0 {
+		return nil, fmt.Errorf("notification with id %d not found", notificationId)
+	}
+
+	return &notifications[0], nil
+}
+
+func (s *Store) MarkNotificationRead(notificationId int) error {
+	_, err := s.db.Exec(`UPDATE "notifications" SET "readAt" = NOW() AT TIME ZONE 'UTC'
+						WHERE "notificationId" = $1 AND "readAt" IS NULL`, notificationId)
+
+	return err
+}
+
+func (s *Store) MarkAllNotificationsRead(userID int) (int, error) {
+	result, err := s.db.Exec(`UPDATE "notifications" SET "readAt" = NOW() AT TIME ZONE 'UTC'
+							WHERE "userId" = $1 AND "readAt" IS NULL`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	marked, err := result.RowsAffected()
+
+	return int(marked), err
+}
+
+func (s *Store) PruneNotifications(readBefore time.Time, unreadBefore time.Time) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM "notifications"
+							WHERE ("readAt" IS NOT NULL AND "createdAt" < $1) OR "createdAt" < $2`,
+		readBefore.UTC(), unreadBefore.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
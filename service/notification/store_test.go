@@ -0,0 +1,40 @@
+package notification
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/whitallee/animal-family-backend/db/dbtest"
+)
+
+// A server restarting with the key it already knows must still seal the
+// private keys stored before keys were sealed.
+func TestEnsureVAPIDKeySealsPlainKeys(t *testing.T) {
+	db := dbtest.Open(t)
+	store := NewStore(db, []byte("server secret"))
+
+	_, err := db.Exec(`INSERT INTO "vapidKeys" ("publicKey", "privateKey", "current") VALUES ('known', 'plain', TRUE)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.EnsureVAPIDKey("known", "plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored string
+	if err := db.QueryRow(`SELECT "privateKey" FROM "vapidKeys" WHERE "publicKey" = 'known'`).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored, sealedVAPIDKeyPrefix) {
+		t.Errorf("stored private key %q, want it sealed", stored)
+	}
+
+	keys, err := store.GetVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].PrivateKey != "plain" {
+		t.Errorf("GetVAPIDKeys() = %+v, want the known key opened", keys)
+	}
+}
@@ -0,0 +1,324 @@
+package notification
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// Keys are added by cmd/vapidgen, outside the server, so the keyring reloads
+// them every vapidKeyRefresh. A key it does not know yet triggers a reload
+// straight away, but at most every vapidKeyMinReload.
+const (
+	vapidKeyRefresh   = time.Minute
+	vapidKeyMinReload = 5 * time.Second
+)
+
+// sealedVAPIDKeyPrefix marks a sealed private key. Keys stored before they
+// were sealed are plain base64url, which never contains a colon.
+const sealedVAPIDKeyPrefix = "sealed:"
+
+// ErrNoVAPIDKey is returned when no VAPID key has been configured, so web
+// push cannot be used.
+var ErrNoVAPIDKey = errors.New("web push is not configured")
+
+// ErrUnknownVAPIDKey is returned for a public key that is not one of the
+// server's, or one already retired.
+var ErrUnknownVAPIDKey = errors.New("unknown VAPID key; fetch the current one and subscribe again")
+
+// VAPIDKeyring caches the VAPID keys in use: the current one, which new
+// subscriptions are made against, and the old ones still signing for the
+// subscriptions made before a rotation.
+type VAPIDKeyring struct {
+	store types.VAPIDKeyStore
+	now   func() time.Time
+
+	mu       sync.Mutex
+	keys     []*types.VAPIDKey
+	loadedAt time.Time
+}
+
+func NewVAPIDKeyring(store types.VAPIDKeyStore) *VAPIDKeyring {
+	return &VAPIDKeyring{store: store, now: time.Now}
+}
+
+// Current returns the key new subscriptions should be made against.
+func (k *VAPIDKeyring) Current() (*types.VAPIDKey, error) {
+	return k.find(func(key *types.VAPIDKey) bool { return key.Current }, ErrNoVAPIDKey)
+}
+
+// ByID returns the key with the given ID.
+func (k *VAPIDKeyring) ByID(vapidKeyId int) (*types.VAPIDKey, error) {
+	return k.find(func(key *types.VAPIDKey) bool { return key.VAPIDKeyId == vapidKeyId }, ErrUnknownVAPIDKey)
+}
+
+// ByPublicKey returns the key a browser subscribed with.
+func (k *VAPIDKeyring) ByPublicKey(publicKey string) (*types.VAPIDKey, error) {
+	return k.find(func(key *types.VAPIDKey) bool { return key.PublicKey == publicKey }, ErrUnknownVAPIDKey)
+}
+
+func (k *VAPIDKeyring) find(match func(*types.VAPIDKey) bool, notFound error) (*types.VAPIDKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.now()
+	if now.Sub(k.loadedAt) >= vapidKeyRefresh {
+		if err := k.load(now); err != nil {
+			return nil, err
+		}
+	}
+
+	if key := findVAPIDKey(k.keys, match); key != nil {
+		return key, nil
+	}
+
+	if now.Sub(k.loadedAt) >= vapidKeyMinReload {
+		if err := k.load(now); err != nil {
+			return nil, err
+		}
+		if key := findVAPIDKey(k.keys, match); key != nil {
+			return key, nil
+		}
+	}
+
+	return nil, notFound
+}
+
+func (k *VAPIDKeyring) load(now time.Time) error {
+	keys, err := k.store.GetVAPIDKeys()
+	if err != nil {
+		return err
+	}
+
+	k.keys = keys
+	k.loadedAt = now
+
+	return nil
+}
+
+func findVAPIDKey(keys []*types.VAPIDKey, match func(*types.VAPIDKey) bool) *types.VAPIDKey {
+	for _, key := range keys {
+		if match(key) {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// sealVAPIDPrivateKey encrypts a private key for the database, so that a copy
+// of the database alone cannot sign pushes to every subscriber. The key it is
+// sealed with is derived from the server secret, so changing the secret
+// leaves the stored keys unreadable, as it does the tokens already issued.
+func sealVAPIDPrivateKey(secret []byte, privateKey string) (string, error) {
+	gcm, err := vapidKeyCipher(secret)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(privateKey), nil)
+
+	return sealedVAPIDKeyPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// openVAPIDPrivateKey reverses sealVAPIDPrivateKey. A key stored before keys
+// were sealed, and the empty key of a retired one, are returned as they are.
+func openVAPIDPrivateKey(secret []byte, stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, sealedVAPIDKeyPrefix)
+	if !ok {
+		return stored, nil
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := vapidKeyCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("sealed VAPID private key is too short")
+	}
+
+	privateKey, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot open VAPID private key, was the server secret changed? %w", err)
+	}
+
+	return string(privateKey), nil
+}
+
+// vapidKeyCipher is keyed from the secret, as the token keys in service/auth
+// are, so that the key is used for nothing else.
+func vapidKeyCipher(secret []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("vapid-private-key"))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// resubscribePayload asks the service worker to subscribe again with
+// publicKey and unsubscribe the old subscription. Browsers require every push
+// to show a notification, so it carries one, and the tag lets a second
+// request replace the first.
+func resubscribePayload(publicKey string) map[string]interface{} {
+	return map[string]interface{}{
+		"title": "Keeping your reminders coming",
+		"body":  "Animal Family is refreshing notifications on this device.",
+		"tag":   "resubscribe",
+		"data": map[string]interface{}{
+			"type":           "resubscribe",
+			"vapidPublicKey": publicKey,
+			"url":            "/",
+		},
+	}
+}
+
+// rotateVAPIDKeys moves subscriptions off old VAPID keys. Each subscription
+// still on one is sent a push, signed with its old key, asking it to
+// resubscribe; a muted one waits until its mute ends. A subscription is only
+// marked as asked once the push service accepts the request, so one that
+// failed is asked again on a later sweep. An old key is retired once no
+// subscription is left on it. Subscriptions that never resubscribe keep their
+// key in use until the browser drops them and the push service reports them
+// gone, or the user removes them.
+func (ns *NotificationSender) rotateVAPIDKeys() {
+	stale, err := ns.vapidKeys.store.GetStaleWebPushRegistrations()
+	if err != nil {
+		log.Printf("failed to get subscriptions on old VAPID keys: %v", err)
+		return
+	}
+
+	if len(stale) > 0 {
+		current, err := ns.vapidKeys.Current()
+		if err != nil {
+			log.Printf("failed to get the current VAPID key: %v", err)
+			return
+		}
+
+		msg, err := newPushMessage(resubscribePayload(current.PublicKey), webpush.UrgencyNormal)
+		if err != nil {
+			log.Printf("failed to encode resubscribe request: %v", err)
+			return
+		}
+
+		for _, reg := range stale {
+			if !ns.startResubscribe(reg.RegistrationId) {
+				continue
+			}
+			ns.pool.enqueue(pushJob{reg: reg, msg: msg, done: func(err error) {
+				ns.finishResubscribe(reg.RegistrationId, err)
+			}})
+		}
+	}
+
+	retired, err := ns.vapidKeys.store.RetireUnusedVAPIDKeys()
+	if err != nil {
+		log.Printf("failed to retire old VAPID keys: %v", err)
+		return
+	}
+
+	if retired > 0 {
+		log.Printf("retired %d VAPID keys no subscription uses", retired)
+	}
+}
+
+// startResubscribe claims a subscription for a resubscribe request, returning
+// false if one is already being sent to it.
+func (ns *NotificationSender) startResubscribe(registrationId int) bool {
+	ns.resubscribeMu.Lock()
+	defer ns.resubscribeMu.Unlock()
+
+	if ns.resubscribing[registrationId] {
+		return false
+	}
+	ns.resubscribing[registrationId] = true
+
+	return true
+}
+
+// finishResubscribe marks the subscription as asked if the request went out,
+// and releases it either way.
+func (ns *NotificationSender) finishResubscribe(registrationId int, err error) {
+	if err == nil {
+		if err := ns.vapidKeys.store.MarkResubscribeRequested([]int{registrationId}); err != nil {
+			log.Printf("failed to mark resubscribe request for device registration %d: %v", registrationId, err)
+		}
+	}
+
+	ns.resubscribeMu.Lock()
+	delete(ns.resubscribing, registrationId)
+	ns.resubscribeMu.Unlock()
+}
+
+// currentVAPIDKeyId is the ID of the current key, or 0 if there is none.
+func (h *Handler) currentVAPIDKeyId() (int, error) {
+	current, err := h.sender.vapidKeys.Current()
+	if errors.Is(err, ErrNoVAPIDKey) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return current.VAPIDKeyId, nil
+}
+
+// currentVAPIDPublicKey is the public key browsers should subscribe with,
+// empty if web push is not configured, as it always was.
+func (h *Handler) currentVAPIDPublicKey() (string, error) {
+	current, err := h.sender.vapidKeys.Current()
+	if errors.Is(err, ErrNoVAPIDKey) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return current.PublicKey, nil
+}
+
+// subscriptionVAPIDKey is the key a new subscription was made against: the
+// one the browser names, or else the current one. It is nil if there is no
+// key at all. A key the server does not have is ErrUnknownVAPIDKey.
+func (h *Handler) subscriptionVAPIDKey(publicKey string) (*int, error) {
+	var key *types.VAPIDKey
+	var err error
+	if publicKey != "" {
+		key, err = h.sender.vapidKeys.ByPublicKey(publicKey)
+	} else {
+		key, err = h.sender.vapidKeys.Current()
+	}
+	if errors.Is(err, ErrNoVAPIDKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &key.VAPIDKeyId, nil
+}
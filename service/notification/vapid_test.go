@@ -0,0 +1,127 @@
+package notification
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+type fakeVAPIDKeyStore struct {
+	types.VAPIDKeyStore
+	keys   []*types.VAPIDKey
+	loads  int
+	marked []int
+}
+
+func (s *fakeVAPIDKeyStore) MarkResubscribeRequested(registrationIds []int) error {
+	s.marked = append(s.marked, registrationIds...)
+	return nil
+}
+
+func (s *fakeVAPIDKeyStore) GetVAPIDKeys() ([]*types.VAPIDKey, error) {
+	s.loads++
+	return s.keys, nil
+}
+
+// Keys are rotated by cmd/vapidgen, outside the server. A browser that
+// fetched the new key from another server must be able to subscribe with it
+// at once, without every unknown key costing a query.
+func TestVAPIDKeyring(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	store := &fakeVAPIDKeyStore{keys: []*types.VAPIDKey{{VAPIDKeyId: 1, PublicKey: "old", Current: true}}}
+	keys := NewVAPIDKeyring(store)
+	keys.now = func() time.Time { return now }
+
+	if key, err := keys.Current(); err != nil || key.VAPIDKeyId != 1 {
+		t.Fatalf("Current() = %v, %v; want key 1", key, err)
+	}
+
+	store.keys = []*types.VAPIDKey{
+		{VAPIDKeyId: 2, PublicKey: "new", Current: true},
+		{VAPIDKeyId: 1, PublicKey: "old"},
+	}
+
+	// Within the minimum reload interval an unknown key is not looked for.
+	now = now.Add(vapidKeyMinReload / 2)
+	if _, err := keys.ByPublicKey("new"); !errors.Is(err, ErrUnknownVAPIDKey) {
+		t.Errorf("ByPublicKey(new) err = %v, want ErrUnknownVAPIDKey before a reload", err)
+	}
+	if store.loads != 1 {
+		t.Errorf("loaded %d times, want 1", store.loads)
+	}
+
+	now = now.Add(vapidKeyMinReload)
+	if key, err := keys.ByPublicKey("new"); err != nil || key.VAPIDKeyId != 2 {
+		t.Errorf("ByPublicKey(new) = %v, %v; want key 2", key, err)
+	}
+
+	// The old key still signs for the subscriptions made against it.
+	if key, err := keys.ByID(1); err != nil || key.PublicKey != "old" {
+		t.Errorf("ByID(1) = %v, %v; want the old key", key, err)
+	}
+	if key, err := keys.Current(); err != nil || key.VAPIDKeyId != 2 {
+		t.Errorf("Current() = %v, %v; want key 2", key, err)
+	}
+}
+
+func TestVAPIDKeyringWithoutKeys(t *testing.T) {
+	keys := NewVAPIDKeyring(&fakeVAPIDKeyStore{})
+
+	if _, err := keys.Current(); !errors.Is(err, ErrNoVAPIDKey) {
+		t.Errorf("Current() err = %v, want ErrNoVAPIDKey", err)
+	}
+}
+
+// A copy of the database alone must not give away the private keys, and keys
+// stored before they were sealed must keep working.
+func TestSealVAPIDPrivateKey(t *testing.T) {
+	secret := []byte("server secret")
+
+	sealed, err := sealVAPIDPrivateKey(secret, "private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "private") {
+		t.Errorf("sealed key %q contains the private key", sealed)
+	}
+
+	if key, err := openVAPIDPrivateKey(secret, sealed); err != nil || key != "private" {
+		t.Errorf("openVAPIDPrivateKey(sealed) = %q, %v; want the private key", key, err)
+	}
+	if key, err := openVAPIDPrivateKey(secret, "plain"); err != nil || key != "plain" {
+		t.Errorf("openVAPIDPrivateKey(plain) = %q, %v; want it as it is", key, err)
+	}
+	if _, err := openVAPIDPrivateKey([]byte("another secret"), sealed); err == nil {
+		t.Error("opened a key sealed with another secret")
+	}
+}
+
+// A subscription is only marked as asked to resubscribe once the request went
+// out, and is not sent a second request while the first is on its way.
+func TestResubscribeMarkedOnlyWhenSent(t *testing.T) {
+	store := &fakeVAPIDKeyStore{}
+	ns := &NotificationSender{vapidKeys: NewVAPIDKeyring(store), resubscribing: make(map[int]bool)}
+
+	if !ns.startResubscribe(1) {
+		t.Fatal("could not start a resubscribe request")
+	}
+	if ns.startResubscribe(1) {
+		t.Error("started a second request while the first was being sent")
+	}
+
+	ns.finishResubscribe(1, errors.New("push service unavailable"))
+	if len(store.marked) != 0 {
+		t.Errorf("marked %v after a failed request", store.marked)
+	}
+
+	if !ns.startResubscribe(1) {
+		t.Fatal("could not retry after a failed request")
+	}
+	ns.finishResubscribe(1, nil)
+	if len(store.marked) != 1 || store.marked[0] != 1 {
+		t.Errorf("marked %v, want 1", store.marked)
+	}
+}
@@ -0,0 +1,241 @@
+package pause
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+type Handler struct {
+	store          types.PauseStore
+	userStore      types.UserStore
+	taskStore      types.TaskStore
+	animalStore    types.AnimalStore
+	enclosureStore types.EnclosureStore
+}
+
+func NewHandler(store types.PauseStore, userStore types.UserStore, taskStore types.TaskStore, animalStore types.AnimalStore, enclosureStore types.EnclosureStore) *Handler {
+	return &Handler{
+		store:          store,
+		userStore:      userStore,
+		taskStore:      taskStore,
+		animalStore:    animalStore,
+		enclosureStore: enclosureStore,
+	}
+}
+
+// RegisterV2Routes mounts the care pause routes. There is no v1 equivalent.
+//
+// A pause is changed only by ending it early or deleting it. Editing the
+// times of a pause already in force would leave its tasks half resumed.
+func (h *Handler) RegisterV2Routes(router *mux.Router) {
+	owned := func(next http.HandlerFunc) http.HandlerFunc {
+		return auth.WithJWTAuth(auth.RequireOwnership("id", h.store.UserOwnsPause, next), h.userStore)
+	}
+
+	router.HandleFunc("/pauses", auth.WithJWTAuth(h.handleListPauses, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/pauses", auth.WithJWTAuth(h.handleCreatePause, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/pauses/{id}", owned(h.handleDeletePause)).Methods(http.MethodDelete)
+	router.HandleFunc("/pauses/{id}/end", owned(h.handleEndPause)).Methods(http.MethodPost)
+}
+
+// handleListPauses godoc
+//
+//	@Id				listPauses
+//	@Summary		List the caller's care pauses
+//	@Description	Newest first, including scheduled and ended pauses.
+//	@Tags			pauses
+//	@Produce		json
+//	@Success		200	{array}		types.CarePause
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/pauses [get]
+func (h *Handler) handleListPauses(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	pauses, err := h.store.GetPausesByUserId(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, pauses)
+}
+
+// handleCreatePause godoc
+//
+//	@Id				createPause
+//	@Summary		Pause the care schedule of a task, animal or enclosure
+//	@Description	Name exactly one of taskId, animalId and enclosureId, which must belong to the caller. Pausing an enclosure also pauses the animals in it. While a pause is in force its tasks are not reset, notified or shown in the calendar feed, and are never overdue. When it ends, a task that fell due during it is due at the end of the pause.
+//	@Tags			pauses
+//	@Accept			json
+//	@Produce		json
+//	@Param			pause	body		types.CreatePausePayload	true	"What to pause and for how long"
+//	@Success		201		{object}	types.CarePause
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/pauses [post]
+func (h *Handler) handleCreatePause(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	var payload types.CreatePausePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	pause, err := newPause(payload, time.Now())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	owned, err := h.ownsTarget(pause, userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !owned {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("you do not have access to that task, animal or enclosure"))
+		return
+	}
+
+	pauseId, err := h.store.CreatePause(pause, userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writePause(w, http.StatusCreated, pauseId)
+}
+
+// handleEndPause godoc
+//
+//	@Id				endPause
+//	@Summary		End a care pause now
+//	@Description	Its tasks go back on schedule straight away. Only a pause in force can be ended; delete a scheduled one instead.
+//	@Tags			pauses
+//	@Produce		json
+//	@Param			id	path		int	true	"Pause ID"
+//	@Success		200	{object}	types.CarePause
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		409	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/pauses/{id}/end [post]
+func (h *Handler) handleEndPause(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	if err := h.store.EndPause(id); err != nil {
+		if errors.Is(err, ErrPauseNotActive) {
+			utils.WriteError(w, http.StatusConflict, err)
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writePause(w, http.StatusOK, id)
+}
+
+// handleDeletePause godoc
+//
+//	@Id				deletePause
+//	@Summary		Delete a care pause
+//	@Description	A pause in force is ended first, so its tasks go back on schedule as if it had been ended.
+//	@Tags			pauses
+//	@Produce		json
+//	@Param			id	path	int	true	"Pause ID"
+//	@Success		204
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/pauses/{id} [delete]
+func (h *Handler) handleDeletePause(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	if err := h.store.DeletePause(id); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteStatus(w, http.StatusNoContent)
+}
+
+func (h *Handler) writePause(w http.ResponseWriter, status int, pauseId int) {
+	pause, err := h.store.GetPauseById(pauseId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, status, pause)
+}
+
+func (h *Handler) ownsTarget(pause types.CarePause, userID int) (bool, error) {
+	switch {
+	case pause.TaskId != nil:
+		return h.taskStore.UserOwnsTask(*pause.TaskId, userID)
+	case pause.AnimalId != nil:
+		return h.animalStore.UserOwnsAnimal(*pause.AnimalId, userID)
+	default:
+		return h.enclosureStore.UserOwnsEnclosure(*pause.EnclosureId, userID)
+	}
+}
+
+// newPause checks what the validator cannot: that exactly one target is
+// named and that the times make sense. A pause may start in the past, to
+// record one that began before it was entered, but must not already be over.
+func newPause(payload types.CreatePausePayload, now time.Time) (types.CarePause, error) {
+	targets := 0
+	for _, id := range []*int{payload.TaskId, payload.AnimalId, payload.EnclosureId} {
+		if id != nil {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return types.CarePause{}, fmt.Errorf("supply exactly one of taskId, animalId and enclosureId")
+	}
+
+	startsAt := now
+	if payload.StartsAt != nil {
+		startsAt = *payload.StartsAt
+	}
+
+	if payload.EndsAt != nil {
+		if !payload.EndsAt.After(startsAt) {
+			return types.CarePause{}, fmt.Errorf("endsAt must be after startsAt")
+		}
+		if !payload.EndsAt.After(now) {
+			return types.CarePause{}, fmt.Errorf("endsAt must be in the future")
+		}
+	}
+
+	return types.CarePause{
+		TaskId:      payload.TaskId,
+		AnimalId:    payload.AnimalId,
+		EnclosureId: payload.EnclosureId,
+		Reason:      payload.Reason,
+		StartsAt:    startsAt,
+		EndsAt:      payload.EndsAt,
+	}, nil
+}
@@ -0,0 +1,70 @@
+package pause
+
+import (
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestNewPause(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	id := 4
+	yesterday := now.Add(-24 * time.Hour)
+	nextWeek := now.Add(7 * 24 * time.Hour)
+
+	cases := []struct {
+		name    string
+		payload types.CreatePausePayload
+		wantErr bool
+	}{
+		{"open-ended from now", types.CreatePausePayload{AnimalId: &id}, false},
+		{"backdated with an end", types.CreatePausePayload{TaskId: &id, StartsAt: &yesterday, EndsAt: &nextWeek}, false},
+		{"no target", types.CreatePausePayload{}, true},
+		{"two targets", types.CreatePausePayload{AnimalId: &id, EnclosureId: &id}, true},
+		{"ends before it starts", types.CreatePausePayload{EnclosureId: &id, StartsAt: &nextWeek, EndsAt: &yesterday}, true},
+		// Already over, so it would pause nothing and resume tasks at once.
+		{"ends in the past", types.CreatePausePayload{AnimalId: &id, StartsAt: &yesterday, EndsAt: &yesterday}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newPause(tc.payload, now)
+			if tc.wantErr && err == nil {
+				t.Error("expected an error, got none")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	pause, err := newPause(types.CreatePausePayload{AnimalId: &id}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pause.StartsAt.Equal(now) {
+		t.Errorf("got startsAt %v, want it to default to now", pause.StartsAt)
+	}
+}
+
+func TestCarePauseStatusAt(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+
+	cases := map[string]struct {
+		pause types.CarePause
+		want  string
+	}{
+		"not started":    {types.CarePause{StartsAt: later}, "scheduled"},
+		"open-ended":     {types.CarePause{StartsAt: now}, "active"},
+		"ends later":     {types.CarePause{StartsAt: now, EndsAt: &later}, "active"},
+		"ends right now": {types.CarePause{StartsAt: now.Add(-time.Hour), EndsAt: &now}, "ended"},
+	}
+
+	for name, tc := range cases {
+		if got := tc.pause.StatusAt(now); got != tc.want {
+			t.Errorf("%s: got %q, want %q", name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package pause
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/service/task"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// ErrPauseNotActive is returned by EndPause for a pause that has not started
+// or has already ended. Handlers map it to 409.
+var ErrPauseNotActive = errors.New("pause is not in force")
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// CreatePause stores the times in UTC, like every other zone-less time.
+func (s *Store) CreatePause(pause types.CarePause, userID int) (int, error) {
+	var endsAt *time.Time
+	if pause.EndsAt != nil {
+		utc := pause.EndsAt.UTC()
+		endsAt = &utc
+	}
+
+	var pauseId int
+	err := s.db.QueryRow(`INSERT INTO "carePauses" ("userId", "taskId", "animalId", "enclosureId", "reason", "startsAt", "endsAt")
+						VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING "pauseId"`,
+		userID, pause.TaskId, pause.AnimalId, pause.EnclosureId, pause.Reason, pause.StartsAt.UTC(), endsAt).Scan(&pauseId)
+	if err != nil {
+		return 0, err
+	}
+
+	return pauseId, nil
+}
+
+func (s *Store) GetPauseById(pauseId int) (*types.CarePause, error) {
+	pauses, err := s.queryPauses(`SELECT `+task.CarePauseColumns+` FROM "carePauses" p WHERE p."pauseId" = $1`, pauseId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pauses) == 0 {
+		return nil, fmt.Errorf("pause with id %d not found", pauseId)
+	}
+
+	return &pauses[0], nil
+}
+
+func (s *Store) GetPausesByUserId(userID int) ([]types.CarePause, error) {
+	return s.queryPauses(`SELECT `+task.CarePauseColumns+` FROM "carePauses" p
+						WHERE p."userId" = $1 ORDER BY p."startsAt" DESC, p."pauseId" DESC`, userID)
+}
+
+func (s *Store) UserOwnsPause(pauseId int, userID int) (bool, error) {
+	var owned bool
+	err := s.db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM "carePauses" WHERE "pauseId" = $1 AND "userId" = $2)`,
+		pauseId, userID,
+	).Scan(&owned)
+	if err != nil {
+		return false, err
+	}
+
+	return owned, nil
+}
+
+func (s *Store) EndPause(pauseId int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	ended, err := endPauseNow(tx, pauseId)
+	if err != nil {
+		return err
+	}
+	if !ended {
+		return ErrPauseNotActive
+	}
+
+	if err := task.ResumeEndedPauses(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletePause ends the pause first if it is in force, so deleting it resumes
+// its tasks exactly as ending it would. A pause that never started is simply
+// removed.
+func (s *Store) DeletePause(pauseId int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := endPauseNow(tx, pauseId); err != nil {
+		return err
+	}
+
+	// Also catches a pause that ended on its own but that no sweep has
+	// resumed yet.
+	if err := task.ResumeEndedPauses(tx); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM "carePauses" WHERE "pauseId" = $1`, pauseId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// endPauseNow brings forward the end of a pause in force to now, reporting
+// whether it was in force.
+func endPauseNow(tx *sql.Tx, pauseId int) (bool, error) {
+	result, err := tx.Exec(`UPDATE "carePauses" SET "endsAt" = NOW()
+							WHERE "pauseId" = $1 AND "startsAt" <= NOW() AND ("endsAt" IS NULL OR "endsAt" > NOW())`, pauseId)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (s *Store) queryPauses(query string, args ...any) ([]types.CarePause, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	now := time.Now()
+	pauses := make([]types.CarePause, 0)
+	for rows.Next() {
+		pause, err := task.ScanRowsIntoCarePause(rows, now)
+		if err != nil {
+			return nil, err
+		}
+
+		pauses = append(pauses, *pause)
+	}
+
+	return pauses, rows.Err()
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/whitallee/animal-family-backend/service/llm"
 )
 
 // ImageTheme controls the background style used in the DALL-E prompt
@@ -119,29 +120,6 @@ Species information to convert:
 
 // Internal types for OpenAI API communication
 
-type openAIMsg struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-type openAIRespFmt struct {
-	Type string `json:"type"`
-}
-
-type openAIChatReq struct {
-	Model          string         `json:"model"`
-	Messages       []openAIMsg    `json:"messages"`
-	ResponseFormat *openAIRespFmt `json:"response_format,omitempty"`
-}
-
-type openAIChatResp struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
-}
-
 type openAIImageReq struct {
 	Model   string `json:"model"`
 	Prompt  string `json:"prompt"`
@@ -174,8 +152,10 @@ type llmSpeciesJSON struct {
 
 // generateSpeciesData runs the two-call LLM flow and returns parsed species data
 func generateSpeciesData(apiKey, name, habitatList string) (*llmSpeciesJSON, error) {
-	naturalInfo, err := callOpenAIChat(
-		apiKey,
+	chat := llm.NewOpenAIClient(apiKey)
+
+	naturalInfo, err := chat.Chat(
+		context.Background(),
 		speciesInfoSystemPrompt,
 		fmt.Sprintf(speciesInfoUserPromptFmt, name, habitatList),
 		false,
@@ -184,8 +164,8 @@ func generateSpeciesData(apiKey, name, habitatList string) (*llmSpeciesJSON, err
 		return nil, fmt.Errorf("failed to generate species info: %w", err)
 	}
 
-	jsonStr, err := callOpenAIChat(
-		apiKey,
+	jsonStr, err := chat.Chat(
+		context.Background(),
 		speciesJSONSystemPrompt,
 		fmt.Sprintf(speciesJSONUserPromptFmt, habitatList, naturalInfo),
 		true,
@@ -202,53 +182,6 @@ func generateSpeciesData(apiKey, name, habitatList string) (*llmSpeciesJSON, err
 	return &data, nil
 }
 
-// callOpenAIChat sends a chat completion request to OpenAI
-func callOpenAIChat(apiKey, systemPrompt, userPrompt string, jsonMode bool) (string, error) {
-	reqBody := openAIChatReq{
-		Model: "gpt-4o",
-		Messages: []openAIMsg{
-			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: userPrompt},
-		},
-	}
-	if jsonMode {
-		reqBody.ResponseFormat = &openAIRespFmt{Type: "json_object"}
-	}
-
-	body, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", err
-	}
-
-	req, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/chat/completions", bytes.NewReader(body))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("openai chat error %d: %s", resp.StatusCode, string(bodyBytes))
-	}
-
-	var result openAIChatResp
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
-	}
-	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("openai returned no choices")
-	}
-
-	return result.Choices[0].Message.Content, nil
-}
-
 // generateAndUploadSpeciesImage runs in a goroutine: generates image via DALL-E,
 // uploads to S3, and updates the species DB record with the real URL
 func generateAndUploadSpeciesImage(store *Store, speciesId int, comName, imageFilename string, theme ImageTheme) {
@@ -0,0 +1,107 @@
+package stats
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+const (
+	defaultWindowDays   = 30
+	maxWindowDays       = 365
+	defaultGraceMinutes = 120
+	// A week is already generous for a task that repeats daily; anything
+	// longer would make every completion on time.
+	maxGraceMinutes = 7 * 24 * 60
+)
+
+// adherenceWindow is the period and tolerance a report covers.
+type adherenceWindow struct {
+	From         time.Time
+	To           time.Time
+	GraceMinutes int
+}
+
+// parseAdherenceWindow reads the days and graceMinutes query parameters. The
+// window always ends now, so a dashboard polling the same URL sees it roll
+// forward.
+func parseAdherenceWindow(r *http.Request, now time.Time) (adherenceWindow, error) {
+	days, err := intQuery(r, "days", defaultWindowDays, 1, maxWindowDays)
+	if err != nil {
+		return adherenceWindow{}, err
+	}
+
+	grace, err := intQuery(r, "graceMinutes", defaultGraceMinutes, 0, maxGraceMinutes)
+	if err != nil {
+		return adherenceWindow{}, err
+	}
+
+	return adherenceWindow{
+		From:         now.AddDate(0, 0, -days),
+		To:           now,
+		GraceMinutes: grace,
+	}, nil
+}
+
+func intQuery(r *http.Request, name string, fallback int, min int, max int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return fallback, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min || value > max {
+		return 0, fmt.Errorf("invalid %s: must be an integer from %d to %d", name, min, max)
+	}
+
+	return value, nil
+}
+
+// finishAdherence derives the totals the query leaves to the caller. The rate
+// is nil rather than 0 when nothing was due, which would otherwise read as a
+// perfect failure.
+func finishAdherence(row *types.AdherenceStats, avgLateness sql.NullFloat64) {
+	row.Occurrences = row.OnTime + row.Late + row.Missed
+
+	row.OnTimeRate = nil
+	if row.Occurrences > 0 {
+		rate := float64(row.OnTime) / float64(row.Occurrences)
+		row.OnTimeRate = &rate
+	}
+
+	row.AvgLatenessMinutes = nil
+	if avgLateness.Valid {
+		minutes := avgLateness.Float64
+		row.AvgLatenessMinutes = &minutes
+	}
+}
+
+// adherenceResponse splits the store's rows by level. Every list is non-nil so
+// an empty level serialises as [] rather than null.
+func adherenceResponse(window adherenceWindow, stats []types.AdherenceStats) types.AdherenceResponse {
+	response := types.AdherenceResponse{
+		From:         window.From,
+		To:           window.To,
+		GraceMinutes: window.GraceMinutes,
+		Tasks:        make([]types.AdherenceStats, 0),
+		Animals:      make([]types.AdherenceStats, 0),
+		Enclosures:   make([]types.AdherenceStats, 0),
+	}
+
+	for _, row := range stats {
+		switch row.Level {
+		case "task":
+			response.Tasks = append(response.Tasks, row)
+		case "animal":
+			response.Animals = append(response.Animals, row)
+		case "enclosure":
+			response.Enclosures = append(response.Enclosures, row)
+		}
+	}
+
+	return response
+}
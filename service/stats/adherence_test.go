@@ -0,0 +1,95 @@
+package stats
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestParseAdherenceWindow(t *testing.T) {
+	now := time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name      string
+		query     string
+		wantFrom  time.Time
+		wantGrace int
+		wantErr   bool
+	}{
+		{"defaults", "", now.AddDate(0, 0, -30), 120, false},
+		{"explicit", "?days=7&graceMinutes=0", now.AddDate(0, 0, -7), 0, false},
+		{"days too small", "?days=0", time.Time{}, 0, true},
+		{"days too large", "?days=366", time.Time{}, 0, true},
+		{"negative grace", "?graceMinutes=-1", time.Time{}, 0, true},
+		{"not a number", "?days=week", time.Time{}, 0, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/stats/adherence"+tc.query, nil)
+
+			window, err := parseAdherenceWindow(r, now)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !window.From.Equal(tc.wantFrom) || !window.To.Equal(now) {
+				t.Errorf("got window %v to %v, want %v to %v", window.From, window.To, tc.wantFrom, now)
+			}
+			if window.GraceMinutes != tc.wantGrace {
+				t.Errorf("got grace %d, want %d", window.GraceMinutes, tc.wantGrace)
+			}
+		})
+	}
+}
+
+func TestFinishAdherence(t *testing.T) {
+	row := types.AdherenceStats{OnTime: 3, Late: 1, Missed: 1, Skipped: 2}
+	finishAdherence(&row, sql.NullFloat64{Float64: 45, Valid: true})
+
+	// Skipped occurrences are not owed, so they stay out of the total.
+	if row.Occurrences != 5 {
+		t.Errorf("got %d occurrences, want 5", row.Occurrences)
+	}
+	if row.OnTimeRate == nil || *row.OnTimeRate != 0.6 {
+		t.Errorf("got rate %v, want 0.6", row.OnTimeRate)
+	}
+	if row.AvgLatenessMinutes == nil || *row.AvgLatenessMinutes != 45 {
+		t.Errorf("got lateness %v, want 45", row.AvgLatenessMinutes)
+	}
+
+	// Only skips in the window: there is no rate to speak of, and no
+	// completions to average.
+	skipped := types.AdherenceStats{Skipped: 1}
+	finishAdherence(&skipped, sql.NullFloat64{})
+
+	if skipped.OnTimeRate != nil || skipped.AvgLatenessMinutes != nil {
+		t.Errorf("expected nil rate and lateness, got %v and %v", skipped.OnTimeRate, skipped.AvgLatenessMinutes)
+	}
+}
+
+func TestAdherenceResponseSplitsByLevel(t *testing.T) {
+	stats := []types.AdherenceStats{
+		{Level: "animal", Id: 1},
+		{Level: "task", Id: 2},
+		{Level: "task", Id: 3},
+	}
+
+	response := adherenceResponse(adherenceWindow{}, stats)
+
+	if len(response.Tasks) != 2 || len(response.Animals) != 1 {
+		t.Errorf("got %d tasks and %d animals, want 2 and 1", len(response.Tasks), len(response.Animals))
+	}
+	if response.Enclosures == nil {
+		t.Error("expected an empty enclosure list rather than nil")
+	}
+}
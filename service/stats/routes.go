@@ -0,0 +1,99 @@
+package stats
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+type Handler struct {
+	store     types.StatsStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.StatsStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+// RegisterV2Routes mounts the reporting routes. There is no v1 equivalent.
+func (h *Handler) RegisterV2Routes(router *mux.Router) {
+	router.HandleFunc("/stats/adherence", auth.WithJWTAuth(h.handleGetAdherence, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/stats/time", auth.WithJWTAuth(h.handleGetTimeStats, h.userStore)).Methods(http.MethodGet)
+}
+
+// handleGetAdherence godoc
+//
+//	@Id				getAdherence
+//	@Summary		Report how consistently care tasks are done
+//	@Description	Covers the last `days` days up to now, for every task, animal and enclosure with something due in that time. A completion within `graceMinutes` of its due time is on time. An occurrence is missed when the next one came due before it was done, and a task still outstanding past its grace period counts as late. Skipped occurrences are reported but left out of the rate and the streaks.
+//	@Tags			stats
+//	@Produce		json
+//	@Param			days			query		int	false	"Window length in days, 1 to 365"	default(30)
+//	@Param			graceMinutes	query		int	false	"Minutes after the due time still counted as on time, up to 10080"	default(120)
+//	@Success		200				{object}	types.AdherenceResponse
+//	@Failure		400				{object}	types.ErrorResponse
+//	@Failure		403				{object}	types.ErrorResponse
+//	@Failure		500				{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/stats/adherence [get]
+func (h *Handler) handleGetAdherence(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	window, err := parseAdherenceWindow(r, time.Now())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	grace := time.Duration(window.GraceMinutes) * time.Minute
+	stats, err := h.store.GetAdherence(userID, window.From, window.To, grace)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, adherenceResponse(window, stats))
+}
+
+// handleGetTimeStats godoc
+//
+//	@Id				getTimeStats
+//	@Summary		Report the time spent on care tasks
+//	@Description	Totals the recorded durations of completions in the last `days` days up to now, per animal, enclosure, user who completed them, and week. Weeks start on Monday in the caller's timezone, and every week the window touches is listed. An enclosure's time includes its animals'. Completions without a recorded duration are only counted in untimedCompletions. The workload is an estimate of the time the caller's tasks take per day, from each task's expectedMinutes, or else its average recorded duration, spread over its interval; paused tasks are left out.
+//	@Tags			stats
+//	@Produce		json
+//	@Param			days	query		int	false	"Window length in days, 1 to 365"	default(28)
+//	@Success		200		{object}	types.TimeStatsResponse
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/stats/time [get]
+func (h *Handler) handleGetTimeStats(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	window, err := parseTimeWindow(r, time.Now())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	u, err := h.userStore.GetUserById(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	loc := u.Location()
+	stats, err := h.store.GetTimeStats(userID, window.From, window.To, loc)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, timeStatsResponse(window, stats, loc))
+}
@@ -0,0 +1,287 @@
+package stats
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// adherenceQuery does all of the work in one statement, so the cost is one
+// round trip and a scan of the window's occurrences however many subjects
+// the user has.
+//
+// "events" holds one row per recorded occurrence due in the window, plus one
+// for each task that is outstanding past its grace period, which counts as
+// late and also accounts for the occurrences it has missed so far. "keyed"
+// repeats every event once per level it rolls up into, so a single
+// GROUP BY level, key produces the task, animal and enclosure figures
+// together.
+//
+// Streaks are a gaps-and-islands count: a running total of "not on time"
+// events splits each key's history into runs, and a run's length is its
+// on-time events. The current streak is the last run, which is 0 when the
+// latest event broke it.
+const adherenceQuery = `
+WITH params AS (
+	SELECT $2::timestamp AS "from", $3::timestamp AS "to", $4::float8 AS grace
+),
+owned AS (
+	SELECT t."taskId", t."complete", t."dueAt", t."repeatIntervHours",
+		ts."animalId", COALESCE(ts."enclosureId", a."enclosureId") AS "enclosureId"
+	FROM "tasks" t
+	INNER JOIN "taskUser" tu ON tu."taskId" = t."taskId"
+	INNER JOIN "taskSubject" ts ON ts."taskId" = t."taskId"
+	LEFT JOIN "animals" a ON a."animalId" = ts."animalId"
+	WHERE tu."userId" = $1
+),
+recorded AS (
+	SELECT o."taskId", o."occurrenceId" AS seq, o."dueAt" AS "sortAt", o."outcome",
+		EXTRACT(EPOCH FROM o."completedAt" - o."dueAt") AS lateness,
+		CASE WHEN o."outcome" = 'completed' AND o."intervalHours" > 0
+			THEN GREATEST(CEIL(EXTRACT(EPOCH FROM o."completedAt" - o."dueAt") / (o."intervalHours" * 3600.0)) - 1, 0)
+			ELSE 0 END AS missed,
+		true AS counted
+	FROM "taskOccurrences" o
+	INNER JOIN owned w ON w."taskId" = o."taskId"
+	CROSS JOIN params p
+	WHERE o."dueAt" >= p."from" AND o."dueAt" < p."to"
+),
+outstanding AS (
+	-- An occurrence that came due before the window only contributes the
+	-- occurrences it missed inside the window, not itself.
+	SELECT w."taskId", 0 AS seq, GREATEST(w."dueAt", p."from") AS "sortAt", 'outstanding' AS "outcome",
+		NULL::numeric AS lateness,
+		CASE WHEN w."repeatIntervHours" > 0
+			THEN GREATEST(CEIL(EXTRACT(EPOCH FROM p."to" - w."dueAt") / (w."repeatIntervHours" * 3600.0))
+				- GREATEST(1, CEIL(EXTRACT(EPOCH FROM p."from" - w."dueAt") / (w."repeatIntervHours" * 3600.0))), 0)
+			ELSE 0 END AS missed,
+		w."dueAt" >= p."from" AS counted
+	FROM owned w
+	CROSS JOIN params p
+	WHERE NOT w."complete" AND EXTRACT(EPOCH FROM p."to" - w."dueAt") > p.grace
+),
+events AS (
+	SELECT e.*, w."animalId", w."enclosureId",
+		e."outcome" = 'completed' AND e.lateness <= p.grace AND e.missed = 0 AS "onTime"
+	FROM (SELECT * FROM recorded UNION ALL SELECT * FROM outstanding) e
+	INNER JOIN owned w ON w."taskId" = e."taskId"
+	CROSS JOIN params p
+),
+keyed AS (
+	SELECT 'task' AS level, "taskId" AS key, * FROM events
+	UNION ALL
+	SELECT 'animal', "animalId", * FROM events WHERE "animalId" IS NOT NULL
+	UNION ALL
+	SELECT 'enclosure', "enclosureId", * FROM events WHERE "enclosureId" IS NOT NULL
+),
+totals AS (
+	SELECT level, key,
+		COUNT(*) FILTER (WHERE counted AND "onTime") AS "onTime",
+		COUNT(*) FILTER (WHERE counted AND "outcome" <> 'skipped' AND NOT "onTime") AS late,
+		COALESCE(SUM(missed), 0)::int AS missed,
+		COUNT(*) FILTER (WHERE "outcome" = 'skipped') AS skipped,
+		AVG(GREATEST(lateness, 0)) FILTER (WHERE "outcome" = 'completed') / 60 AS "avgLatenessMinutes"
+	FROM keyed
+	GROUP BY level, key
+	HAVING COUNT(*) FILTER (WHERE counted) > 0 OR SUM(missed) > 0
+),
+runs AS (
+	SELECT level, key, breaks, COUNT(*) FILTER (WHERE "onTime") AS length
+	FROM (
+		SELECT level, key, "onTime",
+			SUM(CASE WHEN "onTime" THEN 0 ELSE 1 END) OVER (PARTITION BY level, key ORDER BY "sortAt", seq) AS breaks
+		FROM keyed
+		WHERE "outcome" <> 'skipped'
+	) flagged
+	GROUP BY level, key, breaks
+),
+streaks AS (
+	SELECT level, key, MAX(length) AS longest, (ARRAY_AGG(length ORDER BY breaks DESC))[1] AS current
+	FROM runs
+	GROUP BY level, key
+)
+SELECT t.level, t.key, COALESCE(tk."taskName", a."animalName", e."enclosureName", ''),
+	t."onTime", t.late, t.missed, t.skipped, t."avgLatenessMinutes",
+	COALESCE(s.current, 0), COALESCE(s.longest, 0)
+FROM totals t
+LEFT JOIN streaks s ON s.level = t.level AND s.key = t.key
+LEFT JOIN "tasks" tk ON t.level = 'task' AND tk."taskId" = t.key
+LEFT JOIN "animals" a ON t.level = 'animal' AND a."animalId" = t.key
+LEFT JOIN "enclosures" e ON t.level = 'enclosure' AND e."enclosureId" = t.key
+ORDER BY t.level, t.key`
+
+func (s *Store) GetAdherence(userID int, from time.Time, to time.Time, grace time.Duration) ([]types.AdherenceStats, error) {
+	// Stored times are zone-less and written in UTC, so the bounds are passed
+	// the same way.
+	rows, err := s.db.Query(adherenceQuery, userID, from.UTC(), to.UTC(), grace.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	stats := make([]types.AdherenceStats, 0)
+	for rows.Next() {
+		var row types.AdherenceStats
+		var avgLateness sql.NullFloat64
+		err := rows.Scan(
+			&row.Level,
+			&row.Id,
+			&row.Name,
+			&row.OnTime,
+			&row.Late,
+			&row.Missed,
+			&row.Skipped,
+			&avgLateness,
+			&row.CurrentStreak,
+			&row.LongestStreak,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		finishAdherence(&row, avgLateness)
+		stats = append(stats, row)
+	}
+
+	return stats, rows.Err()
+}
+
+// timeTotalsQuery sums recorded durations of the user's completions in the
+// window, once per level each completion rolls up into, as in adherenceQuery.
+// The "total" level has a single key, 0. Completions without a duration are
+// counted separately, since they add nothing to the minutes.
+const timeTotalsQuery = `
+WITH done AS (
+	SELECT o."durationMinutes", o."completedBy", ts."animalId",
+		COALESCE(ts."enclosureId", a."enclosureId") AS "enclosureId"
+	FROM "taskOccurrences" o
+	INNER JOIN "taskUser" tu ON tu."taskId" = o."taskId"
+	INNER JOIN "taskSubject" ts ON ts."taskId" = o."taskId"
+	LEFT JOIN "animals" a ON a."animalId" = ts."animalId"
+	WHERE tu."userId" = $1 AND o."outcome" = 'completed'
+		AND o."completedAt" >= $2 AND o."completedAt" < $3
+),
+keyed AS (
+	SELECT 'total' AS level, 0 AS key, * FROM done
+	UNION ALL
+	SELECT 'animal', "animalId", * FROM done WHERE "animalId" IS NOT NULL
+	UNION ALL
+	SELECT 'enclosure', "enclosureId", * FROM done WHERE "enclosureId" IS NOT NULL
+	UNION ALL
+	SELECT 'user', "completedBy", * FROM done WHERE "completedBy" IS NOT NULL
+),
+totals AS (
+	SELECT level, key,
+		COALESCE(SUM("durationMinutes"), 0)::int AS minutes,
+		COUNT("durationMinutes") AS timed,
+		COUNT(*) - COUNT("durationMinutes") AS untimed
+	FROM keyed
+	GROUP BY level, key
+)
+SELECT t.level, t.key, COALESCE(a."animalName", e."enclosureName", u."firstName" || ' ' || u."lastName", ''),
+	t.minutes, t.timed, t.untimed
+FROM totals t
+LEFT JOIN "animals" a ON t.level = 'animal' AND a."animalId" = t.key
+LEFT JOIN "enclosures" e ON t.level = 'enclosure' AND e."enclosureId" = t.key
+LEFT JOIN "users" u ON t.level = 'user' AND u."userId" = t.key
+ORDER BY t.level, t.key`
+
+// timeWeeksQuery sums the same durations by the week, starting Monday, of
+// the user's local date they were completed on.
+const timeWeeksQuery = `
+SELECT to_char(date_trunc('week', (o."completedAt" AT TIME ZONE 'UTC') AT TIME ZONE $4), 'YYYY-MM-DD') AS week,
+	SUM(o."durationMinutes")::int, COUNT(*)
+FROM "taskOccurrences" o
+INNER JOIN "taskUser" tu ON tu."taskId" = o."taskId"
+WHERE tu."userId" = $1 AND o."outcome" = 'completed' AND o."durationMinutes" IS NOT NULL
+	AND o."completedAt" >= $2 AND o."completedAt" < $3
+GROUP BY week
+ORDER BY week`
+
+// workloadQuery spreads each task's typical duration over its interval to get
+// minutes per day. A task's typical duration is its expected minutes, or
+// failing that the average of every duration ever recorded for it. Paused
+// tasks are left out, as nothing is expected of them.
+const workloadQuery = `
+WITH owned AS (
+	SELECT t."taskId", t."expectedMinutes", t."repeatIntervHours"
+	FROM "tasks" t
+	INNER JOIN "taskUser" tu ON tu."taskId" = t."taskId"
+	WHERE tu."userId" = $1 AND t."taskId" NOT IN (SELECT "taskId" FROM "pausedTasks")
+),
+typical AS (
+	SELECT w."taskId", COALESCE(w."expectedMinutes", AVG(o."durationMinutes")) AS minutes, w."repeatIntervHours"
+	FROM owned w
+	LEFT JOIN "taskOccurrences" o ON o."taskId" = w."taskId" AND o."durationMinutes" IS NOT NULL
+	GROUP BY w."taskId", w."expectedMinutes", w."repeatIntervHours"
+)
+SELECT COALESCE(SUM(minutes * 24.0 / "repeatIntervHours"), 0)::float8,
+	COUNT(minutes), COUNT(*) - COUNT(minutes)
+FROM typical`
+
+func (s *Store) GetTimeStats(userID int, from time.Time, to time.Time, loc *time.Location) (*types.TimeStats, error) {
+	stats := &types.TimeStats{
+		Totals: make([]types.TimeTotal, 0),
+		Weeks:  make([]types.WeekTime, 0),
+	}
+
+	rows, err := s.db.Query(timeTotalsQuery, userID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var row types.TimeTotal
+		var untimed int
+		if err := rows.Scan(&row.Level, &row.Id, &row.Name, &row.Minutes, &row.Completions, &untimed); err != nil {
+			return nil, err
+		}
+
+		if row.Level == "total" {
+			stats.TotalMinutes = row.Minutes
+			stats.Completions = row.Completions
+			stats.Untimed = untimed
+			continue
+		}
+
+		stats.Totals = append(stats.Totals, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	weeks, err := s.db.Query(timeWeeksQuery, userID, from.UTC(), to.UTC(), loc.String())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = weeks.Close() }()
+
+	for weeks.Next() {
+		var week types.WeekTime
+		if err := weeks.Scan(&week.WeekStart, &week.Minutes, &week.Completions); err != nil {
+			return nil, err
+		}
+
+		stats.Weeks = append(stats.Weeks, week)
+	}
+	if err := weeks.Err(); err != nil {
+		return nil, err
+	}
+
+	err = s.db.QueryRow(workloadQuery, userID).Scan(&stats.Workload.DailyMinutes, &stats.Workload.Estimated, &stats.Workload.Unestimated)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
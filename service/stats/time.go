@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// defaultTimeWindowDays is four whole weeks, so the weekly figures of the
+// default window are comparable with one another.
+const defaultTimeWindowDays = 28
+
+// weekStartLayout is how a week is named: the local date of its Monday.
+const weekStartLayout = "2006-01-02"
+
+// timeWindow is the period a time report covers.
+type timeWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+// parseTimeWindow reads the days query parameter. Like the adherence window,
+// it always ends now.
+func parseTimeWindow(r *http.Request, now time.Time) (timeWindow, error) {
+	days, err := intQuery(r, "days", defaultTimeWindowDays, 1, maxWindowDays)
+	if err != nil {
+		return timeWindow{}, err
+	}
+
+	return timeWindow{From: now.AddDate(0, 0, -days), To: now}, nil
+}
+
+// weekStart is the Monday of the week t falls in, on the calendar of loc.
+func weekStart(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	offset := (int(local.Weekday()) + 6) % 7
+
+	return time.Date(local.Year(), local.Month(), local.Day()-offset, 0, 0, 0, 0, loc)
+}
+
+// fillWeeks returns one entry for every week the window touches, oldest
+// first, taking the figures of those the store returned. Weeks with nothing
+// recorded are kept as zeros so a chart of them has no gaps.
+func fillWeeks(recorded []types.WeekTime, window timeWindow, loc *time.Location) []types.WeekTime {
+	byStart := make(map[string]types.WeekTime, len(recorded))
+	for _, week := range recorded {
+		byStart[week.WeekStart] = week
+	}
+
+	weeks := make([]types.WeekTime, 0)
+	for start := weekStart(window.From, loc); start.Before(window.To); start = start.AddDate(0, 0, 7) {
+		name := start.Format(weekStartLayout)
+
+		week, ok := byStart[name]
+		if !ok {
+			week = types.WeekTime{WeekStart: name}
+		}
+
+		weeks = append(weeks, week)
+	}
+
+	return weeks
+}
+
+// timeStatsResponse splits the store's totals by level and fills in the
+// weeks. Every list is non-nil so an empty level serialises as [].
+func timeStatsResponse(window timeWindow, stats *types.TimeStats, loc *time.Location) types.TimeStatsResponse {
+	response := types.TimeStatsResponse{
+		From:               window.From,
+		To:                 window.To,
+		TotalMinutes:       stats.TotalMinutes,
+		Completions:        stats.Completions,
+		UntimedCompletions: stats.Untimed,
+		Animals:            make([]types.TimeTotal, 0),
+		Enclosures:         make([]types.TimeTotal, 0),
+		Users:              make([]types.TimeTotal, 0),
+		Weeks:              fillWeeks(stats.Weeks, window, loc),
+		Workload:           stats.Workload,
+	}
+
+	response.Workload.WeeklyMinutes = response.Workload.DailyMinutes * 7
+
+	for _, row := range stats.Totals {
+		switch row.Level {
+		case "animal":
+			response.Animals = append(response.Animals, row)
+		case "enclosure":
+			response.Enclosures = append(response.Enclosures, row)
+		case "user":
+			response.Users = append(response.Users, row)
+		}
+	}
+
+	return response
+}
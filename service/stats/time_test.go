@@ -0,0 +1,92 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestWeekStart(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("tz database not available")
+	}
+
+	cases := []struct {
+		name string
+		at   time.Time
+		loc  *time.Location
+		want string
+	}{
+		{"monday is its own start", time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC), time.UTC, "2026-10-12"},
+		{"sunday belongs to the week before", time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC), time.UTC, "2026-10-12"},
+		// Sunday evening in UTC is already Monday in Tokyo.
+		{"local calendar", time.Date(2026, 10, 18, 20, 0, 0, 0, time.UTC), tokyo, "2026-10-19"},
+		{"across a month", time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC), time.UTC, "2026-10-26"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := weekStart(tc.at, tc.loc).Format(weekStartLayout); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFillWeeks(t *testing.T) {
+	window := timeWindow{
+		From: time.Date(2026, 9, 23, 12, 0, 0, 0, time.UTC),
+		To:   time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+	}
+	recorded := []types.WeekTime{
+		{WeekStart: "2026-09-28", Minutes: 90, Completions: 4},
+		{WeekStart: "2026-10-12", Minutes: 15, Completions: 1},
+	}
+
+	weeks := fillWeeks(recorded, window, time.UTC)
+
+	want := []types.WeekTime{
+		{WeekStart: "2026-09-21"},
+		{WeekStart: "2026-09-28", Minutes: 90, Completions: 4},
+		{WeekStart: "2026-10-05"},
+		{WeekStart: "2026-10-12", Minutes: 15, Completions: 1},
+	}
+	if len(weeks) != len(want) {
+		t.Fatalf("got %d weeks, want %d: %+v", len(weeks), len(want), weeks)
+	}
+	for i := range want {
+		if weeks[i] != want[i] {
+			t.Errorf("week %d: got %+v, want %+v", i, weeks[i], want[i])
+		}
+	}
+}
+
+func TestTimeStatsResponseSplitsLevels(t *testing.T) {
+	window := timeWindow{
+		From: time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2026, 10, 13, 0, 0, 0, 0, time.UTC),
+	}
+	stats := &types.TimeStats{
+		Totals: []types.TimeTotal{
+			{Level: "animal", Id: 1, Minutes: 10},
+			{Level: "user", Id: 7, Minutes: 10},
+		},
+		Weeks:    []types.WeekTime{},
+		Workload: types.Workload{DailyMinutes: 12.5},
+	}
+
+	response := timeStatsResponse(window, stats, time.UTC)
+
+	if len(response.Animals) != 1 || len(response.Users) != 1 {
+		t.Errorf("got %d animals and %d users, want 1 of each", len(response.Animals), len(response.Users))
+	}
+	// An empty level must serialise as [], not null.
+	if response.Enclosures == nil {
+		t.Error("enclosures is nil")
+	}
+	if response.Workload.WeeklyMinutes != 87.5 {
+		t.Errorf("got weekly minutes %v, want 87.5", response.Workload.WeeklyMinutes)
+	}
+}
@@ -0,0 +1,208 @@
+package task
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+const (
+	bulkOpComplete         = "complete"
+	bulkOpUncomplete       = "uncomplete"
+	bulkOpSetLastCompleted = "setLastCompleted"
+	bulkOpDelete           = "delete"
+	bulkOpReassign         = "reassign"
+
+	// Atomic is the default, so only the opt-out needs naming.
+	bulkModeBestEffort = "bestEffort"
+
+	bulkStatusApplied    = "applied"
+	bulkStatusFailed     = "failed"
+	bulkStatusNotApplied = "notApplied"
+)
+
+// handleBulkTasks godoc
+//
+//	@Id				bulkUpdateTasks
+//	@Summary		Apply several task changes at once
+//	@Description	Operations run in order. Each task must belong to the caller, and reassign must name exactly one subject the caller owns. In atomic mode (the default) a single failure leaves every task unchanged; in bestEffort mode the others still apply. The response always has one result per operation.
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			operations	body		types.BulkTaskPayload	true	"Operations to apply"
+//	@Success		200			{object}	types.BulkTaskResponse
+//	@Failure		400			{object}	types.ErrorResponse
+//	@Failure		403			{object}	types.ErrorResponse
+//	@Failure		500			{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/bulk [post]
+func (h *Handler) handleBulkTasks(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	var payload types.BulkTaskPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	atomic := payload.Mode != bulkModeBestEffort
+
+	checkErrs, err := h.checkBulkOperations(payload.Operations, userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Only operations that passed their checks reach the store. In atomic mode
+	// a failed check means nothing is applied, so the store is not called.
+	var pending []types.BulkTaskOperation
+	var pendingIndex []int
+	for i, op := range payload.Operations {
+		if checkErrs[i] == nil {
+			pending = append(pending, op)
+			pendingIndex = append(pendingIndex, i)
+		}
+	}
+
+	// Completing a complete task changes nothing, so only a task that was
+	// incomplete beforehand is reported as completed.
+	wasComplete := make(map[int]bool)
+	for _, op := range pending {
+		if op.Op == bulkOpComplete {
+			wasComplete[op.TaskId] = h.taskComplete(op.TaskId)
+		}
+	}
+
+	applyErrs := make([]error, len(payload.Operations))
+	if len(pending) > 0 && (!atomic || len(pending) == len(payload.Operations)) {
+		storeErrs, err := h.store.ApplyTaskOperations(pending, userID, atomic)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		for j, i := range pendingIndex {
+			applyErrs[i] = storeErrs[j]
+		}
+	}
+
+	response := bulkResults(payload.Operations, checkErrs, applyErrs, atomic)
+	h.publishBulk(userID, response.Results, wasComplete)
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// checkBulkOperations validates each operation's own fields and checks the
+// caller owns everything it touches, returning one error slot per operation.
+// The error return is for lookups that failed outright.
+func (h *Handler) checkBulkOperations(ops []types.BulkTaskOperation, userID int) ([]error, error) {
+	errs := make([]error, len(ops))
+	deleted := make(map[int]bool)
+
+	for i, op := range ops {
+		if err := checkBulkOperationFields(op); err != nil {
+			errs[i] = err
+			continue
+		}
+
+		// Operations run in order, so one naming a task an earlier operation
+		// deletes would find nothing to change.
+		if deleted[op.TaskId] {
+			errs[i] = fmt.Errorf("task %d is deleted by an earlier operation", op.TaskId)
+			continue
+		}
+
+		owned, err := h.store.UserOwnsTask(op.TaskId, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !owned {
+			errs[i] = fmt.Errorf("task %d does not exist or does not belong to you", op.TaskId)
+			continue
+		}
+
+		if op.Op == bulkOpReassign {
+			owned, err := h.ownsSubject(op.AnimalId, op.EnclosureId, userID)
+			if err != nil {
+				return nil, err
+			}
+			if !owned {
+				errs[i] = fmt.Errorf("you do not have access to that subject")
+				continue
+			}
+		}
+
+		if op.Op == bulkOpDelete {
+			deleted[op.TaskId] = true
+		}
+	}
+
+	return errs, nil
+}
+
+// checkBulkOperationFields enforces which optional fields each operation needs.
+func checkBulkOperationFields(op types.BulkTaskOperation) error {
+	switch op.Op {
+	case bulkOpSetLastCompleted:
+		if op.LastCompleted == nil || op.LastCompleted.IsZero() {
+			return fmt.Errorf("setLastCompleted needs lastCompleted")
+		}
+	case bulkOpReassign:
+		return exactlyOneSubject(op.AnimalId, op.EnclosureId)
+	}
+
+	return nil
+}
+
+// bulkResults assembles the per-operation report. In an atomic batch with any
+// failure, every operation that did not fail itself is notApplied rather than
+// failed, whether it was rolled back or never attempted, so a client can tell
+// which ones to fix and which ones to simply retry.
+func bulkResults(ops []types.BulkTaskOperation, checkErrs []error, applyErrs []error, atomic bool) types.BulkTaskResponse {
+	failed := 0
+	for i := range ops {
+		if checkErrs[i] != nil || applyErrs[i] != nil {
+			failed++
+		}
+	}
+
+	response := types.BulkTaskResponse{Results: make([]types.BulkTaskResult, 0, len(ops))}
+	for i, op := range ops {
+		result := types.BulkTaskResult{Index: i, TaskId: op.TaskId, Op: op.Op}
+
+		switch {
+		case checkErrs[i] != nil:
+			result.Status = bulkStatusFailed
+			result.Error = errorMessage(checkErrs[i])
+		case applyErrs[i] != nil:
+			result.Status = bulkStatusFailed
+			result.Error = errorMessage(applyErrs[i])
+		case atomic && failed > 0:
+			result.Status = bulkStatusNotApplied
+		default:
+			result.Status = bulkStatusApplied
+			response.Applied++
+		}
+
+		response.Results = append(response.Results, result)
+	}
+	response.Failed = failed
+
+	return response
+}
+
+func errorMessage(err error) *string {
+	message := err.Error()
+
+	return &message
+}
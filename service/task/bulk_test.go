@@ -0,0 +1,82 @@
+package task
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestCheckBulkOperationFields(t *testing.T) {
+	animal, enclosure := 3, 7
+	when := time.Date(2026, 3, 10, 8, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		op      types.BulkTaskOperation
+		wantErr bool
+	}{
+		{"complete needs nothing else", types.BulkTaskOperation{Op: bulkOpComplete, TaskId: 1}, false},
+		{"setLastCompleted with a time", types.BulkTaskOperation{Op: bulkOpSetLastCompleted, TaskId: 1, LastCompleted: &when}, false},
+		{"setLastCompleted without a time", types.BulkTaskOperation{Op: bulkOpSetLastCompleted, TaskId: 1}, true},
+		{"reassign to an animal", types.BulkTaskOperation{Op: bulkOpReassign, TaskId: 1, AnimalId: &animal}, false},
+		{"reassign to nothing", types.BulkTaskOperation{Op: bulkOpReassign, TaskId: 1}, true},
+		{"reassign to both", types.BulkTaskOperation{Op: bulkOpReassign, TaskId: 1, AnimalId: &animal, EnclosureId: &enclosure}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkBulkOperationFields(tc.op)
+			if tc.wantErr && err == nil {
+				t.Error("expected an error, got none")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestBulkResults(t *testing.T) {
+	ops := []types.BulkTaskOperation{
+		{Op: bulkOpComplete, TaskId: 1},
+		{Op: bulkOpDelete, TaskId: 2},
+		{Op: bulkOpUncomplete, TaskId: 3},
+	}
+	checkErrs := []error{nil, fmt.Errorf("not yours"), nil}
+	applyErrs := make([]error, len(ops))
+
+	statuses := func(r types.BulkTaskResponse) []string {
+		var out []string
+		for _, result := range r.Results {
+			out = append(out, result.Status)
+		}
+		return out
+	}
+
+	// One failure in an atomic batch means nothing else was applied, and the
+	// others are reported as retryable rather than as failures of their own.
+	atomic := bulkResults(ops, checkErrs, applyErrs, true)
+	if got, want := fmt.Sprint(statuses(atomic)), "[notApplied failed notApplied]"; got != want {
+		t.Errorf("atomic statuses = %s, want %s", got, want)
+	}
+	if atomic.Applied != 0 || atomic.Failed != 1 {
+		t.Errorf("atomic counts = %d applied %d failed, want 0 and 1", atomic.Applied, atomic.Failed)
+	}
+
+	bestEffort := bulkResults(ops, checkErrs, applyErrs, false)
+	if got, want := fmt.Sprint(statuses(bestEffort)), "[applied failed applied]"; got != want {
+		t.Errorf("best-effort statuses = %s, want %s", got, want)
+	}
+	if bestEffort.Applied != 2 || bestEffort.Failed != 1 {
+		t.Errorf("best-effort counts = %d applied %d failed, want 2 and 1", bestEffort.Applied, bestEffort.Failed)
+	}
+
+	if bestEffort.Results[1].Error == nil || *bestEffort.Results[1].Error != "not yours" {
+		t.Errorf("expected the failed operation to carry its error, got %v", bestEffort.Results[1].Error)
+	}
+	if bestEffort.Results[2].Index != 2 || bestEffort.Results[2].TaskId != 3 {
+		t.Errorf("results must line up with the request: got %+v", bestEffort.Results[2])
+	}
+}
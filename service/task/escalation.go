@@ -0,0 +1,258 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// handleGetTaskEscalation godoc
+//
+//	@Id				getTaskEscalation
+//	@Summary		Get a task's escalation policy
+//	@Description	Backups who have accepted are listed by user ID and first name, and invites still pending by the address they were sent to. Rules are listed in the order they fire.
+//	@Tags			tasks
+//	@Produce		json
+//	@Param			id	path		int	true	"Task ID"
+//	@Success		200	{object}	types.TaskEscalation
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/escalation [get]
+func (h *Handler) handleGetTaskEscalation(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	escalation, err := h.store.GetTaskEscalation(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, escalation)
+}
+
+// handleSetTaskEscalation godoc
+//
+//	@Id				setTaskEscalation
+//	@Summary		Replace a task's escalation policy
+//	@Description	Sets the backups and the rules for who is notified when the task is left undone. No one becomes a backup without agreeing to it: backupEmails invites addresses, and the account with each address accepts or declines at /tasks/{id}/backup. backupUserIds keeps backups who have already accepted; any other backup is removed, as is any pending invite left out of backupEmails. A rule fires once the task has been outstanding for afterHours past its due time: "backups" notifies the backup users who have accepted, "everyone" the owner as well. Each rule fires once per occurrence, and none fire once the task is completed or while it is paused. Empty lists turn escalation off.
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int								true	"Task ID"
+//	@Param			escalation	body		types.SetTaskEscalationPayload	true	"The whole policy"
+//	@Success		200			{object}	types.TaskEscalation
+//	@Failure		400			{object}	types.ErrorResponse
+//	@Failure		403			{object}	types.ErrorResponse
+//	@Failure		500			{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/escalation [put]
+func (h *Handler) handleSetTaskEscalation(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	var payload types.SetTaskEscalationPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	// Invites are never checked against other accounts, so the response is
+	// the same whether or not an address has one.
+	owner, err := h.userStore.GetUserById(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, email := range payload.BackupEmails {
+		if email == owner.Email {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("you cannot name yourself as a backup"))
+			return
+		}
+	}
+
+	rules, err := escalationRules(payload.Rules, len(payload.BackupUserIds)+len(payload.BackupEmails))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.SetTaskEscalation(id, payload.BackupUserIds, payload.BackupEmails, rules); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	escalation, err := h.store.GetTaskEscalation(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, escalation)
+}
+
+// handleListBackupInvites godoc
+//
+//	@Id				listBackupInvites
+//	@Summary		List invites to back up other users' tasks
+//	@Description	Invites sent to the caller's email address that have not been accepted or declined. Empty until the address has been verified.
+//	@Tags			tasks
+//	@Produce		json
+//	@Success		200	{array}		types.BackupInvite
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/backup-invites [get]
+func (h *Handler) handleListBackupInvites(w http.ResponseWriter, r *http.Request) {
+	user, err := h.userStore.GetUserById(auth.GetuserIdFromContext(r.Context()))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	invites, err := h.store.GetBackupInvites(inviteEmail(user))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, invites)
+}
+
+// handleAcceptBackupInvite godoc
+//
+//	@Id				acceptBackupInvite
+//	@Summary		Accept an invite to back up a task
+//	@Description	The caller starts hearing about the task when its escalation rules fire. Invites are found by email address, so only an account whose address has been verified can accept one.
+//	@Tags			tasks
+//	@Param			id	path	int	true	"Task ID"
+//	@Success		204
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		404	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/backup [post]
+func (h *Handler) handleAcceptBackupInvite(w http.ResponseWriter, r *http.Request) {
+	h.answerBackupInvite(w, r, h.store.AcceptBackupInvite)
+}
+
+// handleLeaveTaskBackup godoc
+//
+//	@Id				leaveTaskBackup
+//	@Summary		Decline an invite to back up a task, or stop backing it up
+//	@Tags			tasks
+//	@Param			id	path	int	true	"Task ID"
+//	@Success		204
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		404	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/backup [delete]
+func (h *Handler) handleLeaveTaskBackup(w http.ResponseWriter, r *http.Request) {
+	h.answerBackupInvite(w, r, h.store.LeaveTaskBackup)
+}
+
+func (h *Handler) answerBackupInvite(w http.ResponseWriter, r *http.Request, answer func(taskId int, userID int, email string) error) {
+	id, err := utils.ParseIDParam(r, "id")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := h.userStore.GetUserById(auth.GetuserIdFromContext(r.Context()))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := answer(id, user.ID, inviteEmail(user)); err != nil {
+		if errors.Is(err, ErrBackupInviteNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteStatus(w, http.StatusNoContent)
+}
+
+// inviteEmail is the address backup invites to user are found by. Anyone can
+// register with any address, so until the account has verified its address
+// it is shown and can answer none of the invites sent there.
+func inviteEmail(user *types.User) string {
+	if !user.EmailVerifiedAt.Valid {
+		return ""
+	}
+
+	return user.Email
+}
+
+// escalationRules checks the rules against each other and returns them in
+// the order they fire. Rules are told apart by their hours, so two with the
+// same hours are rejected, as is a rule for backups on a task that has none.
+func escalationRules(payload []types.EscalationRulePayload, backups int) ([]types.EscalationRule, error) {
+	rules := make([]types.EscalationRule, 0, len(payload))
+	seen := make(map[int]bool, len(payload))
+
+	for _, rule := range payload {
+		if seen[rule.AfterHours] {
+			return nil, fmt.Errorf("more than one rule fires after %d hours", rule.AfterHours)
+		}
+		seen[rule.AfterHours] = true
+
+		if rule.Audience == "backups" && backups == 0 {
+			return nil, fmt.Errorf("a rule notifying backups needs at least one backup user")
+		}
+
+		rules = append(rules, types.EscalationRule{
+			AfterHours: rule.AfterHours,
+			Audience:   rule.Audience,
+			Urgency:    rule.Urgency,
+		})
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].AfterHours < rules[j].AfterHours })
+
+	return rules, nil
+}
+
+// escalateOverdueTasks fires the escalation rules that have come due and
+// sends their notifications in the background. It runs in the same sweep as
+// the reset, after it, and returns how many tasks were escalated.
+func (h *Handler) escalateOverdueTasks() (int, error) {
+	notifications, err := h.store.ClaimDueEscalations()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(notifications) > 0 && h.notificationSender != nil {
+		go h.notificationSender.SendEscalationNotifications(notifications)
+	}
+
+	return escalatedTaskCount(notifications), nil
+}
+
+// escalatedTaskCount counts tasks rather than notifications, which go out one
+// per recipient.
+func escalatedTaskCount(notifications []*types.TaskEscalationNotification) int {
+	tasks := make(map[int]bool)
+	for _, n := range notifications {
+		tasks[n.TaskId] = true
+	}
+
+	return len(tasks)
+}
@@ -0,0 +1,68 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestEscalationRules(t *testing.T) {
+	rules, err := escalationRules([]types.EscalationRulePayload{
+		{AfterHours: 8, Audience: "everyone", Urgency: "high"},
+		{AfterHours: 2, Audience: "backups", Urgency: "normal"},
+	}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != 2 || rules[0].AfterHours != 2 || rules[1].AfterHours != 8 {
+		t.Errorf("got %+v, want the rules in firing order", rules)
+	}
+
+	cases := []struct {
+		name    string
+		rules   []types.EscalationRulePayload
+		backups int
+	}{
+		{"same hours twice", []types.EscalationRulePayload{
+			{AfterHours: 4, Audience: "everyone", Urgency: "normal"},
+			{AfterHours: 4, Audience: "everyone", Urgency: "high"},
+		}, 1},
+		// It would fire and notify nobody.
+		{"backups without any", []types.EscalationRulePayload{
+			{AfterHours: 4, Audience: "backups", Urgency: "normal"},
+		}, 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := escalationRules(tc.rules, tc.backups); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	// The owner is always there to hear about it.
+	if _, err := escalationRules([]types.EscalationRulePayload{{AfterHours: 4, Audience: "everyone", Urgency: "high"}}, 0); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestEscalatedTaskCount(t *testing.T) {
+	notification := func(taskId int, userID int) *types.TaskEscalationNotification {
+		n := &types.TaskEscalationNotification{}
+		n.TaskId = taskId
+		n.UserID = userID
+		return n
+	}
+
+	// One notification per recipient, but two tasks.
+	got := escalatedTaskCount([]*types.TaskEscalationNotification{
+		notification(1, 10),
+		notification(1, 11),
+		notification(2, 10),
+	})
+	if got != 2 {
+		t.Errorf("got %d, want 2", got)
+	}
+}
@@ -0,0 +1,115 @@
+package task
+
+import (
+	"log"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/service/webhook"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// publishTask tells the user's webhooks about a change to the task, sending
+// it as GET /tasks/{id} would return it now.
+func (h *Handler) publishTask(userID int, event string, id int) {
+	publishTask(h.webhooks, h.store, userID, event, id)
+}
+
+// PublishCompleted sends task.completed for a task completed outside these
+// routes, such as by text, as POST /tasks/{id}/complete sends it.
+func PublishCompleted(webhooks *webhook.Dispatcher, store types.TaskStore, userID int, id int) {
+	publishTask(webhooks, store, userID, webhook.EventTaskCompleted, id)
+}
+
+func publishTask(webhooks *webhook.Dispatcher, store types.TaskStore, userID int, event string, id int) {
+	if webhooks == nil {
+		return
+	}
+
+	t, err := store.GetTaskWithSubjectById(id)
+	if err != nil {
+		log.Printf("failed to read task %d for %s webhook: %v", id, event, err)
+		return
+	}
+
+	annotateDueState(t, time.Now())
+	webhooks.Publish(userID, event, t)
+}
+
+func (h *Handler) publishTaskDeleted(userID int, id int) {
+	h.webhooks.Publish(userID, webhook.EventTaskDeleted, map[string]int{"taskId": id})
+}
+
+// taskComplete says whether the task is complete, so that a change that
+// might complete it can tell afterwards whether it did. When it cannot be
+// read the task is taken to be complete, which errs towards sending no
+// event rather than a false one.
+func (h *Handler) taskComplete(id int) bool {
+	if h.webhooks == nil {
+		return true
+	}
+
+	t, err := h.store.GetTaskById(id)
+	if err != nil {
+		log.Printf("failed to read task %d before a change: %v", id, err)
+		return true
+	}
+
+	return t.Complete
+}
+
+// publishIfCompleted sends task.completed if the task was not complete before
+// a change and is now.
+func (h *Handler) publishIfCompleted(userID int, id int, wasComplete bool) {
+	if wasComplete || !h.taskComplete(id) {
+		return
+	}
+
+	h.publishTask(userID, webhook.EventTaskCompleted, id)
+}
+
+// publishBulk tells webhooks about the bulk operations that were applied,
+// once per task for each kind of change. A task completed and then deleted
+// in the same request is only reported as deleted.
+func (h *Handler) publishBulk(userID int, results []types.BulkTaskResult, wasComplete map[int]bool) {
+	deleted := make(map[int]bool)
+	for _, r := range results {
+		if r.Status == bulkStatusApplied && r.Op == bulkOpDelete && !deleted[r.TaskId] {
+			deleted[r.TaskId] = true
+			h.publishTaskDeleted(userID, r.TaskId)
+		}
+	}
+
+	completed := make(map[int]bool)
+	for _, r := range results {
+		if r.Status == bulkStatusApplied && r.Op == bulkOpComplete && !deleted[r.TaskId] && !completed[r.TaskId] {
+			completed[r.TaskId] = true
+			h.publishIfCompleted(userID, r.TaskId, wasComplete[r.TaskId])
+		}
+	}
+}
+
+// publishResets sends task.reset for a sweep's resets. A sweep can reset
+// many tasks, so which users want the event is asked once for all of them,
+// and only their tasks are read.
+func (h *Handler) publishResets(tasks []*types.TaskResetNotification) {
+	if h.webhooks == nil || len(tasks) == 0 {
+		return
+	}
+
+	userIDs := make([]int, 0, len(tasks))
+	for _, t := range tasks {
+		userIDs = append(userIDs, t.UserID)
+	}
+
+	subscribed, err := h.webhooks.Subscribers(userIDs, webhook.EventTaskReset)
+	if err != nil {
+		log.Printf("failed to look up task.reset webhooks: %v", err)
+		return
+	}
+
+	for _, t := range tasks {
+		if subscribed[t.UserID] {
+			h.publishTask(t.UserID, webhook.EventTaskReset, t.TaskId)
+		}
+	}
+}
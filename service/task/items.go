@@ -0,0 +1,266 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// handleListTaskItems godoc
+//
+//	@Id				listTaskItems
+//	@Summary		List a task's checklist
+//	@Tags			tasks
+//	@Produce		json
+//	@Param			id	path	int	true	"Task ID"
+//	@Success		200	{array}		types.TaskItem
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/items [get]
+func (h *Handler) handleListTaskItems(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	items, err := h.store.GetTaskItems(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, items)
+}
+
+// handleCreateTaskItem godoc
+//
+//	@Id				createTaskItem
+//	@Summary		Add a checklist item to a task
+//	@Description	The item goes to the end of the checklist and starts not done. Returns the task with its updated checklist.
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"Task ID"
+//	@Param			item	body		types.CreateTaskItemPayload	true	"Item to add"
+//	@Success		201		{object}	types.TaskWithSubject
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/items [post]
+func (h *Handler) handleCreateTaskItem(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	var payload types.CreateTaskItemPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	if err := h.store.CreateTaskItem(id, payload.Text); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeTask(w, http.StatusCreated, id)
+}
+
+// handleUpdateTaskItem godoc
+//
+//	@Id				updateTaskItem
+//	@Summary		Edit or tick off a checklist item
+//	@Description	A full replace of the item's text and done state. Ticking off the last outstanding item completes the task. Unticking an item of a completed task leaves the task complete. Returns the task so the caller sees whether it completed.
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"Task ID"
+//	@Param			itemId	path		int							true	"Item ID"
+//	@Param			item	body		types.UpdateTaskItemPayload	true	"Updated item"
+//	@Success		200		{object}	types.TaskWithSubject
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		404		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/items/{itemId} [put]
+func (h *Handler) handleUpdateTaskItem(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	itemId, err := utils.ParseIDParam(r, "itemId")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.UpdateTaskItemPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	wasComplete := !payload.Done || h.taskComplete(id)
+
+	if err := h.store.UpdateTaskItem(id, itemId, payload.Text, payload.Done, userID); err != nil {
+		writeTaskItemError(w, err)
+		return
+	}
+
+	h.publishIfCompleted(userID, id, wasComplete)
+
+	h.writeTask(w, http.StatusOK, id)
+}
+
+// handleDeleteTaskItem godoc
+//
+//	@Id				deleteTaskItem
+//	@Summary		Remove a checklist item
+//	@Description	If every remaining item is done, removing this one completes the task.
+//	@Tags			tasks
+//	@Produce		json
+//	@Param			id		path	int	true	"Task ID"
+//	@Param			itemId	path	int	true	"Item ID"
+//	@Success		204
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		404	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/items/{itemId} [delete]
+func (h *Handler) handleDeleteTaskItem(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	itemId, err := utils.ParseIDParam(r, "itemId")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	wasComplete := h.taskComplete(id)
+
+	if err := h.store.DeleteTaskItem(id, itemId, userID); err != nil {
+		writeTaskItemError(w, err)
+		return
+	}
+
+	h.publishIfCompleted(userID, id, wasComplete)
+
+	utils.WriteStatus(w, http.StatusNoContent)
+}
+
+// handleReorderTaskItems godoc
+//
+//	@Id				reorderTaskItems
+//	@Summary		Reorder a task's checklist
+//	@Description	List every item of the task exactly once, in the new order.
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int								true	"Task ID"
+//	@Param			order	body		types.ReorderTaskItemsPayload	true	"Item IDs in their new order"
+//	@Success		200		{object}	types.TaskWithSubject
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/items/order [put]
+func (h *Handler) handleReorderTaskItems(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	var payload types.ReorderTaskItemsPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	items, err := h.store.GetTaskItems(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := checkItemOrder(items, payload.ItemIds); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.ReorderTaskItems(id, payload.ItemIds); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeTask(w, http.StatusOK, id)
+}
+
+// writeTask responds with the task as the single-task route would show it.
+func (h *Handler) writeTask(w http.ResponseWriter, status int, id int) {
+	task, err := h.store.GetTaskWithSubjectById(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	annotateDueState(task, time.Now())
+
+	utils.WriteJSON(w, status, task)
+}
+
+func writeTaskItemError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrTaskItemNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	utils.WriteError(w, http.StatusInternalServerError, err)
+}
+
+// checkItemOrder requires the new order to be a permutation of the current
+// items. A partial list would leave the missing items sharing positions with
+// the listed ones, so it is rejected rather than guessed at.
+func checkItemOrder(items []types.TaskItem, order []int) error {
+	if len(order) != len(items) {
+		return fmt.Errorf("list all %d items of the task, got %d", len(items), len(order))
+	}
+
+	current := make(map[int]bool, len(items))
+	for _, item := range items {
+		current[item.ItemId] = true
+	}
+
+	seen := make(map[int]bool, len(order))
+	for _, itemId := range order {
+		if !current[itemId] {
+			return fmt.Errorf("item %d is not on this task", itemId)
+		}
+		if seen[itemId] {
+			return fmt.Errorf("item %d is listed more than once", itemId)
+		}
+		seen[itemId] = true
+	}
+
+	return nil
+}
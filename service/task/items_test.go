@@ -0,0 +1,37 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// A reorder must name every item exactly once; anything else would leave
+// items sharing a position or quietly dropped from the order.
+func TestCheckItemOrder(t *testing.T) {
+	items := []types.TaskItem{{ItemId: 4}, {ItemId: 5}, {ItemId: 6}}
+
+	cases := []struct {
+		name    string
+		order   []int
+		wantErr bool
+	}{
+		{"a permutation", []int{6, 4, 5}, false},
+		{"the same order", []int{4, 5, 6}, false},
+		{"an item missing", []int{4, 5}, true},
+		{"an item repeated", []int{4, 4, 5}, true},
+		{"another task's item", []int{4, 5, 9}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkItemOrder(items, tc.order)
+			if tc.wantErr && err == nil {
+				t.Error("expected an error, got none")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package task
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// maxOccurrenceWindow is a quarter, which covers a month view padded out to
+// whole weeks with room to spare. An hourly task projects about 2,200
+// occurrences over it.
+const maxOccurrenceWindow = 93 * 24 * time.Hour
+
+// maxOccurrenceSteps bounds each walk through a task's occurrences, comfortably
+// above what an hourly task needs over maxOccurrenceWindow. Walks jump to the
+// window before they step through it, so only a schedule that stops moving
+// forward could reach it.
+const maxOccurrenceSteps = 5000
+
+// handleListOccurrences godoc
+//
+//	@Id				listTaskOccurrences
+//	@Summary		List the caller's task occurrences over a window
+//	@Description	Combines what happened with what is projected. Recorded completions are done and recorded skips are skipped; history starts from when completions were first recorded. An occurrence that passed without being done is missed, including the current one once it is past due and each one a late completion ran past. The next occurrence of a task completed since it was last announced stays upcoming until it is announced. Occurrences from now on are projected from each task's due time, by its interval, its calendar-day reset time in the caller's timezone, or its animal's age steps, and are upcoming. A paused task projects nothing until its pause ends, and nothing at all under a pause with no end.
+//	@Tags			tasks
+//	@Produce		json
+//	@Param			from	query		string	true	"Start of the window, RFC 3339"	format(date-time)
+//	@Param			to		query		string	true	"End of the window, RFC 3339; at most 93 days after from"	format(date-time)
+//	@Success		200		{object}	types.TaskOccurrencesResponse
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/occurrences [get]
+func (h *Handler) handleListOccurrences(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	from, to, err := parseOccurrenceWindow(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tasks, err := h.store.GetTasksWithSubjectByUserId(userID, types.TaskFilter{})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	records, err := h.store.GetRecordedOccurrences(userID, from, to)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	u, err := h.userStore.GetUserById(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	dobs, err := h.animalDobs(userID, tasks)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	recordsByTask := make(map[int][]types.RecordedOccurrence)
+	for _, record := range records {
+		recordsByTask[record.TaskId] = append(recordsByTask[record.TaskId], record)
+	}
+
+	now := time.Now()
+	occurrences := make([]types.TaskOccurrence, 0)
+	for _, task := range tasks {
+		var dob time.Time
+		if task.AnimalId != nil {
+			dob = dobs[*task.AnimalId]
+		}
+
+		rule := occurrenceRuleFor(task, dob, u.Location())
+		occurrences = append(occurrences, taskOccurrences(task, recordsByTask[task.TaskId], rule, from, to, now)...)
+	}
+
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		if !occurrences[i].DueAt.Equal(occurrences[j].DueAt) {
+			return occurrences[i].DueAt.Before(occurrences[j].DueAt)
+		}
+		return occurrences[i].TaskId < occurrences[j].TaskId
+	})
+
+	utils.WriteJSON(w, http.StatusOK, types.TaskOccurrencesResponse{
+		From:        from,
+		To:          to,
+		Occurrences: occurrences,
+	})
+}
+
+// animalDobs looks up the dates of birth that stepped tasks need, and only
+// when there are any.
+func (h *Handler) animalDobs(userID int, tasks []*types.TaskWithSubject) (map[int]time.Time, error) {
+	dobs := make(map[int]time.Time)
+
+	stepped := false
+	for _, task := range tasks {
+		if len(task.IntervalSteps) > 0 {
+			stepped = true
+			break
+		}
+	}
+	if !stepped {
+		return dobs, nil
+	}
+
+	animals, err := h.animalStore.GetAnimalsByUserId(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, animal := range animals {
+		dobs[animal.AnimalId] = animal.Dob
+	}
+
+	return dobs, nil
+}
+
+func parseOccurrenceWindow(r *http.Request) (time.Time, time.Time, error) {
+	from, err := optionalTimeQuery(r, "from")
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	to, err := optionalTimeQuery(r, "to")
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	switch {
+	case from == nil || to == nil:
+		return time.Time{}, time.Time{}, fmt.Errorf("from and to are required")
+	case !to.After(*from):
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be after from")
+	case to.Sub(*from) > maxOccurrenceWindow:
+		return time.Time{}, time.Time{}, fmt.Errorf("the window can be at most 93 days")
+	}
+
+	return *from, *to, nil
+}
+
+// occurrenceRule is how a task steps from one occurrence to the next,
+// assuming each is done when it falls due.
+type occurrenceRule struct {
+	// next is the occurrence after due.
+	next func(due time.Time) time.Time
+	// lastBefore is the last occurrence before t in the run starting at due,
+	// or due itself if the next one is not before t. It jumps whole intervals
+	// at a time, so a window far from the due time costs no more than a near
+	// one.
+	lastBefore func(due time.Time, t time.Time) time.Time
+}
+
+// occurrenceRuleFor applies the same rule the database applies on
+// completion, by hours or by calendar day, with the interval for the animal's
+// age at the time.
+func occurrenceRuleFor(task *types.TaskWithSubject, dob time.Time, loc *time.Location) occurrenceRule {
+	hours := func(at time.Time) int {
+		return steppedInterval(dob, task.IntervalSteps, at, task.RepeatIntervHours)
+	}
+
+	// advance is n occurrences on from due, all at the interval in force at
+	// due.
+	advance := func(due time.Time, n int) time.Time {
+		return due.Add(time.Duration(n*hours(due)) * time.Hour)
+	}
+
+	if task.ResetTime != nil {
+		resetTime := *task.ResetTime
+		advance = func(due time.Time, n int) time.Time {
+			next, err := calendarDue(due, n*hours(due), resetTime, loc)
+			if err != nil {
+				// The reset time was validated when it was stored, so this
+				// cannot happen; falling back to whole days keeps the
+				// projection going if it ever does.
+				return due.AddDate(0, 0, n*hours(due)/24)
+			}
+			return next
+		}
+	}
+
+	next := func(due time.Time) time.Time {
+		return advance(due, 1)
+	}
+
+	lastBefore := func(due time.Time, t time.Time) time.Time {
+		for i := 0; i < maxOccurrenceSteps; i++ {
+			interval := time.Duration(hours(due)) * time.Hour
+			if interval <= 0 {
+				return due
+			}
+
+			// The interval holds until the animal moves on to its next step.
+			// One interval is left over, since calendar days around a DST
+			// change are an hour short of or over 24 hours.
+			limit := t
+			if shift := nextIntervalShift(dob, task.IntervalSteps, due); shift != nil && shift.At.Before(limit) {
+				limit = shift.At
+			}
+			if n := int(limit.Sub(due)/interval) - 1; n > 0 {
+				due = advance(due, n)
+				continue
+			}
+
+			following := next(due)
+			if !following.Before(t) || !following.After(due) {
+				return due
+			}
+			due = following
+		}
+
+		return due
+	}
+
+	return occurrenceRule{next: next, lastBefore: lastBefore}
+}
+
+// steppedInterval is the interval for an animal's age at a moment, matching
+// "taskInterval" in the database. A task without steps, or an animal without
+// a dob, keeps its fixed interval.
+func steppedInterval(dob time.Time, steps []types.IntervalStep, at time.Time, fixed int) int {
+	age := utils.AgeInMonths(dob, at.UTC())
+	if age == nil || len(steps) == 0 {
+		return fixed
+	}
+
+	for _, step := range steps {
+		if step.UntilAgeMonths == nil || *age < *step.UntilAgeMonths {
+			return step.IntervalHours
+		}
+	}
+
+	return fixed
+}
+
+// taskOccurrences lists one task's occurrences due in [from, to).
+//
+// The history comes first: each record is done or skipped, and a completion
+// that came after the next occurrence was due also accounts for the
+// occurrences it ran past, which are missed. Then the task is projected
+// forward from its current due time. That occurrence is outstanding unless the
+// task is complete; it and any that follow it before now are missed, unless a
+// snooze is holding the current one back. A complete task the sweep has not
+// reset yet has been done since it was last announced, so the occurrence it
+// is about to announce is upcoming rather than missed. Everything from now on
+// is upcoming.
+func taskOccurrences(task *types.TaskWithSubject, records []types.RecordedOccurrence, rule occurrenceRule, from time.Time, to time.Time, now time.Time) []types.TaskOccurrence {
+	occurrences := make([]types.TaskOccurrence, 0)
+	emit := func(due time.Time, status string, completedAt *time.Time, completedBy *int) {
+		if due.Before(from) || !due.Before(to) {
+			return
+		}
+
+		occurrences = append(occurrences, types.TaskOccurrence{
+			TaskId:      task.TaskId,
+			TaskName:    task.TaskName,
+			AnimalId:    task.AnimalId,
+			EnclosureId: task.EnclosureId,
+			DueAt:       due,
+			Status:      status,
+			CompletedAt: completedAt,
+			CompletedBy: completedBy,
+		})
+	}
+
+	for _, record := range records {
+		if record.Outcome == "skipped" {
+			emit(record.DueAt, "skipped", nil, nil)
+			continue
+		}
+
+		emit(record.DueAt, "done", record.CompletedAt, record.CompletedBy)
+
+		if record.CompletedAt == nil {
+			continue
+		}
+
+		due := record.DueAt
+		if due.Before(from) {
+			due = rule.lastBefore(due, from)
+		}
+		for i := 0; i < maxOccurrenceSteps; i++ {
+			following := rule.next(due)
+			if !following.After(due) || !following.Before(*record.CompletedAt) || !following.Before(to) {
+				break
+			}
+
+			due = following
+			emit(due, "missed", nil, nil)
+		}
+	}
+
+	// A pause with no end holds everything back indefinitely. One with an end
+	// resumes the task then, as ResumeEndedPauses will.
+	due := task.DueAt
+	if task.Pause != nil {
+		if task.Pause.EndsAt == nil {
+			return occurrences
+		}
+		if due.Before(*task.Pause.EndsAt) {
+			due = *task.Pause.EndsAt
+		}
+	}
+
+	switch {
+	case !task.Complete:
+		status := "missed"
+		if task.Pause != nil || !EffectiveDue(task).Before(now) {
+			status = "upcoming"
+		}
+
+		emit(due, status, nil, nil)
+		due = rule.next(due)
+	case due.Before(now):
+		emit(due, "upcoming", nil, nil)
+		due = rule.next(due)
+	}
+
+	if due.Before(from) {
+		due = rule.next(rule.lastBefore(due, from))
+	}
+
+	for i := 0; i < maxOccurrenceSteps && due.Before(to); i++ {
+		status := "upcoming"
+		if due.Before(now) {
+			status = "missed"
+		}
+
+		emit(due, status, nil, nil)
+
+		following := rule.next(due)
+		if !following.After(due) {
+			break
+		}
+		due = following
+	}
+
+	return occurrences
+}
@@ -0,0 +1,222 @@
+package task
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestParseOccurrenceWindow(t *testing.T) {
+	cases := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{"a week", "from=2026-03-01T00:00:00Z&to=2026-03-08T00:00:00Z", false},
+		{"missing to", "from=2026-03-01T00:00:00Z", true},
+		{"backwards", "from=2026-03-08T00:00:00Z&to=2026-03-01T00:00:00Z", true},
+		{"too long", "from=2026-01-01T00:00:00Z&to=2026-06-01T00:00:00Z", true},
+		{"not a time", "from=monday&to=2026-03-08T00:00:00Z", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/tasks/occurrences?"+tc.query, nil)
+
+			_, _, err := parseOccurrenceWindow(r)
+			if tc.wantErr && err == nil {
+				t.Error("expected an error")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSteppedInterval(t *testing.T) {
+	dob := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	if got := steppedInterval(dob, juvenileSteps, time.Date(2026, 7, 14, 0, 0, 0, 0, time.UTC), 1); got != 24 {
+		t.Errorf("got %d just under six months, want 24", got)
+	}
+	if got := steppedInterval(dob, juvenileSteps, time.Date(2026, 7, 15, 0, 0, 0, 0, time.UTC), 1); got != 72 {
+		t.Errorf("got %d at six months, want 72", got)
+	}
+	if got := steppedInterval(time.Time{}, juvenileSteps, scheduleNow, 48); got != 48 {
+		t.Errorf("got %d without a dob, want the fixed 48", got)
+	}
+}
+
+// statuses lists the occurrences as hours from scheduleNow and their status,
+// which reads more easily in a failure than whole timestamps.
+func statuses(occurrences []types.TaskOccurrence) map[int]string {
+	got := make(map[int]string, len(occurrences))
+	for _, o := range occurrences {
+		got[int(o.DueAt.Sub(scheduleNow).Hours())] = o.Status
+	}
+	return got
+}
+
+func TestTaskOccurrences(t *testing.T) {
+	hoursFromNow := func(h int) time.Time {
+		return scheduleNow.Add(time.Duration(h) * time.Hour)
+	}
+	daily := occurrenceRuleFor(&types.TaskWithSubject{RepeatIntervHours: 24}, time.Time{}, time.UTC)
+	from, to := hoursFromNow(-96), hoursFromNow(72)
+
+	t.Run("history and projection", func(t *testing.T) {
+		lateCompletion := hoursFromNow(-10)
+		onTime := hoursFromNow(-95)
+		records := []types.RecordedOccurrence{
+			{DueAt: hoursFromNow(-96), CompletedAt: &onTime, IntervalHours: 24, Outcome: "completed"},
+			{DueAt: hoursFromNow(-72), IntervalHours: 24, Outcome: "skipped"},
+			// Done two days late: the two occurrences after it were missed.
+			{DueAt: hoursFromNow(-60), CompletedAt: &lateCompletion, IntervalHours: 24, Outcome: "completed"},
+		}
+		task := &types.TaskWithSubject{TaskId: 1, Complete: true, DueAt: hoursFromNow(14)}
+
+		got := statuses(taskOccurrences(task, records, daily, from, to, scheduleNow))
+		want := map[int]string{-96: "done", -72: "skipped", -60: "done", -36: "missed", -12: "missed", 14: "upcoming", 38: "upcoming", 62: "upcoming"}
+
+		assertStatuses(t, got, want)
+	})
+
+	t.Run("outstanding past due", func(t *testing.T) {
+		task := &types.TaskWithSubject{TaskId: 1, DueAt: hoursFromNow(-30)}
+
+		got := statuses(taskOccurrences(task, nil, daily, from, to, scheduleNow))
+		want := map[int]string{-30: "missed", -6: "missed", 18: "upcoming", 42: "upcoming", 66: "upcoming"}
+
+		assertStatuses(t, got, want)
+	})
+
+	t.Run("snoozed is not missed yet", func(t *testing.T) {
+		snoozed := hoursFromNow(2)
+		task := &types.TaskWithSubject{TaskId: 1, DueAt: hoursFromNow(-3), SnoozedUntil: &snoozed}
+
+		got := statuses(taskOccurrences(task, nil, daily, from, to, scheduleNow))
+		if got[-3] != "upcoming" {
+			t.Errorf("got %q for the snoozed occurrence, want upcoming", got[-3])
+		}
+	})
+
+	t.Run("completed but not reset yet", func(t *testing.T) {
+		task := &types.TaskWithSubject{TaskId: 1, Complete: true, DueAt: hoursFromNow(-2)}
+
+		got := statuses(taskOccurrences(task, nil, daily, from, to, scheduleNow))
+		assertStatuses(t, got, map[int]string{-2: "upcoming", 22: "upcoming", 46: "upcoming", 70: "upcoming"})
+	})
+
+	t.Run("window far ahead", func(t *testing.T) {
+		hourly := occurrenceRuleFor(&types.TaskWithSubject{RepeatIntervHours: 1}, time.Time{}, time.UTC)
+		task := &types.TaskWithSubject{TaskId: 1, Complete: true, DueAt: scheduleNow.Add(30 * time.Minute)}
+		farFrom := scheduleNow.AddDate(2, 0, 0)
+
+		got := taskOccurrences(task, nil, hourly, farFrom, farFrom.Add(24*time.Hour), scheduleNow)
+		if len(got) != 24 {
+			t.Fatalf("got %d occurrences over a day, want 24", len(got))
+		}
+		if want := farFrom.Add(30 * time.Minute); !got[0].DueAt.Equal(want) {
+			t.Errorf("first occurrence at %v, want %v", got[0].DueAt, want)
+		}
+	})
+
+	t.Run("paused until a date", func(t *testing.T) {
+		ends := hoursFromNow(48)
+		task := &types.TaskWithSubject{TaskId: 1, Complete: true, DueAt: hoursFromNow(-5), Pause: &types.CarePause{EndsAt: &ends}}
+
+		got := statuses(taskOccurrences(task, nil, daily, from, to, scheduleNow))
+		assertStatuses(t, got, map[int]string{48: "upcoming"})
+	})
+
+	t.Run("paused indefinitely keeps its history", func(t *testing.T) {
+		records := []types.RecordedOccurrence{{DueAt: hoursFromNow(-72), IntervalHours: 24, Outcome: "skipped"}}
+		task := &types.TaskWithSubject{TaskId: 1, DueAt: hoursFromNow(-5), Pause: &types.CarePause{}}
+
+		got := statuses(taskOccurrences(task, records, daily, from, to, scheduleNow))
+		assertStatuses(t, got, map[int]string{-72: "skipped"})
+	})
+}
+
+func assertStatuses(t *testing.T, got map[int]string, want map[int]string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+		return
+	}
+	for hours, status := range want {
+		if got[hours] != status {
+			t.Errorf("got %v, want %v", got, want)
+			return
+		}
+	}
+}
+
+func TestOccurrenceRuleCalendarDays(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reset := "06:00"
+	task := &types.TaskWithSubject{RepeatIntervHours: 24, ResetTime: &reset}
+	rule := occurrenceRuleFor(task, time.Time{}, newYork)
+
+	// Stepping across the spring-forward change keeps 06:00 local.
+	due := time.Date(2026, 3, 7, 6, 0, 0, 0, newYork)
+	for i := 0; i < 3; i++ {
+		due = rule.next(due)
+		if local := due.In(newYork); local.Hour() != 6 || local.Minute() != 0 {
+			t.Errorf("got %v, want 06:00 local", local)
+		}
+	}
+
+	if want := time.Date(2026, 3, 10, 6, 0, 0, 0, newYork); !due.Equal(want) {
+		t.Errorf("got %v after three days, want %v", due.In(newYork), want)
+	}
+
+	// The occurrences a late completion ran past keep the reset time too.
+	start := time.Date(2026, 3, 7, 6, 0, 0, 0, newYork)
+	completed := time.Date(2026, 3, 10, 12, 0, 0, 0, newYork)
+	records := []types.RecordedOccurrence{{DueAt: start, CompletedAt: &completed, IntervalHours: 24, Outcome: "completed"}}
+	task.Complete = true
+	task.DueAt = time.Date(2026, 3, 11, 6, 0, 0, 0, newYork)
+
+	occurrences := taskOccurrences(task, records, rule, start, start.AddDate(0, 0, 3), completed)
+	for _, o := range occurrences {
+		if local := o.DueAt.In(newYork); local.Hour() != 6 {
+			t.Errorf("%s occurrence at %v, want 06:00 local", o.Status, local)
+		}
+	}
+	if len(occurrences) != 3 {
+		t.Errorf("got %d occurrences, want the completion and the two it ran past", len(occurrences))
+	}
+}
+
+// Jumping to a window must land on the same occurrence as stepping to it one
+// at a time, including across the animal's interval steps.
+func TestOccurrenceRuleLastBefore(t *testing.T) {
+	dob := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+	task := &types.TaskWithSubject{RepeatIntervHours: 24, IntervalSteps: juvenileSteps}
+	rule := occurrenceRuleFor(task, dob, time.UTC)
+
+	start := time.Date(2026, 1, 20, 9, 0, 0, 0, time.UTC)
+	for _, before := range []time.Time{
+		start.Add(time.Hour),
+		time.Date(2026, 7, 20, 0, 0, 0, 0, time.UTC),
+		time.Date(2027, 11, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		want := start
+		for next := rule.next(want); next.Before(before); next = rule.next(want) {
+			want = next
+		}
+
+		if got := rule.lastBefore(start, before); !got.Equal(want) {
+			t.Errorf("lastBefore(%v) = %v, want %v", before, got, want)
+		}
+	}
+}
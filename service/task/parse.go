@@ -0,0 +1,285 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/llm"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// maxProposals bounds what one piece of text can turn into. A sentence that
+// reads as more tasks than this is more likely a misreading than a request.
+const maxProposals = 10
+
+// Each call sends the user's text to a paid model, so one user can make
+// parseBurst calls in quick succession and then one every parseInterval.
+const (
+	parseBurst    = 5
+	parseInterval = 12 * time.Second
+)
+
+// errParseFailed is all the caller hears when the model fails. What went wrong
+// upstream is logged instead, since it can carry the provider's own messages.
+var errParseFailed = errors.New("could not read tasks from that text right now, try again later")
+
+const parseTasksSystemPrompt = `You turn a pet owner's description of care tasks into JSON. Return ONLY a JSON object of the form {"tasks": [...]}, one entry per task described, each with these fields:
+- taskName: a short name, e.g. "Feed"
+- taskDesc: one sentence with the details, e.g. "Feed a medium rat"
+- animalId: the ID of the animal the task is for, from the list given, or null
+- enclosureId: the ID of the enclosure the task is for, from the list given, or null
+- repeatIntervHours: how often it repeats, in hours; every 10 days is 240, weekly is 168
+- resetTime: the local time of day it is due, as "HH:MM" in 24-hour time, or null if none was given; only when repeatIntervHours is a multiple of 24
+- priority: "low", "normal" or "high"
+- tags: a few short lower-case words, e.g. ["feeding"]
+
+Set exactly one of animalId and enclosureId when the text names one of the listed animals or enclosures, and neither when it names none of them. Never invent an ID.`
+
+// handleParseTasks godoc
+//
+//	@Id				parseTasks
+//	@Summary		Read tasks from free text
+//	@Description	Turns a description such as "feed Rex a medium rat every 10 days at 7pm" into proposed tasks, matched against the caller's animals and enclosures. Nothing is created: each proposal is a body for POST /tasks, to be confirmed by the user first. A proposal with problems needs them fixed before it will be accepted. Returns 503 when no language model is configured, and 429, with Retry-After in seconds, to a caller making more than a few requests a minute.
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			text	body		types.ParseTasksPayload	true	"What to do, in the user's words"
+//	@Success		200		{object}	types.ParseTasksResponse
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		429		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Failure		502		{object}	types.ErrorResponse
+//	@Failure		503		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/parse [post]
+func (h *Handler) handleParseTasks(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	if h.chat == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("task parsing is not configured"))
+		return
+	}
+
+	var payload types.ParseTasksPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	if wait := h.parseLimiter.take(userID); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		utils.WriteError(w, http.StatusTooManyRequests, fmt.Errorf("too many requests, try again shortly"))
+		return
+	}
+
+	animals, err := h.animalStore.GetAnimalsByUserId(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	enclosures, err := h.enclosureStore.GetEnclosuresByUserId(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	proposals, err := proposeTasks(r.Context(), h.chat, payload.Text, animals, enclosures)
+	if err != nil {
+		log.Printf("failed to parse tasks for user %d: %v", userID, err)
+		utils.WriteError(w, http.StatusBadGateway, errParseFailed)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.ParseTasksResponse{Proposals: proposals})
+}
+
+// proposeTasks asks the model to read tasks from text and checks what comes
+// back. The model only ever suggests: a subject ID it returns is kept only if
+// it is one of the user's own, and every proposal is validated as POST /tasks
+// would validate it.
+func proposeTasks(ctx context.Context, chat llm.ChatClient, text string, animals []*types.Animal, enclosures []*types.Enclosure) ([]types.TaskProposal, error) {
+	reply, err := chat.Chat(ctx, parseTasksSystemPrompt, parseTasksUserPrompt(text, animals, enclosures), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tasks: %w", err)
+	}
+
+	var parsed struct {
+		Tasks []types.CreateTaskV2Payload `json:"tasks"`
+	}
+	if err := json.Unmarshal([]byte(reply), &parsed); err != nil {
+		return nil, fmt.Errorf("failed to read tasks: the model's reply was not valid JSON")
+	}
+
+	if len(parsed.Tasks) > maxProposals {
+		parsed.Tasks = parsed.Tasks[:maxProposals]
+	}
+
+	animalNames := make(map[int]string, len(animals))
+	for _, animal := range animals {
+		animalNames[animal.AnimalId] = animal.AnimalName
+	}
+	enclosureNames := make(map[int]string, len(enclosures))
+	for _, enclosure := range enclosures {
+		enclosureNames[enclosure.EnclosureId] = enclosure.EnclosureName
+	}
+
+	proposals := make([]types.TaskProposal, 0, len(parsed.Tasks))
+	for _, task := range parsed.Tasks {
+		proposals = append(proposals, checkProposal(task, text, animalNames, enclosureNames))
+	}
+
+	return proposals, nil
+}
+
+func parseTasksUserPrompt(text string, animals []*types.Animal, enclosures []*types.Enclosure) string {
+	var b strings.Builder
+
+	b.WriteString("Animals:\n")
+	if len(animals) == 0 {
+		b.WriteString("(none)\n")
+	}
+	for _, animal := range animals {
+		fmt.Fprintf(&b, "- id %d: %s\n", animal.AnimalId, animal.AnimalName)
+	}
+
+	b.WriteString("\nEnclosures:\n")
+	if len(enclosures) == 0 {
+		b.WriteString("(none)\n")
+	}
+	for _, enclosure := range enclosures {
+		fmt.Fprintf(&b, "- id %d: %s\n", enclosure.EnclosureId, enclosure.EnclosureName)
+	}
+
+	fmt.Fprintf(&b, "\nTasks to read:\n%s", text)
+
+	return b.String()
+}
+
+// checkProposal tidies one task the model proposed and lists what is wrong
+// with it. Problems are reported rather than fixed by guessing, since the user
+// confirms each proposal anyway.
+func checkProposal(task types.CreateTaskV2Payload, text string, animalNames map[int]string, enclosureNames map[int]string) types.TaskProposal {
+	proposal := types.TaskProposal{Problems: make([]string, 0)}
+
+	if task.AnimalId != nil {
+		if name, ok := animalNames[*task.AnimalId]; ok {
+			proposal.SubjectName = name
+		} else {
+			task.AnimalId = nil
+		}
+	}
+	if task.EnclosureId != nil {
+		if name, ok := enclosureNames[*task.EnclosureId]; ok && task.AnimalId == nil {
+			proposal.SubjectName = name
+		} else {
+			task.EnclosureId = nil
+		}
+	}
+	if task.AnimalId == nil && task.EnclosureId == nil {
+		proposal.Problems = append(proposal.Problems, "no matching animal or enclosure: choose one")
+	}
+
+	task.TaskName = strings.TrimSpace(task.TaskName)
+	task.TaskDesc = strings.TrimSpace(task.TaskDesc)
+	if task.TaskDesc == "" {
+		task.TaskDesc = strings.TrimSpace(text)
+	}
+	if task.Priority == "" {
+		task.Priority = "normal"
+	}
+	task.Tags = normalizeTags(task.Tags)
+
+	if err := utils.Validate.Struct(task); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, fieldErr := range validationErrors {
+				proposal.Problems = append(proposal.Problems, fmt.Sprintf("%s is missing or invalid", fieldErr.Field()))
+			}
+		} else {
+			proposal.Problems = append(proposal.Problems, err.Error())
+		}
+	}
+
+	if err := checkResetTime(task.ResetTime, task.RepeatIntervHours); err != nil {
+		proposal.Problems = append(proposal.Problems, err.Error())
+	}
+
+	proposal.Task = task
+
+	return proposal
+}
+
+// userLimiter is a token bucket per user: each allows burst calls at once and
+// then one every interval.
+type userLimiter struct {
+	burst    float64
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	buckets map[int]*userBucket
+}
+
+type userBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newUserLimiter(burst int, interval time.Duration) *userLimiter {
+	return &userLimiter{burst: float64(burst), interval: interval, now: time.Now, buckets: make(map[int]*userBucket)}
+}
+
+// take spends one of userID's calls, returning 0 if there was one to spend,
+// or else how long until there is.
+func (l *userLimiter) take(userID int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[userID]
+	if !ok {
+		l.forgetIdle(now)
+		b = &userBucket{tokens: l.burst, last: now}
+		l.buckets[userID] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+float64(now.Sub(b.last))/float64(l.interval))
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) * float64(l.interval))
+	}
+
+	b.tokens--
+	return 0
+}
+
+// forgetIdle drops the buckets that have refilled, which are no different
+// from a new one, so users who stopped calling are not kept forever.
+func (l *userLimiter) forgetIdle(now time.Time) {
+	full := time.Duration(l.burst * float64(l.interval))
+	for userID, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, userID)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/service/llm"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+var (
+	parseAnimals    = []*types.Animal{{AnimalId: 3, AnimalName: "Rex"}, {AnimalId: 4, AnimalName: "Nova"}}
+	parseEnclosures = []*types.Enclosure{{EnclosureId: 9, EnclosureName: "Rack A"}}
+)
+
+func TestProposeTasks(t *testing.T) {
+	fake := &llm.Fake{Reply: `{"tasks": [{
+		"taskName": "Feed",
+		"taskDesc": "Feed a medium rat",
+		"animalId": 3,
+		"enclosureId": null,
+		"repeatIntervHours": 240,
+		"resetTime": "19:00",
+		"priority": "normal",
+		"tags": ["Feeding", "feeding "]
+	}]}`}
+
+	proposals, err := proposeTasks(context.Background(), fake, "feed Rex a medium rat every 10 days at 7pm", parseAnimals, parseEnclosures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The model can only pick subjects it was told about.
+	prompt := fake.Prompts()[0]
+	for _, want := range []string{"id 3: Rex", "id 4: Nova", "id 9: Rack A", "every 10 days at 7pm"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt does not contain %q:\n%s", want, prompt)
+		}
+	}
+
+	if len(proposals) != 1 {
+		t.Fatalf("got %d proposals, want 1", len(proposals))
+	}
+
+	got := proposals[0]
+	if len(got.Problems) != 0 {
+		t.Errorf("unexpected problems: %v", got.Problems)
+	}
+	if got.SubjectName != "Rex" || got.Task.AnimalId == nil || *got.Task.AnimalId != 3 {
+		t.Errorf("got subject %q (%v), want Rex (3)", got.SubjectName, got.Task.AnimalId)
+	}
+	if got.Task.RepeatIntervHours != 240 || got.Task.ResetTime == nil || *got.Task.ResetTime != "19:00" {
+		t.Errorf("got every %dh at %v, want every 240h at 19:00", got.Task.RepeatIntervHours, got.Task.ResetTime)
+	}
+	if len(got.Task.Tags) != 1 || got.Task.Tags[0] != "feeding" {
+		t.Errorf("got tags %v, want [feeding]", got.Task.Tags)
+	}
+}
+
+func TestProposeTasksFailures(t *testing.T) {
+	if _, err := proposeTasks(context.Background(), &llm.Fake{Err: errors.New("timeout")}, "feed Rex", parseAnimals, parseEnclosures); err == nil {
+		t.Error("expected an error when the model fails")
+	}
+
+	if _, err := proposeTasks(context.Background(), &llm.Fake{Reply: "Sure! Here are your tasks:"}, "feed Rex", parseAnimals, parseEnclosures); err == nil {
+		t.Error("expected an error for a reply that is not JSON")
+	}
+}
+
+func TestCheckProposal(t *testing.T) {
+	animalNames := map[int]string{3: "Rex"}
+	enclosureNames := map[int]string{9: "Rack A"}
+	id := func(n int) *int { return &n }
+	reset := "07:00"
+
+	cases := []struct {
+		name        string
+		task        types.CreateTaskV2Payload
+		wantSubject string
+		wantProblem bool
+	}{
+		{"matched enclosure", types.CreateTaskV2Payload{TaskName: "Mist", TaskDesc: "Mist", RepeatIntervHours: 24, EnclosureId: id(9)}, "Rack A", false},
+		// Someone else's animal, or one the model made up, is dropped.
+		{"unknown animal", types.CreateTaskV2Payload{TaskName: "Feed", TaskDesc: "Feed", RepeatIntervHours: 24, AnimalId: id(77)}, "", true},
+		{"both subjects keeps the animal", types.CreateTaskV2Payload{TaskName: "Feed", TaskDesc: "Feed", RepeatIntervHours: 24, AnimalId: id(3), EnclosureId: id(9)}, "Rex", false},
+		{"no interval", types.CreateTaskV2Payload{TaskName: "Feed", TaskDesc: "Feed", AnimalId: id(3)}, "Rex", true},
+		{"reset time on part days", types.CreateTaskV2Payload{TaskName: "Feed", TaskDesc: "Feed", RepeatIntervHours: 36, AnimalId: id(3), ResetTime: &reset}, "Rex", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := checkProposal(tc.task, "text", animalNames, enclosureNames)
+
+			if got.SubjectName != tc.wantSubject {
+				t.Errorf("got subject %q, want %q", got.SubjectName, tc.wantSubject)
+			}
+			if (len(got.Problems) > 0) != tc.wantProblem {
+				t.Errorf("got problems %v, want problems: %v", got.Problems, tc.wantProblem)
+			}
+			if got.Task.AnimalId != nil && got.Task.EnclosureId != nil {
+				t.Error("a proposal must never carry two subjects")
+			}
+		})
+	}
+
+	// A missing description falls back to what the user typed.
+	got := checkProposal(types.CreateTaskV2Payload{TaskName: "Feed", RepeatIntervHours: 24, AnimalId: id(3)}, " feed Rex daily ", animalNames, enclosureNames)
+	if got.Task.TaskDesc != "feed Rex daily" {
+		t.Errorf("got description %q, want the original text", got.Task.TaskDesc)
+	}
+}
+
+func TestUserLimiter(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	limiter := newUserLimiter(2, 10*time.Second)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if wait := limiter.take(1); wait != 0 {
+			t.Fatalf("call %d of the burst had to wait %v", i+1, wait)
+		}
+	}
+	if wait := limiter.take(1); wait != 10*time.Second {
+		t.Errorf("got a wait of %v once the burst was spent, want 10s", wait)
+	}
+	if wait := limiter.take(2); wait != 0 {
+		t.Errorf("another user had to wait %v", wait)
+	}
+
+	now = now.Add(10 * time.Second)
+	if wait := limiter.take(1); wait != 0 {
+		t.Errorf("had to wait %v after a call refilled", wait)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/llm"
 	"github.com/whitallee/animal-family-backend/service/notification"
+	"github.com/whitallee/animal-family-backend/service/webhook"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
@@ -19,10 +21,20 @@ type Handler struct {
 	animalStore        types.AnimalStore
 	enclosureStore     types.EnclosureStore
 	notificationSender *notification.NotificationSender
+	// webhooks is told about changes to tasks, for users' integrations.
+	webhooks *webhook.Dispatcher
+	// chat reads tasks from free text. It is nil when no model is
+	// configured, and POST /tasks/parse then reports itself unavailable.
+	chat llm.ChatClient
+	// parseLimiter holds each user to a few model calls a minute.
+	parseLimiter *userLimiter
+	// actionSecret verifies the tokens notification actions carry. It is the
+	// secret the notification sender signs them with.
+	actionSecret []byte
 }
 
-func NewHandler(store types.TaskStore, userStore types.UserStore, animalStore types.AnimalStore, enclosureStore types.EnclosureStore, notificationSender *notification.NotificationSender) *Handler {
-	return &Handler{store: store, userStore: userStore, animalStore: animalStore, enclosureStore: enclosureStore, notificationSender: notificationSender}
+func NewHandler(store types.TaskStore, userStore types.UserStore, animalStore types.AnimalStore, enclosureStore types.EnclosureStore, notificationSender *notification.NotificationSender, webhooks *webhook.Dispatcher, chat llm.ChatClient, actionSecret []byte) *Handler {
+	return &Handler{store: store, userStore: userStore, animalStore: animalStore, enclosureStore: enclosureStore, notificationSender: notificationSender, webhooks: webhooks, chat: chat, parseLimiter: newUserLimiter(parseBurst, parseInterval), actionSecret: actionSecret}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
@@ -57,11 +69,23 @@ func (h *Handler) handleCheckTaskCompletion(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Send notifications asynchronously (non-blocking)
-	if len(resetTasks) > 0 && h.notificationSender != nil {
+	// Send notifications asynchronously (non-blocking). This runs even when
+	// nothing was reset, to release notifications held back by quiet hours or
+	// a digest.
+	if h.notificationSender != nil {
 		go h.notificationSender.SendTaskResetNotifications(resetTasks)
 	}
 
+	go h.publishResets(resetTasks)
+	go h.webhooks.RetryDue()
+
+	// A scheduler still pointed at v1 keeps escalations going too. The
+	// response shape is left as it was.
+	if _, err := h.escalateOverdueTasks(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	// Return immediately
 	utils.WriteJSON(w, http.StatusOK, map[string]int{
 		"tasksReset": len(resetTasks),
@@ -104,12 +128,12 @@ func (h *Handler) handleAdminCreateTask(w http.ResponseWriter, r *http.Request)
 	}
 
 	// if it doesn't exist, create new task
-	err = h.store.CreateTask(types.Task{
+	_, err = h.store.CreateTask(types.Task{
 		TaskName:          taskPayload.TaskName,
 		TaskDesc:          taskPayload.TaskDesc,
 		RepeatIntervHours: taskPayload.RepeatIntervHours,
 		LastCompleted:     time.Now(),
-	}, taskPayload.AnimalId, taskPayload.EnclosureId, taskPayload.UserId)
+	}, taskPayload.AnimalId, taskPayload.EnclosureId, taskPayload.UserId, types.TaskOptions{})
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
@@ -150,12 +174,12 @@ func (h *Handler) handleUserCreateTask(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// if it doesn't exist, create new task
-	err = h.store.CreateTask(types.Task{
+	_, err = h.store.CreateTask(types.Task{
 		TaskName:          taskPayload.TaskName,
 		TaskDesc:          taskPayload.TaskDesc,
 		RepeatIntervHours: taskPayload.RepeatIntervHours,
 		LastCompleted:     time.Now(),
-	}, taskPayload.AnimalId, taskPayload.EnclosureId, userId)
+	}, taskPayload.AnimalId, taskPayload.EnclosureId, userId, types.TaskOptions{})
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
@@ -368,7 +392,7 @@ func (h *Handler) handleAdminGetTasksByUser(w http.ResponseWriter, r *http.Reque
 	}
 
 	// get tasks
-	taskList, err := h.store.GetTasksWithSubjectByUserId(userIdPayload.UserID)
+	taskList, err := h.store.GetTasksWithSubjectByUserId(userIdPayload.UserID, types.TaskFilter{})
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -383,7 +407,7 @@ func (h *Handler) handleUserGetTasks(w http.ResponseWriter, r *http.Request) {
 	userId := auth.GetuserIdFromContext(r.Context())
 
 	// get tasks
-	taskList, err := h.store.GetTasksWithSubjectByUserId(userId)
+	taskList, err := h.store.GetTasksWithSubjectByUserId(userId, types.TaskFilter{})
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -1,13 +1,18 @@
 package task
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/webhook"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
@@ -29,16 +34,52 @@ func (h *Handler) RegisterV2Routes(router *mux.Router) {
 
 	router.HandleFunc("/tasks", auth.WithJWTAuth(h.handleListTasks, h.userStore)).Methods(http.MethodGet)
 	router.HandleFunc("/tasks", auth.WithJWTAuth(h.handleCreateTaskV2, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/tasks/bulk", auth.WithJWTAuth(h.handleBulkTasks, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/tasks/occurrences", auth.WithJWTAuth(h.handleListOccurrences, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/tasks/parse", auth.WithJWTAuth(h.handleParseTasks, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/tasks/backup-invites", auth.WithJWTAuth(h.handleListBackupInvites, h.userStore)).Methods(http.MethodGet)
 	router.HandleFunc("/tasks/{id}", owned(h.handleGetTask)).Methods(http.MethodGet)
 	router.HandleFunc("/tasks/{id}", owned(h.handleUpdateTaskV2)).Methods(http.MethodPut)
 	router.HandleFunc("/tasks/{id}", owned(h.handleDeleteTaskV2)).Methods(http.MethodDelete)
+
+	// Scheduling actions that move a task's due time without pretending it was
+	// completed.
+	router.HandleFunc("/tasks/{id}/snooze", owned(h.handleSnoozeTask)).Methods(http.MethodPost)
+	router.HandleFunc("/tasks/{id}/skip", owned(h.handleSkipTask)).Methods(http.MethodPost)
+	router.HandleFunc("/tasks/{id}/interval-steps", owned(h.handleSetIntervalSteps)).Methods(http.MethodPut)
+
+	// Completion with time tracking: either a timer started and finished, or a
+	// duration given on completion.
+	router.HandleFunc("/tasks/{id}/start", owned(h.handleStartTask)).Methods(http.MethodPost)
+	router.HandleFunc("/tasks/{id}/finish", owned(h.handleFinishTask)).Methods(http.MethodPost)
+	router.HandleFunc("/tasks/{id}/complete", owned(h.handleCompleteTask)).Methods(http.MethodPost)
+
+	// Checklist items. The order route is registered before {itemId} so
+	// "order" is not read as an item ID.
+	router.HandleFunc("/tasks/{id}/items", owned(h.handleListTaskItems)).Methods(http.MethodGet)
+	router.HandleFunc("/tasks/{id}/items", owned(h.handleCreateTaskItem)).Methods(http.MethodPost)
+	router.HandleFunc("/tasks/{id}/items/order", owned(h.handleReorderTaskItems)).Methods(http.MethodPut)
+	router.HandleFunc("/tasks/{id}/items/{itemId}", owned(h.handleUpdateTaskItem)).Methods(http.MethodPut)
+	router.HandleFunc("/tasks/{id}/items/{itemId}", owned(h.handleDeleteTaskItem)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/tasks/{id}/escalation", owned(h.handleGetTaskEscalation)).Methods(http.MethodGet)
+	router.HandleFunc("/tasks/{id}/escalation", owned(h.handleSetTaskEscalation)).Methods(http.MethodPut)
+
+	// Answered by the invited account, which does not own the task, so these
+	// check the invite rather than ownership.
+	router.HandleFunc("/tasks/{id}/backup", auth.WithJWTAuth(h.handleAcceptBackupInvite, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/tasks/{id}/backup", auth.WithJWTAuth(h.handleLeaveTaskBackup, h.userStore)).Methods(http.MethodDelete)
+
+	// Reached from a push notification's action button, where there is no
+	// session to send. The signed token in the query string stands in for it.
+	router.HandleFunc("/tasks/{id}/actions/complete", h.handleCompleteTaskAction).Methods(http.MethodPost)
 }
 
 // handleCheckTaskCompletionV2 godoc
 //
 //	@Id				checkTaskCompletion
 //	@Summary		Reset repeating tasks that are due
-//	@Description	Intended for a scheduler. Resets tasks whose repeat interval has elapsed and sends their notifications in the background.
+//	@Description	Intended for a scheduler. Resets completed tasks that have come due and sends their notifications in the background, as each owner's notification preferences allow, along with any held back earlier by quiet hours or a digest whose time has come. Snoozed tasks are held back until their snooze ends, and an overdue task whose snooze has just ended is notified about again. Then fires any escalation rules of tasks left undone. Also sends task.reset webhooks and any webhook retries that have come due.
 //	@Tags			tasks
 //	@Produce		json
 //	@Success		200	{object}	types.TaskCompletionResponse
@@ -51,12 +92,25 @@ func (h *Handler) handleCheckTaskCompletionV2(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if len(resetTasks) > 0 && h.notificationSender != nil {
+	// This runs even when nothing was reset, to release notifications held
+	// back by quiet hours or a digest.
+	if h.notificationSender != nil {
 		go h.notificationSender.SendTaskResetNotifications(resetTasks)
 	}
 
+	// Likewise webhook retries go out whether or not anything was reset.
+	go h.publishResets(resetTasks)
+	go h.webhooks.RetryDue()
+
+	escalated, err := h.escalateOverdueTasks()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	utils.WriteJSON(w, http.StatusOK, types.TaskCompletionResponse{
-		TasksReset: len(resetTasks),
+		TasksReset:     len(resetTasks),
+		TasksEscalated: escalated,
 	})
 }
 
@@ -64,11 +118,18 @@ func (h *Handler) handleCheckTaskCompletionV2(w http.ResponseWriter, r *http.Req
 //
 //	@Id				listTasks
 //	@Summary		List the caller's tasks
-//	@Description	Pass animalId or enclosureId to return only the tasks attached to that subject. Supplying both is rejected.
+//	@Description	Every filter is optional and they combine with AND. Pass animalId or enclosureId, not both, for the tasks attached to that subject. Repeat tag to require several tags, and priority to allow several priorities. The due bounds and overdue apply to the effective due time, which a snooze pushes back. Sort by due, priority (most urgent first), name or lastCompleted, with a leading "-" to reverse; the default is by task ID.
 //	@Tags			tasks
 //	@Produce		json
-//	@Param			animalId	query	int	false	"Only tasks attached to this animal"
-//	@Param			enclosureId	query	int	false	"Only tasks attached to this enclosure"
+//	@Param			animalId	query	int			false	"Only tasks attached to this animal"
+//	@Param			enclosureId	query	int			false	"Only tasks attached to this enclosure"
+//	@Param			tag			query	[]string	false	"Only tasks carrying this tag"	collectionFormat(multi)
+//	@Param			priority	query	[]string	false	"Only tasks with this priority"	collectionFormat(multi)	Enums(low, normal, high)
+//	@Param			complete	query	bool		false	"Only complete, or only incomplete, tasks"
+//	@Param			overdue		query	bool		false	"Only overdue, or only not overdue, tasks"
+//	@Param			dueBefore	query	string		false	"Only tasks due before this RFC 3339 time"	format(date-time)
+//	@Param			dueAfter	query	string		false	"Only tasks due at or after this RFC 3339 time"	format(date-time)
+//	@Param			sort		query	string		false	"Sort key"	Enums(due, -due, priority, -priority, name, -name, lastCompleted, -lastCompleted)
 //	@Success		200	{array}		types.TaskWithSubject
 //	@Failure		400	{object}	types.ErrorResponse
 //	@Failure		403	{object}	types.ErrorResponse
@@ -78,32 +139,34 @@ func (h *Handler) handleCheckTaskCompletionV2(w http.ResponseWriter, r *http.Req
 func (h *Handler) handleListTasks(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetuserIdFromContext(r.Context())
 
-	animalId, enclosureId, err := parseSubjectFilter(r)
+	filter, err := parseTaskFilter(r)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	// Always sourced from the caller's own tasks, so the filter narrows a set
-	// that is already owner-scoped and cannot expose anyone else's task.
-	tasks, err := h.store.GetTasksWithSubjectByUserId(userID)
+	// Always scoped to the caller's own tasks, so no filter can expose anyone
+	// else's task.
+	tasks, err := h.store.GetTasksWithSubjectByUserId(userID, filter)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, filterTasksBySubject(tasks, animalId, enclosureId))
+	annotateDueStates(tasks, time.Now())
+
+	utils.WriteJSON(w, http.StatusOK, tasks)
 }
 
 // parseSubjectFilter reads the optional animalId / enclosureId query
 // parameters that replace v1's /task/bysubject route.
 func parseSubjectFilter(r *http.Request) (animalId *int, enclosureId *int, err error) {
-	animalId, err = optionalPositiveQueryParam(r, "animalId")
+	animalId, err = utils.ParseOptionalIDQuery(r, "animalId")
 	if err != nil {
 		return nil, nil, err
 	}
 
-	enclosureId, err = optionalPositiveQueryParam(r, "enclosureId")
+	enclosureId, err = utils.ParseOptionalIDQuery(r, "enclosureId")
 	if err != nil {
 		return nil, nil, err
 	}
@@ -115,40 +178,97 @@ func parseSubjectFilter(r *http.Request) (animalId *int, enclosureId *int, err e
 	return animalId, enclosureId, nil
 }
 
-func optionalPositiveQueryParam(r *http.Request, name string) (*int, error) {
+// parseTaskFilter reads the list route's query string. The sort key is checked
+// here as well as in the store so a typo is a 400 rather than a 500.
+func parseTaskFilter(r *http.Request) (types.TaskFilter, error) {
+	var filter types.TaskFilter
+	var err error
+	query := r.URL.Query()
+
+	filter.AnimalId, filter.EnclosureId, err = parseSubjectFilter(r)
+	if err != nil {
+		return types.TaskFilter{}, err
+	}
+
+	filter.Tags = normalizeTags(query["tag"])
+
+	for _, priority := range query["priority"] {
+		if !validPriority(priority) {
+			return types.TaskFilter{}, fmt.Errorf("invalid priority %q: must be low, normal or high", priority)
+		}
+		filter.Priorities = append(filter.Priorities, priority)
+	}
+
+	if filter.Complete, err = optionalBoolQuery(r, "complete"); err != nil {
+		return types.TaskFilter{}, err
+	}
+	if filter.Overdue, err = optionalBoolQuery(r, "overdue"); err != nil {
+		return types.TaskFilter{}, err
+	}
+	if filter.DueBefore, err = optionalTimeQuery(r, "dueBefore"); err != nil {
+		return types.TaskFilter{}, err
+	}
+	if filter.DueAfter, err = optionalTimeQuery(r, "dueAfter"); err != nil {
+		return types.TaskFilter{}, err
+	}
+
+	filter.Sort = query.Get("sort")
+	if _, err := taskOrderBy(filter.Sort); err != nil {
+		return types.TaskFilter{}, err
+	}
+
+	return filter, nil
+}
+
+func optionalBoolQuery(r *http.Request, name string) (*bool, error) {
 	raw := r.URL.Query().Get(name)
 	if raw == "" {
 		return nil, nil
 	}
 
-	value, err := strconv.Atoi(raw)
-	if err != nil || value < 1 {
-		return nil, fmt.Errorf("invalid %s: must be a positive integer", name)
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: must be true or false", name)
 	}
 
 	return &value, nil
 }
 
-// filterTasksBySubject narrows tasks to one subject. A nil filter returns
-// everything.
-func filterTasksBySubject(tasks []*types.TaskWithSubject, animalId *int, enclosureId *int) []*types.TaskWithSubject {
-	if animalId == nil && enclosureId == nil {
-		return tasks
-	}
-
-	// Never nil: a nil slice encodes as `null`, but the endpoint is typed as
-	// returning an array.
-	filtered := make([]*types.TaskWithSubject, 0, len(tasks))
-	for _, task := range tasks {
-		switch {
-		case animalId != nil && task.AnimalId != nil && *task.AnimalId == *animalId:
-			filtered = append(filtered, task)
-		case enclosureId != nil && task.EnclosureId != nil && *task.EnclosureId == *enclosureId:
-			filtered = append(filtered, task)
+func optionalTimeQuery(r *http.Request, name string) (*time.Time, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+
+	value, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: must be an RFC 3339 time", name)
+	}
+
+	return &value, nil
+}
+
+func validPriority(priority string) bool {
+	return priority == "low" || priority == "normal" || priority == "high"
+}
+
+// normalizeTags trims and lower-cases tags and drops blanks and repeats, so
+// "Daily" and "daily " are the same tag whether written or filtered on.
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
 		}
+
+		seen[tag] = true
+		normalized = append(normalized, tag)
 	}
 
-	return filtered
+	return normalized
 }
 
 // handleGetTask godoc
@@ -174,6 +294,7 @@ func (h *Handler) handleGetTask(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
+	annotateDueState(task, time.Now())
 
 	utils.WriteJSON(w, http.StatusOK, task)
 }
@@ -182,7 +303,7 @@ func (h *Handler) handleGetTask(w http.ResponseWriter, r *http.Request) {
 //
 //	@Id				createTask
 //	@Summary		Create a task
-//	@Description	A task belongs to exactly one subject, so supply either animalId or enclosureId. The subject must belong to the caller.
+//	@Description	A task belongs to exactly one subject, so supply either animalId or enclosureId. The subject must belong to the caller. Priority defaults to normal. Tags are trimmed and lower-cased, and repeats are dropped. With resetTime, the task resets at that local time in the caller's timezone every repeatIntervHours / 24 days, so repeatIntervHours must be a multiple of 24.
 //	@Tags			tasks
 //	@Accept			json
 //	@Produce		json
@@ -213,6 +334,11 @@ func (h *Handler) handleCreateTaskV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := checkResetTime(payload.ResetTime, payload.RepeatIntervHours); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
 	if !h.assertOwnsSubject(w, payload.AnimalId, payload.EnclosureId, userID) {
 		return
 	}
@@ -225,16 +351,23 @@ func (h *Handler) handleCreateTaskV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.store.CreateTask(types.Task{
+	id, err := h.store.CreateTask(types.Task{
 		TaskName:          payload.TaskName,
 		TaskDesc:          payload.TaskDesc,
 		RepeatIntervHours: payload.RepeatIntervHours,
-	}, animalId, enclosureId, userID)
+	}, animalId, enclosureId, userID, types.TaskOptions{
+		Priority:        payload.Priority,
+		Tags:            normalizeTags(payload.Tags),
+		ResetTime:       payload.ResetTime,
+		ExpectedMinutes: payload.ExpectedMinutes,
+	})
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	h.publishTask(userID, webhook.EventTaskCreated, id)
+
 	utils.WriteStatus(w, http.StatusCreated)
 }
 
@@ -280,11 +413,48 @@ func (h *Handler) handleUpdateTaskV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := checkResetTime(payload.ResetTime, payload.RepeatIntervHours); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// A stepped task will move on to its other steps' intervals, so a reset
+	// time has to suit those as well.
+	if payload.ResetTime != nil {
+		current, err := h.store.GetTaskWithSubjectById(id)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		for _, step := range current.IntervalSteps {
+			if err := checkResetTime(payload.ResetTime, step.IntervalHours); err != nil {
+				utils.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+	}
+
 	if !h.assertOwnsSubject(w, payload.AnimalId, payload.EnclosureId, userID) {
 		return
 	}
 
-	err := h.store.UpdateTask(types.Task{
+	wasComplete := !payload.Complete || h.taskComplete(id)
+
+	// Options go first so that, if this update completes the task, its next
+	// due time is worked out with the reset time being written.
+	err := h.store.SetTaskOptions(id, types.TaskOptions{
+		Priority:        payload.Priority,
+		Tags:            normalizeTags(payload.Tags),
+		ResetTime:       payload.ResetTime,
+		ExpectedMinutes: payload.ExpectedMinutes,
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = h.store.UpdateTask(types.Task{
 		TaskId:            id,
 		TaskName:          payload.TaskName,
 		TaskDesc:          payload.TaskDesc,
@@ -297,11 +467,20 @@ func (h *Handler) handleUpdateTaskV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if payload.Complete {
+		if err := h.store.SetTaskCompletedBy(id, userID); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
 	if err := h.store.SetTaskSubject(id, payload.AnimalId, payload.EnclosureId); err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	h.publishIfCompleted(userID, id, wasComplete)
+
 	utils.WriteStatus(w, http.StatusNoContent)
 }
 
@@ -320,12 +499,251 @@ func (h *Handler) handleUpdateTaskV2(w http.ResponseWriter, r *http.Request) {
 //	@Router			/tasks/{id} [delete]
 func (h *Handler) handleDeleteTaskV2(w http.ResponseWriter, r *http.Request) {
 	id := auth.ResourceIDFromContext(r.Context())
+	userID := auth.GetuserIdFromContext(r.Context())
 
 	if err := h.store.DeleteTaskById(id); err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	h.publishTaskDeleted(userID, id)
+
+	utils.WriteStatus(w, http.StatusNoContent)
+}
+
+// handleSnoozeTask godoc
+//
+//	@Id				snoozeTask
+//	@Summary		Snooze one of the caller's tasks
+//	@Description	Defers the task by the given number of minutes, counted from now or from when it is currently due, whichever is later. No completion is recorded. A snoozed task is not reset or notified about until the snooze ends, and an overdue task is notified about again once it does.
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int						true	"Task ID"
+//	@Param			snooze	body		types.SnoozeTaskPayload	true	"How long to snooze for"
+//	@Success		200		{object}	types.TaskWithSubject
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/snooze [post]
+func (h *Handler) handleSnoozeTask(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	var payload types.SnoozeTaskPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	task, err := h.store.GetTaskWithSubjectById(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	now := time.Now()
+	until := SnoozeUntil(task, now, time.Duration(payload.DurationMinutes)*time.Minute)
+
+	if err := h.store.SnoozeTask(id, until); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	task.SnoozedUntil = &until
+	annotateDueState(task, now)
+
+	utils.WriteJSON(w, http.StatusOK, task)
+}
+
+// handleSkipTask godoc
+//
+//	@Id				skipTask
+//	@Summary		Skip the current occurrence of one of the caller's tasks
+//	@Description	Marks the current occurrence dealt with and moves the task on to its next occurrence that has not already passed. lastCompleted is left as it was, so a skip is never mistaken for a completion.
+//	@Tags			tasks
+//	@Produce		json
+//	@Param			id	path		int	true	"Task ID"
+//	@Success		200	{object}	types.TaskWithSubject
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/skip [post]
+func (h *Handler) handleSkipTask(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	task, err := h.store.GetTaskWithSubjectById(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	now := time.Now()
+	nextDue := nextDueAfterSkip(task.DueAt, time.Duration(task.RepeatIntervHours)*time.Hour, now)
+
+	if task.ResetTime != nil {
+		u, err := h.userStore.GetUserById(auth.GetuserIdFromContext(r.Context()))
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		nextDue, err = nextCalendarDueAfterSkip(task.DueAt, task.RepeatIntervHours, *task.ResetTime, u.Location(), now)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if err := h.store.SkipTaskOccurrence(id, nextDue); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	task.Complete = true
+	task.DueAt = nextDue
+	task.SnoozedUntil = nil
+	annotateDueState(task, now)
+
+	utils.WriteJSON(w, http.StatusOK, task)
+}
+
+// handleSetIntervalSteps godoc
+//
+//	@Id				setTaskIntervalSteps
+//	@Summary		Step a task's interval by its animal's age
+//	@Description	Replaces the task's age steps. Each step applies until the animal is untilAgeMonths old, and the last, which has no untilAgeMonths, from then on; for example every 24 hours until 6 months, every 72 until 18, then every 168. Only a task for an animal with a dob can be stepped. The interval follows the animal's age from then on, and the returned task's intervalShift says when it next changes. No steps leaves the task repeating at its current interval.
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int								true	"Task ID"
+//	@Param			steps	body		types.SetIntervalStepsPayload	true	"Steps in age order"
+//	@Success		200		{object}	types.TaskWithSubject
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/interval-steps [put]
+func (h *Handler) handleSetIntervalSteps(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	var payload types.SetIntervalStepsPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	task, err := h.store.GetTaskWithSubjectById(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	steps, err := intervalSteps(payload.Steps, task.ResetTime)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(steps) > 0 {
+		if task.AnimalId == nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("only a task for an animal can step its interval by age"))
+			return
+		}
+
+		animal, err := h.animalStore.GetAnimalById(*task.AnimalId)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if animal.Dob.IsZero() {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("the animal needs a dob before its tasks can step by age"))
+			return
+		}
+	}
+
+	if err := h.store.SetTaskIntervalSteps(id, steps); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeTask(w, http.StatusOK, id)
+}
+
+// handleCompleteTaskAction godoc
+//
+//	@Id				completeTaskAction
+//	@Summary		Complete a task from a push notification
+//	@Description	Authenticated by the single-use actionToken from the notification's data rather than a session. Tokens expire after two hours and are tied to one task; after that, open the task in the app instead. Completing a task that is already complete spends the token without changing the task.
+//	@Tags			tasks
+//	@Produce		json
+//	@Param			id		path	int		true	"Task ID"
+//	@Param			token	query	string	true	"actionToken from the notification data"
+//	@Success		204
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		409	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Router			/tasks/{id}/actions/complete [post]
+func (h *Handler) handleCompleteTaskAction(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ParseIDParam(r, "id")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	claims, err := auth.ParseTaskActionToken(h.actionSecret, auth.ActionCompleteTask, r.URL.Query().Get("token"))
+	if err != nil {
+		log.Printf("rejected action token for task %d: %v", id, err)
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("invalid or expired action token"))
+		return
+	}
+
+	if claims.TaskId != id {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("invalid or expired action token"))
+		return
+	}
+
+	// The task may have changed hands since the notification went out, and a
+	// token must not outlast the ownership it was issued under.
+	owned, err := h.store.UserOwnsTask(id, claims.UserID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !owned {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return
+	}
+
+	wasComplete := h.taskComplete(id)
+
+	err = h.store.CompleteTaskWithActionToken(id, claims.UserID, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		if errors.Is(err, ErrActionTokenUsed) {
+			utils.WriteError(w, http.StatusConflict, err)
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.publishIfCompleted(claims.UserID, id, wasComplete)
+
 	utils.WriteStatus(w, http.StatusNoContent)
 }
 
@@ -348,20 +766,7 @@ func exactlyOneSubject(animalId *int, enclosureId *int) error {
 // v1 omitted this check entirely, so a task could be attached to another user's
 // animal or enclosure.
 func (h *Handler) assertOwnsSubject(w http.ResponseWriter, animalId *int, enclosureId *int, userID int) bool {
-	var (
-		owned bool
-		err   error
-	)
-
-	switch {
-	case animalId != nil:
-		owned, err = h.animalStore.UserOwnsAnimal(*animalId, userID)
-	case enclosureId != nil:
-		owned, err = h.enclosureStore.UserOwnsEnclosure(*enclosureId, userID)
-	default:
-		return true
-	}
-
+	owned, err := h.ownsSubject(animalId, enclosureId, userID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return false
@@ -375,6 +780,19 @@ func (h *Handler) assertOwnsSubject(w http.ResponseWriter, animalId *int, enclos
 	return true
 }
 
+// ownsSubject reports whether the user owns whichever subject is set. With
+// neither set there is nothing to own, so it reports true.
+func (h *Handler) ownsSubject(animalId *int, enclosureId *int, userID int) (bool, error) {
+	switch {
+	case animalId != nil:
+		return h.animalStore.UserOwnsAnimal(*animalId, userID)
+	case enclosureId != nil:
+		return h.enclosureStore.UserOwnsEnclosure(*enclosureId, userID)
+	default:
+		return true, nil
+	}
+}
+
 // zeroIfNil converts to the zero-as-absent form the existing store expects.
 func zeroIfNil(value *int) int {
 	if value == nil {
@@ -1,10 +1,10 @@
 package task
 
 import (
+	"net/http/httptest"
 	"reflect"
 	"testing"
-
-	"github.com/whitallee/animal-family-backend/types"
+	"time"
 )
 
 // A task belongs to exactly one subject: "taskSubject" holds two nullable
@@ -37,55 +37,88 @@ func TestExactlyOneSubject(t *testing.T) {
 	}
 }
 
-func taskFor(id int, animalId *int, enclosureId *int) *types.TaskWithSubject {
-	return &types.TaskWithSubject{TaskId: id, AnimalId: animalId, EnclosureId: enclosureId}
-}
+func TestParseTaskFilter(t *testing.T) {
+	r := httptest.NewRequest("GET", "/tasks?animalId=3&tag=Daily&tag=meds&priority=high&priority=normal&complete=false&overdue=true&dueBefore=2026-03-01T10:00:00%2B02:00&sort=-due", nil)
 
-func TestFilterTasksBySubject(t *testing.T) {
-	a3, a4, e7 := 3, 4, 7
-	tasks := []*types.TaskWithSubject{
-		taskFor(1, &a3, nil),
-		taskFor(2, &a4, nil),
-		taskFor(3, nil, &e7),
-		taskFor(4, &a3, nil),
+	filter, err := parseTaskFilter(r)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	cases := []struct {
-		name        string
-		animalId    *int
-		enclosureId *int
-		wantIds     []int
-	}{
-		{"no filter returns everything", nil, nil, []int{1, 2, 3, 4}},
-		{"by animal", &a3, nil, []int{1, 4}},
-		{"by enclosure", nil, &e7, []int{3}},
-		{"animal with no tasks", &[]int{99}[0], nil, []int{}},
+	if filter.AnimalId == nil || *filter.AnimalId != 3 || filter.EnclosureId != nil {
+		t.Errorf("got subject %v / %v, want animal 3", filter.AnimalId, filter.EnclosureId)
+	}
+	if !reflect.DeepEqual(filter.Tags, []string{"daily", "meds"}) {
+		t.Errorf("got tags %v", filter.Tags)
+	}
+	if !reflect.DeepEqual(filter.Priorities, []string{"high", "normal"}) {
+		t.Errorf("got priorities %v", filter.Priorities)
+	}
+	if filter.Complete == nil || *filter.Complete || filter.Overdue == nil || !*filter.Overdue {
+		t.Errorf("got complete %v overdue %v, want false and true", filter.Complete, filter.Overdue)
+	}
+	if filter.DueBefore == nil || !filter.DueBefore.Equal(time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("got dueBefore %v", filter.DueBefore)
+	}
+	if filter.DueAfter != nil {
+		t.Errorf("got dueAfter %v, want none", filter.DueAfter)
+	}
+	if filter.Sort != "-due" {
+		t.Errorf("got sort %q", filter.Sort)
 	}
+}
 
-	for _, tc := range cases {
-		t.Run(tc.name, func(t *testing.T) {
-			got := filterTasksBySubject(tasks, tc.animalId, tc.enclosureId)
+func TestParseTaskFilterRejects(t *testing.T) {
+	cases := map[string]string{
+		"both subjects":    "animalId=1&enclosureId=2",
+		"unknown priority": "priority=urgent",
+		"non-bool":         "complete=maybe",
+		"bare date":        "dueAfter=2026-03-01",
+		"unknown sort":     "sort=colour",
+		// The key is looked up, never interpolated into the query.
+		"injected sort": "sort=due%20DESC%3B%20DROP%20TABLE%20tasks",
+	}
 
-			ids := make([]int, 0, len(got))
-			for _, task := range got {
-				ids = append(ids, task.TaskId)
-			}
+	for name, query := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/tasks", nil)
+			r.URL.RawQuery = query
 
-			if !reflect.DeepEqual(ids, tc.wantIds) {
-				t.Errorf("got task ids %v, want %v", ids, tc.wantIds)
+			if _, err := parseTaskFilter(r); err == nil {
+				t.Error("expected an error")
 			}
 		})
 	}
 }
 
-// An enclosure task must not be matched by an animal filter that shares its id,
-// which a naive comparison ignoring which pointer is set would do.
-func TestFilterTasksBySubjectDoesNotConfuseSubjectTypes(t *testing.T) {
-	id := 5
-	tasks := []*types.TaskWithSubject{taskFor(1, nil, &id)}
+func TestTaskOrderBy(t *testing.T) {
+	cases := map[string]string{
+		"":          `ORDER BY t."taskId"`,
+		"name":      `ORDER BY LOWER(t."taskName") ASC, t."taskId"`,
+		"-priority": `ORDER BY CASE t."priority" WHEN 'high' THEN 0 WHEN 'normal' THEN 1 ELSE 2 END DESC, t."taskId"`,
+	}
+
+	for sort, want := range cases {
+		got, err := taskOrderBy(sort)
+		if err != nil {
+			t.Fatalf("taskOrderBy(%q): %v", sort, err)
+		}
+		if got != want {
+			t.Errorf("taskOrderBy(%q) = %s, want %s", sort, got, want)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got := normalizeTags([]string{" Daily", "daily", "", "  ", "Meds"})
+
+	if !reflect.DeepEqual(got, []string{"daily", "meds"}) {
+		t.Errorf("got %v, want [daily meds]", got)
+	}
 
-	if got := filterTasksBySubject(tasks, &id, nil); len(got) != 0 {
-		t.Errorf("animal filter matched an enclosure task: %v", got)
+	// Never nil, so a task saved without tags reads back as [] not null.
+	if got := normalizeTags(nil); got == nil {
+		t.Error("expected an empty slice, got nil")
 	}
 }
 
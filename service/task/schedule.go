@@ -0,0 +1,208 @@
+package task
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// upcomingWindow is how far ahead a task counts as upcoming. It matches the
+// shortest interval most tasks use, so "upcoming" reads as "due before this
+// time tomorrow".
+const upcomingWindow = 24 * time.Hour
+
+// EffectiveDue is when a task actually next needs attention: its due time, or
+// the end of its snooze if that is later.
+func EffectiveDue(task *types.TaskWithSubject) time.Time {
+	if task.SnoozedUntil != nil && task.SnoozedUntil.After(task.DueAt) {
+		return *task.SnoozedUntil
+	}
+
+	return task.DueAt
+}
+
+// annotateDueState fills in the computed fields on a task read from the store.
+//
+// A complete task is never overdue: completion covers the current occurrence,
+// and the next one only becomes outstanding when CheckAndResetTasks resets it.
+// A paused task is neither overdue nor upcoming, since nothing is expected of
+// it until the pause ends.
+func annotateDueState(task *types.TaskWithSubject, now time.Time) {
+	due := EffectiveDue(task)
+
+	task.IsSnoozed = task.SnoozedUntil != nil && task.SnoozedUntil.After(now)
+	task.IsPaused = task.Pause != nil
+	task.IsOverdue = !task.IsPaused && !task.Complete && now.After(due)
+	task.IsUpcoming = !task.IsPaused && !task.IsOverdue && !due.Before(now) && due.Sub(now) <= upcomingWindow
+}
+
+func annotateDueStates(tasks []*types.TaskWithSubject, now time.Time) {
+	for _, task := range tasks {
+		annotateDueState(task, now)
+	}
+}
+
+// SnoozeUntil is when a snooze of the given length ends. It counts from the
+// task's current due time when that is still ahead, so snoozing a feeding due
+// tomorrow by a day moves it to the day after rather than to this time
+// tomorrow.
+func SnoozeUntil(task *types.TaskWithSubject, now time.Time, duration time.Duration) time.Time {
+	from := EffectiveDue(task)
+	if from.Before(now) {
+		from = now
+	}
+
+	return from.Add(duration)
+}
+
+// nextDueAfterSkip is the due time of the first occurrence after the one being
+// skipped that has not already passed. Skipping a long-overdue task must not
+// leave it due in the past, or the next reset sweep would immediately report it
+// again.
+func nextDueAfterSkip(dueAt time.Time, interval time.Duration, now time.Time) time.Time {
+	next := dueAt.Add(interval)
+	if interval <= 0 || next.After(now) {
+		return next
+	}
+
+	missed := now.Sub(next)/interval + 1
+
+	return next.Add(missed * interval)
+}
+
+// resetTimeLayout is how a calendar-day task's reset time is written.
+const resetTimeLayout = "15:04"
+
+// calendarDue is when a calendar-day task completed at completed next falls
+// due: its reset time, in loc, on the day one interval after the day the
+// completion covers. A completion before the reset time covers the previous
+// day, so a 06:00 feeding done at 05:00 is due again at 06:00 the same morning.
+//
+// It mirrors "taskNextDue" in the database, which sets the due time on
+// completion; TestTaskNextDueMatchesCalendarDue holds the two to the same
+// answers. Days are added to the date rather than 24 hours to the time, so
+// the reset stays at the same wall-clock time across a DST change.
+func calendarDue(completed time.Time, intervalHours int, resetTime string, loc *time.Location) (time.Time, error) {
+	clock, err := time.Parse(resetTimeLayout, resetTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid reset time %q", resetTime)
+	}
+
+	local := completed.In(loc)
+	year, month, day := local.Date()
+	if local.Hour()*60+local.Minute() < clock.Hour()*60+clock.Minute() {
+		day--
+	}
+
+	days := max(intervalHours/24, 1)
+
+	due := time.Date(year, month, day+days, clock.Hour(), clock.Minute(), 0, 0, loc)
+	if due.Hour() != clock.Hour() || due.Minute() != clock.Minute() {
+		// The reset time falls in a gap the clocks skip. Go reads it with the
+		// offset from after the gap, which lands before it; Postgres reads it
+		// with the one from before, which lands after. Follow Postgres, using
+		// the offset in force at the start of the day.
+		_, offset := time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, loc).Zone()
+		wall := time.Date(due.Year(), due.Month(), due.Day(), clock.Hour(), clock.Minute(), 0, 0, time.UTC)
+		due = wall.Add(-time.Duration(offset) * time.Second)
+	}
+
+	return due.UTC(), nil
+}
+
+// nextCalendarDueAfterSkip is nextDueAfterSkip for a calendar-day task.
+func nextCalendarDueAfterSkip(dueAt time.Time, intervalHours int, resetTime string, loc *time.Location, now time.Time) (time.Time, error) {
+	next, err := calendarDue(dueAt, intervalHours, resetTime, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for !next.After(now) {
+		next, _ = calendarDue(next, intervalHours, resetTime, loc)
+	}
+
+	return next, nil
+}
+
+// checkResetTime rejects a reset time on a task whose interval is not a whole
+// number of days, which has no single time of day to reset at.
+func checkResetTime(resetTime *string, intervalHours int) error {
+	if resetTime != nil && intervalHours%24 != 0 {
+		return fmt.Errorf("a task with a resetTime must repeat a whole number of days, so repeatIntervHours must be a multiple of 24")
+	}
+
+	return nil
+}
+
+// intervalSteps checks a stepped interval and returns it as stored. Steps must
+// run in age order, and only the last may be open-ended, since it is the one
+// that applies for the rest of the animal's life. A calendar-day task needs
+// every step to be a whole number of days.
+func intervalSteps(payload []types.IntervalStepPayload, resetTime *string) ([]types.IntervalStep, error) {
+	steps := make([]types.IntervalStep, 0, len(payload))
+
+	for i, step := range payload {
+		last := i == len(payload)-1
+
+		switch {
+		case last && step.UntilAgeMonths != nil:
+			return nil, fmt.Errorf("the last step applies for the rest of the animal's life, so it has no untilAgeMonths")
+		case !last && step.UntilAgeMonths == nil:
+			return nil, fmt.Errorf("every step but the last needs untilAgeMonths")
+		case !last && i > 0 && *step.UntilAgeMonths <= *payload[i-1].UntilAgeMonths:
+			return nil, fmt.Errorf("steps must be in order of untilAgeMonths")
+		}
+
+		if err := checkResetTime(resetTime, step.IntervalHours); err != nil {
+			return nil, err
+		}
+
+		steps = append(steps, types.IntervalStep{
+			UntilAgeMonths: step.UntilAgeMonths,
+			IntervalHours:  step.IntervalHours,
+		})
+	}
+
+	return steps, nil
+}
+
+// nextIntervalShift is the next time an animal born on dob moves on to a new
+// step, or nil once it is on the last one or if its age is unknown. Ages are
+// counted on UTC dates, as the database counts them.
+func nextIntervalShift(dob time.Time, steps []types.IntervalStep, now time.Time) *types.IntervalShift {
+	age := utils.AgeInMonths(dob, now.UTC())
+	if age == nil {
+		return nil
+	}
+
+	for i, step := range steps {
+		if step.UntilAgeMonths == nil || i == len(steps)-1 {
+			return nil
+		}
+
+		if *age < *step.UntilAgeMonths {
+			return &types.IntervalShift{
+				At:        ageReachedOn(dob, *step.UntilAgeMonths),
+				FromHours: step.IntervalHours,
+				ToHours:   steps[i+1].IntervalHours,
+			}
+		}
+	}
+
+	return nil
+}
+
+// ageReachedOn is the first day an animal born on dob is months old. A
+// birthday late in the month that the target month is too short for counts
+// from the first of the month after, which is how AgeInMonths reads it;
+// time.AddDate would instead overflow by a few days.
+func ageReachedOn(dob time.Time, months int) time.Time {
+	first := time.Date(dob.Year(), dob.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	if dob.Day() > first.AddDate(0, 1, -1).Day() {
+		return first.AddDate(0, 1, 0)
+	}
+
+	return time.Date(first.Year(), first.Month(), dob.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,331 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+var scheduleNow = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func TestAnnotateDueState(t *testing.T) {
+	past := scheduleNow.Add(-6 * time.Hour)
+	soon := scheduleNow.Add(3 * time.Hour)
+	later := scheduleNow.Add(72 * time.Hour)
+
+	cases := []struct {
+		name                       string
+		task                       types.TaskWithSubject
+		overdue, upcoming, snoozed bool
+	}{
+		{"incomplete and past due", types.TaskWithSubject{DueAt: past}, true, false, false},
+		{"complete tasks are never overdue", types.TaskWithSubject{Complete: true, DueAt: past}, false, false, false},
+		{"due within a day", types.TaskWithSubject{Complete: true, DueAt: soon}, false, true, false},
+		{"due in three days", types.TaskWithSubject{Complete: true, DueAt: later}, false, false, false},
+		{"snooze holds back an overdue task", types.TaskWithSubject{DueAt: past, SnoozedUntil: &soon}, false, true, true},
+		{"an expired snooze no longer applies", types.TaskWithSubject{DueAt: past, SnoozedUntil: &past}, true, false, false},
+		{"a paused task is not overdue", types.TaskWithSubject{DueAt: past, Pause: &types.CarePause{}}, false, false, false},
+		{"a paused task is not upcoming", types.TaskWithSubject{Complete: true, DueAt: soon, Pause: &types.CarePause{}}, false, false, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			task := tc.task
+			annotateDueState(&task, scheduleNow)
+
+			if task.IsOverdue != tc.overdue {
+				t.Errorf("IsOverdue = %v, want %v", task.IsOverdue, tc.overdue)
+			}
+			if task.IsUpcoming != tc.upcoming {
+				t.Errorf("IsUpcoming = %v, want %v", task.IsUpcoming, tc.upcoming)
+			}
+			if task.IsSnoozed != tc.snoozed {
+				t.Errorf("IsSnoozed = %v, want %v", task.IsSnoozed, tc.snoozed)
+			}
+		})
+	}
+}
+
+// Snoozing a task that is not yet due must push it back by the full duration,
+// not merely to "duration from now", which could be earlier than it was due.
+func TestSnoozeUntilCountsFromLaterOfNowAndDue(t *testing.T) {
+	day := 24 * time.Hour
+
+	overdue := &types.TaskWithSubject{DueAt: scheduleNow.Add(-time.Hour)}
+	if got, want := SnoozeUntil(overdue, scheduleNow, 2*time.Hour), scheduleNow.Add(2*time.Hour); !got.Equal(want) {
+		t.Errorf("overdue task: got %v, want %v", got, want)
+	}
+
+	dueTomorrow := &types.TaskWithSubject{Complete: true, DueAt: scheduleNow.Add(day)}
+	if got, want := SnoozeUntil(dueTomorrow, scheduleNow, day), scheduleNow.Add(2*day); !got.Equal(want) {
+		t.Errorf("task due tomorrow: got %v, want %v", got, want)
+	}
+
+	alreadySnoozed := scheduleNow.Add(5 * time.Hour)
+	resnoozed := &types.TaskWithSubject{DueAt: scheduleNow.Add(-time.Hour), SnoozedUntil: &alreadySnoozed}
+	if got, want := SnoozeUntil(resnoozed, scheduleNow, time.Hour), alreadySnoozed.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("re-snoozed task: got %v, want %v", got, want)
+	}
+}
+
+func TestNextDueAfterSkip(t *testing.T) {
+	day := 24 * time.Hour
+
+	cases := []struct {
+		name  string
+		dueAt time.Time
+		want  time.Time
+	}{
+		{"upcoming occurrence moves one interval on", scheduleNow.Add(time.Hour), scheduleNow.Add(25 * time.Hour)},
+		{"slightly overdue lands on the next future occurrence", scheduleNow.Add(-time.Hour), scheduleNow.Add(23 * time.Hour)},
+		{"long overdue never lands in the past", scheduleNow.Add(-10*day - time.Hour), scheduleNow.Add(23 * time.Hour)},
+		{"an occurrence exactly at now is skipped past", scheduleNow.Add(-day), scheduleNow.Add(day)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := nextDueAfterSkip(tc.dueAt, day, scheduleNow)
+			if !got.Equal(tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if !got.After(scheduleNow) {
+				t.Errorf("next due %v is not in the future", got)
+			}
+		})
+	}
+}
+
+type calendarDueCase struct {
+	name          string
+	completed     time.Time
+	intervalHours int
+	resetTime     string
+	want          time.Time
+}
+
+// calendarDueCases are run against both calendarDue and "taskNextDue" in the
+// database, which must agree.
+func calendarDueCases(t *testing.T) (*time.Location, []calendarDueCase) {
+	t.Helper()
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, newYork)
+	}
+
+	// 2026 clocks in New York go forward on 8 March and back on 1 November.
+	return newYork, []calendarDueCase{
+		{"late evening resets next morning", local(1, 14, 23, 0), 24, "06:00", local(1, 15, 6, 0)},
+		{"before the reset covers the previous day", local(1, 14, 5, 0), 24, "06:00", local(1, 14, 6, 0)},
+		{"exactly at the reset covers that day", local(1, 14, 6, 0), 24, "06:00", local(1, 15, 6, 0)},
+		{"several days", local(1, 14, 9, 0), 72, "06:00", local(1, 17, 6, 0)},
+		{"across spring forward keeps the wall clock", local(3, 7, 20, 0), 24, "06:00", local(3, 8, 6, 0)},
+		{"across fall back keeps the wall clock", local(10, 31, 20, 0), 24, "06:00", local(11, 1, 6, 0)},
+		{"a reset time in the spring gap happens after it", local(3, 7, 20, 0), 24, "02:30", local(3, 8, 3, 30)},
+		{"across a month end", local(1, 31, 20, 0), 24, "06:00", local(2, 1, 6, 0)},
+		{"several days across February", local(2, 27, 9, 0), 72, "06:00", local(3, 2, 6, 0)},
+		{"before the reset on the first covers the month before", local(3, 1, 5, 0), 24, "06:00", local(3, 1, 6, 0)},
+		{"across the year end", local(12, 31, 23, 0), 24, "06:00", time.Date(2027, 1, 1, 6, 0, 0, 0, newYork)},
+	}
+}
+
+func TestCalendarDue(t *testing.T) {
+	newYork, cases := calendarDueCases(t)
+	local := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, newYork)
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := calendarDue(tc.completed, tc.intervalHours, tc.resetTime, newYork)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tc.want) {
+				t.Errorf("got %v, want %v", got.In(newYork), tc.want)
+			}
+			if got.Location() != time.UTC {
+				t.Errorf("got a time in %v; due times are stored in UTC", got.Location())
+			}
+		})
+	}
+
+	// Across a transition the UTC gap between two resets is 23 or 25 hours,
+	// not 24. Adding a day's worth of hours would drift the reset time.
+	before := local(3, 7, 6, 0)
+	after, err := calendarDue(before, 24, "06:00", newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gap := after.Sub(before); gap != 23*time.Hour {
+		t.Errorf("got %v between resets across spring forward, want 23h", gap)
+	}
+}
+
+func TestNextCalendarDueAfterSkip(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Due at 06:00 on 1 March and skipped at noon on 10 March: the next
+	// occurrence is 06:00 local the following day, after the clocks changed.
+	dueAt := time.Date(2026, 3, 1, 6, 0, 0, 0, newYork)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, newYork)
+
+	got, err := nextCalendarDueAfterSkip(dueAt, 24, "06:00", newYork, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := time.Date(2026, 3, 11, 6, 0, 0, 0, newYork)
+	if !got.Equal(want) {
+		t.Errorf("got %v, want %v", got.In(newYork), want)
+	}
+}
+
+func TestCheckResetTime(t *testing.T) {
+	reset := "06:00"
+
+	if err := checkResetTime(&reset, 48); err != nil {
+		t.Errorf("unexpected error for whole days: %v", err)
+	}
+	if err := checkResetTime(&reset, 36); err == nil {
+		t.Error("expected an error for an interval that is not whole days")
+	}
+	if err := checkResetTime(nil, 36); err != nil {
+		t.Errorf("unexpected error without a reset time: %v", err)
+	}
+}
+
+func months(n int) *int {
+	return &n
+}
+
+// The example from the request: daily until 6 months, every three days until
+// 18 months, then weekly.
+var juvenileSteps = []types.IntervalStep{
+	{UntilAgeMonths: months(6), IntervalHours: 24},
+	{UntilAgeMonths: months(18), IntervalHours: 72},
+	{IntervalHours: 168},
+}
+
+func TestIntervalSteps(t *testing.T) {
+	reset := "06:00"
+
+	cases := []struct {
+		name      string
+		steps     []types.IntervalStepPayload
+		resetTime *string
+		wantErr   bool
+	}{
+		{"none", nil, nil, false},
+		{"one open-ended step", []types.IntervalStepPayload{{IntervalHours: 24}}, nil, false},
+		{"in order", []types.IntervalStepPayload{
+			{UntilAgeMonths: months(6), IntervalHours: 24},
+			{UntilAgeMonths: months(18), IntervalHours: 72},
+			{IntervalHours: 168},
+		}, &reset, false},
+		{"last step has an end", []types.IntervalStepPayload{
+			{UntilAgeMonths: months(6), IntervalHours: 24},
+		}, nil, true},
+		{"middle step has no end", []types.IntervalStepPayload{
+			{IntervalHours: 24},
+			{IntervalHours: 72},
+		}, nil, true},
+		{"out of order", []types.IntervalStepPayload{
+			{UntilAgeMonths: months(18), IntervalHours: 24},
+			{UntilAgeMonths: months(6), IntervalHours: 72},
+			{IntervalHours: 168},
+		}, nil, true},
+		{"part days on a calendar-day task", []types.IntervalStepPayload{
+			{UntilAgeMonths: months(6), IntervalHours: 12},
+			{IntervalHours: 24},
+		}, &reset, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			steps, err := intervalSteps(tc.steps, tc.resetTime)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(steps) != len(tc.steps) {
+				t.Errorf("got %d steps, want %d", len(steps), len(tc.steps))
+			}
+		})
+	}
+}
+
+func TestNextIntervalShift(t *testing.T) {
+	dob := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name string
+		now  time.Time
+		want *types.IntervalShift
+	}{
+		{"hatchling", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			&types.IntervalShift{At: time.Date(2026, 7, 15, 0, 0, 0, 0, time.UTC), FromHours: 24, ToHours: 72}},
+		{"the day before six months", time.Date(2026, 7, 14, 23, 0, 0, 0, time.UTC),
+			&types.IntervalShift{At: time.Date(2026, 7, 15, 0, 0, 0, 0, time.UTC), FromHours: 24, ToHours: 72}},
+		{"six months to the day", time.Date(2026, 7, 15, 0, 0, 0, 0, time.UTC),
+			&types.IntervalShift{At: time.Date(2027, 7, 15, 0, 0, 0, 0, time.UTC), FromHours: 72, ToHours: 168}},
+		{"adult", time.Date(2028, 1, 1, 0, 0, 0, 0, time.UTC), nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := nextIntervalShift(dob, juvenileSteps, tc.now)
+			if tc.want == nil {
+				if got != nil {
+					t.Errorf("got %+v, want no shift", got)
+				}
+				return
+			}
+			if got == nil || !got.At.Equal(tc.want.At) || got.FromHours != tc.want.FromHours || got.ToHours != tc.want.ToHours {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	// An animal created without a dob has no age to step by.
+	if got := nextIntervalShift(time.Time{}, juvenileSteps, scheduleNow); got != nil {
+		t.Errorf("got %+v for an unknown dob, want nil", got)
+	}
+}
+
+// A shift date must agree with AgeInMonths, which the database copies, or the
+// app would announce a change a few days before or after it happens.
+func TestAgeReachedOnMatchesAgeInMonths(t *testing.T) {
+	dobs := []time.Time{
+		time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 8, 30, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, dob := range dobs {
+		for m := 1; m <= 24; m++ {
+			at := ageReachedOn(dob, m)
+
+			if age := utils.AgeInMonths(dob, at); *age != m {
+				t.Errorf("born %s: %d months reached on %s, but the age there is %d", dob.Format(time.DateOnly), m, at.Format(time.DateOnly), *age)
+			}
+			if age := utils.AgeInMonths(dob, at.AddDate(0, 0, -1)); *age != m-1 {
+				t.Errorf("born %s: the day before %s already has age %d, want %d", dob.Format(time.DateOnly), at.Format(time.DateOnly), *age, m-1)
+			}
+		}
+	}
+}
@@ -2,12 +2,38 @@ package task
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
 
+// ErrActionTokenUsed is returned by CompleteTaskWithActionToken when the token
+// has already been spent. Handlers map it to 409 so a replayed notification
+// tap is not reported as a server fault.
+var ErrActionTokenUsed = errors.New("action token has already been used")
+
+// ErrBackupInviteNotFound is returned when a user has neither an invite to
+// back up a task nor the role of its backup. Handlers map it to 404, so it
+// says nothing about whether the task exists.
+var ErrBackupInviteNotFound = errors.New("no backup invite found for this task")
+
+// ErrTaskItemNotFound is returned when an item ID does not name an item of the
+// task it was given with.
+var ErrTaskItemNotFound = errors.New("checklist item not found on this task")
+
+// ErrTaskAlreadyComplete is returned when a timer is started on, or a
+// completion recorded for, a task that is already complete.
+var ErrTaskAlreadyComplete = errors.New("task is already complete")
+
+// ErrTimerNotRunning is returned by FinishTask for a task without a running
+// timer.
+var ErrTimerNotRunning = errors.New("task has no timer running")
+
 type Store struct {
 	db *sql.DB
 }
@@ -17,21 +43,94 @@ func NewStore(db *sql.DB) *Store {
 }
 
 func (s *Store) CheckTaskCompletion() error {
-	// check if any tasks should be reset
-	_, err := s.db.Exec(`
-		UPDATE "tasks"
-		SET "complete" = false
-		WHERE "complete" = true
-		AND "lastCompleted" + ("repeatIntervHours" * interval '1 hour') < NOW()`)
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	return nil
+	defer func() { _ = tx.Rollback() }()
+
+	if err := ResumeEndedPauses(tx); err != nil {
+		return err
+	}
+
+	if err := syncSteppedIntervals(tx); err != nil {
+		return err
+	}
+
+	// check if any tasks should be reset. Each one reset starts a new
+	// occurrence, so its checklist is cleared with it, as CheckAndResetTasks
+	// clears it.
+	_, err = tx.Exec(`
+		WITH reset AS (
+			UPDATE "tasks"
+			SET "complete" = false, "snoozedUntil" = NULL
+			WHERE "complete" = true
+			AND "dueAt" <= NOW()
+			AND ("snoozedUntil" IS NULL OR "snoozedUntil" <= NOW())
+			AND "taskId" NOT IN (SELECT "taskId" FROM "pausedTasks")
+			RETURNING "taskId"
+		)
+		UPDATE "taskItems"
+		SET "done" = false, "doneAt" = NULL
+		WHERE "taskId" IN (SELECT "taskId" FROM reset)`)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
+// CheckAndResetTasks resets completed tasks whose next occurrence has come due
+// and returns everything that should be notified about.
+//
+// Two kinds of row qualify. A completed task whose "dueAt" has passed is reset
+// to incomplete, unless a snooze is still holding it back. An incomplete task
+// whose snooze has just run out is reported again, since the snooze was a
+// request to be reminded later. Both have their snooze cleared so neither is
+// reported twice.
+//
+// The update and the read are one statement. Selecting first and updating
+// afterwards let a task come due between the two, so it was reset without ever
+// being notified.
+//
+// Paused tasks are neither reset nor reported. Pauses that have ended are
+// resumed first, in the same transaction, so a task coming back from one is
+// reset on this sweep if it is due. Stepped intervals are brought up to date
+// with their animals' ages at the same point.
 func (s *Store) CheckAndResetTasks() ([]*types.TaskResetNotification, error) {
-	// Query tasks that need resetting with subject names
-	rows, err := s.db.Query(`
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := ResumeEndedPauses(tx); err != nil {
+		return nil, err
+	}
+
+	if err := syncSteppedIntervals(tx); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		WITH reset AS (
+			UPDATE "tasks"
+			SET "complete" = false, "snoozedUntil" = NULL
+			WHERE (("complete" = true AND "dueAt" <= NOW() AND ("snoozedUntil" IS NULL OR "snoozedUntil" <= NOW()))
+			OR ("complete" = false AND "snoozedUntil" <= NOW()))
+			AND "taskId" NOT IN (SELECT "taskId" FROM "pausedTasks")
+			RETURNING "taskId", "taskName", "taskDesc"
+		),
+		-- Sibling CTEs read the snapshot from before "reset" ran, so this sees
+		-- which reset tasks were complete. Only those start a new occurrence
+		-- with a fresh checklist; a snooze running out on an incomplete task
+		-- keeps whatever was already ticked off.
+		reset_items AS (
+			UPDATE "taskItems"
+			SET "done" = false, "doneAt" = NULL
+			WHERE "taskId" IN (SELECT "taskId" FROM reset)
+			AND "taskId" IN (SELECT "taskId" FROM "tasks" WHERE "complete" = true)
+		)
 		SELECT
 			t."taskId",
 			t."taskName",
@@ -42,20 +141,17 @@ func (s *Store) CheckAndResetTasks() ([]*types.TaskResetNotification, error) {
 				WHEN ts."animalId" IS NOT NULL THEN 'animal'
 				ELSE 'enclosure'
 			END as subjectType
-		FROM "tasks" t
+		FROM reset t
 		INNER JOIN "taskUser" tu ON tu."taskId" = t."taskId"
 		INNER JOIN "taskSubject" ts ON ts."taskId" = t."taskId"
 		LEFT JOIN "animals" a ON a."animalId" = ts."animalId"
 		LEFT JOIN "enclosures" e ON e."enclosureId" = ts."enclosureId"
-		WHERE t."complete" = true
-		AND t."lastCompleted" + (t."repeatIntervHours" * interval '1 hour') < NOW()
 	`)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = rows.Close() }()
 
-	// Collect tasks to reset
 	var tasks []*types.TaskResetNotification
 	for rows.Next() {
 		task := &types.TaskResetNotification{}
@@ -73,71 +169,186 @@ func (s *Store) CheckAndResetTasks() ([]*types.TaskResetNotification, error) {
 		tasks = append(tasks, task)
 	}
 
-	// Bulk update tasks to incomplete
-	if len(tasks) > 0 {
-		_, err = s.db.Exec(`
-			UPDATE "tasks"
-			SET "complete" = false
-			WHERE "complete" = true
-			AND "lastCompleted" + ("repeatIntervHours" * interval '1 hour') < NOW()
-		`)
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_ = rows.Close()
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// InsertTasksTx creates tasks attached to one subject inside tx. It exists for
+// stores that create a subject and its tasks together, so both land in the same
+// transaction; CreateTask opens a transaction of its own and cannot join one.
+//
+// Exactly one of animalId and enclosureId must be non-nil. The tasks start
+// incomplete and due immediately, matching a task created through the API.
+func InsertTasksTx(tx *sql.Tx, tasks []types.Task, animalId *int, enclosureId *int, userID int) error {
+	if (animalId == nil) == (enclosureId == nil) {
+		return fmt.Errorf("exactly one of animalId and enclosureId must be set")
+	}
+
+	for _, task := range tasks {
+		var taskId int
+		err := tx.QueryRow(`INSERT INTO "tasks" ("taskName", "taskDesc", "complete", "lastCompleted", "repeatIntervHours", "dueAt")
+							VALUES ($1, $2, false, NOW(), $3, NOW()) RETURNING "taskId"`,
+			task.TaskName, task.TaskDesc, task.RepeatIntervHours).Scan(&taskId)
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		if _, err := tx.Exec(`INSERT INTO "taskUser" ("taskId", "userId") VALUES ($1, $2)`, taskId, userID); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`INSERT INTO "taskSubject" ("taskId", "animalId", "enclosureId") VALUES ($1, $2, $3)`, taskId, animalId, enclosureId); err != nil {
+			return err
 		}
 	}
 
-	return tasks, nil
+	return nil
+}
+
+// ResumeEndedPauses puts the tasks of pauses that have ended back on schedule,
+// once per pause. It runs inside tx for the same reason as InsertTasksTx: the
+// task sweep and the pause store both need it as one step of their own
+// transaction.
+//
+// A task that fell due while paused is due when the pause ended instead, so it
+// comes back as one fresh occurrence rather than as long overdue. A task still
+// under another pause is left for that pause to resume.
+func ResumeEndedPauses(tx *sql.Tx) error {
+	_, err := tx.Exec(`WITH ended AS (
+							UPDATE "carePauses" SET "resumedAt" = NOW()
+							WHERE "endsAt" <= NOW() AND "resumedAt" IS NULL
+							RETURNING "pauseId", "endsAt"
+						),
+						resumed AS (
+							SELECT cpt."taskId", MAX(e."endsAt") AS "endsAt"
+							FROM ended e INNER JOIN "carePauseTasks" cpt ON cpt."pauseId" = e."pauseId"
+							GROUP BY cpt."taskId"
+						)
+						UPDATE "tasks" t SET "dueAt" = r."endsAt", "snoozedUntil" = NULL
+						FROM resumed r
+						WHERE t."taskId" = r."taskId" AND t."dueAt" < r."endsAt"
+						AND t."taskId" NOT IN (SELECT "taskId" FROM "pausedTasks")`)
+
+	return err
+}
+
+// CarePauseColumns are what ScanRowsIntoCarePause reads, from "carePauses" p.
+const CarePauseColumns = `p."pauseId", p."taskId", p."animalId", p."enclosureId", p."reason", p."startsAt", p."endsAt"`
+
+// ScanRowsIntoCarePause fills in Status as of now, since it is not stored.
+// keys receive any columns selected ahead of CarePauseColumns, such as the ID
+// of the task or animal a pause was looked up for.
+func ScanRowsIntoCarePause(rows *sql.Rows, now time.Time, keys ...any) (*types.CarePause, error) {
+	pause := new(types.CarePause)
+
+	err := rows.Scan(append(keys,
+		&pause.PauseId,
+		&pause.TaskId,
+		&pause.AnimalId,
+		&pause.EnclosureId,
+		&pause.Reason,
+		&pause.StartsAt,
+		&pause.EndsAt,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	pause.Status = pause.StatusAt(now)
+
+	return pause, nil
 }
 
-func (s *Store) CreateTask(task types.Task, animalId int, enclosureId int, userId int) error {
+func (s *Store) CreateTask(task types.Task, animalId int, enclosureId int, userId int, options types.TaskOptions) (int, error) {
 	// start transaction
 	tx, err := s.db.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// create task in tasks table
 	var addedTaskId int
-	err = tx.QueryRow(`INSERT INTO "tasks" ("taskName", "taskDesc", "complete", "lastCompleted", "repeatIntervHours") VALUES ($1, $2, $3, $4, $5) RETURNING "taskId"`,
-		task.TaskName, task.TaskDesc, task.Complete, task.LastCompleted, task.RepeatIntervHours).Scan(&addedTaskId)
+	// A task created already complete is next due one interval after that
+	// completion; one created incomplete is due straight away.
+	err = tx.QueryRow(`INSERT INTO "tasks" ("taskName", "taskDesc", "complete", "lastCompleted", "repeatIntervHours", "dueAt", "priority", "tags", "resetTime", "expectedMinutes")
+		VALUES ($1, $2, $3, $4, $5, CASE WHEN $3 THEN ($4::timestamptz AT TIME ZONE 'UTC') + ($5 * interval '1 hour') ELSE NOW() END, $6, $7, $8, $9) RETURNING "taskId"`,
+		task.TaskName, task.TaskDesc, task.Complete, task.LastCompleted, task.RepeatIntervHours,
+		optionPriority(options), pq.Array(optionTags(options)), options.ResetTime, options.ExpectedMinutes).Scan(&addedTaskId)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// add user-task joiner to taskUser table
 	_, err = tx.Exec(`INSERT INTO "taskUser" ("taskId", "userId") VALUES ($1, $2)`, addedTaskId, userId)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// add subject-task joiner to taskSubject table
 	if animalId != 0 && enclosureId == 0 {
 		_, err = tx.Exec(`INSERT INTO "taskSubject" ("taskId", "animalId", "enclosureId") VALUES ($1, $2, $3)`, addedTaskId, animalId, nil)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	} else if enclosureId != 0 && animalId == 0 {
 		_, err = tx.Exec(`INSERT INTO "taskSubject" ("taskId", "animalId", "enclosureId") VALUES ($1, $2, $3)`, addedTaskId, nil, enclosureId)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	} else {
-		return fmt.Errorf("invalid payload, exclusively either animalId or enclosureId must be nonzero")
+		return 0, fmt.Errorf("invalid payload, exclusively either animalId or enclosureId must be nonzero")
 	}
 
 	// commit transation
 	err = tx.Commit()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return addedTaskId, nil
 }
 
+// UpdateTask writes the task's fields. Its schedule is only recomputed when the
+// write changes it: a new completion, completion state or interval moves
+// "dueAt" to one interval after lastCompleted and drops any snooze, while an
+// edit to the name or description leaves a snoozed or skipped task as it was.
 func (s *Store) UpdateTask(task types.Task) error {
-	_, err := s.db.Exec(`UPDATE "tasks"
-						SET "taskName" = $1, "taskDesc" = $2, "complete" = $3, "lastCompleted" = $4, "repeatIntervHours" = $5
-						WHERE "taskId" = $6`, task.TaskName, task.TaskDesc, task.Complete, task.LastCompleted, task.RepeatIntervHours, task.TaskId)
+	// Completing the task through an update records the occurrence it covers,
+	// along with the time on any running timer, and marking it incomplete takes
+	// the latest record back. As in completeTask, "old" reads the row from
+	// before the update.
+	_, err := s.db.Exec(`WITH updated AS (
+							UPDATE "tasks"
+							SET "taskName" = $1, "taskDesc" = $2, "complete" = $3, "lastCompleted" = $4::timestamptz, "repeatIntervHours" = $5,
+							"dueAt" = CASE WHEN "complete" <> $3 OR "lastCompleted" <> $4::timestamptz OR "repeatIntervHours" <> $5
+								THEN "taskNextDue"("taskId", $4::timestamptz, $5, "resetTime") ELSE "dueAt" END,
+							"snoozedUntil" = CASE WHEN "complete" <> $3 OR "lastCompleted" <> $4::timestamptz OR "repeatIntervHours" <> $5
+								THEN NULL ELSE "snoozedUntil" END,
+							"lastCompletedBy" = CASE WHEN "complete" <> $3 OR "lastCompleted" <> $4::timestamptz
+								THEN NULL ELSE "lastCompletedBy" END,
+							"startedAt" = CASE WHEN $3 THEN NULL ELSE "startedAt" END,
+							"startedBy" = CASE WHEN $3 THEN NULL ELSE "startedBy" END
+							WHERE "taskId" = $6
+							RETURNING "taskId"
+						),
+						recorded AS (
+							INSERT INTO "taskOccurrences" ("taskId", "dueAt", "completedAt", "intervalHours", "outcome", "durationMinutes")
+							SELECT old."taskId", old."dueAt", $4::timestamptz AT TIME ZONE 'UTC', old."repeatIntervHours", 'completed',
+								`+elapsedMinutesSQL(`$4::timestamptz AT TIME ZONE 'UTC'`)+`
+							FROM updated INNER JOIN "tasks" old ON old."taskId" = updated."taskId"
+							WHERE $3 AND NOT old."complete"
+						)
+						`+undoLastOccurrence(`
+							SELECT old."taskId" FROM updated INNER JOIN "tasks" old ON old."taskId" = updated."taskId"
+							WHERE NOT $3 AND old."complete"`), task.TaskName, task.TaskDesc, task.Complete, task.LastCompleted, task.RepeatIntervHours, task.TaskId)
 	if err != nil {
 		return err
 	}
@@ -145,6 +356,36 @@ func (s *Store) UpdateTask(task types.Task) error {
 	return nil
 }
 
+// SetTaskOptions also moves the due time of a complete task whose reset time
+// changes, so switching a task to or from calendar days takes effect from the
+// current occurrence rather than the next one.
+func (s *Store) SetTaskOptions(taskId int, options types.TaskOptions) error {
+	_, err := s.db.Exec(`UPDATE "tasks" SET "priority" = $1, "tags" = $2, "resetTime" = $3, "expectedMinutes" = $4,
+						"dueAt" = CASE WHEN "complete" AND "resetTime" IS DISTINCT FROM $3::time
+							THEN "taskNextDue"("taskId", "lastCompleted", "repeatIntervHours", $3::time) ELSE "dueAt" END
+						WHERE "taskId" = $5`,
+		optionPriority(options), pq.Array(optionTags(options)), options.ResetTime, options.ExpectedMinutes, taskId)
+
+	return err
+}
+
+func optionPriority(options types.TaskOptions) string {
+	if options.Priority == "" {
+		return "normal"
+	}
+
+	return options.Priority
+}
+
+// optionTags writes no tags as an empty array, since the column is NOT NULL.
+func optionTags(options types.TaskOptions) []string {
+	if options.Tags == nil {
+		return []string{}
+	}
+
+	return options.Tags
+}
+
 func (s *Store) UpdateTaskOwner(oldTaskUser types.TaskUser, newUserId int) error {
 	_, err := s.db.Exec(`UPDATE "taskUser"
 						SET "userId" = $1
@@ -212,7 +453,7 @@ func (s *Store) GetTaskUserByIds(taskId int, userID int) (*types.TaskUser, error
 }
 
 func (s *Store) GetTaskById(taskId int) (*types.Task, error) {
-	rows, err := s.db.Query(`SELECT * FROM "tasks" WHERE "taskId" = $1`, taskId)
+	rows, err := s.db.Query(`SELECT "taskId", "taskName", "taskDesc", "complete", "lastCompleted", "repeatIntervHours" FROM "tasks" WHERE "taskId" = $1`, taskId)
 	if err != nil {
 		return nil, err
 	}
@@ -232,13 +473,74 @@ func (s *Store) GetTaskById(taskId int) (*types.Task, error) {
 	return task, nil
 }
 
-func (s *Store) GetTasksWithSubjectByUserId(userID int) ([]*types.TaskWithSubject, error) {
-	rows, err := s.db.Query(`SELECT t."taskId", t."taskName", t."taskDesc", t."complete", t."lastCompleted", t."repeatIntervHours", ts."animalId", ts."enclosureId"
+// taskWithSubjectColumns are what utils.ScanRowsIntoTaskWithSubject reads, from
+// "tasks" t joined to "taskSubject" ts.
+const taskWithSubjectColumns = `t."taskId", t."taskName", t."taskDesc", t."complete", t."lastCompleted", t."repeatIntervHours", ts."animalId", ts."enclosureId", t."dueAt", t."snoozedUntil", t."lastCompletedBy", t."priority", t."tags", to_char(t."resetTime", 'HH24:MI'), t."expectedMinutes", t."startedAt", t."startedBy"`
+
+// effectiveDueSQL mirrors EffectiveDue. GREATEST ignores the NULL of a task
+// that was never snoozed.
+const effectiveDueSQL = `GREATEST(t."dueAt", t."snoozedUntil")`
+
+// taskSorts maps the sort keys the list route accepts to the expressions they
+// order by. Priority sorts most urgent first.
+var taskSorts = map[string]string{
+	"due":           effectiveDueSQL,
+	"priority":      `CASE t."priority" WHEN 'high' THEN 0 WHEN 'normal' THEN 1 ELSE 2 END`,
+	"name":          `LOWER(t."taskName")`,
+	"lastCompleted": `t."lastCompleted"`,
+}
+
+// taskOrderBy turns a sort key, optionally prefixed with "-" for descending,
+// into an ORDER BY clause. Keys are looked up rather than interpolated, so
+// only known expressions ever reach the query. Task ID breaks ties so pages
+// of equal keys come back in a stable order.
+func taskOrderBy(sort string) (string, error) {
+	if sort == "" {
+		return `ORDER BY t."taskId"`, nil
+	}
+
+	direction := "ASC"
+	key := sort
+	if strings.HasPrefix(sort, "-") {
+		direction = "DESC"
+		key = sort[1:]
+	}
+
+	expression, ok := taskSorts[key]
+	if !ok {
+		return "", fmt.Errorf("unknown sort %q", sort)
+	}
+
+	return fmt.Sprintf(`ORDER BY %s %s, t."taskId"`, expression, direction), nil
+}
+
+func (s *Store) GetTasksWithSubjectByUserId(userID int, filter types.TaskFilter) ([]*types.TaskWithSubject, error) {
+	orderBy, err := taskOrderBy(filter.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each filter is a nullable parameter, so the statement is the same
+	// whichever of them are in use. Times are compared in UTC, as stored.
+	rows, err := s.db.Query(`SELECT `+taskWithSubjectColumns+`
 							FROM "tasks" t INNER JOIN "taskUser" tu ON tu."taskId"=t."taskId" INNER JOIN "taskSubject" ts ON ts."taskId"=t."taskId"
-							WHERE "userId" = $1`, userID)
+							WHERE tu."userId" = $1
+							AND ($2::int IS NULL OR ts."animalId" = $2)
+							AND ($3::int IS NULL OR ts."enclosureId" = $3)
+							AND ($4::text[] IS NULL OR t."tags" @> $4)
+							AND ($5::text[] IS NULL OR t."priority" = ANY($5))
+							AND ($6::boolean IS NULL OR t."complete" = $6)
+							AND ($7::boolean IS NULL OR (NOT t."complete" AND `+effectiveDueSQL+` < $10
+								AND t."taskId" NOT IN (SELECT "taskId" FROM "pausedTasks")) = $7)
+							AND ($8::timestamp IS NULL OR `+effectiveDueSQL+` < $8)
+							AND ($9::timestamp IS NULL OR `+effectiveDueSQL+` >= $9)
+							`+orderBy,
+		userID, filter.AnimalId, filter.EnclosureId, nullableArray(filter.Tags), nullableArray(filter.Priorities),
+		filter.Complete, filter.Overdue, utcOrNil(filter.DueBefore), utcOrNil(filter.DueAfter), time.Now().UTC())
 	if err != nil {
 		return nil, err
 	}
+	defer func() { _ = rows.Close() }()
 
 	tasks := make([]*types.TaskWithSubject, 0)
 	for rows.Next() {
@@ -249,10 +551,49 @@ func (s *Store) GetTasksWithSubjectByUserId(userID int) ([]*types.TaskWithSubjec
 
 		tasks = append(tasks, task)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_ = rows.Close()
+
+	if err := s.attachTaskItems(tasks); err != nil {
+		return nil, err
+	}
+
+	if err := s.attachTaskPauses(tasks); err != nil {
+		return nil, err
+	}
+
+	if err := s.attachIntervalSteps(tasks); err != nil {
+		return nil, err
+	}
 
 	return tasks, nil
 }
 
+// nullableArray passes an empty filter list as NULL, which the list query
+// reads as "no filter", rather than as an empty array nothing matches.
+func nullableArray(values []string) any {
+	if len(values) == 0 {
+		return nil
+	}
+
+	return pq.Array(values)
+}
+
+// utcOrNil converts a bound to UTC before it is compared with a zone-less
+// column, which would otherwise take its wall-clock time in whatever zone it
+// arrived in.
+func utcOrNil(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	utc := t.UTC()
+
+	return &utc
+}
+
 func (s *Store) GetTasksBySubjectIds(animalId int, enclosureId int) ([]*types.Task, error) {
 	rows, err := s.db.Query(`SELECT t."taskId", t."taskName", t."taskDesc", t."complete", t."lastCompleted", t."repeatIntervHours"
 							FROM "tasks" t JOIN "taskSubject" ON "taskSubject"."taskId"=t."taskId"
@@ -343,7 +684,7 @@ func (s *Store) SetTaskSubject(taskId int, animalId *int, enclosureId *int) erro
 // change one field and send it back.
 func (s *Store) GetTaskWithSubjectById(taskId int) (*types.TaskWithSubject, error) {
 	rows, err := s.db.Query(
-		`SELECT t."taskId", t."taskName", t."taskDesc", t."complete", t."lastCompleted", t."repeatIntervHours", ts."animalId", ts."enclosureId"
+		`SELECT `+taskWithSubjectColumns+`
 		 FROM "tasks" t INNER JOIN "taskSubject" ts ON ts."taskId" = t."taskId"
 		 WHERE t."taskId" = $1`, taskId)
 	if err != nil {
@@ -363,6 +704,907 @@ func (s *Store) GetTaskWithSubjectById(taskId int) (*types.TaskWithSubject, erro
 	if err != nil {
 		return nil, err
 	}
+	_ = rows.Close()
+
+	if err := s.attachTaskItems([]*types.TaskWithSubject{task}); err != nil {
+		return nil, err
+	}
+
+	if err := s.attachTaskPauses([]*types.TaskWithSubject{task}); err != nil {
+		return nil, err
+	}
+
+	if err := s.attachIntervalSteps([]*types.TaskWithSubject{task}); err != nil {
+		return nil, err
+	}
 
 	return task, nil
 }
+
+// SnoozeTask defers the task until the given time. Completion state is left
+// alone: a snoozed overdue task is still incomplete, it is just not reported
+// again until the snooze runs out.
+func (s *Store) SnoozeTask(taskId int, until time.Time) error {
+	_, err := s.db.Exec(`UPDATE "tasks" SET "snoozedUntil" = $1 WHERE "taskId" = $2`, until, taskId)
+
+	return err
+}
+
+// SkipTaskOccurrence marks the current occurrence done without pretending it
+// was completed, and moves the task on to nextDue. Any snooze is dropped, since
+// the occurrence it was deferring no longer exists.
+func (s *Store) SkipTaskOccurrence(taskId int, nextDue time.Time) error {
+	_, err := s.db.Exec(`WITH skipped AS (
+							UPDATE "tasks" SET "complete" = true, "dueAt" = $1, "snoozedUntil" = NULL
+							WHERE "taskId" = $2
+							RETURNING "taskId"
+						)
+						INSERT INTO "taskOccurrences" ("taskId", "dueAt", "intervalHours", "outcome")
+						SELECT old."taskId", old."dueAt", old."repeatIntervHours", 'skipped'
+						FROM skipped INNER JOIN "tasks" old ON old."taskId" = skipped."taskId"`, nextDue, taskId)
+
+	return err
+}
+
+// SetTaskCompletedBy records who completed the task. UpdateTask cannot, since
+// types.Task doesn't carry a user, so it clears the record whenever completion
+// changes and the caller fills it back in here. A record that survived the
+// update is left alone.
+func (s *Store) SetTaskCompletedBy(taskId int, userID int) error {
+	_, err := s.db.Exec(`WITH marked AS (
+							UPDATE "tasks" SET "lastCompletedBy" = $1
+							WHERE "taskId" = $2 AND "complete" AND "lastCompletedBy" IS NULL
+							RETURNING "taskId"
+						)
+						UPDATE "taskOccurrences" SET "completedBy" = $1
+						WHERE "occurrenceId" = (
+							SELECT MAX(o."occurrenceId") FROM "taskOccurrences" o
+							INNER JOIN marked m ON m."taskId" = o."taskId"
+							WHERE o."outcome" = 'completed' AND o."completedBy" IS NULL
+						)`, userID, taskId)
+
+	return err
+}
+
+// CompleteTaskWithActionToken spends the token and completes the task in one
+// transaction, so a token is only ever consumed by a completion that stuck.
+// A task that is already complete is left as it is: the token is still spent,
+// but the due time is not pushed back a second time.
+func (s *Store) CompleteTaskWithActionToken(taskId int, userID int, tokenId string, expiresAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`DELETE FROM "usedActionTokens" WHERE "expiresAt" < NOW()`); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`INSERT INTO "usedActionTokens" ("tokenId", "expiresAt") VALUES ($1, $2)
+							ON CONFLICT ("tokenId") DO NOTHING`, tokenId, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrActionTokenUsed
+	}
+
+	if err := completeTask(tx, taskId, userID, nil, ""); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) StartTask(taskId int, userID int) error {
+	result, err := s.db.Exec(`UPDATE "tasks" SET "startedAt" = NOW() AT TIME ZONE 'UTC', "startedBy" = $1
+							WHERE "taskId" = $2 AND NOT "complete"`, userID, taskId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTaskAlreadyComplete
+	}
+
+	return nil
+}
+
+func (s *Store) FinishTask(taskId int, userID int) error {
+	return s.completeTimedTask(taskId, userID, nil, true)
+}
+
+func (s *Store) CompleteTask(taskId int, userID int, durationMinutes *int) error {
+	return s.completeTimedTask(taskId, userID, durationMinutes, false)
+}
+
+// completeTimedTask locks the task before completing it, so that a conflict
+// can be told apart from a completion that happened: completeTask on its own
+// silently leaves a complete task alone.
+func (s *Store) completeTimedTask(taskId int, userID int, durationMinutes *int, requireTimer bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var complete bool
+	var startedAt sql.NullTime
+	err = tx.QueryRow(`SELECT "complete", "startedAt" FROM "tasks" WHERE "taskId" = $1 FOR UPDATE`, taskId).Scan(&complete, &startedAt)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case complete:
+		return ErrTaskAlreadyComplete
+	case requireTimer && !startedAt.Valid:
+		return ErrTimerNotRunning
+	}
+
+	if err := completeTask(tx, taskId, userID, durationMinutes, ""); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) ApplyTaskOperations(ops []types.BulkTaskOperation, userID int, atomic bool) ([]error, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	results := make([]error, len(ops))
+	for i, op := range ops {
+		// In best-effort mode each operation gets a savepoint, so a failure
+		// undoes only its own partial work and leaves the transaction usable
+		// for the rest.
+		if !atomic {
+			if _, err := tx.Exec(`SAVEPOINT bulk_op`); err != nil {
+				return nil, err
+			}
+		}
+
+		opErr := applyTaskOperation(tx, op, userID)
+		results[i] = opErr
+
+		switch {
+		case opErr != nil && atomic:
+			return results, nil
+		case opErr != nil:
+			if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT bulk_op`); err != nil {
+				return nil, err
+			}
+		case !atomic:
+			if _, err := tx.Exec(`RELEASE SAVEPOINT bulk_op`); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// applyTaskOperation mirrors what UpdateTask, SetTaskSubject and
+// DeleteTaskById do for the same change, so a bulk edit leaves a task in the
+// state the single-task routes would have.
+func applyTaskOperation(tx *sql.Tx, op types.BulkTaskOperation, userID int) error {
+	var err error
+
+	switch op.Op {
+	case bulkOpComplete:
+		err = completeTask(tx, op.TaskId, userID, nil, "")
+	case bulkOpUncomplete:
+		_, err = tx.Exec(`WITH undone AS (
+							UPDATE "tasks"
+							SET "complete" = false, "dueAt" = "taskNextDue"("taskId", "lastCompleted", "repeatIntervHours", "resetTime"),
+							"snoozedUntil" = NULL, "lastCompletedBy" = NULL
+							WHERE "taskId" = $1 AND "complete"
+							RETURNING "taskId"
+						)
+						`+undoLastOccurrence(`SELECT "taskId" FROM undone`), op.TaskId)
+	case bulkOpSetLastCompleted:
+		// Correcting when a complete task was done also corrects the record of
+		// the occurrence that completion covered.
+		_, err = tx.Exec(`WITH corrected AS (
+							UPDATE "tasks"
+							SET "lastCompleted" = $1::timestamptz, "dueAt" = "taskNextDue"("taskId", $1::timestamptz, "repeatIntervHours", "resetTime"),
+							"snoozedUntil" = NULL, "lastCompletedBy" = CASE WHEN "complete" THEN $2::int ELSE NULL END
+							WHERE "taskId" = $3
+							RETURNING "taskId", "complete"
+						)
+						UPDATE "taskOccurrences" SET "completedAt" = $1::timestamptz AT TIME ZONE 'UTC', "completedBy" = $2
+						WHERE "occurrenceId" = (
+							SELECT MAX(o."occurrenceId") FROM "taskOccurrences" o
+							INNER JOIN corrected c ON c."taskId" = o."taskId"
+							WHERE c."complete" AND o."outcome" = 'completed'
+						)`, op.LastCompleted, userID, op.TaskId)
+	case bulkOpReassign:
+		_, err = tx.Exec(`UPDATE "taskSubject" SET "animalId" = $1, "enclosureId" = $2 WHERE "taskId" = $3`,
+			op.AnimalId, op.EnclosureId, op.TaskId)
+	case bulkOpDelete:
+		if _, err = tx.Exec(`DELETE FROM "taskUser" WHERE "taskId" = $1`, op.TaskId); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`DELETE FROM "taskSubject" WHERE "taskId" = $1`, op.TaskId); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM "tasks" WHERE "taskId" = $1`, op.TaskId)
+	default:
+		err = fmt.Errorf("unknown operation %q", op.Op)
+	}
+
+	return err
+}
+
+// attachTaskItems loads the checklists for tasks in one query. Every task gets
+// a non-nil slice so a task without a checklist serialises as [] rather than
+// null.
+func (s *Store) attachTaskItems(tasks []*types.TaskWithSubject) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	byId := make(map[int]*types.TaskWithSubject, len(tasks))
+	ids := make([]int, 0, len(tasks))
+	for _, task := range tasks {
+		task.Items = make([]types.TaskItem, 0)
+		byId[task.TaskId] = task
+		ids = append(ids, task.TaskId)
+	}
+
+	items, err := s.queryTaskItems(`SELECT `+taskItemColumns+` FROM "taskItems"
+									WHERE "taskId" = ANY($1) ORDER BY "taskId", "position", "itemId"`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		task := byId[item.TaskId]
+		task.Items = append(task.Items, item)
+	}
+
+	return nil
+}
+
+// attachTaskPauses sets Pause on each task under a pause in force. A task
+// under several is given the one that holds it longest.
+func (s *Store) attachTaskPauses(tasks []*types.TaskWithSubject) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	byId := make(map[int]*types.TaskWithSubject, len(tasks))
+	ids := make([]int, 0, len(tasks))
+	for _, task := range tasks {
+		byId[task.TaskId] = task
+		ids = append(ids, task.TaskId)
+	}
+
+	rows, err := s.db.Query(`SELECT DISTINCT ON (pt."taskId") pt."taskId", `+CarePauseColumns+`
+							FROM "pausedTasks" pt INNER JOIN "carePauses" p ON p."pauseId" = pt."pauseId"
+							WHERE pt."taskId" = ANY($1)
+							ORDER BY pt."taskId", p."endsAt" DESC NULLS FIRST, p."pauseId"`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	now := time.Now()
+	for rows.Next() {
+		var taskId int
+		pause, err := ScanRowsIntoCarePause(rows, now, &taskId)
+		if err != nil {
+			return err
+		}
+
+		byId[taskId].Pause = pause
+	}
+
+	return rows.Err()
+}
+
+// attachIntervalSteps loads the age steps of tasks in one query and works out
+// the next shift of each stepped task. Every task gets a non-nil slice.
+func (s *Store) attachIntervalSteps(tasks []*types.TaskWithSubject) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	byId := make(map[int]*types.TaskWithSubject, len(tasks))
+	ids := make([]int, 0, len(tasks))
+	for _, task := range tasks {
+		task.IntervalSteps = make([]types.IntervalStep, 0)
+		byId[task.TaskId] = task
+		ids = append(ids, task.TaskId)
+	}
+
+	rows, err := s.db.Query(`SELECT s."taskId", s."untilAgeMonths", s."intervalHours", a."dob"
+							FROM "taskIntervalSteps" s
+							INNER JOIN "taskSubject" ts ON ts."taskId" = s."taskId"
+							LEFT JOIN "animals" a ON a."animalId" = ts."animalId"
+							WHERE s."taskId" = ANY($1)
+							ORDER BY s."taskId", s."position"`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	dobs := make(map[int]*time.Time)
+	for rows.Next() {
+		var taskId int
+		var step types.IntervalStep
+		var dob *time.Time
+		if err := rows.Scan(&taskId, &step.UntilAgeMonths, &step.IntervalHours, &dob); err != nil {
+			return err
+		}
+
+		byId[taskId].IntervalSteps = append(byId[taskId].IntervalSteps, step)
+		dobs[taskId] = dob
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for taskId, dob := range dobs {
+		// Steps on a task that has since moved to an enclosure do nothing.
+		if dob != nil {
+			byId[taskId].IntervalShift = nextIntervalShift(*dob, byId[taskId].IntervalSteps, now)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) GetTaskItems(taskId int) ([]types.TaskItem, error) {
+	return s.queryTaskItems(`SELECT `+taskItemColumns+` FROM "taskItems"
+							WHERE "taskId" = $1 ORDER BY "position", "itemId"`, taskId)
+}
+
+func (s *Store) CreateTaskItem(taskId int, text string) error {
+	_, err := s.db.Exec(`INSERT INTO "taskItems" ("taskId", "position", "itemText")
+						VALUES ($1, (SELECT COALESCE(MAX("position"), -1) + 1 FROM "taskItems" WHERE "taskId" = $1), $2)`,
+		taskId, text)
+
+	return err
+}
+
+func (s *Store) UpdateTaskItem(taskId int, itemId int, text string, done bool, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// "doneAt" keeps the time an item was first ticked, so editing the text of
+	// a done item does not move it.
+	result, err := tx.Exec(`UPDATE "taskItems"
+							SET "itemText" = $1, "done" = $2,
+							"doneAt" = CASE WHEN NOT $2 THEN NULL WHEN "done" THEN "doneAt" ELSE NOW() END
+							WHERE "itemId" = $3 AND "taskId" = $4`, text, done, itemId, taskId)
+	if err != nil {
+		return err
+	}
+
+	if err := requireOneRow(result); err != nil {
+		return err
+	}
+
+	if err := completeTaskIfChecklistDone(tx, taskId, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) DeleteTaskItem(taskId int, itemId int, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec(`DELETE FROM "taskItems" WHERE "itemId" = $1 AND "taskId" = $2`, itemId, taskId)
+	if err != nil {
+		return err
+	}
+
+	if err := requireOneRow(result); err != nil {
+		return err
+	}
+
+	if err := completeTaskIfChecklistDone(tx, taskId, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) ReorderTaskItems(taskId int, itemIds []int) error {
+	// WITH ORDINALITY numbers the array elements, so the new position of each
+	// item is simply its index in the list.
+	_, err := s.db.Exec(`UPDATE "taskItems" i
+						SET "position" = o.ord - 1
+						FROM unnest($1::int[]) WITH ORDINALITY AS o("itemId", ord)
+						WHERE i."itemId" = o."itemId" AND i."taskId" = $2`, pq.Array(itemIds), taskId)
+
+	return err
+}
+
+func (s *Store) GetTaskEscalation(taskId int) (*types.TaskEscalation, error) {
+	escalation := &types.TaskEscalation{
+		TaskId:         taskId,
+		BackupUsers:    make([]types.BackupUser, 0),
+		PendingInvites: make([]string, 0),
+		Rules:          make([]types.EscalationRule, 0),
+	}
+
+	rows, err := s.db.Query(`SELECT u."userId", u."firstName"
+							FROM "taskBackupUsers" b
+							INNER JOIN "users" u ON u."userId" = b."userId"
+							WHERE b."taskId" = $1
+							ORDER BY u."userId"`, taskId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var backup types.BackupUser
+		if err := rows.Scan(&backup.UserId, &backup.DisplayName); err != nil {
+			return nil, err
+		}
+		escalation.BackupUsers = append(escalation.BackupUsers, backup)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_ = rows.Close()
+
+	rows, err = s.db.Query(`SELECT "email" FROM "taskBackupInvites" WHERE "taskId" = $1 ORDER BY "invitedAt", "email"`, taskId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		escalation.PendingInvites = append(escalation.PendingInvites, email)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_ = rows.Close()
+
+	rows, err = s.db.Query(`SELECT "afterHours", "audience", "urgency" FROM "taskEscalations"
+							WHERE "taskId" = $1 ORDER BY "afterHours"`, taskId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var rule types.EscalationRule
+		if err := rows.Scan(&rule.AfterHours, &rule.Audience, &rule.Urgency); err != nil {
+			return nil, err
+		}
+		escalation.Rules = append(escalation.Rules, rule)
+	}
+
+	return escalation, rows.Err()
+}
+
+func (s *Store) SetTaskEscalation(taskId int, keepUserIds []int, inviteEmails []string, rules []types.EscalationRule) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`DELETE FROM "taskBackupUsers" WHERE "taskId" = $1 AND NOT ("userId" = ANY($2::int[]))`,
+		taskId, pq.Array(keepUserIds))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM "taskBackupInvites" WHERE "taskId" = $1 AND NOT ("email" = ANY($2::text[]))`,
+		taskId, pq.Array(inviteEmails))
+	if err != nil {
+		return err
+	}
+
+	// An invite still pending keeps its "invitedAt". One to the address of a
+	// backup who has already accepted is left out.
+	_, err = tx.Exec(`INSERT INTO "taskBackupInvites" ("taskId", "email")
+					SELECT $1, e."email" FROM unnest($2::text[]) AS e("email")
+					WHERE NOT EXISTS (
+						SELECT 1 FROM "taskBackupUsers" b
+						INNER JOIN "users" u ON u."userId" = b."userId"
+						WHERE b."taskId" = $1 AND u."email" = e."email"
+					)
+					ON CONFLICT DO NOTHING`, taskId, pq.Array(inviteEmails))
+	if err != nil {
+		return err
+	}
+
+	afterHours := make([]int, 0, len(rules))
+	for _, rule := range rules {
+		afterHours = append(afterHours, rule.AfterHours)
+	}
+
+	_, err = tx.Exec(`DELETE FROM "taskEscalations" WHERE "taskId" = $1 AND NOT ("afterHours" = ANY($2::int[]))`,
+		taskId, pq.Array(afterHours))
+	if err != nil {
+		return err
+	}
+
+	// Upserting on afterHours rather than replacing the rows keeps
+	// "lastSentDueAt", so a rule that has already fired for the current
+	// occurrence does not fire again because the policy was saved.
+	for _, rule := range rules {
+		_, err = tx.Exec(`INSERT INTO "taskEscalations" ("taskId", "afterHours", "audience", "urgency")
+						VALUES ($1, $2, $3, $4)
+						ON CONFLICT ("taskId", "afterHours") DO UPDATE
+						SET "audience" = EXCLUDED."audience", "urgency" = EXCLUDED."urgency"`,
+			taskId, rule.AfterHours, rule.Audience, rule.Urgency)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) GetBackupInvites(email string) ([]types.BackupInvite, error) {
+	rows, err := s.db.Query(`SELECT i."taskId", t."taskName", o."firstName", i."invitedAt"
+							FROM "taskBackupInvites" i
+							INNER JOIN "tasks" t ON t."taskId" = i."taskId"
+							INNER JOIN "taskUser" tu ON tu."taskId" = i."taskId"
+							INNER JOIN "users" o ON o."userId" = tu."userId"
+							WHERE i."email" = $1
+							ORDER BY i."invitedAt", i."taskId"`, email)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	invites := make([]types.BackupInvite, 0)
+	for rows.Next() {
+		var invite types.BackupInvite
+		if err := rows.Scan(&invite.TaskId, &invite.TaskName, &invite.OwnerName, &invite.InvitedAt); err != nil {
+			return nil, err
+		}
+
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+func (s *Store) AcceptBackupInvite(taskId int, userID int, email string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec(`DELETE FROM "taskBackupInvites" WHERE "taskId" = $1 AND "email" = $2`, taskId, email)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrBackupInviteNotFound
+	}
+
+	_, err = tx.Exec(`INSERT INTO "taskBackupUsers" ("taskId", "userId") VALUES ($1, $2) ON CONFLICT DO NOTHING`, taskId, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) LeaveTaskBackup(taskId int, userID int, email string) error {
+	var left bool
+	err := s.db.QueryRow(`WITH invite AS (
+							DELETE FROM "taskBackupInvites" WHERE "taskId" = $1 AND "email" = $3 RETURNING 1
+						),
+						backup AS (
+							DELETE FROM "taskBackupUsers" WHERE "taskId" = $1 AND "userId" = $2 RETURNING 1
+						)
+						SELECT EXISTS (SELECT 1 FROM invite) OR EXISTS (SELECT 1 FROM backup)`,
+		taskId, userID, email).Scan(&left)
+	if err != nil {
+		return err
+	}
+	if !left {
+		return ErrBackupInviteNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) SetTaskIntervalSteps(taskId int, steps []types.IntervalStep) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`DELETE FROM "taskIntervalSteps" WHERE "taskId" = $1`, taskId)
+	if err != nil {
+		return err
+	}
+
+	for i, step := range steps {
+		_, err = tx.Exec(`INSERT INTO "taskIntervalSteps" ("taskId", "position", "untilAgeMonths", "intervalHours")
+						VALUES ($1, $2, $3, $4)`, taskId, i, step.UntilAgeMonths, step.IntervalHours)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A complete task is already waiting out an interval. It is worked out
+	// again from its last completion, so new steps apply to the current wait
+	// rather than only from the next completion on.
+	_, err = tx.Exec(`UPDATE "tasks"
+					SET "repeatIntervHours" = COALESCE("taskInterval"("taskId", NOW()), "repeatIntervHours"),
+					"dueAt" = CASE WHEN "complete"
+						THEN "taskNextDue"("taskId", "lastCompleted", "repeatIntervHours", "resetTime") ELSE "dueAt" END
+					WHERE "taskId" = $1`, taskId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) GetRecordedOccurrences(userID int, from time.Time, to time.Time) ([]types.RecordedOccurrence, error) {
+	// An occurrence due before the window still shows in it if it was
+	// completed there, since the occurrences it ran past may fall inside.
+	rows, err := s.db.Query(`SELECT o."taskId", o."dueAt", o."completedAt", o."completedBy", o."intervalHours", o."outcome"
+							FROM "taskOccurrences" o
+							INNER JOIN "taskUser" tu ON tu."taskId" = o."taskId"
+							WHERE tu."userId" = $1
+							AND o."dueAt" < $3
+							AND COALESCE(o."completedAt", o."dueAt") >= $2
+							ORDER BY o."dueAt", o."occurrenceId"`, userID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	occurrences := make([]types.RecordedOccurrence, 0)
+	for rows.Next() {
+		var o types.RecordedOccurrence
+		if err := rows.Scan(&o.TaskId, &o.DueAt, &o.CompletedAt, &o.CompletedBy, &o.IntervalHours, &o.Outcome); err != nil {
+			return nil, err
+		}
+
+		occurrences = append(occurrences, o)
+	}
+
+	return occurrences, rows.Err()
+}
+
+// syncSteppedIntervals moves each stepped task on to the interval for its
+// animal's age today. Due times are worked out from the steps directly, so
+// this only keeps "repeatIntervHours" honest for everything that reads it.
+func syncSteppedIntervals(tx *sql.Tx) error {
+	_, err := tx.Exec(`UPDATE "tasks" t
+					SET "repeatIntervHours" = stepped.hours
+					FROM (
+						SELECT "taskId", "taskInterval"("taskId", NOW()) AS hours
+						FROM "taskIntervalSteps"
+						GROUP BY "taskId"
+					) stepped
+					WHERE t."taskId" = stepped."taskId"
+					AND stepped.hours IS NOT NULL
+					AND t."repeatIntervHours" <> stepped.hours`)
+
+	return err
+}
+
+// ClaimDueEscalations finds the rules due to fire and records them as fired
+// in the same statement, so two overlapping sweeps cannot both send one.
+//
+// A rule is due once the task has been outstanding for its hours past the
+// effective due time, which a snooze pushes back, and has not yet fired for
+// the occurrence due at "dueAt". Completing the task stops it, and resetting
+// it starts a new occurrence with a new "dueAt", for which every rule fires
+// again. Paused tasks are left alone.
+func (s *Store) ClaimDueEscalations() ([]*types.TaskEscalationNotification, error) {
+	rows, err := s.db.Query(`
+		WITH due AS (
+			UPDATE "taskEscalations" e
+			SET "lastSentDueAt" = t."dueAt"
+			FROM "tasks" t
+			WHERE t."taskId" = e."taskId"
+			AND NOT t."complete"
+			AND ` + effectiveDueSQL + ` + e."afterHours" * interval '1 hour' <= NOW()
+			AND e."lastSentDueAt" IS DISTINCT FROM t."dueAt"
+			AND t."taskId" NOT IN (SELECT "taskId" FROM "pausedTasks")
+			RETURNING e."taskId", e."afterHours", e."audience", e."urgency"
+		),
+		-- A sweep that runs late can find several rules of one task due
+		-- together. They are all marked as fired, but only the latest is sent.
+		latest AS (
+			SELECT DISTINCT ON ("taskId") * FROM due ORDER BY "taskId", "afterHours" DESC
+		),
+		recipients AS (
+			SELECT l."taskId", b."userId"
+			FROM latest l
+			INNER JOIN "taskBackupUsers" b ON b."taskId" = l."taskId"
+			UNION
+			SELECT l."taskId", tu."userId"
+			FROM latest l
+			INNER JOIN "taskUser" tu ON tu."taskId" = l."taskId"
+			WHERE l."audience" = 'everyone'
+		)
+		SELECT
+			t."taskId",
+			t."taskName",
+			t."taskDesc",
+			r."userId",
+			COALESCE(a."animalName", en."enclosureName"),
+			CASE WHEN ts."animalId" IS NOT NULL THEN 'animal' ELSE 'enclosure' END,
+			tu."userId",
+			o."firstName",
+			l."afterHours",
+			l."urgency"
+		FROM latest l
+		INNER JOIN recipients r ON r."taskId" = l."taskId"
+		INNER JOIN "tasks" t ON t."taskId" = l."taskId"
+		INNER JOIN "taskUser" tu ON tu."taskId" = l."taskId"
+		INNER JOIN "users" o ON o."userId" = tu."userId"
+		INNER JOIN "taskSubject" ts ON ts."taskId" = l."taskId"
+		LEFT JOIN "animals" a ON a."animalId" = ts."animalId"
+		LEFT JOIN "enclosures" en ON en."enclosureId" = ts."enclosureId"
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var notifications []*types.TaskEscalationNotification
+	for rows.Next() {
+		n := &types.TaskEscalationNotification{}
+		err := rows.Scan(
+			&n.TaskId,
+			&n.TaskName,
+			&n.TaskDesc,
+			&n.UserID,
+			&n.SubjectName,
+			&n.SubjectType,
+			&n.OwnerID,
+			&n.OwnerName,
+			&n.AfterHours,
+			&n.Urgency,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, rows.Err()
+}
+
+// completeTaskIfChecklistDone completes the task when it has a checklist and
+// every item on it is done. An empty checklist never completes a task, or
+// deleting the only item would.
+func completeTaskIfChecklistDone(tx *sql.Tx, taskId int, userID int) error {
+	return completeTask(tx, taskId, userID, nil, `
+				AND EXISTS (SELECT 1 FROM "taskItems" WHERE "taskId" = $2)
+				AND NOT EXISTS (SELECT 1 FROM "taskItems" WHERE "taskId" = $2 AND NOT "done")`)
+}
+
+// completeTask completes a task now on behalf of userID and records the
+// occurrence that completion covers, taking durationMinutes, or the time on
+// the running timer when that is nil, as how long it took. condition narrows
+// the WHERE clause further. A task that is already complete is left alone
+// rather than having its due time pushed back.
+//
+// The occurrence is read by joining "tasks" outside the CTE: every part of
+// the statement sees the same snapshot, from before the update, so that join
+// still has the due time being completed.
+func completeTask(tx *sql.Tx, taskId int, userID int, durationMinutes *int, condition string) error {
+	_, err := tx.Exec(`WITH done AS (
+				UPDATE "tasks"
+				SET "complete" = true, "lastCompleted" = NOW(), "dueAt" = "taskNextDue"("taskId", NOW(), "repeatIntervHours", "resetTime"),
+				"snoozedUntil" = NULL, "lastCompletedBy" = $1, "startedAt" = NULL, "startedBy" = NULL
+				WHERE "taskId" = $2 AND NOT "complete"`+condition+`
+				RETURNING "taskId"
+			)
+			INSERT INTO "taskOccurrences" ("taskId", "dueAt", "completedAt", "completedBy", "intervalHours", "outcome", "durationMinutes")
+			SELECT old."taskId", old."dueAt", NOW() AT TIME ZONE 'UTC', $1, old."repeatIntervHours", 'completed',
+				COALESCE($3::int, `+elapsedMinutesSQL(`NOW() AT TIME ZONE 'UTC'`)+`)
+			FROM done INNER JOIN "tasks" old ON old."taskId" = done."taskId"`, userID, taskId, durationMinutes)
+
+	return err
+}
+
+// elapsedMinutesSQL is the time on the timer of the task "old" as of the
+// zone-less UTC timestamp at, in whole minutes rounded up, or NULL when no
+// timer is running. A completion backdated to before the timer started
+// records no time rather than a negative one.
+func elapsedMinutesSQL(at string) string {
+	return `CASE WHEN old."startedAt" <= (` + at + `)
+					THEN CEIL(EXTRACT(EPOCH FROM (` + at + `) - old."startedAt") / 60)::int END`
+}
+
+// undoLastOccurrence builds a statement removing the most recent occurrence
+// record of each task selected by taskIds. Marking a task incomplete again
+// takes back the completion or skip that made it complete, and its record
+// with it.
+func undoLastOccurrence(taskIds string) string {
+	return `DELETE FROM "taskOccurrences"
+			WHERE "occurrenceId" IN (
+				SELECT MAX("occurrenceId") FROM "taskOccurrences"
+				WHERE "taskId" IN (` + taskIds + `)
+				GROUP BY "taskId"
+			)`
+}
+
+func requireOneRow(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTaskItemNotFound
+	}
+
+	return nil
+}
+
+const taskItemColumns = `"itemId", "taskId", "position", "itemText", "done", "doneAt"`
+
+func (s *Store) queryTaskItems(query string, args ...any) ([]types.TaskItem, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	items := make([]types.TaskItem, 0)
+	for rows.Next() {
+		var item types.TaskItem
+		err := rows.Scan(&item.ItemId, &item.TaskId, &item.Position, &item.Text, &item.Done, &item.DoneAt)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
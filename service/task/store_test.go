@@ -0,0 +1,88 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/db/dbtest"
+)
+
+// The v1 sweep starts a new occurrence of a task it resets with a fresh
+// checklist, as the v2 sweep does.
+func TestCheckTaskCompletionClearsChecklist(t *testing.T) {
+	db := dbtest.Open(t)
+	store := NewStore(db)
+
+	var taskId int
+	err := db.QueryRow(`INSERT INTO "tasks" ("taskName", "taskDesc", "complete", "dueAt")
+						VALUES ('Feed', '', true, NOW() - interval '1 hour') RETURNING "taskId"`).Scan(&taskId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO "taskItems" ("taskId", "position", "itemText", "done", "doneAt")
+						VALUES ($1, 0, 'Crickets', true, NOW())`, taskId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.CheckTaskCompletion(); err != nil {
+		t.Fatal(err)
+	}
+
+	var complete, done bool
+	err = db.QueryRow(`SELECT t."complete", i."done" FROM "tasks" t
+						INNER JOIN "taskItems" i ON i."taskId" = t."taskId"
+						WHERE t."taskId" = $1`, taskId).Scan(&complete, &done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if complete || done {
+		t.Errorf("after the sweep complete = %v and item done = %v, want both reset", complete, done)
+	}
+}
+
+// "taskNextDue" sets the due time the sweeps go by, and calendarDue is its
+// copy in Go. Both must give the same answers, across DST changes and month
+// ends alike.
+func TestTaskNextDueMatchesCalendarDue(t *testing.T) {
+	db := dbtest.Open(t)
+	newYork, cases := calendarDueCases(t)
+
+	var taskId int
+	err := db.QueryRow(`WITH u AS (
+							INSERT INTO "users" ("firstName", "lastName", "email", "password", "timezone")
+							VALUES ('Ada', 'Keeper', 'ada@example.com', 'x', $1) RETURNING "userId"
+						), t AS (
+							INSERT INTO "tasks" ("taskName", "taskDesc") VALUES ('Feed', '') RETURNING "taskId"
+						)
+						INSERT INTO "taskUser" ("taskId", "userId") SELECT t."taskId", u."userId" FROM t, u
+						RETURNING "taskId"`, newYork.String()).Scan(&taskId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got time.Time
+			err := db.QueryRow(`SELECT "taskNextDue"($1, $2, $3, $4::time)`,
+				taskId, tc.completed, tc.intervalHours, tc.resetTime).Scan(&got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The function returns a zone-less UTC timestamp.
+			got = time.Date(got.Year(), got.Month(), got.Day(), got.Hour(), got.Minute(), got.Second(), 0, time.UTC)
+			if !got.Equal(tc.want) {
+				t.Errorf("taskNextDue = %v, want %v", got.In(newYork), tc.want)
+			}
+
+			goDue, err := calendarDue(tc.completed, tc.intervalHours, tc.resetTime, newYork)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(goDue) {
+				t.Errorf("taskNextDue = %v but calendarDue = %v", got.In(newYork), goDue.In(newYork))
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/webhook"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+// handleStartTask godoc
+//
+//	@Id				startTask
+//	@Summary		Start a timer on one of the caller's tasks
+//	@Description	Records that the caller has started on the task. Finishing or completing it records the time since, and any other completion also stops the timer. Starting a task whose timer is already running restarts it.
+//	@Tags			tasks
+//	@Produce		json
+//	@Param			id	path		int	true	"Task ID"
+//	@Success		200	{object}	types.TaskWithSubject
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		409	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/start [post]
+func (h *Handler) handleStartTask(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	if err := h.store.StartTask(id, userID); err != nil {
+		writeTimerError(w, err)
+		return
+	}
+
+	h.writeTask(w, http.StatusOK, id)
+}
+
+// handleFinishTask godoc
+//
+//	@Id				finishTask
+//	@Summary		Stop the timer on one of the caller's tasks and complete it
+//	@Description	Completes the task, recording the minutes since it was started, rounded up, as how long it took.
+//	@Tags			tasks
+//	@Produce		json
+//	@Param			id	path		int	true	"Task ID"
+//	@Success		200	{object}	types.TaskWithSubject
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		409	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/finish [post]
+func (h *Handler) handleFinishTask(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	if err := h.store.FinishTask(id, userID); err != nil {
+		writeTimerError(w, err)
+		return
+	}
+
+	h.publishTask(userID, webhook.EventTaskCompleted, id)
+
+	h.writeTask(w, http.StatusOK, id)
+}
+
+// handleCompleteTask godoc
+//
+//	@Id				completeTask
+//	@Summary		Complete one of the caller's tasks, optionally saying how long it took
+//	@Description	Records a completion of the current occurrence. durationMinutes is how long it took; without it, the time on the task's running timer is recorded, or none if there is no timer. Send {} to complete a task without a duration.
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int							true	"Task ID"
+//	@Param			completion	body		types.CompleteTaskPayload	true	"How long the task took"
+//	@Success		200			{object}	types.TaskWithSubject
+//	@Failure		400			{object}	types.ErrorResponse
+//	@Failure		403			{object}	types.ErrorResponse
+//	@Failure		409			{object}	types.ErrorResponse
+//	@Failure		500			{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/tasks/{id}/complete [post]
+func (h *Handler) handleCompleteTask(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	var payload types.CompleteTaskPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	if err := h.store.CompleteTask(id, userID, payload.DurationMinutes); err != nil {
+		writeTimerError(w, err)
+		return
+	}
+
+	h.publishTask(userID, webhook.EventTaskCompleted, id)
+
+	h.writeTask(w, http.StatusOK, id)
+}
+
+func writeTimerError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrTaskAlreadyComplete) || errors.Is(err, ErrTimerNotRunning) {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteError(w, http.StatusInternalServerError, err)
+}
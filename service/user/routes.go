@@ -6,18 +6,24 @@ import (
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-	"github.com/whitallee/animal-family-backend/config"
 	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/mailer"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
 
 type Handler struct {
-	store types.UserStore
+	store      types.UserStore
+	mail       *mailer.Mailer
+	publicHost string
+	secret     []byte
 }
 
-func NewHandler(store types.UserStore) *Handler {
-	return &Handler{store: store}
+// NewHandler takes the mailer account emails are sent with, or nil to send
+// none, as when no mail relay is configured. publicHost is where links in
+// those emails point, and secret signs session tokens and those links.
+func NewHandler(store types.UserStore, mail *mailer.Mailer, publicHost string, secret []byte) *Handler {
+	return &Handler{store: store, mail: mail, publicHost: publicHost, secret: secret}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
@@ -106,8 +112,7 @@ func (h *Handler) handleUserLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	secret := []byte(config.Envs.JWTSecret)
-	token, err := auth.CreateJWT(secret, u.ID)
+	token, err := auth.CreateJWT(h.secret, u.ID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -137,8 +142,7 @@ func (h *Handler) handleUserRefreshToken(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	secret := []byte(config.Envs.JWTSecret)
-	token, err := auth.CreateJWT(secret, u.ID)
+	token, err := auth.CreateJWT(h.secret, u.ID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/whitallee/animal-family-backend/types"
@@ -14,7 +15,7 @@ import (
 
 func TestUserServiceHandlers(t *testing.T) {
 	userStore := &mockUserStore{}
-	handler := NewHandler(userStore)
+	handler := NewHandler(userStore, nil, "http://localhost", []byte("secret"))
 
 	t.Run("should fail if the user payload is invalid", func(t *testing.T) {
 		payload := types.RegisterUserPayload{
@@ -84,3 +85,15 @@ func (m *mockUserStore) CreateUser(types.User) error {
 func (m *mockUserStore) DeleteUserById(int) error {
 	return nil
 }
+func (m *mockUserStore) UpdateUserTimezone(int, string) error {
+	return nil
+}
+func (m *mockUserStore) StartEmailVerification(int, time.Duration) error {
+	return nil
+}
+func (m *mockUserStore) CancelEmailVerification(int) error {
+	return nil
+}
+func (m *mockUserStore) VerifyEmail(int, string) error {
+	return nil
+}
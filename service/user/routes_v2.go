@@ -1,13 +1,18 @@
 package user
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-	"github.com/whitallee/animal-family-backend/config"
 	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/service/mailer"
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
@@ -27,12 +32,19 @@ func (h *Handler) RegisterV2Routes(router *mux.Router) {
 	router.HandleFunc("/users/refresh-token", auth.WithJWTAuth(h.handleRefreshToken, h.store)).Methods(http.MethodPost)
 	router.HandleFunc("/users/me", auth.WithJWTAuth(h.handleGetCurrentUser, h.store)).Methods(http.MethodGet)
 	router.HandleFunc("/users/me", auth.WithJWTAuth(h.handleDeleteCurrentUser, h.store)).Methods(http.MethodDelete)
+	router.HandleFunc("/users/me/timezone", auth.WithJWTAuth(h.handleUpdateTimezone, h.store)).Methods(http.MethodPut)
+	router.HandleFunc("/users/me/email/verification", auth.WithJWTAuth(h.handleResendEmailVerification, h.store)).Methods(http.MethodPost)
+
+	// Authenticated by the token in the link, not a session.
+	router.HandleFunc("/users/email/verify", h.handleVerifyEmailForm).Methods(http.MethodGet)
+	router.HandleFunc("/users/email/verify", h.handleVerifyEmail).Methods(http.MethodPost)
 }
 
 // handleRegisterUser godoc
 //
 //	@Id				registerUser
 //	@Summary		Create an account
+//	@Description	When a mail relay is configured, emails a link that verifies the address. Nothing else is emailed to it until that link is followed; see POST /users/me/email/verification to send another.
 //	@Tags			users
 //	@Accept			json
 //	@Produce		json
@@ -76,9 +88,76 @@ func (h *Handler) handleRegisterUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.mail != nil {
+		u, err := h.store.GetUserByEmail(payload.Email)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// The account exists whether or not the link goes out, and another
+		// can be asked for, so a failure here is only logged.
+		if err := h.sendEmailVerification(u); err != nil {
+			log.Printf("failed to send a verification link to user %d: %v", u.ID, err)
+		}
+	}
+
 	utils.WriteStatus(w, http.StatusCreated)
 }
 
+// emailVerificationTTL is how long a verification link works. A link that
+// has expired is replaced by asking for another from the app.
+const emailVerificationTTL = 7 * 24 * time.Hour
+
+// emailVerificationCooldown spaces out verification links. Anyone can
+// register with any address, so without it the resend route could be used to
+// flood a stranger's inbox.
+const emailVerificationCooldown = 10 * time.Minute
+
+// sendEmailVerification emails u a link that verifies their address. The
+// cooldown is claimed before the link is sent, and lifted again if sending
+// fails, so the user is not left waiting out a link that never arrived.
+func (h *Handler) sendEmailVerification(u *types.User) error {
+	if err := h.store.StartEmailVerification(u.ID, emailVerificationCooldown); err != nil {
+		return err
+	}
+
+	token, err := auth.CreateEmailVerificationToken(h.secret, u.ID, u.Email, emailVerificationTTL)
+	if err != nil {
+		_ = h.store.CancelEmailVerification(u.ID)
+		return err
+	}
+	verifyURL := strings.TrimSuffix(h.publicHost, "/") + "/api/v2/users/email/verify?token=" + url.QueryEscape(token)
+
+	h.sendAccountEmail(func(m *mailer.Mailer) error {
+		if err := m.SendEmailVerification(u.Email, u.FirstName, verifyURL); err != nil {
+			if err := h.store.CancelEmailVerification(u.ID); err != nil {
+				log.Printf("failed to lift the verification cooldown for user %d: %v", u.ID, err)
+			}
+			return err
+		}
+		return nil
+	})
+
+	return nil
+}
+
+// sendAccountEmail sends an account email in the background, so a slow mail
+// relay does not hold up the request; a failure is only logged. Account
+// emails ignore notification preferences, and do nothing without a mailer.
+// Apart from the verification link, they are only sent to verified addresses.
+func (h *Handler) sendAccountEmail(send func(m *mailer.Mailer) error) {
+	if h.mail == nil {
+		return
+	}
+
+	go func() {
+		if err := send(h.mail); err != nil {
+			log.Printf("failed to send account email: %v", err)
+		}
+	}()
+}
+
 // handleLoginUser godoc
 //
 //	@Id				loginUser
@@ -118,7 +197,7 @@ func (h *Handler) handleLoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := auth.CreateJWT([]byte(config.Envs.JWTSecret), u.ID)
+	token, err := auth.CreateJWT(h.secret, u.ID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -150,7 +229,7 @@ func (h *Handler) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := auth.CreateJWT([]byte(config.Envs.JWTSecret), u.ID)
+	token, err := auth.CreateJWT(h.secret, u.ID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -189,6 +268,7 @@ func (h *Handler) handleGetCurrentUser(w http.ResponseWriter, r *http.Request) {
 //
 //	@Id				deleteCurrentUser
 //	@Summary		Delete the authenticated user's account
+//	@Description	Sends a confirmation to the account's email address when a mail relay is configured and the address has been verified.
 //	@Tags			users
 //	@Produce		json
 //	@Success		204
@@ -199,10 +279,197 @@ func (h *Handler) handleGetCurrentUser(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleDeleteCurrentUser(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetuserIdFromContext(r.Context())
 
+	// Read before the delete, which takes the address with it.
+	u, err := h.store.GetUserById(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	if err := h.store.DeleteUserById(userID); err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	if u.EmailVerifiedAt.Valid {
+		h.sendAccountEmail(func(m *mailer.Mailer) error {
+			return m.SendAccountDeleted(u.Email, u.FirstName)
+		})
+	}
+
 	utils.WriteStatus(w, http.StatusNoContent)
 }
+
+// handleUpdateTimezone godoc
+//
+//	@Id				updateTimezone
+//	@Summary		Set the account's timezone
+//	@Description	Times in the calendar feed are shown in this zone, and tasks with a resetTime reset at that local time in it. A task already waiting to reset keeps its current due time; the new zone applies from its next completion. New accounts start in UTC.
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			timezone	body		types.UpdateTimezonePayload	true	"IANA timezone name"
+//	@Success		200			{object}	types.UserResponse
+//	@Failure		400			{object}	types.ErrorResponse
+//	@Failure		403			{object}	types.ErrorResponse
+//	@Failure		500			{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/users/me/timezone [put]
+func (h *Handler) handleUpdateTimezone(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	var payload types.UpdateTimezonePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	if err := h.store.UpdateUserTimezone(userID, payload.Timezone); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	u, err := h.store.GetUserById(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.NewUserResponse(u))
+}
+
+// handleResendEmailVerification godoc
+//
+//	@Id				resendEmailVerification
+//	@Summary		Email another link that verifies the account's address
+//	@Description	For when the link sent at registration was lost or has expired; links work for seven days. Another can be asked for ten minutes after the last.
+//	@Tags			users
+//	@Produce		json
+//	@Success		202
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		409	{object}	types.ErrorResponse
+//	@Failure		429	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Failure		503	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/users/me/email/verification [post]
+func (h *Handler) handleResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	if h.mail == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("email is not configured"))
+		return
+	}
+
+	u, err := h.store.GetUserById(auth.GetuserIdFromContext(r.Context()))
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.sendEmailVerification(u); err != nil {
+		switch {
+		case errors.Is(err, ErrEmailAlreadyVerified):
+			utils.WriteError(w, http.StatusConflict, err)
+		case errors.Is(err, ErrEmailVerificationTooSoon):
+			utils.WriteError(w, http.StatusTooManyRequests, err)
+		default:
+			utils.WriteError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	utils.WriteStatus(w, http.StatusAccepted)
+}
+
+// handleVerifyEmailForm godoc
+//
+//	@Id				confirmVerifyEmail
+//	@Summary		Show the page a verification link opens
+//	@Description	Changes nothing: the page asks the reader to confirm, which POSTs the token back to the same URL. Mail scanners follow links in emails, and must not verify an address by doing so.
+//	@Tags			users
+//	@Produce		html
+//	@Param			token	query	string	true	"Token from the verification link"
+//	@Success		200
+//	@Failure		403
+//	@Router			/users/email/verify [get]
+func (h *Handler) handleVerifyEmailForm(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.parseEmailVerificationToken(r); err != nil {
+		utils.WritePage(w, http.StatusForbidden, verificationLinkInvalidPage)
+		return
+	}
+
+	utils.WritePage(w, http.StatusOK, utils.Page{
+		Title:   "Verify your email address",
+		Message: "Confirm that this is your address to start getting emails from Animal Family.",
+		Button:  "Verify",
+	})
+}
+
+// handleVerifyEmail godoc
+//
+//	@Id				verifyEmail
+//	@Summary		Verify the account's address from a verification link
+//	@Description	Authenticated by the token in the link rather than a session. This is the confirmation form on the page GET shows. A welcome email follows the first time the address is verified.
+//	@Tags			users
+//	@Accept			x-www-form-urlencoded
+//	@Produce		html
+//	@Param			token	query	string	true	"Token from the verification link"
+//	@Success		200
+//	@Failure		403
+//	@Failure		500
+//	@Router			/users/email/verify [post]
+func (h *Handler) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.parseEmailVerificationToken(r)
+	if err != nil {
+		utils.WritePage(w, http.StatusForbidden, verificationLinkInvalidPage)
+		return
+	}
+
+	err = h.store.VerifyEmail(claims.UserID, claims.Email)
+	switch {
+	case err == nil:
+		if u, err := h.store.GetUserById(claims.UserID); err == nil {
+			h.sendAccountEmail(func(m *mailer.Mailer) error {
+				return m.SendWelcome(u.Email, u.FirstName)
+			})
+		}
+	case errors.Is(err, ErrEmailAlreadyVerified):
+	case errors.Is(err, ErrEmailVerificationStale):
+		utils.WritePage(w, http.StatusForbidden, verificationLinkInvalidPage)
+		return
+	default:
+		log.Printf("failed to verify the address of user %d: %v", claims.UserID, err)
+		utils.WritePage(w, http.StatusInternalServerError, utils.Page{
+			Title:   "Something went wrong",
+			Message: "Your address has not been verified yet. Try the link again later.",
+		})
+		return
+	}
+
+	utils.WritePage(w, http.StatusOK, utils.Page{
+		Title:   "Email address verified",
+		Message: "Thanks. Animal Family can now email you.",
+	})
+}
+
+// verificationLinkInvalidPage covers expired links as well as bad ones; the
+// reader can do the same about either.
+var verificationLinkInvalidPage = utils.Page{
+	Title:   "This link no longer works",
+	Message: "Verification links expire after seven days. Sign in to the app to send yourself another.",
+}
+
+func (h *Handler) parseEmailVerificationToken(r *http.Request) (*auth.EmailVerificationClaims, error) {
+	claims, err := auth.ParseEmailVerificationToken(h.secret, r.URL.Query().Get("token"))
+	if err != nil {
+		log.Printf("rejected email verification token: %v", err)
+		return nil, err
+	}
+
+	return claims, nil
+}
@@ -2,12 +2,29 @@ package user
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/whitallee/animal-family-backend/types"
 	"github.com/whitallee/animal-family-backend/utils"
 )
 
+// ErrEmailAlreadyVerified is returned when the user's address needs no
+// verifying. Handlers map it to 409.
+var ErrEmailAlreadyVerified = errors.New("this email address is already verified")
+
+// ErrEmailVerificationTooSoon is returned when a user asks for another
+// verification link before the cooldown on the last one has passed.
+// Handlers map it to 429.
+var ErrEmailVerificationTooSoon = errors.New("a verification link was sent moments ago; check your inbox or wait a few minutes before asking for another")
+
+// ErrEmailVerificationStale is returned for a verification link whose
+// account is gone or now has a different address.
+var ErrEmailVerificationStale = errors.New("this link is for an address the account no longer has")
+
+const userColumns = `"userId", "firstName", "lastName", "email", "phone", "password", "createdAt", "timezone", "emailVerifiedAt"`
+
 type Store struct {
 	db *sql.DB
 }
@@ -26,7 +43,7 @@ func (s *Store) CreateUser(user types.User) error {
 }
 
 func (s *Store) GetUserByEmail(email string) (*types.User, error) {
-	rows, err := s.db.Query(`SELECT * FROM "users" WHERE "email" = $1`, email)
+	rows, err := s.db.Query(`SELECT `+userColumns+` FROM "users" WHERE "email" = $1`, email)
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +64,7 @@ func (s *Store) GetUserByEmail(email string) (*types.User, error) {
 }
 
 func (s *Store) GetUserById(id int) (*types.User, error) {
-	rows, err := s.db.Query(`SELECT * FROM "users" WHERE "userId" = $1`, id)
+	rows, err := s.db.Query(`SELECT `+userColumns+` FROM "users" WHERE "userId" = $1`, id)
 	if err != nil {
 		return nil, err
 	}
@@ -67,6 +84,76 @@ func (s *Store) GetUserById(id int) (*types.User, error) {
 	return u, nil
 }
 
+func (s *Store) UpdateUserTimezone(userID int, timezone string) error {
+	_, err := s.db.Exec(`UPDATE "users" SET "timezone" = $1 WHERE "userId" = $2`, timezone, userID)
+
+	return err
+}
+
+// StartEmailVerification claims the cooldown in the same statement that
+// checks it, so two requests at once cannot both send a link.
+func (s *Store) StartEmailVerification(userID int, cooldown time.Duration) error {
+	result, err := s.db.Exec(`UPDATE "users" SET "emailVerificationSentAt" = NOW() AT TIME ZONE 'UTC'
+							WHERE "userId" = $1 AND "emailVerifiedAt" IS NULL
+							AND ("emailVerificationSentAt" IS NULL
+								OR "emailVerificationSentAt" <= NOW() AT TIME ZONE 'UTC' - make_interval(secs => $2))`,
+		userID, cooldown.Seconds())
+	if err != nil {
+		return err
+	}
+
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if claimed > 0 {
+		return nil
+	}
+
+	u, err := s.GetUserById(userID)
+	if err != nil {
+		return err
+	}
+	if u.EmailVerifiedAt.Valid {
+		return ErrEmailAlreadyVerified
+	}
+
+	return ErrEmailVerificationTooSoon
+}
+
+func (s *Store) CancelEmailVerification(userID int) error {
+	_, err := s.db.Exec(`UPDATE "users" SET "emailVerificationSentAt" = NULL WHERE "userId" = $1`, userID)
+
+	return err
+}
+
+// VerifyEmail returns ErrEmailAlreadyVerified for an address that was
+// verified before, and ErrEmailVerificationStale when the account is gone or
+// no longer has that address.
+func (s *Store) VerifyEmail(userID int, email string) error {
+	result, err := s.db.Exec(`UPDATE "users" SET "emailVerifiedAt" = NOW() AT TIME ZONE 'UTC'
+							WHERE "userId" = $1 AND "email" = $2 AND "emailVerifiedAt" IS NULL`,
+		userID, email)
+	if err != nil {
+		return err
+	}
+
+	verified, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if verified > 0 {
+		return nil
+	}
+
+	u, err := s.GetUserById(userID)
+	if err != nil || u.Email != email {
+		return ErrEmailVerificationStale
+	}
+
+	return ErrEmailAlreadyVerified
+}
+
 func (s *Store) DeleteUserById(userID int) error {
 	// get tasks, animals, and enclosures from userID
 	tRows, err := s.db.Query(`SELECT t."taskId", t."taskName", t."complete", t."lastCompleted", t."repeatIntervHours"
@@ -192,6 +279,8 @@ func scanRowsIntoUser(rows *sql.Rows) (*types.User, error) {
 		&user.Phone,
 		&user.Password,
 		&user.CreatedAt,
+		&user.Timezone,
+		&user.EmailVerifiedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,372 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// The events a webhook can subscribe to. EventTest is only ever sent by
+// POST /webhooks/{id}/test.
+const (
+	EventTaskCreated        = "task.created"
+	EventTaskCompleted      = "task.completed"
+	EventTaskReset          = "task.reset"
+	EventTaskDeleted        = "task.deleted"
+	EventAnimalCreated      = "animal.created"
+	EventAnimalUpdated      = "animal.updated"
+	EventAnimalMemorialized = "animal.memorialized"
+	EventAnimalDeleted      = "animal.deleted"
+	EventEnclosureCreated   = "enclosure.created"
+	EventEnclosureUpdated   = "enclosure.updated"
+	EventEnclosureDeleted   = "enclosure.deleted"
+	EventTest               = "webhook.test"
+)
+
+// The states of a delivery.
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// A delivery is tried up to maxAttempts times, waiting retryDelay between
+// tries, which spreads them over about two days. A webhook is disabled after
+// maxConsecutiveFailures failed attempts in a row, across all its deliveries.
+const (
+	maxAttempts            = 8
+	baseRetryDelay         = time.Minute
+	maxRetryDelay          = 24 * time.Hour
+	maxConsecutiveFailures = 20
+)
+
+const (
+	attemptTimeout = 10 * time.Second
+	// deliveryLease keeps the retry job off a delivery while it is being
+	// sent. It has to outlast an attempt.
+	deliveryLease     = 2 * time.Minute
+	retryBatchSize    = 100
+	deliveryRetention = 30 * 24 * time.Hour
+)
+
+// Every attempt, first or retry, is made by one of deliveryWorkers, taking
+// deliveries from a queue of deliveryQueueSize. The queue is kept short
+// enough to drain well inside deliveryLease, so that a delivery is not
+// claimed for a retry while it is still waiting for its first attempt.
+const (
+	deliveryWorkers   = 8
+	deliveryQueueSize = 64
+)
+
+// Headers sent with every delivery. The delivery ID stays the same across
+// retries, so a receiver can use it to ignore one it has already handled.
+const (
+	HeaderEvent     = "X-AnimalFamily-Event"
+	HeaderDelivery  = "X-AnimalFamily-Delivery"
+	HeaderSignature = "X-AnimalFamily-Signature"
+)
+
+// Dispatcher sends events to the webhooks subscribed to them. A nil
+// Dispatcher sends nothing, so handlers can publish without checking.
+//
+// Handlers publish once their change has been made, so anything that goes
+// wrong from there, including reading back what to send, is logged rather
+// than failing the request: it costs the webhook an event, not the user
+// their change.
+type Dispatcher struct {
+	store  types.WebhookStore
+	client *http.Client
+	now    func() time.Time
+	queue  chan *types.PendingWebhookDelivery
+}
+
+// NewDispatcher refuses to connect to loopback, private and link-local
+// addresses unless allowPrivate is set, so that a webhook URL cannot be used
+// to reach the server's own network.
+func NewDispatcher(store types.WebhookStore, allowPrivate bool) *Dispatcher {
+	dialer := &net.Dialer{Timeout: attemptTimeout}
+	if !allowPrivate {
+		dialer.Control = refusePrivateAddresses
+	}
+
+	d := &Dispatcher{
+		store: store,
+		client: &http.Client{
+			Timeout:   attemptTimeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+			// A redirect would send the signed body somewhere the user did
+			// not register, so the 3xx is recorded as a failure instead.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		now:   time.Now,
+		queue: make(chan *types.PendingWebhookDelivery, deliveryQueueSize),
+	}
+
+	// The workers are not stopped on shutdown. A delivery they had not got
+	// to is still pending in the database, and is retried once its lease is
+	// up.
+	for i := 0; i < deliveryWorkers; i++ {
+		go func() {
+			for delivery := range d.queue {
+				d.deliver(delivery)
+			}
+		}()
+	}
+
+	return d
+}
+
+// envelope is the body of every delivery.
+type envelope struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+// Publish sends event to each of the user's webhooks subscribed to it. It
+// returns at once: the deliveries are queued and sent in the background.
+// When the queue is full they are left for the retry job, which takes them
+// once their lease is up.
+func (d *Dispatcher) Publish(userID int, event string, data any) {
+	if d == nil {
+		return
+	}
+
+	payload, err := json.Marshal(envelope{Event: event, CreatedAt: d.now().UTC(), Data: data})
+	if err != nil {
+		log.Printf("failed to encode %s webhook for user %d: %v", event, userID, err)
+		return
+	}
+
+	go func() {
+		deliveries, err := d.store.CreateWebhookDeliveries(userID, event, string(payload), deliveryLease)
+		if err != nil {
+			log.Printf("failed to queue %s webhooks for user %d: %v", event, userID, err)
+			return
+		}
+
+		for _, delivery := range deliveries {
+			select {
+			case d.queue <- delivery:
+			default:
+				log.Printf("webhook queue is full; delivery %d waits for a retry", delivery.DeliveryId)
+			}
+		}
+	}()
+}
+
+// Subscribers returns which of userIDs have an enabled webhook subscribed to
+// event, so that a caller with many events to publish can skip building
+// them for users who would not be sent them.
+func (d *Dispatcher) Subscribers(userIDs []int, event string) (map[int]bool, error) {
+	if d == nil {
+		return map[int]bool{}, nil
+	}
+
+	return d.store.GetUsersSubscribedTo(userIDs, event)
+}
+
+// SendTest sends a test event to the webhook and waits for the outcome. It
+// is logged like any other delivery but is not retried, and does not count
+// towards disabling the webhook.
+func (d *Dispatcher) SendTest(hook *types.Webhook) (*types.WebhookDelivery, error) {
+	payload, err := json.Marshal(envelope{
+		Event:     EventTest,
+		CreatedAt: d.now().UTC(),
+		Data:      map[string]any{"webhookId": hook.WebhookId},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := d.store.CreateWebhookDelivery(hook.WebhookId, EventTest, string(payload), deliveryLease)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt := d.send(delivery)
+	if err := d.store.RecordWebhookAttempt(delivery.DeliveryId, attempt, nil); err != nil {
+		return nil, err
+	}
+
+	status := StatusFailed
+	if attemptSucceeded(attempt) {
+		status = StatusSucceeded
+	}
+
+	return &types.WebhookDelivery{
+		DeliveryId: delivery.DeliveryId,
+		Event:      EventTest,
+		Payload:    delivery.Payload,
+		Status:     status,
+		CreatedAt:  attempt.CreatedAt,
+		Attempts:   []types.WebhookAttempt{attempt},
+	}, nil
+}
+
+// RetryDue queues the deliveries whose next attempt has come due, and prunes
+// old ones from the log. It is run by the scheduler's periodic call, so
+// retries go out at the first run after they are due. It waits for room in
+// the queue, which keeps a backlog of retries from crowding out new events
+// for longer than one batch.
+func (d *Dispatcher) RetryDue() {
+	if d == nil {
+		return
+	}
+
+	if _, err := d.store.PruneWebhookDeliveries(d.now().Add(-deliveryRetention)); err != nil {
+		log.Printf("failed to prune webhook deliveries: %v", err)
+	}
+
+	deliveries, err := d.store.ClaimDueWebhookDeliveries(deliveryLease, retryBatchSize)
+	if err != nil {
+		log.Printf("failed to claim webhook retries: %v", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		d.queue <- delivery
+	}
+}
+
+// deliver makes one attempt at a delivery and schedules the next, if it
+// failed and has tries left.
+func (d *Dispatcher) deliver(delivery *types.PendingWebhookDelivery) {
+	attempt := d.send(delivery)
+	succeeded := attemptSucceeded(attempt)
+
+	var next *time.Time
+	if !succeeded && delivery.Attempts+1 < maxAttempts {
+		at := d.now().Add(retryDelay(delivery.Attempts + 1))
+		next = &at
+	}
+
+	if err := d.store.RecordWebhookAttempt(delivery.DeliveryId, attempt, next); err != nil {
+		log.Printf("failed to record webhook delivery %d: %v", delivery.DeliveryId, err)
+	}
+
+	disabled, err := d.store.RecordWebhookOutcome(delivery.WebhookId, succeeded, maxConsecutiveFailures)
+	if err != nil {
+		log.Printf("failed to record outcome for webhook %d: %v", delivery.WebhookId, err)
+	}
+	if disabled {
+		log.Printf("disabled webhook %d after %d failed attempts in a row", delivery.WebhookId, maxConsecutiveFailures)
+	}
+}
+
+// send posts the delivery's payload, signed, and reports what came back.
+func (d *Dispatcher) send(delivery *types.PendingWebhookDelivery) types.WebhookAttempt {
+	started := d.now()
+	attempt := types.WebhookAttempt{CreatedAt: started.UTC()}
+
+	fail := func(err error) types.WebhookAttempt {
+		message := err.Error()
+		attempt.Error = &message
+		attempt.DurationMs = int(d.now().Sub(started).Milliseconds())
+		return attempt
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), attemptTimeout)
+	defer cancel()
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "AnimalFamily-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(delivery.DeliveryId))
+	req.Header.Set(HeaderSignature, signature(delivery.Secret, started.Unix(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// Draining a little of the body lets the connection be reused; the rest
+	// is of no interest.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	status := resp.StatusCode
+	attempt.ResponseStatus = &status
+	attempt.DurationMs = int(d.now().Sub(started).Milliseconds())
+
+	return attempt
+}
+
+func attemptSucceeded(attempt types.WebhookAttempt) bool {
+	return attempt.Error == nil && attempt.ResponseStatus != nil && *attempt.ResponseStatus >= 200 && *attempt.ResponseStatus < 300
+}
+
+// retryDelay is how long to wait after the nth failed attempt: a minute,
+// then four times as long each time, up to a day.
+func retryDelay(n int) time.Duration {
+	delay := baseRetryDelay
+	for i := 1; i < n && delay < maxRetryDelay; i++ {
+		delay *= 4
+	}
+
+	return min(delay, maxRetryDelay)
+}
+
+// signature is the value of the signature header: the time of sending and an
+// HMAC-SHA256 of that time and the body, as "t=<unix>,v1=<hex>". Signing the
+// time lets a receiver refuse a delivery replayed long after it was sent.
+func signature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// newSecret returns a signing secret with 256 bits of randomness.
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// refusePrivateAddresses runs once the address has been resolved, so a
+// hostname that points at an internal address is caught as well.
+func refusePrivateAddresses(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
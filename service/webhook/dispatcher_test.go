@@ -0,0 +1,165 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+func TestRetryDelay(t *testing.T) {
+	cases := map[int]time.Duration{
+		1: time.Minute,
+		2: 4 * time.Minute,
+		3: 16 * time.Minute,
+		4: 64 * time.Minute,
+		5: 256 * time.Minute,
+		6: 1024 * time.Minute,
+		7: 24 * time.Hour,
+		8: 24 * time.Hour,
+	}
+
+	for n, want := range cases {
+		if got := retryDelay(n); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", n, got, want)
+		}
+	}
+}
+
+// A receiver checks the signature by computing the HMAC of "<t>.<body>" with
+// its copy of the secret, so the test does the same rather than calling
+// signature twice.
+func TestSignature(t *testing.T) {
+	body := []byte(`{"event":"task.created"}`)
+
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte("1700000000."))
+	mac.Write(body)
+	want := "t=1700000000,v1=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := signature("whsec_test", 1700000000, body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if got := signature("whsec_other", 1700000000, body); got == want {
+		t.Error("signature did not depend on the secret")
+	}
+	if got := signature("whsec_test", 1700000001, body); got == want {
+		t.Error("signature did not depend on the timestamp")
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	cases := map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::248": true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"0.0.0.0":              false,
+		"224.0.0.1":            false,
+	}
+
+	for raw, want := range cases {
+		if got := isPublicIP(net.ParseIP(raw)); got != want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(nil, true)
+	delivery := &types.PendingWebhookDelivery{
+		DeliveryId: 42,
+		WebhookId:  7,
+		URL:        server.URL,
+		Secret:     "whsec_test",
+		Event:      EventTaskCreated,
+		Payload:    `{"event":"task.created"}`,
+	}
+
+	attempt := d.send(delivery)
+	if !attemptSucceeded(attempt) {
+		t.Fatalf("send = %+v, want success", attempt)
+	}
+	if *attempt.ResponseStatus != http.StatusAccepted {
+		t.Errorf("responseStatus = %d, want %d", *attempt.ResponseStatus, http.StatusAccepted)
+	}
+
+	if string(gotBody) != delivery.Payload {
+		t.Errorf("body = %s, want %s", gotBody, delivery.Payload)
+	}
+	if got.Header.Get(HeaderEvent) != EventTaskCreated {
+		t.Errorf("%s = %q", HeaderEvent, got.Header.Get(HeaderEvent))
+	}
+	if got.Header.Get(HeaderDelivery) != strconv.Itoa(delivery.DeliveryId) {
+		t.Errorf("%s = %q", HeaderDelivery, got.Header.Get(HeaderDelivery))
+	}
+
+	var timestamp int64
+	if _, err := fmt.Sscanf(got.Header.Get(HeaderSignature), "t=%d,", &timestamp); err != nil {
+		t.Fatalf("unreadable %s %q: %v", HeaderSignature, got.Header.Get(HeaderSignature), err)
+	}
+	if want := signature(delivery.Secret, timestamp, gotBody); got.Header.Get(HeaderSignature) != want {
+		t.Errorf("%s = %q, want %q", HeaderSignature, got.Header.Get(HeaderSignature), want)
+	}
+}
+
+// Following a redirect would send the signed body to a URL the user never
+// registered, so the redirect itself is the response, and a failed one.
+func TestSendDoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	}))
+	defer target.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	attempt := NewDispatcher(nil, true).send(&types.PendingWebhookDelivery{URL: server.URL, Secret: "whsec_test", Payload: "{}"})
+
+	if followed {
+		t.Error("the redirect was followed")
+	}
+	if attemptSucceeded(attempt) || attempt.ResponseStatus == nil || *attempt.ResponseStatus != http.StatusTemporaryRedirect {
+		t.Errorf("send = %+v, want a failed %d", attempt, http.StatusTemporaryRedirect)
+	}
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the request reached a loopback server")
+	}))
+	defer server.Close()
+
+	attempt := NewDispatcher(nil, false).send(&types.PendingWebhookDelivery{URL: server.URL, Secret: "whsec_test", Payload: "{}"})
+
+	if attempt.Error == nil {
+		t.Errorf("send = %+v, want an error", attempt)
+	}
+}
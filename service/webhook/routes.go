@@ -0,0 +1,423 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/whitallee/animal-family-backend/service/auth"
+	"github.com/whitallee/animal-family-backend/types"
+	"github.com/whitallee/animal-family-backend/utils"
+)
+
+const (
+	defaultDeliveryPageSize = 20
+	maxDeliveryPageSize     = 100
+)
+
+type Handler struct {
+	store        types.WebhookStore
+	userStore    types.UserStore
+	dispatcher   *Dispatcher
+	requireHTTPS bool
+}
+
+// NewHandler only accepts https webhook URLs when requireHTTPS is set, which
+// it is outside of development, where a receiver is often a plain http server
+// on the same machine.
+func NewHandler(store types.WebhookStore, userStore types.UserStore, dispatcher *Dispatcher, requireHTTPS bool) *Handler {
+	return &Handler{store: store, userStore: userStore, dispatcher: dispatcher, requireHTTPS: requireHTTPS}
+}
+
+// RegisterV2Routes mounts the webhook routes. There is no v1 equivalent.
+func (h *Handler) RegisterV2Routes(router *mux.Router) {
+	owned := func(next http.HandlerFunc) http.HandlerFunc {
+		return auth.WithJWTAuth(auth.RequireOwnership("id", h.store.UserOwnsWebhook, next), h.userStore)
+	}
+
+	router.HandleFunc("/webhooks", auth.WithJWTAuth(h.handleListWebhooks, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/webhooks", auth.WithJWTAuth(h.handleCreateWebhook, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/webhooks/{id}", owned(h.handleGetWebhook)).Methods(http.MethodGet)
+	router.HandleFunc("/webhooks/{id}", owned(h.handleUpdateWebhook)).Methods(http.MethodPut)
+	router.HandleFunc("/webhooks/{id}", owned(h.handleDeleteWebhook)).Methods(http.MethodDelete)
+	router.HandleFunc("/webhooks/{id}/secret", owned(h.handleRotateWebhookSecret)).Methods(http.MethodPost)
+	router.HandleFunc("/webhooks/{id}/deliveries", owned(h.handleListWebhookDeliveries)).Methods(http.MethodGet)
+	router.HandleFunc("/webhooks/{id}/test", owned(h.handleTestWebhook)).Methods(http.MethodPost)
+}
+
+// handleListWebhooks godoc
+//
+//	@Id				listWebhooks
+//	@Summary		List the caller's webhooks
+//	@Description	Signing secrets are not included; they are only shown when issued.
+//	@Tags			webhooks
+//	@Produce		json
+//	@Success		200	{array}		types.Webhook
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/webhooks [get]
+func (h *Handler) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	hooks, err := h.store.GetWebhooksByUserId(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, hooks)
+}
+
+// handleCreateWebhook godoc
+//
+//	@Id				createWebhook
+//	@Summary		Register a webhook
+//	@Description	The URL is sent a POST for each of the listed events on the caller's tasks, animals and enclosures. The JSON body is {"event", "createdAt", "data"}, where data is the task, animal or enclosure as the API returns it, or just its ID for a deletion. The X-AnimalFamily-Signature header is "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>." followed by the body, keyed with the secret>". X-AnimalFamily-Delivery identifies the delivery and is the same on each retry. A response other than 2xx, including a redirect, is a failure, and is retried with exponential backoff for about two days. The webhook is disabled after 20 failed attempts in a row. The secret is only returned by this call and POST /webhooks/{id}/secret.
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Param			webhook	body		types.CreateWebhookPayload	true	"Webhook to register"
+//	@Success		201		{object}	types.WebhookSecretResponse
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/webhooks [post]
+func (h *Handler) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetuserIdFromContext(r.Context())
+
+	var payload types.CreateWebhookPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	if err := checkWebhookURL(payload.URL, h.requireHTTPS); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	id, err := h.store.CreateWebhook(types.Webhook{
+		UserID:      userID,
+		URL:         payload.URL,
+		Description: payload.Description,
+		Events:      uniqueEvents(payload.Events),
+		Secret:      secret,
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeWebhookWithSecret(w, http.StatusCreated, id)
+}
+
+// handleGetWebhook godoc
+//
+//	@Id				getWebhook
+//	@Summary		Get one of the caller's webhooks
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id	path		int	true	"Webhook ID"
+//	@Success		200	{object}	types.Webhook
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/webhooks/{id} [get]
+func (h *Handler) handleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	hook, err := h.store.GetWebhookById(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, hook)
+}
+
+// handleUpdateWebhook godoc
+//
+//	@Id				updateWebhook
+//	@Summary		Update one of the caller's webhooks
+//	@Description	A full replace of the URL, description, events and enabled state. Enabling a webhook that was disabled after failing clears its failure count; deliveries that failed while it was disabled are not sent again. The secret is unchanged.
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"Webhook ID"
+//	@Param			webhook	body		types.UpdateWebhookPayload	true	"Updated webhook"
+//	@Success		200		{object}	types.Webhook
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/webhooks/{id} [put]
+func (h *Handler) handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	var payload types.UpdateWebhookPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", validationErrors))
+		return
+	}
+
+	if err := checkWebhookURL(payload.URL, h.requireHTTPS); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := h.store.UpdateWebhook(types.Webhook{
+		WebhookId:   id,
+		URL:         payload.URL,
+		Description: payload.Description,
+		Events:      uniqueEvents(payload.Events),
+		Enabled:     payload.Enabled,
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	hook, err := h.store.GetWebhookById(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, hook)
+}
+
+// handleDeleteWebhook godoc
+//
+//	@Id				deleteWebhook
+//	@Summary		Delete one of the caller's webhooks
+//	@Description	Also deletes its delivery log. Retries still pending are dropped.
+//	@Tags			webhooks
+//	@Param			id	path	int	true	"Webhook ID"
+//	@Success		204
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/webhooks/{id} [delete]
+func (h *Handler) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	if err := h.store.DeleteWebhook(id); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteStatus(w, http.StatusNoContent)
+}
+
+// handleRotateWebhookSecret godoc
+//
+//	@Id				rotateWebhookSecret
+//	@Summary		Issue a new signing secret for a webhook
+//	@Description	The old secret stops being used at once, including for retries of deliveries made before the change. The new secret is only returned by this call.
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id	path		int	true	"Webhook ID"
+//	@Success		200	{object}	types.WebhookSecretResponse
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/webhooks/{id}/secret [post]
+func (h *Handler) handleRotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	secret, err := newSecret()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.SetWebhookSecret(id, secret); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeWebhookWithSecret(w, http.StatusOK, id)
+}
+
+// handleListWebhookDeliveries godoc
+//
+//	@Id				listWebhookDeliveries
+//	@Summary		List what has been sent to a webhook
+//	@Description	Newest first, each with every attempt made at it and the response status it got. Deliveries are kept for 30 days after they finish. Pass nextBefore from one page as before to fetch the next.
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id		path		int	true	"Webhook ID"
+//	@Param			limit	query		int	false	"Page size, 1 to 100"	default(20)
+//	@Param			before	query		int	false	"Only deliveries older than this delivery ID"
+//	@Success		200		{object}	types.WebhookDeliveryListResponse
+//	@Failure		400		{object}	types.ErrorResponse
+//	@Failure		403		{object}	types.ErrorResponse
+//	@Failure		500		{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/webhooks/{id}/deliveries [get]
+func (h *Handler) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	limit, before, err := parseDeliveryPage(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// One more than the page is fetched to tell whether there is another.
+	deliveries, err := h.store.GetWebhookDeliveries(id, limit+1, before)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, deliveryList(deliveries, limit))
+}
+
+// handleTestWebhook godoc
+//
+//	@Id				testWebhook
+//	@Summary		Send a test event to a webhook
+//	@Description	Sends a webhook.test event, signed like any other, and waits up to 10 seconds for the response. Works whether or not the webhook is enabled, so a fixed endpoint can be checked before turning it back on. The attempt is logged but not retried, and does not count towards disabling the webhook. A failed send is still a 200; the delivery's status says how it went.
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id	path		int	true	"Webhook ID"
+//	@Success		200	{object}	types.WebhookDelivery
+//	@Failure		400	{object}	types.ErrorResponse
+//	@Failure		403	{object}	types.ErrorResponse
+//	@Failure		500	{object}	types.ErrorResponse
+//	@Security		BearerAuth
+//	@Router			/webhooks/{id}/test [post]
+func (h *Handler) handleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	id := auth.ResourceIDFromContext(r.Context())
+
+	hook, err := h.store.GetWebhookById(id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	delivery, err := h.dispatcher.SendTest(hook)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, delivery)
+}
+
+func (h *Handler) writeWebhookWithSecret(w http.ResponseWriter, status int, id int) {
+	hook, err := h.store.GetWebhookById(id)
+	if err != nil {
+		if errors.Is(err, ErrWebhookNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, status, types.WebhookSecretResponse{Webhook: *hook, Secret: hook.Secret})
+}
+
+// checkWebhookURL takes what the validator's url check lets through, which
+// includes schemes such as ftp and mailto, down to absolute http(s) URLs.
+func checkWebhookURL(raw string, requireHTTPS bool) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+
+	switch {
+	case u.Scheme != "https" && u.Scheme != "http":
+		return fmt.Errorf("url must be http or https")
+	case requireHTTPS && u.Scheme != "https":
+		return fmt.Errorf("url must be https")
+	case u.Host == "":
+		return fmt.Errorf("url must have a host")
+	case u.User != nil:
+		return fmt.Errorf("url must not contain credentials; check the signature instead")
+	}
+
+	return nil
+}
+
+// uniqueEvents drops repeats, keeping the first of each.
+func uniqueEvents(events []string) []string {
+	seen := make(map[string]bool, len(events))
+	unique := make([]string, 0, len(events))
+	for _, event := range events {
+		if !seen[event] {
+			seen[event] = true
+			unique = append(unique, event)
+		}
+	}
+
+	return unique
+}
+
+func parseDeliveryPage(r *http.Request) (int, *int, error) {
+	query := r.URL.Query()
+	limit := defaultDeliveryPageSize
+
+	if raw := query.Get("limit"); raw != "" {
+		l, err := strconv.Atoi(raw)
+		if err != nil || l < 1 || l > maxDeliveryPageSize {
+			return 0, nil, fmt.Errorf("invalid limit: must be an integer from 1 to %d", maxDeliveryPageSize)
+		}
+		limit = l
+	}
+
+	var before *int
+	if raw := query.Get("before"); raw != "" {
+		b, err := strconv.Atoi(raw)
+		if err != nil || b < 1 {
+			return 0, nil, fmt.Errorf("invalid before: must be a delivery ID")
+		}
+		before = &b
+	}
+
+	return limit, before, nil
+}
+
+// deliveryList trims the extra delivery fetched past the end of the page,
+// whose presence is what says there is another page.
+func deliveryList(deliveries []types.WebhookDelivery, limit int) types.WebhookDeliveryListResponse {
+	response := types.WebhookDeliveryListResponse{Deliveries: deliveries}
+
+	if len(deliveries) > limit {
+		response.Deliveries = deliveries[:limit]
+		next := deliveries[limit-1].DeliveryId
+		response.NextBefore = &next
+	}
+
+	return response
+}
@@ -0,0 +1,358 @@
+package webhook
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/whitallee/animal-family-backend/types"
+)
+
+// ErrWebhookNotFound is returned when no webhook has the ID.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const webhookColumns = `"webhookId", "userId", "url", "description", "events", "secret", "enabled", "consecutiveFailures", "disabledAt", "createdAt"`
+
+func scanWebhook(row interface{ Scan(...any) error }) (*types.Webhook, error) {
+	hook := new(types.Webhook)
+	err := row.Scan(
+		&hook.WebhookId,
+		&hook.UserID,
+		&hook.URL,
+		&hook.Description,
+		pq.Array(&hook.Events),
+		&hook.Secret,
+		&hook.Enabled,
+		&hook.ConsecutiveFailures,
+		&hook.DisabledAt,
+		&hook.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+func (s *Store) UserOwnsWebhook(webhookId int, userID int) (bool, error) {
+	var owned bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM "webhooks" WHERE "webhookId" = $1 AND "userId" = $2)`,
+		webhookId, userID).Scan(&owned)
+
+	return owned, err
+}
+
+func (s *Store) GetWebhooksByUserId(userID int) ([]*types.Webhook, error) {
+	rows, err := s.db.Query(`SELECT `+webhookColumns+` FROM "webhooks" WHERE "userId" = $1 ORDER BY "webhookId"`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	hooks := make([]*types.Webhook, 0)
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, rows.Err()
+}
+
+func (s *Store) GetWebhookById(webhookId int) (*types.Webhook, error) {
+	hook, err := scanWebhook(s.db.QueryRow(`SELECT `+webhookColumns+` FROM "webhooks" WHERE "webhookId" = $1`, webhookId))
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+func (s *Store) CreateWebhook(hook types.Webhook) (int, error) {
+	var webhookId int
+	err := s.db.QueryRow(`INSERT INTO "webhooks" ("userId", "url", "description", "events", "secret")
+						VALUES ($1, $2, $3, $4, $5) RETURNING "webhookId"`,
+		hook.UserID, hook.URL, hook.Description, pq.Array(hook.Events), hook.Secret).Scan(&webhookId)
+
+	return webhookId, err
+}
+
+func (s *Store) UpdateWebhook(hook types.Webhook) error {
+	_, err := s.db.Exec(`UPDATE "webhooks" SET "url" = $2, "description" = $3, "events" = $4, "enabled" = $5,
+							"consecutiveFailures" = CASE WHEN $5 THEN 0 ELSE "consecutiveFailures" END,
+							"disabledAt" = CASE WHEN $5 THEN NULL ELSE "disabledAt" END
+						WHERE "webhookId" = $1`,
+		hook.WebhookId, hook.URL, hook.Description, pq.Array(hook.Events), hook.Enabled)
+
+	return err
+}
+
+func (s *Store) SetWebhookSecret(webhookId int, secret string) error {
+	_, err := s.db.Exec(`UPDATE "webhooks" SET "secret" = $2 WHERE "webhookId" = $1`, webhookId, secret)
+	return err
+}
+
+func (s *Store) DeleteWebhook(webhookId int) error {
+	_, err := s.db.Exec(`DELETE FROM "webhooks" WHERE "webhookId" = $1`, webhookId)
+	return err
+}
+
+func (s *Store) CreateWebhookDeliveries(userID int, event string, payload string, lease time.Duration) ([]*types.PendingWebhookDelivery, error) {
+	rows, err := s.db.Query(`WITH created AS (
+								INSERT INTO "webhookDeliveries" ("webhookId", "event", "payload", "nextAttemptAt")
+								SELECT "webhookId", $2, $3, NOW() AT TIME ZONE 'UTC' + make_interval(secs => $4)
+								FROM "webhooks"
+								WHERE "userId" = $1 AND "enabled" AND $2 = ANY("events")
+								RETURNING "deliveryId", "webhookId"
+							)
+							SELECT c."deliveryId", c."webhookId", w."url", w."secret"
+							FROM created c INNER JOIN "webhooks" w ON w."webhookId" = c."webhookId"`,
+		userID, event, payload, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	deliveries := make([]*types.PendingWebhookDelivery, 0)
+	for rows.Next() {
+		d := &types.PendingWebhookDelivery{Event: event, Payload: payload}
+		if err := rows.Scan(&d.DeliveryId, &d.WebhookId, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (s *Store) GetUsersSubscribedTo(userIDs []int, event string) (map[int]bool, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT "userId" FROM "webhooks"
+							WHERE "userId" = ANY($1) AND "enabled" AND $2 = ANY("events")`,
+		pq.Array(userIDs), event)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	subscribed := make(map[int]bool)
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		subscribed[userID] = true
+	}
+
+	return subscribed, rows.Err()
+}
+
+func (s *Store) CreateWebhookDelivery(webhookId int, event string, payload string, lease time.Duration) (*types.PendingWebhookDelivery, error) {
+	d := &types.PendingWebhookDelivery{WebhookId: webhookId, Event: event, Payload: payload}
+	err := s.db.QueryRow(`WITH created AS (
+							INSERT INTO "webhookDeliveries" ("webhookId", "event", "payload", "nextAttemptAt")
+							VALUES ($1, $2, $3, NOW() AT TIME ZONE 'UTC' + make_interval(secs => $4))
+							RETURNING "deliveryId", "webhookId"
+						)
+						SELECT c."deliveryId", w."url", w."secret"
+						FROM created c INNER JOIN "webhooks" w ON w."webhookId" = c."webhookId"`,
+		webhookId, event, payload, lease.Seconds()).Scan(&d.DeliveryId, &d.URL, &d.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// ClaimDueWebhookDeliveries skips rows another claim has locked rather than
+// waiting on them, so overlapping runs share the work instead of repeating it.
+func (s *Store) ClaimDueWebhookDeliveries(lease time.Duration, limit int) ([]*types.PendingWebhookDelivery, error) {
+	rows, err := s.db.Query(`WITH due AS (
+								SELECT d."deliveryId" FROM "webhookDeliveries" d
+								INNER JOIN "webhooks" w ON w."webhookId" = d."webhookId"
+								WHERE d."status" = 'pending' AND w."enabled" AND d."nextAttemptAt" <= NOW() AT TIME ZONE 'UTC'
+								ORDER BY d."nextAttemptAt"
+								LIMIT $2
+								FOR UPDATE OF d SKIP LOCKED
+							),
+							claimed AS (
+								UPDATE "webhookDeliveries" d SET "nextAttemptAt" = NOW() AT TIME ZONE 'UTC' + make_interval(secs => $1)
+								FROM due WHERE d."deliveryId" = due."deliveryId"
+								RETURNING d."deliveryId", d."webhookId", d."event", d."payload", d."attempts"
+							)
+							SELECT c."deliveryId", c."webhookId", w."url", w."secret", c."event", c."payload", c."attempts"
+							FROM claimed c INNER JOIN "webhooks" w ON w."webhookId" = c."webhookId"
+							ORDER BY c."deliveryId"`,
+		lease.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	deliveries := make([]*types.PendingWebhookDelivery, 0)
+	for rows.Next() {
+		d := new(types.PendingWebhookDelivery)
+		if err := rows.Scan(&d.DeliveryId, &d.WebhookId, &d.URL, &d.Secret, &d.Event, &d.Payload, &d.Attempts); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (s *Store) RecordWebhookAttempt(deliveryId int, attempt types.WebhookAttempt, nextAttemptAt *time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`INSERT INTO "webhookAttempts" ("deliveryId", "responseStatus", "error", "durationMs", "createdAt")
+						VALUES ($1, $2, $3, $4, NOW() AT TIME ZONE 'UTC')`,
+		deliveryId, attempt.ResponseStatus, attempt.Error, attempt.DurationMs); err != nil {
+		return err
+	}
+
+	status := StatusFailed
+	switch {
+	case attemptSucceeded(attempt):
+		status, nextAttemptAt = StatusSucceeded, nil
+	case nextAttemptAt != nil:
+		status = StatusPending
+		utc := nextAttemptAt.UTC()
+		nextAttemptAt = &utc
+	}
+
+	if _, err := tx.Exec(`UPDATE "webhookDeliveries" SET "attempts" = "attempts" + 1, "status" = $2, "nextAttemptAt" = $3
+						WHERE "deliveryId" = $1`, deliveryId, status, nextAttemptAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) RecordWebhookOutcome(webhookId int, succeeded bool, maxFailures int) (bool, error) {
+	if succeeded {
+		_, err := s.db.Exec(`UPDATE "webhooks" SET "consecutiveFailures" = 0 WHERE "webhookId" = $1`, webhookId)
+		return false, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var disabled bool
+	err = tx.QueryRow(`WITH old AS (
+							SELECT "webhookId", "enabled" FROM "webhooks" WHERE "webhookId" = $1 FOR UPDATE
+						)
+						UPDATE "webhooks" w SET "consecutiveFailures" = w."consecutiveFailures" + 1,
+							"enabled" = w."enabled" AND w."consecutiveFailures" + 1 < $2,
+							"disabledAt" = CASE WHEN w."enabled" AND w."consecutiveFailures" + 1 >= $2 THEN NOW() AT TIME ZONE 'UTC' ELSE w."disabledAt" END
+						FROM old
+						WHERE w."webhookId" = old."webhookId"
+						RETURNING old."enabled" AND NOT w."enabled"`, webhookId, maxFailures).Scan(&disabled)
+	if err == sql.ErrNoRows {
+		// Deleted while the attempt was in flight.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Nothing is sent to a disabled webhook, so what is still queued for it
+	// would otherwise sit pending until it was turned back on, and then go
+	// out long after the events it describes.
+	if disabled {
+		if _, err := tx.Exec(`UPDATE "webhookDeliveries" SET "status" = $2, "nextAttemptAt" = NULL
+							WHERE "webhookId" = $1 AND "status" = $3`, webhookId, StatusFailed, StatusPending); err != nil {
+			return false, err
+		}
+	}
+
+	return disabled, tx.Commit()
+}
+
+func (s *Store) GetWebhookDeliveries(webhookId int, limit int, before *int) ([]types.WebhookDelivery, error) {
+	rows, err := s.db.Query(`SELECT "deliveryId", "event", "payload", "status", "nextAttemptAt", "createdAt"
+							FROM "webhookDeliveries"
+							WHERE "webhookId" = $1 AND ($3::int IS NULL OR "deliveryId" < $3)
+							ORDER BY "deliveryId" DESC
+							LIMIT $2`, webhookId, limit, before)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	deliveries := make([]types.WebhookDelivery, 0)
+	index := make(map[int]int)
+	ids := make([]int, 0)
+	for rows.Next() {
+		d := types.WebhookDelivery{Attempts: []types.WebhookAttempt{}}
+		if err := rows.Scan(&d.DeliveryId, &d.Event, &d.Payload, &d.Status, &d.NextAttemptAt, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		index[d.DeliveryId] = len(deliveries)
+		ids = append(ids, d.DeliveryId)
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return deliveries, nil
+	}
+
+	attempts, err := s.db.Query(`SELECT "deliveryId", "responseStatus", "error", "durationMs", "createdAt"
+								FROM "webhookAttempts" WHERE "deliveryId" = ANY($1)
+								ORDER BY "attemptId"`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = attempts.Close() }()
+
+	for attempts.Next() {
+		var deliveryId int
+		var a types.WebhookAttempt
+		if err := attempts.Scan(&deliveryId, &a.ResponseStatus, &a.Error, &a.DurationMs, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		i, ok := index[deliveryId]
+		if !ok {
+			return nil, fmt.Errorf("attempt for unexpected delivery %d", deliveryId)
+		}
+		deliveries[i].Attempts = append(deliveries[i].Attempts, a)
+	}
+
+	return deliveries, attempts.Err()
+}
+
+// PruneWebhookDeliveries removes finished deliveries created before the
+// cutoff, along with their attempts. Pending ones are kept until they finish.
+func (s *Store) PruneWebhookDeliveries(before time.Time) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM "webhookDeliveries" WHERE "status" <> $2 AND "createdAt" < $1`,
+		before.UTC(), StatusPending)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
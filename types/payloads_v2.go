@@ -30,6 +30,9 @@ type CreateEnclosureV2Payload struct {
 	Image         string `json:"image"`
 	Notes         string `json:"notes"`
 	AnimalIds     []int  `json:"animalIds" validate:"omitempty,dive,min=1"`
+	// ApplyCareTemplates creates a task from each of the habitat's care
+	// templates along with the enclosure.
+	ApplyCareTemplates bool `json:"applyCareTemplates"`
 }
 
 // UpdateEnclosureV2Payload is the body of PUT /enclosures/{id}.
@@ -41,17 +44,22 @@ type UpdateEnclosureV2Payload struct {
 }
 
 // CreateAnimalV2Payload is the body of POST /animals.
+//
+// ApplyCareTemplates creates a task from each of the species' care templates
+// that fits the animal's age along with the animal. Without a dob only
+// templates that do not depend on age are used.
 type CreateAnimalV2Payload struct {
-	AnimalName      string    `json:"animalName" validate:"required"`
-	SpeciesId       int       `json:"speciesId" validate:"required,min=1"`
-	EnclosureId     *int      `json:"enclosureId" validate:"omitempty,min=1" extensions:"x-nullable"`
-	Image           string    `json:"image"`
-	Gender          string    `json:"gender"`
-	Dob             time.Time `json:"dob"`
-	PersonalityDesc string    `json:"personalityDesc"`
-	DietDesc        string    `json:"dietDesc"`
-	RoutineDesc     string    `json:"routineDesc"`
-	ExtraNotes      string    `json:"extraNotes"`
+	AnimalName         string    `json:"animalName" validate:"required"`
+	SpeciesId          int       `json:"speciesId" validate:"required,min=1"`
+	EnclosureId        *int      `json:"enclosureId" validate:"omitempty,min=1" extensions:"x-nullable"`
+	Image              string    `json:"image"`
+	Gender             string    `json:"gender"`
+	Dob                time.Time `json:"dob"`
+	PersonalityDesc    string    `json:"personalityDesc"`
+	DietDesc           string    `json:"dietDesc"`
+	RoutineDesc        string    `json:"routineDesc"`
+	ExtraNotes         string    `json:"extraNotes"`
+	ApplyCareTemplates bool      `json:"applyCareTemplates"`
 }
 
 // UpdateAnimalV2Payload is the body of PUT /animals/{id}.
@@ -104,12 +112,26 @@ type SetAnimalMemorialPayload struct {
 // integer 0, which the store then translated to SQL NULL; v2 uses null
 // directly, matching how the subject already comes back on TaskWithSubject.
 // Supplying both or neither is rejected.
+//
+// With resetTime set, the task resets at that local time of day in the
+// owner's timezone every repeatIntervHours / 24 days, instead of
+// repeatIntervHours after each completion.
 type CreateTaskV2Payload struct {
-	TaskName          string `json:"taskName" validate:"required"`
-	TaskDesc          string `json:"taskDesc" validate:"required"`
-	RepeatIntervHours int    `json:"repeatIntervHours" validate:"required,min=1"`
-	AnimalId          *int   `json:"animalId" validate:"omitempty,min=1" extensions:"x-nullable"`
-	EnclosureId       *int   `json:"enclosureId" validate:"omitempty,min=1" extensions:"x-nullable"`
+	TaskName          string   `json:"taskName" validate:"required"`
+	TaskDesc          string   `json:"taskDesc" validate:"required"`
+	RepeatIntervHours int      `json:"repeatIntervHours" validate:"required,min=1"`
+	AnimalId          *int     `json:"animalId" validate:"omitempty,min=1" extensions:"x-nullable"`
+	EnclosureId       *int     `json:"enclosureId" validate:"omitempty,min=1" extensions:"x-nullable"`
+	Priority          string   `json:"priority" validate:"omitempty,oneof=low normal high" enums:"low,normal,high" default:"normal"`
+	Tags              []string `json:"tags" validate:"max=20,dive,required,max=32"`
+	ResetTime         *string  `json:"resetTime" validate:"omitempty,datetime=15:04" example:"06:00" extensions:"x-nullable"`
+	ExpectedMinutes   *int     `json:"expectedMinutes" validate:"omitempty,min=1,max=1440" extensions:"x-nullable"`
+}
+
+// ParseTasksPayload is the body of POST /tasks/parse: a description of one or
+// more tasks in the user's own words.
+type ParseTasksPayload struct {
+	Text string `json:"text" validate:"required,max=1000" example:"feed Rex a medium rat every 10 days at 7pm"`
 }
 
 // UpdateTaskV2Payload is the body of PUT /tasks/{id}.
@@ -121,7 +143,9 @@ type CreateTaskV2Payload struct {
 // PUT is a full replace, so exactly one subject must be supplied on every
 // update rather than only when moving the task. Omitting it is rejected, which
 // is a loud failure rather than the silent "leave it alone" that would
-// otherwise reintroduce implicit preservation.
+// otherwise reintroduce implicit preservation. The same goes for priority,
+// tags, resetTime and expectedMinutes: omitting them resets the task to normal
+// priority, no tags, hour-based resets and no expected duration.
 type UpdateTaskV2Payload struct {
 	TaskName          string    `json:"taskName" validate:"required"`
 	TaskDesc          string    `json:"taskDesc" validate:"required"`
@@ -130,6 +154,110 @@ type UpdateTaskV2Payload struct {
 	RepeatIntervHours int       `json:"repeatIntervHours" validate:"required,min=1"`
 	AnimalId          *int      `json:"animalId" validate:"omitempty,min=1" extensions:"x-nullable"`
 	EnclosureId       *int      `json:"enclosureId" validate:"omitempty,min=1" extensions:"x-nullable"`
+	Priority          string    `json:"priority" validate:"omitempty,oneof=low normal high" enums:"low,normal,high" default:"normal"`
+	Tags              []string  `json:"tags" validate:"max=20,dive,required,max=32"`
+	ResetTime         *string   `json:"resetTime" validate:"omitempty,datetime=15:04" example:"06:00" extensions:"x-nullable"`
+	ExpectedMinutes   *int      `json:"expectedMinutes" validate:"omitempty,min=1,max=1440" extensions:"x-nullable"`
+}
+
+// SnoozeTaskPayload is the body of POST /tasks/{id}/snooze.
+//
+// The duration is counted from whichever is later, now or the time the task is
+// currently due, so snoozing a task that is not yet due pushes it back by the
+// full amount instead of being swallowed by the time left before it was due
+// anyway.
+type SnoozeTaskPayload struct {
+	DurationMinutes int `json:"durationMinutes" validate:"required,min=1,max=43200"`
+}
+
+// CompleteTaskPayload is the body of POST /tasks/{id}/complete. Without
+// durationMinutes the time on the task's running timer is recorded, if it has
+// one.
+type CompleteTaskPayload struct {
+	DurationMinutes *int `json:"durationMinutes" validate:"omitempty,min=0,max=1440" extensions:"x-nullable"`
+}
+
+// BulkTaskPayload is the body of POST /tasks/bulk.
+//
+// In the default atomic mode either every operation is applied or none is. In
+// bestEffort mode each operation stands alone and failures are only reported.
+type BulkTaskPayload struct {
+	Mode       string              `json:"mode" validate:"omitempty,oneof=atomic bestEffort" enums:"atomic,bestEffort"`
+	Operations []BulkTaskOperation `json:"operations" validate:"required,min=1,max=100,dive"`
+}
+
+// BulkTaskOperation is one step of a bulk request. Operations run in the order
+// given, so a later one sees the effect of an earlier one on the same task.
+//
+// lastCompleted is read only by setLastCompleted, and animalId / enclosureId
+// only by reassign, which needs exactly one of them.
+type BulkTaskOperation struct {
+	Op            string     `json:"op" validate:"required,oneof=complete uncomplete setLastCompleted delete reassign" enums:"complete,uncomplete,setLastCompleted,delete,reassign"`
+	TaskId        int        `json:"taskId" validate:"required,min=1"`
+	LastCompleted *time.Time `json:"lastCompleted" extensions:"x-nullable"`
+	AnimalId      *int       `json:"animalId" validate:"omitempty,min=1" extensions:"x-nullable"`
+	EnclosureId   *int       `json:"enclosureId" validate:"omitempty,min=1" extensions:"x-nullable"`
+}
+
+// CreateTaskItemPayload is the body of POST /tasks/{id}/items. New items go
+// to the end of the checklist.
+type CreateTaskItemPayload struct {
+	Text string `json:"text" validate:"required,max=255"`
+}
+
+// UpdateTaskItemPayload is the body of PUT /tasks/{id}/items/{itemId}.
+type UpdateTaskItemPayload struct {
+	Text string `json:"text" validate:"required,max=255"`
+	Done bool   `json:"done"`
+}
+
+// ReorderTaskItemsPayload is the body of PUT /tasks/{id}/items/order. It lists
+// every item of the task, in the new order.
+type ReorderTaskItemsPayload struct {
+	ItemIds []int `json:"itemIds" validate:"required,min=1,dive,min=1"`
+}
+
+// SetIntervalStepsPayload is the body of PUT /tasks/{id}/interval-steps. Steps
+// are in age order, and only the last one omits untilAgeMonths. No steps puts
+// the task back on its fixed interval.
+type SetIntervalStepsPayload struct {
+	Steps []IntervalStepPayload `json:"steps" validate:"max=10,dive"`
+}
+
+type IntervalStepPayload struct {
+	UntilAgeMonths *int `json:"untilAgeMonths" validate:"omitempty,min=1" example:"6" extensions:"x-nullable"`
+	IntervalHours  int  `json:"intervalHours" validate:"required,min=1" example:"24"`
+}
+
+// SetTaskEscalationPayload is the body of PUT /tasks/{id}/escalation. It
+// replaces the whole policy; empty lists turn escalation off. BackupUserIds
+// keeps backups who have already accepted, and BackupEmails invites new ones
+// or keeps their invites pending.
+type SetTaskEscalationPayload struct {
+	BackupUserIds []int                   `json:"backupUserIds" validate:"max=10"`
+	BackupEmails  []string                `json:"backupEmails" validate:"max=10,dive,required,email"`
+	Rules         []EscalationRulePayload `json:"rules" validate:"max=5,dive"`
+}
+
+type EscalationRulePayload struct {
+	// A month is as long as a task can sensibly go undone before anyone hears.
+	AfterHours int    `json:"afterHours" validate:"required,min=1,max=720" example:"4"`
+	Audience   string `json:"audience" validate:"required,oneof=backups everyone" enums:"backups,everyone"`
+	Urgency    string `json:"urgency" validate:"required,oneof=normal high" enums:"normal,high"`
+}
+
+// CareTemplatePayload is the body of POST /care-templates and
+// PUT /care-templates/{id}. A template belongs to exactly one of a species or
+// a habitat; supplying both or neither is rejected.
+type CareTemplatePayload struct {
+	SpeciesId         *int   `json:"speciesId" validate:"omitempty,min=1" extensions:"x-nullable"`
+	HabitatId         *int   `json:"habitatId" validate:"omitempty,min=1" extensions:"x-nullable"`
+	TaskName          string `json:"taskName" validate:"required"`
+	TaskDesc          string `json:"taskDesc" validate:"required"`
+	RepeatIntervHours int    `json:"repeatIntervHours" validate:"required,min=1"`
+	LifeStage         string `json:"lifeStage"`
+	MinAgeMonths      *int   `json:"minAgeMonths" validate:"omitempty,min=0" extensions:"x-nullable"`
+	MaxAgeMonths      *int   `json:"maxAgeMonths" validate:"omitempty,min=1" extensions:"x-nullable"`
 }
 
 // UpdateSpeciesV2Payload is the body of PUT /species/{id}.
@@ -148,3 +276,92 @@ type UpdateSpeciesV2Payload struct {
 	ConservationStatus string `json:"conservationStatus" validate:"required"`
 	ExtraCare          string `json:"extraCare" validate:"required"`
 }
+
+// UpdateTimezonePayload is the body of PUT /users/me/timezone. The value is an
+// IANA zone name such as "Europe/London"; fixed offsets like "+01:00" are
+// rejected because they cannot follow daylight saving changes.
+type UpdateTimezonePayload struct {
+	Timezone string `json:"timezone" validate:"required,timezone" example:"Europe/London"`
+}
+
+// CreatePausePayload is the body of POST /pauses. Exactly one of taskId,
+// animalId and enclosureId names what to pause. startsAt defaults to now, and
+// without endsAt the pause lasts until it is ended.
+type CreatePausePayload struct {
+	TaskId      *int       `json:"taskId" validate:"omitempty,min=1" extensions:"x-nullable"`
+	AnimalId    *int       `json:"animalId" validate:"omitempty,min=1" extensions:"x-nullable"`
+	EnclosureId *int       `json:"enclosureId" validate:"omitempty,min=1" extensions:"x-nullable"`
+	Reason      string     `json:"reason" validate:"required,max=255" example:"brumation"`
+	StartsAt    *time.Time `json:"startsAt" extensions:"x-nullable"`
+	EndsAt      *time.Time `json:"endsAt" extensions:"x-nullable"`
+}
+
+// SetNotificationPreferencesPayload is the body of PUT
+// /notifications/preferences. PUT is a full replace: a channel that is not
+// listed goes back to its default, and omitting the quiet hours removes them.
+// Quiet hours need both ends, and a start equal to the end is rejected rather
+// than read as all day or never.
+type SetNotificationPreferencesPayload struct {
+	Channels        []NotificationChannelPayload `json:"channels" validate:"dive"`
+	QuietHoursStart *string                      `json:"quietHoursStart" validate:"omitempty,datetime=15:04" example:"22:00" extensions:"x-nullable"`
+	QuietHoursEnd   *string                      `json:"quietHoursEnd" validate:"omitempty,datetime=15:04" example:"07:00" extensions:"x-nullable"`
+	DigestEnabled   bool                         `json:"digestEnabled"`
+	DigestMinutes   int                          `json:"digestMinutes" validate:"omitempty,min=5,max=1440" default:"60"`
+}
+
+type NotificationChannelPayload struct {
+	Event   string `json:"event" validate:"required,oneof=taskReset taskEscalation" enums:"taskReset,taskEscalation"`
+	Channel string `json:"channel" validate:"required,oneof=push email sms" enums:"push,email,sms"`
+	Enabled bool   `json:"enabled"`
+}
+
+// RegisterDevicePayload is the body of POST /notifications/devices, which
+// registers a native app's FCM or APNs device token. Browsers register
+// through POST /notifications/subscribe, which takes their encryption keys.
+type RegisterDevicePayload struct {
+	Provider string `json:"provider" validate:"required,oneof=fcm apns" enums:"fcm,apns"`
+	Token    string `json:"token" validate:"required,max=4096"`
+}
+
+// UpdateDevicePayload is the body of PUT /notifications/devices/{id}. PUT is
+// a full replace: a null mutedUntil unmutes the device, and a null subjects
+// lets it alert for everything again. An empty subjects is allowed, and keeps
+// the device for tests only.
+type UpdateDevicePayload struct {
+	Name       string                 `json:"name" validate:"max=64" example:"Kitchen tablet"`
+	MutedUntil *time.Time             `json:"mutedUntil" extensions:"x-nullable"`
+	Subjects   *DeviceSubjectsPayload `json:"subjects" extensions:"x-nullable"`
+}
+
+// DeviceSubjectsPayload names the animals and enclosures a device alerts for.
+// An enclosure covers the animals in it.
+type DeviceSubjectsPayload struct {
+	AnimalIds    []int `json:"animalIds" validate:"max=100,dive,min=1"`
+	EnclosureIds []int `json:"enclosureIds" validate:"max=100,dive,min=1"`
+}
+
+// SetPhonePayload is the body of PUT /users/me/phone. Numbers are E.164,
+// with the country code.
+type SetPhonePayload struct {
+	Phone string `json:"phone" validate:"required,e164" example:"+15555550100"`
+}
+
+type VerifyPhonePayload struct {
+	Code string `json:"code" validate:"required,len=6,numeric" example:"123456"`
+}
+
+// CreateWebhookPayload is the body of POST /webhooks.
+type CreateWebhookPayload struct {
+	URL         string   `json:"url" validate:"required,url,max=2048" example:"https://example.com/hooks/animal-family"`
+	Description string   `json:"description" validate:"max=255"`
+	Events      []string `json:"events" validate:"required,min=1,dive,oneof=task.created task.completed task.reset task.deleted animal.created animal.updated animal.memorialized animal.deleted enclosure.created enclosure.updated enclosure.deleted" enums:"task.created,task.completed,task.reset,task.deleted,animal.created,animal.updated,animal.memorialized,animal.deleted,enclosure.created,enclosure.updated,enclosure.deleted"`
+}
+
+// UpdateWebhookPayload is the body of PUT /webhooks/{id}, a full replace.
+// Setting enabled turns a webhook that was disabled after failing back on.
+type UpdateWebhookPayload struct {
+	URL         string   `json:"url" validate:"required,url,max=2048" example:"https://example.com/hooks/animal-family"`
+	Description string   `json:"description" validate:"max=255"`
+	Events      []string `json:"events" validate:"required,min=1,dive,oneof=task.created task.completed task.reset task.deleted animal.created animal.updated animal.memorialized animal.deleted enclosure.created enclosure.updated enclosure.deleted" enums:"task.created,task.completed,task.reset,task.deleted,animal.created,animal.updated,animal.memorialized,animal.deleted,enclosure.created,enclosure.updated,enclosure.deleted"`
+	Enabled     bool     `json:"enabled"`
+}
@@ -36,15 +36,21 @@ type UserResponse struct {
 	Email     string    `json:"email"`
 	Phone     *string   `json:"phone" extensions:"x-nullable"`
 	CreatedAt time.Time `json:"createdAt"`
+	Timezone  string    `json:"timezone" example:"Europe/London"`
+	// EmailVerified is false until the link emailed at registration is
+	// followed. No other email is sent to the address before then.
+	EmailVerified bool `json:"emailVerified"`
 }
 
 func NewUserResponse(u *User) UserResponse {
 	response := UserResponse{
-		Id:        u.ID,
-		FirstName: u.FirstName,
-		LastName:  u.LastName,
-		Email:     u.Email,
-		CreatedAt: u.CreatedAt,
+		Id:            u.ID,
+		FirstName:     u.FirstName,
+		LastName:      u.LastName,
+		Email:         u.Email,
+		CreatedAt:     u.CreatedAt,
+		Timezone:      u.Timezone,
+		EmailVerified: u.EmailVerifiedAt.Valid,
 	}
 
 	if u.Phone.Valid {
@@ -67,38 +73,233 @@ type MessageResponse struct {
 	Message string `json:"message" example:"subscription created successfully"`
 }
 
-// TaskCompletionResponse reports how many repeating tasks were reset.
+// ParseTasksResponse holds the tasks read from free text. Nothing has been
+// created: the client shows the proposals for confirmation and posts the ones
+// the user accepts to POST /tasks.
+type ParseTasksResponse struct {
+	Proposals []TaskProposal `json:"proposals"`
+}
+
+// TaskProposal is one task read from free text. Task is ready to post as it
+// is when Problems is empty; otherwise each problem names what the user has
+// to fill in or correct first. SubjectName is the name of the animal or
+// enclosure the task was matched to, or empty if none was.
+type TaskProposal struct {
+	Task        CreateTaskV2Payload `json:"task"`
+	SubjectName string              `json:"subjectName"`
+	Problems    []string            `json:"problems"`
+}
+
+// TaskOccurrencesResponse is the care calendar for a window, in order of due
+// time.
+type TaskOccurrencesResponse struct {
+	From        time.Time        `json:"from"`
+	To          time.Time        `json:"to"`
+	Occurrences []TaskOccurrence `json:"occurrences"`
+}
+
+// TaskCompletionResponse reports how many repeating tasks were reset, and how
+// many tasks left undone were escalated.
 type TaskCompletionResponse struct {
-	TasksReset int `json:"tasksReset"`
+	TasksReset     int `json:"tasksReset"`
+	TasksEscalated int `json:"tasksEscalated"`
+}
+
+// CalendarFeedResponse carries a newly issued calendar feed address. The token
+// inside it is only ever returned here; the server keeps a hash, so a lost URL
+// has to be replaced by issuing a new one.
+type CalendarFeedResponse struct {
+	Url       string `json:"url" example:"https://api.example.com/api/v2/calendar/3q2-7wEjR0B9yT0e5Zc1Jg.ics"`
+	WebcalUrl string `json:"webcalUrl" example:"webcal://api.example.com/api/v2/calendar/3q2-7wEjR0B9yT0e5Zc1Jg.ics"`
+}
+
+// BulkTaskResponse reports the outcome of every operation in a bulk request,
+// in request order.
+type BulkTaskResponse struct {
+	Applied int              `json:"applied"`
+	Failed  int              `json:"failed"`
+	Results []BulkTaskResult `json:"results"`
+}
+
+// BulkTaskResult is the outcome of one bulk operation. notApplied means the
+// operation itself was fine but an atomic batch was abandoned because another
+// one failed.
+type BulkTaskResult struct {
+	Index  int     `json:"index"`
+	TaskId int     `json:"taskId"`
+	Op     string  `json:"op"`
+	Status string  `json:"status" enums:"applied,failed,notApplied"`
+	Error  *string `json:"error" extensions:"x-nullable"`
+}
+
+// AdherenceResponse is the body of GET /stats/adherence, split by level.
+type AdherenceResponse struct {
+	From         time.Time        `json:"from"`
+	To           time.Time        `json:"to"`
+	GraceMinutes int              `json:"graceMinutes"`
+	Tasks        []AdherenceStats `json:"tasks"`
+	Animals      []AdherenceStats `json:"animals"`
+	Enclosures   []AdherenceStats `json:"enclosures"`
+}
+
+// TimeStatsResponse is the body of GET /stats/time. Minutes only count
+// completions with a recorded duration; untimedCompletions is how many in the
+// window had none.
+type TimeStatsResponse struct {
+	From               time.Time   `json:"from"`
+	To                 time.Time   `json:"to"`
+	TotalMinutes       int         `json:"totalMinutes"`
+	Completions        int         `json:"completions"`
+	UntimedCompletions int         `json:"untimedCompletions"`
+	Animals            []TimeTotal `json:"animals"`
+	Enclosures         []TimeTotal `json:"enclosures"`
+	Users              []TimeTotal `json:"users"`
+	Weeks              []WeekTime  `json:"weeks"`
+	Workload           Workload    `json:"workload"`
+}
+
+// NotificationListResponse is one page of GET /notifications. nextBefore is
+// the value of before that fetches the next page, or null on the last one.
+// unreadCount covers all of the caller's notifications, not just this page.
+type NotificationListResponse struct {
+	Notifications []Notification `json:"notifications"`
+	UnreadCount   int            `json:"unreadCount"`
+	NextBefore    *int           `json:"nextBefore" extensions:"x-nullable"`
+}
+
+// NotificationsReadResponse is the body of POST /notifications/read-all.
+type NotificationsReadResponse struct {
+	Marked int `json:"marked"`
+}
+
+// WebhookSecretResponse carries a webhook with its signing secret, which is
+// only ever returned when it is issued: by POST /webhooks and POST
+// /webhooks/{id}/secret.
+type WebhookSecretResponse struct {
+	Webhook
+	Secret string `json:"secret" example:"whsec_Zx3kQ9v1b2N8mT0pLw7sYd4fR6hJ5cUe"`
+}
+
+// WebhookDeliveryListResponse is one page of GET /webhooks/{id}/deliveries.
+// nextBefore is the value of before that fetches the next page, or null on
+// the last one.
+type WebhookDeliveryListResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	NextBefore *int              `json:"nextBefore" extensions:"x-nullable"`
 }
 
-// PushSubscriptionResponse describes a registered push subscription.
+// PushSubscriptionResponse describes a registered web push subscription.
+//
+// It deliberately omits the p256dh and auth keys that DeviceRegistration
+// carries. Those exist to encrypt payloads for the endpoint and nothing outside
+// the sender needs them, so listing them would widen their exposure for no
+// benefit.
 //
-// It deliberately omits the p256dh and auth keys that PushSubscription carries.
-// Those exist to encrypt payloads for the endpoint and nothing outside the
-// sender needs them, so listing them would widen their exposure for no benefit.
+// Resubscribe is set on a subscription made against a VAPID key that has
+// since been rotated out.
 type PushSubscriptionResponse struct {
 	SubscriptionId int       `json:"subscriptionId"`
 	Endpoint       string    `json:"endpoint"`
 	UserAgent      string    `json:"userAgent"`
+	Resubscribe    bool      `json:"resubscribe"`
 	CreatedAt      time.Time `json:"createdAt"`
 	LastUsed       time.Time `json:"lastUsed"`
 }
 
-func NewPushSubscriptionResponse(s *PushSubscription) PushSubscriptionResponse {
+// NewPushSubscriptionResponse flags the subscription for resubscribing when
+// it is on a key other than currentVAPIDKeyId. One with no key recorded is
+// left alone, since which key it has is not known.
+func NewPushSubscriptionResponse(r *DeviceRegistration, currentVAPIDKeyId int) PushSubscriptionResponse {
 	return PushSubscriptionResponse{
-		SubscriptionId: s.SubscriptionId,
-		Endpoint:       s.Endpoint,
-		UserAgent:      s.UserAgent,
-		CreatedAt:      s.CreatedAt,
-		LastUsed:       s.LastUsed,
+		SubscriptionId: r.RegistrationId,
+		Endpoint:       r.Token,
+		UserAgent:      r.UserAgent,
+		Resubscribe:    r.VAPIDKeyId != nil && *r.VAPIDKeyId != currentVAPIDKeyId,
+		CreatedAt:      r.CreatedAt,
+		LastUsed:       r.LastUsed,
+	}
+}
+
+// NewPushSubscriptionResponses lists the web push registrations among
+// registrations. The subscription routes predate native devices, and their
+// clients expect every entry to be a browser endpoint.
+func NewPushSubscriptionResponses(registrations []*DeviceRegistration, currentVAPIDKeyId int) []PushSubscriptionResponse {
+	responses := make([]PushSubscriptionResponse, 0, len(registrations))
+	for _, r := range registrations {
+		if r.Provider == ProviderWebPush {
+			responses = append(responses, NewPushSubscriptionResponse(r, currentVAPIDKeyId))
+		}
 	}
+
+	return responses
+}
+
+// DeviceRegistrationResponse describes a registered device. Like
+// PushSubscriptionResponse it leaves out the keys, and the token as well: the
+// device already knows its own, and the ID is enough to remove it.
+//
+// Subjects is null for a device that alerts for everything.
+type DeviceRegistrationResponse struct {
+	RegistrationId int                     `json:"registrationId"`
+	Provider       string                  `json:"provider" enums:"webpush,fcm,apns"`
+	Name           string                  `json:"name"`
+	UserAgent      string                  `json:"userAgent"`
+	MutedUntil     *time.Time              `json:"mutedUntil" extensions:"x-nullable"`
+	Subjects       *DeviceSubjectsResponse `json:"subjects" extensions:"x-nullable"`
+	Health         DeviceHealthResponse    `json:"health"`
+	CreatedAt      time.Time               `json:"createdAt"`
+	LastUsed       time.Time               `json:"lastUsed"`
+}
+
+// DeviceSubjectsResponse is the animals and enclosures a device alerts for.
+type DeviceSubjectsResponse struct {
+	AnimalIds    []int `json:"animalIds"`
+	EnclosureIds []int `json:"enclosureIds"`
+}
+
+// DeviceHealthResponse is how pushes to a device have gone. FailureCount is
+// the failed pushes since the last success, and LastFailureStatus the push
+// service's HTTP status, null when the push never reached it.
+type DeviceHealthResponse struct {
+	LastSuccessAt     *time.Time `json:"lastSuccessAt" extensions:"x-nullable"`
+	LastFailureAt     *time.Time `json:"lastFailureAt" extensions:"x-nullable"`
+	LastFailureStatus *int       `json:"lastFailureStatus" extensions:"x-nullable"`
+	FailureCount      int        `json:"failureCount"`
+}
+
+func NewDeviceRegistrationResponse(r *DeviceRegistration) DeviceRegistrationResponse {
+	response := DeviceRegistrationResponse{
+		RegistrationId: r.RegistrationId,
+		Provider:       r.Provider,
+		Name:           r.Name,
+		UserAgent:      r.UserAgent,
+		MutedUntil:     r.MutedUntil,
+		Health: DeviceHealthResponse{
+			LastSuccessAt:     r.LastSuccessAt,
+			LastFailureAt:     r.LastFailureAt,
+			LastFailureStatus: r.LastFailureStatus,
+			FailureCount:      r.FailureCount,
+		},
+		CreatedAt: r.CreatedAt,
+		LastUsed:  r.LastUsed,
+	}
+	if r.AlertsFiltered {
+		response.Subjects = &DeviceSubjectsResponse{AnimalIds: r.AnimalIds, EnclosureIds: r.EnclosureIds}
+		if response.Subjects.AnimalIds == nil {
+			response.Subjects.AnimalIds = []int{}
+		}
+		if response.Subjects.EnclosureIds == nil {
+			response.Subjects.EnclosureIds = []int{}
+		}
+	}
+
+	return response
 }
 
-func NewPushSubscriptionResponses(subscriptions []*PushSubscription) []PushSubscriptionResponse {
-	responses := make([]PushSubscriptionResponse, 0, len(subscriptions))
-	for _, s := range subscriptions {
-		responses = append(responses, NewPushSubscriptionResponse(s))
+func NewDeviceRegistrationResponses(registrations []*DeviceRegistration) []DeviceRegistrationResponse {
+	responses := make([]DeviceRegistrationResponse, 0, len(registrations))
+	for _, r := range registrations {
+		responses = append(responses, NewDeviceRegistrationResponse(r))
 	}
 
 	return responses
@@ -109,9 +310,11 @@ type VAPIDKeyResponse struct {
 	PublicKey string `json:"publicKey"`
 }
 
-// TestNotificationResult is the per-subscription outcome of a test send.
+// TestNotificationResult is the per-registration outcome of a test send.
+// SubscriptionId is the registration's ID, and Endpoint its truncated token.
 type TestNotificationResult struct {
 	SubscriptionId int    `json:"subscriptionId"`
+	Provider       string `json:"provider" enums:"webpush,fcm,apns"`
 	Endpoint       string `json:"endpoint"`
 	HttpStatus     int    `json:"httpStatus"`
 	Success        bool   `json:"success"`
@@ -156,6 +359,8 @@ type AnimalResponse struct {
 	LastMessage     *string   `json:"lastMessage" extensions:"x-nullable"`
 	MemorialPhotos  []string  `json:"memorialPhotos" extensions:"x-nullable"`
 	MemorialDate    time.Time `json:"memorialDate"`
+	// Pause is the pause in force on the animal or its enclosure, if any.
+	Pause *CarePause `json:"pause" extensions:"x-nullable"`
 }
 
 // NewAnimalResponse converts a stored animal into its wire representation.
@@ -178,6 +383,7 @@ func NewAnimalResponse(a *Animal) (AnimalResponse, error) {
 		ExtraNotes:      a.ExtraNotes,
 		IsMemorialized:  a.IsMemorialized,
 		MemorialDate:    a.MemorialDate,
+		Pause:           a.Pause,
 	}
 
 	if a.LastMessage.Valid {
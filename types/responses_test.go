@@ -77,16 +77,17 @@ func TestUserResponseNeverIncludesPassword(t *testing.T) {
 // The stored subscription carries the p256dh and auth encryption keys; the
 // response type deliberately omits them.
 func TestPushSubscriptionResponseOmitsEncryptionKeys(t *testing.T) {
-	subscription := PushSubscription{
-		SubscriptionId: 1,
+	subscription := DeviceRegistration{
+		RegistrationId: 1,
 		UserID:         4,
-		Endpoint:       "https://push.example/abc",
+		Provider:       "webpush",
+		Token:          "https://push.example/abc",
 		P256dh:         "p256dh-secret-material",
 		Auth:           "auth-secret-material",
 		UserAgent:      "test-agent",
 	}
 
-	encoded, err := json.Marshal(NewPushSubscriptionResponse(&subscription))
+	encoded, err := json.Marshal(NewPushSubscriptionResponse(&subscription, 1))
 	if err != nil {
 		t.Fatalf("marshal: %v", err)
 	}
@@ -98,8 +99,39 @@ func TestPushSubscriptionResponseOmitsEncryptionKeys(t *testing.T) {
 	}
 }
 
+// The subscription routes predate native devices, so their clients would read
+// an FCM token as a browser endpoint.
+func TestNewPushSubscriptionResponsesListsOnlyWebPush(t *testing.T) {
+	responses := NewPushSubscriptionResponses([]*DeviceRegistration{
+		{RegistrationId: 1, Provider: "webpush", Token: "https://push.example/abc"},
+		{RegistrationId: 2, Provider: "fcm", Token: "fcm-token"},
+	}, 0)
+
+	if len(responses) != 1 || responses[0].SubscriptionId != 1 {
+		t.Errorf("got %+v, want only the web push subscription", responses)
+	}
+}
+
+// Only a subscription known to be on an old key is asked to resubscribe. One
+// from before keys were recorded could be on any of them.
+func TestPushSubscriptionResponseResubscribe(t *testing.T) {
+	current, old := 2, 1
+	responses := NewPushSubscriptionResponses([]*DeviceRegistration{
+		{RegistrationId: 1, Provider: "webpush", VAPIDKeyId: &current},
+		{RegistrationId: 2, Provider: "webpush", VAPIDKeyId: &old},
+		{RegistrationId: 3, Provider: "webpush"},
+	}, current)
+
+	want := []bool{false, true, false}
+	for i, r := range responses {
+		if r.Resubscribe != want[i] {
+			t.Errorf("subscription %d: resubscribe = %v, want %v", r.SubscriptionId, r.Resubscribe, want[i])
+		}
+	}
+}
+
 func TestNewPushSubscriptionResponsesEncodesEmptyListAsArray(t *testing.T) {
-	encoded, err := json.Marshal(NewPushSubscriptionResponses(nil))
+	encoded, err := json.Marshal(NewPushSubscriptionResponses(nil, 0))
 	if err != nil {
 		t.Fatalf("marshal: %v", err)
 	}
@@ -109,6 +141,26 @@ func TestNewPushSubscriptionResponsesEncodesEmptyListAsArray(t *testing.T) {
 	}
 }
 
+// A null subjects means the device alerts for everything, so a filtered
+// device with nothing picked must still encode as lists, not null.
+func TestDeviceRegistrationResponseSubjects(t *testing.T) {
+	unfiltered, err := json.Marshal(NewDeviceRegistrationResponse(&DeviceRegistration{RegistrationId: 1}))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !strings.Contains(string(unfiltered), `"subjects":null`) {
+		t.Errorf("unfiltered device should have null subjects: %s", unfiltered)
+	}
+
+	filtered, err := json.Marshal(NewDeviceRegistrationResponse(&DeviceRegistration{RegistrationId: 2, AlertsFiltered: true}))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !strings.Contains(string(filtered), `"subjects":{"animalIds":[],"enclosureIds":[]}`) {
+		t.Errorf("filtered device should list empty subjects: %s", filtered)
+	}
+}
+
 // AnimalResponse exists to describe the wire format that Animal.MarshalJSON
 // already produces, so the two must encode identically. If they diverge, the
 // generated client's types stop matching what the server actually sends —
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 )
 
@@ -13,6 +14,16 @@ type UserStore interface {
 	GetUserByEmail(email string) (*User, error)
 	GetUserById(id int) (*User, error)
 	DeleteUserById(id int) error
+	UpdateUserTimezone(userID int, timezone string) error
+	// StartEmailVerification records that a verification link is going out,
+	// unless the address is already verified or a link went out less than
+	// cooldown ago. CancelEmailVerification lifts the cooldown again when the
+	// link could not be sent.
+	StartEmailVerification(userID int, cooldown time.Duration) error
+	CancelEmailVerification(userID int) error
+	// VerifyEmail marks the user's address verified, provided it is still
+	// email.
+	VerifyEmail(userID int, email string) error
 }
 
 type User struct {
@@ -23,6 +34,27 @@ type User struct {
 	Phone     sql.NullString `json:"phone"`
 	Password  string         `json:"-"`
 	CreatedAt time.Time      `json:"createdAt"`
+	Timezone  string         `json:"timezone"`
+	// EmailVerifiedAt is unset until the user follows the link emailed to
+	// them. Until then, nothing but that link is sent to the address.
+	EmailVerifiedAt sql.NullTime `json:"emailVerifiedAt"`
+}
+
+// Location is the user's timezone, falling back to UTC for a zone that no
+// longer loads. Names are validated when they are set, but the tz database
+// drops and renames zones over time, and a schedule that fails outright is
+// worse than one in UTC.
+func (u *User) Location() *time.Location {
+	return locationOrUTC(u.Timezone)
+}
+
+func locationOrUTC(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
 }
 
 type RegisterUserPayload struct {
@@ -164,8 +196,9 @@ type HabitatIdPayload struct {
 
 // Enclosure-related Types
 type EnclosureStore interface {
-	CreateEnclosure(Enclosure, int) error
-	CreateEnclosureWithAnimals(enclosure Enclosure, animalIds []int, userID int) error
+	// The Create methods return the new enclosure's ID.
+	CreateEnclosure(Enclosure, int) (int, error)
+	CreateEnclosureWithAnimals(enclosure Enclosure, animalIds []int, userID int) (int, error)
 	UpdateEnclosure(Enclosure) error
 	UpdateEnclosureOwnerWithAnimals(oldEnclosureUser EnclosureUser, newUserId int) error
 	GetEnclosures() ([]*Enclosure, error)
@@ -180,6 +213,9 @@ type EnclosureStore interface {
 	DeleteEnclosureById(enclosureId int) error
 	DeleteEnclosureAndTasksById(enclosureId int) error
 	DeleteEnclosureAndAnimalsAndTasksById(enclosureId int) error
+	// CreateEnclosureWithTasks creates the enclosure, moves any animals into
+	// it and attaches the given tasks to it, all in one transaction.
+	CreateEnclosureWithTasks(enclosure Enclosure, animalIds []int, tasks []Task, userID int) (int, error)
 }
 
 type Enclosure struct {
@@ -247,7 +283,8 @@ type EnclosureIdPayload struct {
 
 // Animal-related Types
 type AnimalStore interface {
-	CreateAnimal(Animal, int) error
+	// The Create methods return the new animal's ID.
+	CreateAnimal(Animal, int) (int, error)
 	UpdateAnimal(Animal) error
 	// UpdateAnimalDetails writes only the editable detail columns, leaving
 	// memorial state untouched. UpdateAnimal writes all fourteen columns, so
@@ -273,6 +310,9 @@ type AnimalStore interface {
 	GetAnimalsByEnclosureId(int) ([]*Animal, error)
 	DeleteAnimalById(int) error
 	DeleteAnimalAndTasksById(int) error
+	// CreateAnimalWithTasks creates the animal and attaches the given tasks to
+	// it in one transaction, so a failure leaves neither behind.
+	CreateAnimalWithTasks(animal Animal, tasks []Task, userID int) (int, error)
 }
 
 type Animal struct {
@@ -291,6 +331,7 @@ type Animal struct {
 	LastMessage     sql.NullString `json:"-"`
 	MemorialPhotos  sql.NullString `json:"-"`
 	MemorialDate    time.Time      `json:"memorialDate"`
+	Pause           *CarePause     `json:"pause"`
 }
 
 // MarshalJSON customizes JSON marshaling to convert fields properly
@@ -452,8 +493,15 @@ type AnimalIdPayload struct {
 type TaskStore interface {
 	CheckTaskCompletion() error
 	CheckAndResetTasks() ([]*TaskResetNotification, error)
-	CreateTask(task Task, animalId int, enclosureId int, userId int) error
+	// CreateTask gives the task the options' priority, tags and reset time;
+	// the zero TaskOptions means normal priority, no tags and no reset time.
+	// It returns the new task's ID.
+	CreateTask(task Task, animalId int, enclosureId int, userId int, options TaskOptions) (int, error)
 	UpdateTask(Task) error
+	// SetTaskOptions replaces the task's priority, tags, reset time and
+	// expected minutes. Task has no room for them, since v1 converts its
+	// payloads into it directly.
+	SetTaskOptions(taskId int, options TaskOptions) error
 	UpdateTaskOwner(oldTaskUser TaskUser, newUserId int) error
 	UpdateTaskSubject(TaskSubject) error
 	// SetTaskSubject writes the unset side as SQL NULL. UpdateTaskSubject
@@ -470,9 +518,78 @@ type TaskStore interface {
 	// omits the subject, which PUT /tasks/{id} requires, so reading a task,
 	// changing a field and writing it back would be impossible without it.
 	GetTaskWithSubjectById(int) (*TaskWithSubject, error)
-	GetTasksWithSubjectByUserId(int) ([]*TaskWithSubject, error)
+	// GetTasksWithSubjectByUserId returns the user's tasks matching the
+	// filter; the zero TaskFilter matches all of them, ordered by task ID.
+	GetTasksWithSubjectByUserId(userID int, filter TaskFilter) ([]*TaskWithSubject, error)
 	GetTasksBySubjectIds(animalId int, enclosureId int) ([]*Task, error)
 	DeleteTaskById(int) error
+	// SnoozeTask defers the task's current occurrence until the given time
+	// without recording a completion.
+	SnoozeTask(taskId int, until time.Time) error
+	// SkipTaskOccurrence marks the current occurrence dealt with and moves the
+	// task's due time to nextDue, leaving lastCompleted untouched.
+	SkipTaskOccurrence(taskId int, nextDue time.Time) error
+	// SetTaskCompletedBy records who completed a task after UpdateTask has
+	// marked it complete.
+	SetTaskCompletedBy(taskId int, userID int) error
+	// CompleteTaskWithActionToken completes the task on behalf of userID and
+	// marks the token as spent, failing if it already was.
+	CompleteTaskWithActionToken(taskId int, userID int, tokenId string, expiresAt time.Time) error
+	// ApplyTaskOperations runs the operations in order in one transaction and
+	// returns one error slot per operation. When atomic, the first failure
+	// rolls everything back and later operations are not attempted; otherwise
+	// each failure is rolled back on its own and the rest still apply. The
+	// second return value is for failures of the batch as a whole.
+	ApplyTaskOperations(ops []BulkTaskOperation, userID int, atomic bool) ([]error, error)
+	GetTaskItems(taskId int) ([]TaskItem, error)
+	// CreateTaskItem appends an item to the end of the task's checklist.
+	CreateTaskItem(taskId int, text string) error
+	// UpdateTaskItem and DeleteTaskItem complete the task on behalf of userID
+	// if the change leaves every remaining item done.
+	UpdateTaskItem(taskId int, itemId int, text string, done bool, userID int) error
+	DeleteTaskItem(taskId int, itemId int, userID int) error
+	// ReorderTaskItems numbers the items in the order given, which must name
+	// each of the task's items exactly once.
+	ReorderTaskItems(taskId int, itemIds []int) error
+	// GetTaskEscalation returns the task's backup users, its pending invites
+	// and its escalation rules in the order they fire.
+	GetTaskEscalation(taskId int) (*TaskEscalation, error)
+	// SetTaskEscalation replaces the task's backups and rules. Of the current
+	// backup users only those in keepUserIds stay; no one becomes a backup
+	// without accepting an invite. inviteEmails replaces the pending invites,
+	// leaving out any address of a backup user kept. A rule kept with the
+	// same AfterHours remembers the occurrence it last fired for, so saving
+	// the policy again does not repeat a notification.
+	SetTaskEscalation(taskId int, keepUserIds []int, inviteEmails []string, rules []EscalationRule) error
+	// GetBackupInvites lists the invites waiting for the account with email.
+	GetBackupInvites(email string) ([]BackupInvite, error)
+	// AcceptBackupInvite makes userID a backup of the task the invite to
+	// their email is for.
+	AcceptBackupInvite(taskId int, userID int, email string) error
+	// LeaveTaskBackup declines the invite to email, or stops userID being a
+	// backup of the task.
+	LeaveTaskBackup(taskId int, userID int, email string) error
+	// ClaimDueEscalations marks every rule that has come due as fired and
+	// returns one notification per recipient. When several rules of a task
+	// came due at once only the latest is sent.
+	ClaimDueEscalations() ([]*TaskEscalationNotification, error)
+	// SetTaskIntervalSteps replaces the task's age steps, in order, and brings
+	// its interval and due time in line with the step it is now on. No steps
+	// leaves it on a fixed interval.
+	SetTaskIntervalSteps(taskId int, steps []IntervalStep) error
+	// GetRecordedOccurrences returns the user's occurrence history that can
+	// show in [from, to): occurrences due in it, and late completions that ran
+	// into it, in order of due time.
+	GetRecordedOccurrences(userID int, from time.Time, to time.Time) ([]RecordedOccurrence, error)
+	// StartTask starts a timer on an incomplete task on behalf of userID,
+	// restarting it if one is already running.
+	StartTask(taskId int, userID int) error
+	// FinishTask completes a task with a running timer, recording the time
+	// since it was started.
+	FinishTask(taskId int, userID int) error
+	// CompleteTask completes the task on behalf of userID. A nil duration
+	// records the time on the running timer, if there is one.
+	CompleteTask(taskId int, userID int, durationMinutes *int) error
 }
 
 type Task struct {
@@ -487,15 +604,158 @@ type Task struct {
 // TaskWithSubject is a task together with whichever subject it belongs to.
 // Exactly one of AnimalId and EnclosureId is set; the x-nullable extensions are
 // read only by the spec generator and do not affect encoding.
+//
+// DueAt is when the current occurrence falls due and SnoozedUntil, if set,
+// defers it. A task with a ResetTime falls due at that local time of day in
+// its owner's timezone. Pause is the pause in force on the task, if any, which
+// may be on its animal or enclosure rather than the task itself.
+//
+// The Is* fields are not stored: they are derived from DueAt, SnoozedUntil and
+// Pause at read time, so they are only as fresh as the response they arrive in.
 type TaskWithSubject struct {
-	TaskId            int       `json:"taskId"`
-	TaskName          string    `json:"taskName"`
-	TaskDesc          string    `json:"taskDesc"`
-	Complete          bool      `json:"complete"`
-	LastCompleted     time.Time `json:"lastCompleted"`
-	RepeatIntervHours int       `json:"repeatIntervHours"`
-	AnimalId          *int      `json:"animalId" extensions:"x-nullable"`
-	EnclosureId       *int      `json:"enclosureId" extensions:"x-nullable"`
+	TaskId            int        `json:"taskId"`
+	TaskName          string     `json:"taskName"`
+	TaskDesc          string     `json:"taskDesc"`
+	Complete          bool       `json:"complete"`
+	LastCompleted     time.Time  `json:"lastCompleted"`
+	RepeatIntervHours int        `json:"repeatIntervHours"`
+	AnimalId          *int       `json:"animalId" extensions:"x-nullable"`
+	EnclosureId       *int       `json:"enclosureId" extensions:"x-nullable"`
+	DueAt             time.Time  `json:"dueAt"`
+	SnoozedUntil      *time.Time `json:"snoozedUntil" extensions:"x-nullable"`
+	LastCompletedBy   *int       `json:"lastCompletedBy" extensions:"x-nullable"`
+	Priority          string     `json:"priority" enums:"low,normal,high"`
+	Tags              []string   `json:"tags"`
+	ResetTime         *string    `json:"resetTime" example:"06:00" extensions:"x-nullable"`
+	ExpectedMinutes   *int       `json:"expectedMinutes" extensions:"x-nullable"`
+	// StartedAt is when the running timer on the task was started, or nil
+	// when there is none.
+	StartedAt *time.Time `json:"startedAt" extensions:"x-nullable"`
+	StartedBy *int       `json:"startedBy" extensions:"x-nullable"`
+	// IntervalSteps is empty for a task on a fixed interval. While it is not,
+	// RepeatIntervHours follows the step the animal's age puts the task on.
+	IntervalSteps []IntervalStep `json:"intervalSteps"`
+	IntervalShift *IntervalShift `json:"intervalShift" extensions:"x-nullable"`
+	Pause         *CarePause     `json:"pause" extensions:"x-nullable"`
+	Items         []TaskItem     `json:"items"`
+	IsOverdue     bool           `json:"isOverdue"`
+	IsUpcoming    bool           `json:"isUpcoming"`
+	IsSnoozed     bool           `json:"isSnoozed"`
+	IsPaused      bool           `json:"isPaused"`
+}
+
+// TaskOptions are the fields of a task that v1 does not know about. An empty
+// Priority means normal. ResetTime is a local "15:04" time of day, or nil for
+// a task that resets a fixed number of hours after it was completed.
+// ExpectedMinutes is how long the task usually takes, or nil if unknown.
+type TaskOptions struct {
+	Priority        string
+	Tags            []string
+	ResetTime       *string
+	ExpectedMinutes *int
+}
+
+// TaskFilter narrows a task listing. Nil and empty fields do not filter. A
+// task must carry every tag in Tags and have one of Priorities. The due
+// bounds and Overdue apply to the effective due time, which a snooze pushes
+// back. Sort is a key the store recognises, optionally prefixed with "-" to
+// reverse it; empty orders by task ID.
+type TaskFilter struct {
+	AnimalId    *int
+	EnclosureId *int
+	Tags        []string
+	Priorities  []string
+	Complete    *bool
+	Overdue     *bool
+	DueBefore   *time.Time
+	DueAfter    *time.Time
+	Sort        string
+}
+
+// TaskItem is one step of a task's checklist. Items are listed by Position,
+// and the task completes itself once every item is done.
+type TaskItem struct {
+	ItemId   int        `json:"itemId"`
+	TaskId   int        `json:"taskId"`
+	Position int        `json:"position"`
+	Text     string     `json:"text"`
+	Done     bool       `json:"done"`
+	DoneAt   *time.Time `json:"doneAt" extensions:"x-nullable"`
+}
+
+// RecordedOccurrence is an occurrence that was dealt with: Outcome is
+// "completed" or "skipped". IntervalHours is the interval in force for it.
+type RecordedOccurrence struct {
+	TaskId        int
+	DueAt         time.Time
+	CompletedAt   *time.Time
+	CompletedBy   *int
+	IntervalHours int
+	Outcome       string
+}
+
+// TaskOccurrence is one occurrence of a task on the care calendar, whether it
+// happened or is projected. An occurrence is missed when it passed without
+// being done, including those a late completion ran past.
+type TaskOccurrence struct {
+	TaskId      int        `json:"taskId"`
+	TaskName    string     `json:"taskName"`
+	AnimalId    *int       `json:"animalId" extensions:"x-nullable"`
+	EnclosureId *int       `json:"enclosureId" extensions:"x-nullable"`
+	DueAt       time.Time  `json:"dueAt"`
+	Status      string     `json:"status" enums:"done,missed,upcoming,skipped"`
+	CompletedAt *time.Time `json:"completedAt" extensions:"x-nullable"`
+	CompletedBy *int       `json:"completedBy" extensions:"x-nullable"`
+}
+
+// IntervalStep is one age range of a stepped interval. It applies until the
+// animal is UntilAgeMonths old; the last step has none and applies from then
+// on.
+type IntervalStep struct {
+	UntilAgeMonths *int `json:"untilAgeMonths" extensions:"x-nullable"`
+	IntervalHours  int  `json:"intervalHours"`
+}
+
+// IntervalShift is the next change of step of a stepped task: from At on it
+// repeats every ToHours instead of every FromHours.
+type IntervalShift struct {
+	At        time.Time `json:"at"`
+	FromHours int       `json:"fromHours"`
+	ToHours   int       `json:"toHours"`
+}
+
+// TaskEscalation is who else hears about a task left undone, and when.
+// PendingInvites are the addresses invited as backups that have not accepted
+// yet, exactly as the owner gave them.
+type TaskEscalation struct {
+	TaskId         int              `json:"taskId"`
+	BackupUsers    []BackupUser     `json:"backupUsers"`
+	PendingInvites []string         `json:"pendingInvites"`
+	Rules          []EscalationRule `json:"rules"`
+}
+
+// BackupUser is another account that has agreed to back up a task. Only
+// enough of the account is shown for the owner to recognise it.
+type BackupUser struct {
+	UserId      int    `json:"userId"`
+	DisplayName string `json:"displayName"`
+}
+
+// BackupInvite asks the invited account to back up someone else's task.
+type BackupInvite struct {
+	TaskId    int       `json:"taskId"`
+	TaskName  string    `json:"taskName"`
+	OwnerName string    `json:"ownerName"`
+	InvitedAt time.Time `json:"invitedAt"`
+}
+
+// EscalationRule fires once a task has been outstanding for AfterHours past
+// its due time. "backups" notifies the backup users only; "everyone" notifies
+// the owner as well.
+type EscalationRule struct {
+	AfterHours int    `json:"afterHours"`
+	Audience   string `json:"audience" enums:"backups,everyone"`
+	Urgency    string `json:"urgency" enums:"normal,high"`
 }
 
 type TaskUser struct {
@@ -556,17 +816,238 @@ type SubjectIdsPayload struct {
 	EnclosureId int `json:"enclosureId" validate:"min=0"`
 }
 
+// Care template-related types
+type CareTemplateStore interface {
+	CreateCareTemplate(CareTemplate) error
+	UpdateCareTemplate(CareTemplate) error
+	// GetCareTemplates lists templates, narrowed to one species or habitat when
+	// either filter is non-nil.
+	GetCareTemplates(speciesId *int, habitatId *int) ([]*CareTemplate, error)
+	GetCareTemplateById(int) (*CareTemplate, error)
+	// GetCareTemplatesForAnimal returns the species' templates that apply at
+	// the given age. A nil age means the animal's date of birth is unknown, so
+	// only templates without an age range are returned.
+	GetCareTemplatesForAnimal(speciesId int, ageMonths *int) ([]*CareTemplate, error)
+	GetCareTemplatesForHabitat(habitatId int) ([]*CareTemplate, error)
+	DeleteCareTemplateById(int) error
+}
+
+// CareTemplate is a task that every animal of a species, or every enclosure of
+// a habitat, usually needs. Exactly one of SpeciesId and HabitatId is set.
+//
+// MinAgeMonths and MaxAgeMonths bound the life stage a species template
+// applies to, as a half-open range; either may be omitted. LifeStage is only a
+// label for display.
+type CareTemplate struct {
+	TemplateId        int    `json:"templateId"`
+	SpeciesId         *int   `json:"speciesId" extensions:"x-nullable"`
+	HabitatId         *int   `json:"habitatId" extensions:"x-nullable"`
+	TaskName          string `json:"taskName"`
+	TaskDesc          string `json:"taskDesc"`
+	RepeatIntervHours int    `json:"repeatIntervHours"`
+	LifeStage         string `json:"lifeStage"`
+	MinAgeMonths      *int   `json:"minAgeMonths" extensions:"x-nullable"`
+	MaxAgeMonths      *int   `json:"maxAgeMonths" extensions:"x-nullable"`
+}
+
+// Calendar-related types
+type CalendarFeedStore interface {
+	// SetCalendarFeedToken replaces any existing feed token for the user, so
+	// the old feed URL stops working as soon as a new one is issued.
+	SetCalendarFeedToken(userID int, tokenHash string) error
+	DeleteCalendarFeed(userID int) error
+	GetUserIdByCalendarFeedToken(tokenHash string) (int, error)
+	// GetCalendarTasks returns the user's tasks, optionally narrowed to one
+	// animal or to one enclosure together with the animals living in it.
+	GetCalendarTasks(userID int, animalId *int, enclosureId *int) ([]*CalendarTask, error)
+}
+
+// CalendarTask is a task with the name of its subject, which the feed needs
+// for event titles and TaskWithSubject does not carry.
+type CalendarTask struct {
+	TaskWithSubject
+	SubjectName string
+}
+
+// Pause-related types
+type PauseStore interface {
+	CreatePause(pause CarePause, userID int) (int, error)
+	GetPauseById(pauseId int) (*CarePause, error)
+	// GetPausesByUserId lists the user's pauses, newest first.
+	GetPausesByUserId(userID int) ([]CarePause, error)
+	UserOwnsPause(pauseId int, userID int) (bool, error)
+	// EndPause ends a pause in force now and puts its tasks back on schedule.
+	EndPause(pauseId int) error
+	// DeletePause removes a pause, first resuming its tasks if it had started.
+	DeletePause(pauseId int) error
+}
+
+// CarePause suspends the care schedule of exactly one of a task, an animal or
+// an enclosure. An enclosure's pause covers the animals in it and their
+// tasks. EndsAt is nil for a pause that lasts until it is ended.
+type CarePause struct {
+	PauseId     int        `json:"pauseId"`
+	TaskId      *int       `json:"taskId" extensions:"x-nullable"`
+	AnimalId    *int       `json:"animalId" extensions:"x-nullable"`
+	EnclosureId *int       `json:"enclosureId" extensions:"x-nullable"`
+	Reason      string     `json:"reason"`
+	StartsAt    time.Time  `json:"startsAt"`
+	EndsAt      *time.Time `json:"endsAt" extensions:"x-nullable"`
+	Status      string     `json:"status" enums:"scheduled,active,ended"`
+}
+
+// StatusAt works out Status, which is not stored.
+func (p *CarePause) StatusAt(now time.Time) string {
+	switch {
+	case now.Before(p.StartsAt):
+		return "scheduled"
+	case p.EndsAt != nil && !now.Before(*p.EndsAt):
+		return "ended"
+	default:
+		return "active"
+	}
+}
+
+// Stats-related types
+type StatsStore interface {
+	// GetAdherence reports adherence for every task, animal and enclosure of
+	// the user with at least one occurrence in [from, to). A completion within
+	// grace of its due time counts as on time.
+	GetAdherence(userID int, from time.Time, to time.Time, grace time.Duration) ([]AdherenceStats, error)
+	// GetTimeStats totals the recorded time of the user's completions in
+	// [from, to), with weeks starting on Mondays in loc, and estimates the
+	// daily workload of their tasks.
+	GetTimeStats(userID int, from time.Time, to time.Time, loc *time.Location) (*TimeStats, error)
+}
+
+// AdherenceStats is how consistently one task, or all the tasks of one animal
+// or enclosure, were done over a window. An enclosure's figures include the
+// tasks of the animals living in it.
+//
+// Occurrences counts every due occurrence that was completed, is still
+// outstanding, or was missed; skipped ones are counted separately and left
+// out of the rate and the streaks. An occurrence is missed when the next one
+// came due before it was done.
+type AdherenceStats struct {
+	Level              string   `json:"level" enums:"task,animal,enclosure"`
+	Id                 int      `json:"id"`
+	Name               string   `json:"name"`
+	Occurrences        int      `json:"occurrences"`
+	OnTime             int      `json:"onTime"`
+	Late               int      `json:"late"`
+	Missed             int      `json:"missed"`
+	Skipped            int      `json:"skipped"`
+	OnTimeRate         *float64 `json:"onTimeRate" extensions:"x-nullable"`
+	AvgLatenessMinutes *float64 `json:"avgLatenessMinutes" extensions:"x-nullable"`
+	CurrentStreak      int      `json:"currentStreak"`
+	LongestStreak      int      `json:"longestStreak"`
+}
+
+// TimeStats is the time spent on care over a window. Only completions with a
+// recorded duration count towards the totals; Untimed is how many had none.
+type TimeStats struct {
+	TotalMinutes int
+	Completions  int
+	Untimed      int
+	Totals       []TimeTotal
+	Weeks        []WeekTime
+	Workload     Workload
+}
+
+// TimeTotal is the recorded time of one animal, enclosure or user. An
+// enclosure's time includes that of the animals living in it.
+type TimeTotal struct {
+	Level       string `json:"level" enums:"animal,enclosure,user"`
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Minutes     int    `json:"minutes"`
+	Completions int    `json:"completions"`
+}
+
+// WeekTime is the recorded time of the week starting on WeekStart.
+type WeekTime struct {
+	WeekStart   string `json:"weekStart" example:"2026-10-12"`
+	Minutes     int    `json:"minutes"`
+	Completions int    `json:"completions"`
+}
+
+// Workload estimates how long the user's care takes in a typical day. A
+// task without expectedMinutes is estimated from its recorded durations;
+// Unestimated counts the tasks with neither, which are left out.
+type Workload struct {
+	DailyMinutes  float64 `json:"dailyMinutes"`
+	WeeklyMinutes float64 `json:"weeklyMinutes"`
+	Estimated     int     `json:"estimatedTasks"`
+	Unestimated   int     `json:"unestimatedTasks"`
+}
+
 type LoopMessageStore interface {
-	ReceiveLoopMessage(InboundLoopMessagePayload) error
-	SendLoopMessage(SentLoopMessagePayload) error
+	GetPhoneStatus(userID int) (*PhoneStatus, error)
+	// StartPhoneVerification replaces any code waiting for the user, unless
+	// it was issued less than cooldown ago.
+	StartPhoneVerification(userID int, phone string, codeHash string, expiresAt time.Time, cooldown time.Duration) error
+	// CancelPhoneVerification drops the code with codeHash, which could not
+	// be texted, so its cooldown does not hold up the next request.
+	CancelPhoneVerification(userID int, codeHash string) error
+	// SpendPhoneVerificationAttempt counts one guess against the user's
+	// waiting code and returns it, as long as it has guesses left and has not
+	// expired.
+	SpendPhoneVerificationAttempt(userID int, maxAttempts int) (*PhoneVerification, error)
+	// ConfirmPhone sets the user's phone from their waiting code and opts them
+	// in to texts.
+	ConfirmPhone(userID int, phone string) error
+	SetSMSOptIn(userID int, optedIn bool) error
+	RemovePhone(userID int) error
+
+	RecordLoopMessage(m LoopMessage) error
+	// UpdateLoopMessageStatus returns false when no text has messageId.
+	UpdateLoopMessageStatus(messageId string, status string) (bool, error)
+
+	// GetUserIdByPhone finds the user who verified phone.
+	GetUserIdByPhone(phone string) (int, error)
+	// ClaimSMSCommand logs an inbound text before its command runs, returning
+	// false if messageId was logged already.
+	ClaimSMSCommand(messageId string, userID int, text string) (bool, error)
+	// FinishSMSCommand records what a logged text was read as and the reply.
+	FinishSMSCommand(messageId string, command string, reply string) error
+}
+
+// PhoneStatus is the user's number for text reminders. Phone is only set once
+// it is verified, and texts only go to it while OptedIn. PendingPhone is a
+// number waiting for its code.
+type PhoneStatus struct {
+	Phone        *string `json:"phone" example:"+15555550100" extensions:"x-nullable"`
+	OptedIn      bool    `json:"optedIn"`
+	PendingPhone *string `json:"pendingPhone" example:"+15555550100" extensions:"x-nullable"`
+}
+
+type PhoneVerification struct {
+	UserID    int
+	Phone     string
+	CodeHash  string
+	Attempts  int
+	ExpiresAt time.Time
+}
+
+// LoopMessage is one text sent through LoopMessage. NotificationId is the
+// notification center record it delivers, or 0 for texts such as
+// verification codes that deliver none.
+type LoopMessage struct {
+	MessageId      string
+	UserID         int
+	NotificationId int
+	Recipient      string
+	Text           string
 }
 
+// InboundLoopMessagePayload is a LoopMessage webhook. Only an inbound
+// message is sure to name the message and who sent it.
 type InboundLoopMessagePayload struct {
-	AlertType   string `json:"alertType"`
-	Recipient   string `json:"recipient"`
+	AlertType   string `json:"alertType" validate:"required"`
+	Recipient   string `json:"recipient" validate:"required_if=AlertType message_inbound"`
 	Text        string `json:"text"`
 	MessageType string `json:"messageType"`
-	MessageId   string `json:"messageId"`
+	MessageId   string `json:"messageId" validate:"required_if=AlertType message_inbound"`
 	WebhookId   string `json:"webhookId"`
 	ApiVersion  string `json:"apiVersion"`
 }
@@ -581,38 +1062,354 @@ type SentLoopMessagePayload struct {
 	ApiVersion string `json:"apiVersion"`
 }
 
-// Notification-related types
-type PushSubscriptionStore interface {
-	CreateSubscription(sub PushSubscription) error
-	GetSubscriptionsByUserId(userId int) ([]*PushSubscription, error)
-	DeleteSubscription(subscriptionId int) error
-	DeleteSubscriptionByEndpoint(userId int, endpoint string) error
-	UpdateLastUsed(subscriptionId int) error
-}
-
-type PushSubscription struct {
-	SubscriptionId int       `json:"subscriptionId"`
-	UserID         int       `json:"userId"`
-	Endpoint       string    `json:"endpoint"`
-	P256dh         string    `json:"p256dh"`
-	Auth           string    `json:"auth"`
-	UserAgent      string    `json:"userAgent"`
+// WebhookStore keeps users' outgoing webhooks and the log of what was sent
+// to them.
+type WebhookStore interface {
+	UserOwnsWebhook(webhookId int, userID int) (bool, error)
+	GetWebhooksByUserId(userID int) ([]*Webhook, error)
+	GetWebhookById(webhookId int) (*Webhook, error)
+	CreateWebhook(hook Webhook) (int, error)
+	// UpdateWebhook replaces the URL, description, events and enabled state.
+	// Enabling a webhook clears its failure count.
+	UpdateWebhook(hook Webhook) error
+	SetWebhookSecret(webhookId int, secret string) error
+	DeleteWebhook(webhookId int) error
+
+	// CreateWebhookDeliveries queues payload for each of the user's enabled
+	// webhooks subscribed to event, and returns them to be sent. They are not
+	// due for a retry until lease has passed, so the first attempt can be made
+	// without the retry job also picking them up.
+	CreateWebhookDeliveries(userID int, event string, payload string, lease time.Duration) ([]*PendingWebhookDelivery, error)
+	// GetUsersSubscribedTo returns which of userIDs have an enabled webhook
+	// subscribed to event.
+	GetUsersSubscribedTo(userIDs []int, event string) (map[int]bool, error)
+	// CreateWebhookDelivery queues payload for one webhook, whatever its
+	// events and whether or not it is enabled.
+	CreateWebhookDelivery(webhookId int, event string, payload string, lease time.Duration) (*PendingWebhookDelivery, error)
+	// ClaimDueWebhookDeliveries returns the pending deliveries of enabled
+	// webhooks whose next attempt is due, pushing that back by lease so that a
+	// second run of the retry job does not send them too.
+	ClaimDueWebhookDeliveries(lease time.Duration, limit int) ([]*PendingWebhookDelivery, error)
+	// RecordWebhookAttempt logs an attempt and moves the delivery on: to
+	// succeeded if it did, otherwise to pending until nextAttemptAt, or to
+	// failed when there is none.
+	RecordWebhookAttempt(deliveryId int, attempt WebhookAttempt, nextAttemptAt *time.Time) error
+	// RecordWebhookOutcome counts a failed attempt against the webhook, or
+	// clears the count after a success. Reaching maxFailures disables it and
+	// fails its pending deliveries; the return says whether that happened.
+	RecordWebhookOutcome(webhookId int, succeeded bool, maxFailures int) (bool, error)
+	// GetWebhookDeliveries returns a page of the webhook's deliveries, newest
+	// first, each with its attempts.
+	GetWebhookDeliveries(webhookId int, limit int, before *int) ([]WebhookDelivery, error)
+	PruneWebhookDeliveries(before time.Time) (int64, error)
+}
+
+// Webhook is a URL a user has asked to be sent events. The secret signs what
+// is sent and is only shown when it is issued.
+type Webhook struct {
+	WebhookId           int        `json:"webhookId"`
+	UserID              int        `json:"-"`
+	URL                 string     `json:"url" example:"https://example.com/hooks/animal-family"`
+	Description         string     `json:"description"`
+	Events              []string   `json:"events" example:"task.completed"`
+	Enabled             bool       `json:"enabled"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	DisabledAt          *time.Time `json:"disabledAt" extensions:"x-nullable"`
+	CreatedAt           time.Time  `json:"createdAt"`
+	Secret              string     `json:"-"`
+}
+
+// PendingWebhookDelivery is a delivery with what is needed to send it.
+// Attempts is how many times it has been sent already.
+type PendingWebhookDelivery struct {
+	DeliveryId int
+	WebhookId  int
+	URL        string
+	Secret     string
+	Event      string
+	Payload    string
+	Attempts   int
+}
+
+// WebhookDelivery is one event sent, or still being sent, to a webhook.
+// Payload is the exact body that was signed.
+type WebhookDelivery struct {
+	DeliveryId    int              `json:"deliveryId"`
+	Event         string           `json:"event" example:"task.completed"`
+	Payload       string           `json:"payload"`
+	Status        string           `json:"status" enums:"pending,succeeded,failed"`
+	NextAttemptAt *time.Time       `json:"nextAttemptAt" extensions:"x-nullable"`
+	CreatedAt     time.Time        `json:"createdAt"`
+	Attempts      []WebhookAttempt `json:"attempts"`
+}
+
+// WebhookAttempt is one try at sending a delivery. ResponseStatus is null
+// when nothing came back, and Error then says why.
+type WebhookAttempt struct {
+	ResponseStatus *int      `json:"responseStatus" extensions:"x-nullable"`
+	Error          *string   `json:"error" extensions:"x-nullable"`
+	DurationMs     int       `json:"durationMs"`
 	CreatedAt      time.Time `json:"createdAt"`
-	LastUsed       time.Time `json:"lastUsed"`
 }
 
+// Notification-related types
+type DeviceRegistrationStore interface {
+	// CreateDeviceRegistration registers a device, or refreshes the user's
+	// existing registration of the same token, and takes the token from any
+	// other user who had registered it.
+	CreateDeviceRegistration(reg DeviceRegistration) (int, error)
+	GetDeviceRegistrationsByUserId(userId int) ([]*DeviceRegistration, error)
+	GetDeviceRegistrationById(registrationId int) (*DeviceRegistration, error)
+	UserOwnsDeviceRegistration(registrationId int, userId int) (bool, error)
+	DeleteDeviceRegistration(registrationId int) error
+	DeleteDeviceRegistrationByToken(userId int, token string) error
+	// UpdateDeviceRegistration saves a device's name, mute and alert filter.
+	UpdateDeviceRegistration(reg DeviceRegistration) error
+	// GetAlertDevices returns the user's devices that are not muted, each
+	// with which of the tasks its filter covers, so that one lookup serves
+	// every push going out to the user together.
+	GetAlertDevices(userId int, taskIds []int) ([]*AlertDevice, error)
+	// RecordDeviceDelivery keeps a device's delivery health from the outcome
+	// of a push. status is the push service's HTTP status, or 0 if none.
+	RecordDeviceDelivery(registrationId int, status int, succeeded bool) error
+}
+
+// The push services a device can be registered with.
+const (
+	ProviderWebPush = "webpush"
+	ProviderFCM     = "fcm"
+	ProviderAPNs    = "apns"
+)
+
+// DeviceRegistration is a device that can be sent pushes, through the push
+// service its provider names. For web push, Token is the subscription's
+// endpoint URL and P256dh and Auth are its encryption keys; for FCM and APNs
+// it is the device token, and there are no keys.
+//
+// VAPIDKeyId is the VAPID key a web push subscription was made against, nil
+// for native devices.
+//
+// A device with AlertsFiltered set only alerts for the animals and
+// enclosures listed, an enclosure covering the animals in it. FailureCount
+// counts the failed pushes since the last one that got through.
+type DeviceRegistration struct {
+	RegistrationId    int        `json:"registrationId"`
+	UserID            int        `json:"userId"`
+	Provider          string     `json:"provider"`
+	Token             string     `json:"token"`
+	P256dh            string     `json:"p256dh"`
+	Auth              string     `json:"auth"`
+	UserAgent         string     `json:"userAgent"`
+	Name              string     `json:"name"`
+	MutedUntil        *time.Time `json:"mutedUntil"`
+	AlertsFiltered    bool       `json:"alertsFiltered"`
+	AnimalIds         []int      `json:"animalIds"`
+	EnclosureIds      []int      `json:"enclosureIds"`
+	LastSuccessAt     *time.Time `json:"lastSuccessAt"`
+	LastFailureAt     *time.Time `json:"lastFailureAt"`
+	LastFailureStatus *int       `json:"lastFailureStatus"`
+	FailureCount      int        `json:"failureCount"`
+	VAPIDKeyId        *int       `json:"vapidKeyId"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	LastUsed          time.Time  `json:"lastUsed"`
+}
+
+// AlertDevice is a device that is not muted, with the tasks its filter
+// covers among those it was looked up for.
+type AlertDevice struct {
+	Registration   *DeviceRegistration
+	CoveredTaskIds []int
+}
+
+// AlertsFor says whether the device alerts for a push about any of taskIds.
+// A filter names the animals and enclosures of the device's own user, so it
+// only applies to pushes about their own tasks: a backup's filter would
+// otherwise block every escalation sent to them.
+func (d *AlertDevice) AlertsFor(taskIds []int, ownTasks bool) bool {
+	if !d.Registration.AlertsFiltered || !ownTasks {
+		return true
+	}
+	for _, taskId := range taskIds {
+		if slices.Contains(d.CoveredTaskIds, taskId) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SubscribePayload registers a browser's push subscription. VAPIDPublicKey
+// is the applicationServerKey it subscribed with; without it the subscription
+// is taken to be made against the current key.
 type SubscribePayload struct {
 	Endpoint string `json:"endpoint" validate:"required"`
 	Keys     struct {
 		P256dh string `json:"p256dh" validate:"required"`
 		Auth   string `json:"auth" validate:"required"`
 	} `json:"keys" validate:"required"`
+	VAPIDPublicKey string `json:"vapidPublicKey,omitempty"`
 }
 
 type UnsubscribePayload struct {
 	Endpoint string `json:"endpoint" validate:"required"`
 }
 
+type VAPIDKeyStore interface {
+	// EnsureVAPIDKey adds the configured key pair if it is new, making it
+	// current. The first key added also takes the subscriptions that predate
+	// VAPID keys being recorded. Private keys are stored encrypted, and it
+	// also encrypts any stored before they were, which is all it does when
+	// no key is configured.
+	EnsureVAPIDKey(publicKey, privateKey string) error
+	// CreateVAPIDKey adds a key pair and makes it current.
+	CreateVAPIDKey(publicKey, privateKey string) (int, error)
+	// GetVAPIDKeys returns the keys not yet retired, newest first.
+	GetVAPIDKeys() ([]*VAPIDKey, error)
+	// GetStaleWebPushRegistrations returns the unmuted web push subscriptions
+	// on a key that is no longer current and not yet asked to resubscribe.
+	GetStaleWebPushRegistrations() ([]*DeviceRegistration, error)
+	MarkResubscribeRequested(registrationIds []int) error
+	// RetireUnusedVAPIDKeys retires the old keys no subscription is on any
+	// more, returning how many it retired.
+	RetireUnusedVAPIDKeys() (int64, error)
+}
+
+// VAPIDKey is a key pair web push is signed with. Subscriptions counts the
+// web push subscriptions made against it.
+type VAPIDKey struct {
+	VAPIDKeyId    int        `json:"vapidKeyId"`
+	PublicKey     string     `json:"publicKey"`
+	PrivateKey    string     `json:"-"`
+	Current       bool       `json:"current"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	RotatedAt     *time.Time `json:"rotatedAt"`
+	Subscriptions int        `json:"subscriptions"`
+}
+
+type NotificationPreferenceStore interface {
+	// GetNotificationPreferences lists every event on every channel. A user
+	// who has never saved any gets the defaults: push and sms on, email off,
+	// no quiet hours and no digest.
+	GetNotificationPreferences(userID int) (*NotificationPreferences, error)
+	// SetNotificationPreferences replaces the user's preferences. Channels not
+	// listed go back to their defaults.
+	SetNotificationPreferences(userID int, prefs NotificationPreferences) error
+	// SetNotificationChannel turns one event on one channel on or off,
+	// leaving the rest of the user's preferences as they are.
+	SetNotificationChannel(userID int, event string, channel string, enabled bool) error
+	HoldNotification(n HeldNotification) error
+	// PendingDigestAt is when the user's held reset notifications are next
+	// due to go out, or nil when none are waiting.
+	PendingDigestAt(userID int) (*time.Time, error)
+	// ClaimDueNotifications claims and returns every held notification due by
+	// now, oldest first. A claim that was never followed by a delete is taken
+	// again once it is old enough. Notifications for tasks that have since
+	// been completed, snoozed or paused are deleted rather than returned.
+	ClaimDueNotifications(now time.Time) ([]*HeldNotification, error)
+	// DeleteHeldNotifications deletes claimed notifications once they have
+	// been queued for delivery.
+	DeleteHeldNotifications(heldIds []int) error
+}
+
+// NotificationPreferences decide which notifications a user is sent, and
+// when. Quiet hours are local "15:04" times in Timezone, which is the user's
+// and is changed through PUT /users/me/timezone; both are nil when there are
+// none. With a digest, reset notifications are collected for DigestMinutes
+// and sent as one.
+type NotificationPreferences struct {
+	Channels        []NotificationChannel `json:"channels"`
+	QuietHoursStart *string               `json:"quietHoursStart" example:"22:00" extensions:"x-nullable"`
+	QuietHoursEnd   *string               `json:"quietHoursEnd" example:"07:00" extensions:"x-nullable"`
+	DigestEnabled   bool                  `json:"digestEnabled"`
+	DigestMinutes   int                   `json:"digestMinutes"`
+	Timezone        string                `json:"timezone"`
+}
+
+// Location is the timezone quiet hours are read in. See User.Location.
+func (p *NotificationPreferences) Location() *time.Location {
+	return locationOrUTC(p.Timezone)
+}
+
+// Enabled reports whether the user wants event sent on channel. A pair that
+// is not listed is on; preferences read from the store list every pair, so
+// this only matters for ones built in code.
+func (p *NotificationPreferences) Enabled(event string, channel string) bool {
+	for _, c := range p.Channels {
+		if c.Event == event && c.Channel == channel {
+			return c.Enabled
+		}
+	}
+
+	return true
+}
+
+// NotificationChannel turns one kind of notification on or off on one
+// channel.
+type NotificationChannel struct {
+	Event   string `json:"event" enums:"taskReset,taskEscalation"`
+	Channel string `json:"channel" enums:"push,email,sms"`
+	Enabled bool   `json:"enabled"`
+}
+
+// HeldNotification is a push notification waiting out quiet hours or a
+// digest window. Payload is the JSON payload without its data block.
+type HeldNotification struct {
+	HeldId int
+	UserID int
+	Event  string
+	TaskId int
+	// NotificationId is the notification center record the push delivers, or
+	// 0 if recording it failed.
+	NotificationId int
+	OwnerID        int
+	Urgency        string
+	Payload        []byte
+	DeliverAt      time.Time
+}
+
+// NotificationServiceStore is everything the notification service keeps in
+// the database: devices, preferences and held notifications, and the
+// notification center. One store implements all of it.
+type NotificationServiceStore interface {
+	DeviceRegistrationStore
+	NotificationPreferenceStore
+	NotificationStore
+}
+
+type NotificationStore interface {
+	// CreateNotification records n, filling in its ID and creation time.
+	CreateNotification(n *Notification) error
+	// ListNotifications returns up to limit of the user's notifications,
+	// newest first, starting after before when it is not nil.
+	ListNotifications(userID int, before *int, limit int, unreadOnly bool) ([]Notification, error)
+	CountUnreadNotifications(userID int) (int, error)
+	// UserOwnsNotification separates "not owned" from "lookup failed" — see
+	// the note on AnimalStore.UserOwnsAnimal.
+	UserOwnsNotification(notificationId int, userID int) (bool, error)
+	GetNotificationById(notificationId int) (*Notification, error)
+	// MarkNotificationRead leaves the read time of a notification that was
+	// already read as it was.
+	MarkNotificationRead(notificationId int) error
+	// MarkAllNotificationsRead returns how many notifications it marked.
+	MarkAllNotificationsRead(userID int) (int, error)
+	// PruneNotifications deletes read notifications created before readBefore
+	// and unread ones created before unreadBefore, returning how many went.
+	PruneNotifications(readBefore time.Time, unreadBefore time.Time) (int64, error)
+}
+
+// Notification is the notification center's record of something the user
+// was told about. It is kept whether or not it was also pushed to a device.
+type Notification struct {
+	NotificationId int        `json:"notificationId"`
+	UserID         int        `json:"-"`
+	Event          string     `json:"event" enums:"taskReset,taskEscalation"`
+	TaskId         *int       `json:"taskId" extensions:"x-nullable"`
+	Title          string     `json:"title"`
+	Body           string     `json:"body"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	ReadAt         *time.Time `json:"readAt" extensions:"x-nullable"`
+}
+
 type TaskResetNotification struct {
 	TaskId      int
 	TaskName    string
@@ -621,3 +1418,13 @@ type TaskResetNotification struct {
 	SubjectName string
 	SubjectType string
 }
+
+// TaskEscalationNotification tells one recipient that a task is still not
+// done. UserID is the recipient, who is either the owner or a backup user.
+type TaskEscalationNotification struct {
+	TaskResetNotification
+	OwnerID    int
+	OwnerName  string
+	AfterHours int
+	Urgency    string
+}
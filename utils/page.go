@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// Page is a bare HTML page, for the few routes people reach by following a
+// link in an email rather than from the app.
+//
+// With a Button, the page holds a form that POSTs back to the URL it was
+// loaded from, query string included. Routes that change something only do
+// so on that POST: mail scanners and link previews fetch links with GET, and
+// must not act on them for the recipient.
+type Page struct {
+	Title   string
+	Message string
+	Button  string
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+</head>
+<body style="font-family: sans-serif; max-width: 32rem; margin: 4rem auto; padding: 0 1rem;">
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{if .Button}}<form method="post"><button type="submit">{{.Button}}</button></form>
+{{end}}</body>
+</html>
+`))
+
+func WritePage(w http.ResponseWriter, status int, page Page) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := pageTemplate.Execute(w, page); err != nil {
+		log.Printf("failed to write page %q: %v", page.Title, err)
+	}
+}
@@ -31,3 +31,20 @@ func ParseIDParam(r *http.Request, name string) (int, error) {
 
 	return id, nil
 }
+
+// ParseOptionalIDQuery reads an optional positive integer ID from the query
+// string, returning nil when the parameter is absent. v2 list routes use these
+// as filters.
+func ParseOptionalIDQuery(r *http.Request, name string) (*int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+
+	id, err := strconv.Atoi(raw)
+	if err != nil || id < 1 {
+		return nil, fmt.Errorf("invalid %s: must be a positive integer", name)
+	}
+
+	return &id, nil
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
 	"github.com/whitallee/animal-family-backend/types"
 )
 
@@ -163,11 +165,24 @@ func ScanRowsIntoTaskWithSubject(rows *sql.Rows) (*types.TaskWithSubject, error)
 		&task.RepeatIntervHours,
 		&task.AnimalId,
 		&task.EnclosureId,
+		&task.DueAt,
+		&task.SnoozedUntil,
+		&task.LastCompletedBy,
+		&task.Priority,
+		pq.Array(&task.Tags),
+		&task.ResetTime,
+		&task.ExpectedMinutes,
+		&task.StartedAt,
+		&task.StartedBy,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	if task.Tags == nil {
+		task.Tags = []string{}
+	}
+
 	return task, nil
 }
 
@@ -184,3 +199,22 @@ func ScanRowsIntoTaskUser(rows *sql.Rows) (*types.TaskUser, error) {
 
 	return taskUser, nil
 }
+
+// AgeInMonths is how many whole months old an animal born on dob is at now.
+// It returns nil for the zero time, which is what an animal created without a
+// dob holds.
+func AgeInMonths(dob time.Time, now time.Time) *int {
+	if dob.IsZero() {
+		return nil
+	}
+
+	months := (now.Year()-dob.Year())*12 + int(now.Month()) - int(dob.Month())
+	if now.Day() < dob.Day() {
+		months--
+	}
+	if months < 0 {
+		months = 0
+	}
+
+	return &months
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 // serve runs handler against a real http.Server rather than httptest.Recorder,
@@ -112,3 +113,32 @@ func TestWriteErrorUsesErrorEnvelope(t *testing.T) {
 		t.Errorf("unexpected body %q", body)
 	}
 }
+
+func TestAgeInMonths(t *testing.T) {
+	now := time.Date(2026, 6, 15, 0, 0, 0, 0, time.UTC)
+
+	if got := AgeInMonths(time.Time{}, now); got != nil {
+		t.Errorf("unknown dob: got %d, want nil", *got)
+	}
+
+	cases := map[string]struct {
+		dob  time.Time
+		want int
+	}{
+		"born today":                 {now, 0},
+		"one day short of a month":   {time.Date(2026, 5, 16, 0, 0, 0, 0, time.UTC), 0},
+		"exactly one month":          {time.Date(2026, 5, 15, 0, 0, 0, 0, time.UTC), 1},
+		"across a year boundary":     {time.Date(2025, 11, 15, 0, 0, 0, 0, time.UTC), 7},
+		"dob in the future clamps":   {time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC), 0},
+		"eighteen months and a half": {time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), 18},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := AgeInMonths(tc.dob, now)
+			if got == nil || *got != tc.want {
+				t.Errorf("got %v, want %d", got, tc.want)
+			}
+		})
+	}
+}